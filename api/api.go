@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"sync"
 
+	"github.com/connectome-neuprint/neuPrintHTTP/audit"
 	"github.com/connectome-neuprint/neuPrintHTTP/internal/version"
 	"github.com/connectome-neuprint/neuPrintHTTP/storage"
 	"github.com/connectome-neuprint/neuPrintHTTP/utils"
@@ -42,6 +43,7 @@ const (
 	POST
 	PUT
 	DELETE
+	PATCH
 )
 
 // RoutePolicy is the declared authorization contract for an API route.
@@ -137,12 +139,15 @@ func (c *ConnectomeAPI) setRoute(connType ConnectionType, prefix string, route e
 	case DELETE:
 		c.e.DELETE(prefix, route)
 		c.e.DELETE("/v:ver"+prefix, CheckVersion(route))
+	case PATCH:
+		c.e.PATCH(prefix, route)
+		c.e.PATCH("/v:ver"+prefix, CheckVersion(route))
 	}
 }
 
 // SetAdminRoute sets a handler function to a given prefix with admin privileges.
 func (c *ConnectomeAPI) SetAdminRoute(connType ConnectionType, prefix string, route echo.HandlerFunc) {
-	c.setRoute(connType, prefix, c.adminMiddleware(route), AdminRoute)
+	c.setRoute(connType, prefix, audit.Middleware(c.adminMiddleware(route)), AdminRoute)
 }
 
 // SetGroupRoute registers a non-versioned /api route with an explicit policy.
@@ -157,6 +162,8 @@ func SetGroupRoute(group *echo.Group, connType ConnectionType, path string, rout
 		group.PUT(path, route)
 	case DELETE:
 		group.DELETE(path, route)
+	case PATCH:
+		group.PATCH(path, route)
 	}
 }
 
@@ -196,6 +203,8 @@ func methodForConnection(connType ConnectionType) string {
 		return http.MethodPut
 	case DELETE:
 		return http.MethodDelete
+	case PATCH:
+		return http.MethodPatch
 	default:
 		panic("invalid API connection type")
 	}
@@ -0,0 +1,156 @@
+package auditlog
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/api"
+	"github.com/connectome-neuprint/neuPrintHTTP/audit"
+	"github.com/connectome-neuprint/neuPrintHTTP/secure"
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+	"github.com/labstack/echo/v4"
+)
+
+func init() {
+	api.RegisterAPI(PREFIX, setupAPI)
+}
+
+const PREFIX = "/audit"
+
+type auditAPI struct {
+	Store storage.Store
+}
+
+// setupAPI sets up the audit log query endpoint
+func setupAPI(mainapi *api.ConnectomeAPI) error {
+	q := &auditAPI{mainapi.Store}
+
+	endPoint := "records"
+	mainapi.SetAdminRoute(api.GET, PREFIX+"/"+endPoint, q.getRecords)
+	mainapi.SupportedEndpoints["audit"] = true
+	return nil
+}
+
+// parseTime reads an RFC 3339 time or a YYYY-MM-DD date
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("time %q must be RFC 3339 or YYYY-MM-DD", value)
+}
+
+// getRecords returns the audit records matching the filter
+func (aa auditAPI) getRecords(c echo.Context) error {
+	// swagger:operation GET /api/audit/records audit getAuditRecords
+	//
+	// Query the audit log.
+	//
+	// Returns the recorded data mutations in order.  Every record holds the
+	// hash of the record before it; the request fails if the chain is
+	// broken.  Requires admin access to the dataset, or to every dataset
+	// if none is given.
+	//
+	// ---
+	// parameters:
+	// - in: "query"
+	//   name: "dataset"
+	//   description: "only return mutations of this dataset"
+	// - in: "query"
+	//   name: "user"
+	//   description: "only return mutations by this user (email)"
+	// - in: "query"
+	//   name: "from"
+	//   description: "earliest time (RFC 3339 or YYYY-MM-DD)"
+	// - in: "query"
+	//   name: "to"
+	//   description: "time before which to stop (RFC 3339 or YYYY-MM-DD)"
+	// responses:
+	//   200:
+	//     description: "successful operation"
+	//     schema:
+	//       type: "array"
+	//       items:
+	//         type: "object"
+	//         properties:
+	//           seq:
+	//             type: "integer"
+	//           time:
+	//             type: "string"
+	//           user:
+	//             type: "string"
+	//           dataset:
+	//             type: "string"
+	//           operation:
+	//             type: "string"
+	//             example: "POST /api/skeletons/skeleton/:dataset/:id"
+	//           target:
+	//             type: "string"
+	//             description: "path parameters, or the request body without them"
+	//           payloadHash:
+	//             type: "string"
+	//             description: "SHA-256 of the request body"
+	//           previousValueHash:
+	//             type: "string"
+	//             description: "SHA-256 of the replaced value"
+	//           status:
+	//             type: "integer"
+	//           outcome:
+	//             type: "string"
+	//             description: "success or failure"
+	//           error:
+	//             type: "string"
+	//           prevHash:
+	//             type: "string"
+	//           hash:
+	//             type: "string"
+	// security:
+	// - Bearer: [admin]
+
+	filter := audit.Filter{Dataset: c.QueryParam("dataset"), User: c.QueryParam("user")}
+	if filter.Dataset != "" {
+		if err := secure.RequireDatasetAccess(c, filter.Dataset, secure.ADMIN); err != nil {
+			return err
+		}
+	} else {
+		datasets, err := aa.Store.GetDatasets()
+		if err != nil {
+			errJSON := api.ErrorInfo{Error: err.Error()}
+			return c.JSON(http.StatusBadRequest, errJSON)
+		}
+		names := make([]string, 0, len(datasets))
+		for name := range datasets {
+			names = append(names, name)
+		}
+		if err := secure.RequireDatasetsAccess(c, names, secure.ADMIN); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if filter.From, err = parseTime(c.QueryParam("from")); err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	if filter.To, err = parseTime(c.QueryParam("to")); err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+
+	if audit.Default == nil {
+		errJSON := api.ErrorInfo{Error: "audit log is not configured"}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	records, err := audit.Default.Query(filter)
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusInternalServerError, errJSON)
+	}
+	return c.JSON(http.StatusOK, records)
+}
@@ -0,0 +1,331 @@
+package cached
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/api"
+	"github.com/connectome-neuprint/neuPrintHTTP/secure"
+	"github.com/labstack/echo/v4"
+)
+
+// setupAdmin adds the routes for inspecting and controlling the cache
+func setupAdmin(mainapi *api.ConnectomeAPI) {
+	q := &cypherAPI{mainapi.Store}
+	mainapi.SetAdminRoute(api.GET, PREFIX+"/admin/entries", q.getEntries)
+	mainapi.SetAdminRoute(api.GET, PREFIX+"/admin/errors", q.getErrors)
+	mainapi.SetAdminRoute(api.POST, PREFIX+"/admin/recompute", q.recompute)
+	mainapi.SetAdminRoute(api.POST, PREFIX+"/admin/evict", q.evict)
+}
+
+// requireAdmin requires admin access to a dataset, or to every dataset if
+// none is given
+func (ca cypherAPI) requireAdmin(c echo.Context, dataset string) error {
+	if dataset != "" {
+		return secure.RequireDatasetAccess(c, dataset, secure.ADMIN)
+	}
+	datasets, err := ca.Store.GetDatasets()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	names := make([]string, 0, len(datasets))
+	for name := range datasets {
+		names = append(names, name)
+	}
+	return secure.RequireDatasetsAccess(c, names, secure.ADMIN)
+}
+
+// checkComputation returns an error for a non-empty unknown computation
+func checkComputation(name string) error {
+	if name == "" {
+		return nil
+	}
+	for _, known := range manager.Computations() {
+		if known == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown cached computation %s", name)
+}
+
+// getEntries lists the cached results
+func (ca cypherAPI) getEntries(c echo.Context) error {
+	// swagger:operation GET /api/cached/admin/entries cached getCacheEntries
+	//
+	// Lists cached results.
+	//
+	// Lists the stored results with their age, size and compute duration.
+	// All datasets are listed unless one is given.
+	//
+	// ---
+	// parameters:
+	// - in: "query"
+	//   name: "dataset"
+	//   description: "only list results for this dataset"
+	// - in: "query"
+	//   name: "name"
+	//   description: "only list results of this computation"
+	// responses:
+	//   200:
+	//     description: "successful operation"
+	//     schema:
+	//       type: "object"
+	//       properties:
+	//         entries:
+	//           type: "array"
+	//           items:
+	//             type: "object"
+	//             properties:
+	//               name:
+	//                 type: "string"
+	//               dataset:
+	//                 type: "string"
+	//               key:
+	//                 type: "string"
+	//               lastEdit:
+	//                 type: "string"
+	//               computed:
+	//                 type: "string"
+	//               ageSeconds:
+	//                 type: "number"
+	//               size:
+	//                 type: "integer"
+	//               durationSeconds:
+	//                 type: "number"
+	//         warmup:
+	//           type: "object"
+	//           description: "warm-up mode (eager, lazy or disabled) of each dataset"
+	// security:
+	// - Bearer: [admin]
+
+	dataset := c.QueryParam("dataset")
+	name := c.QueryParam("name")
+	if err := ca.requireAdmin(c, dataset); err != nil {
+		return err
+	}
+	if err := checkComputation(name); err != nil {
+		return c.JSON(http.StatusBadRequest, api.ErrorInfo{Error: err.Error()})
+	}
+	entries, err := manager.Entries(name, dataset)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, api.ErrorInfo{Error: err.Error()})
+	}
+
+	modes := make(map[string]string)
+	if dataset != "" {
+		modes[dataset] = warmupMode(dataset)
+	} else if datasets, err := ca.Store.GetDatasets(); err == nil {
+		for name := range datasets {
+			modes[name] = warmupMode(name)
+		}
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"entries": entries, "warmup": modes})
+}
+
+// getErrors lists the last error of each failing computation
+func (ca cypherAPI) getErrors(c echo.Context) error {
+	// swagger:operation GET /api/cached/admin/errors cached getCacheErrors
+	//
+	// Lists cached computation errors.
+	//
+	// Lists the last error of each computation and dataset whose latest
+	// attempt failed.  A successful computation clears its error.
+	//
+	// ---
+	// parameters:
+	// - in: "query"
+	//   name: "dataset"
+	//   description: "only list errors for this dataset"
+	// responses:
+	//   200:
+	//     description: "successful operation"
+	//     schema:
+	//       type: "array"
+	//       items:
+	//         type: "object"
+	//         properties:
+	//           name:
+	//             type: "string"
+	//           dataset:
+	//             type: "string"
+	//           key:
+	//             type: "string"
+	//           error:
+	//             type: "string"
+	//           time:
+	//             type: "string"
+	// security:
+	// - Bearer: [admin]
+
+	dataset := c.QueryParam("dataset")
+	if err := ca.requireAdmin(c, dataset); err != nil {
+		return err
+	}
+	errs := manager.Errors()
+	if dataset != "" {
+		filtered := errs[:0]
+		for _, computeErr := range errs {
+			if computeErr.Dataset == dataset {
+				filtered = append(filtered, computeErr)
+			}
+		}
+		errs = filtered
+	}
+	return c.JSON(http.StatusOK, errs)
+}
+
+// recomputeReq selects the results to recompute
+type recomputeReq struct {
+	Dataset string `json:"dataset"`
+	Name    string `json:"name,omitempty"`
+	Key     string `json:"key,omitempty"`
+	Async   bool   `json:"async,omitempty"`
+}
+
+// recompute recomputes one result or all the results of a dataset
+func (ca cypherAPI) recompute(c echo.Context) error {
+	// swagger:operation POST /api/cached/admin/recompute cached recomputeCache
+	//
+	// Recomputes cached results.
+	//
+	// Recomputes one computation for a dataset, or every computation in
+	// dependency order if no name is given.  The default result is
+	// recomputed unless a key is given.  Asynchronous requests return
+	// immediately; failures are reported by /api/cached/admin/errors.
+	//
+	// ---
+	// parameters:
+	// - in: "body"
+	//   name: "body"
+	//   required: true
+	//   schema:
+	//     type: "object"
+	//     required: ["dataset"]
+	//     properties:
+	//       dataset:
+	//         type: "string"
+	//         example: "hemibrain"
+	//       name:
+	//         type: "string"
+	//         description: "computation to recompute"
+	//         example: "roiconnectivity"
+	//       key:
+	//         type: "string"
+	//         description: "key of the result (the default result if empty)"
+	//       async:
+	//         type: "boolean"
+	//         description: "return without waiting for the computation"
+	// responses:
+	//   200:
+	//     description: "the recomputed results"
+	//   202:
+	//     description: "recompute started"
+	// security:
+	// - Bearer: [admin]
+
+	var req recomputeReq
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, api.ErrorInfo{Error: "request object not formatted correctly"})
+	}
+	if req.Dataset == "" {
+		return c.JSON(http.StatusBadRequest, api.ErrorInfo{Error: "dataset must be specified"})
+	}
+	if err := secure.RequireDatasetAccess(c, req.Dataset, secure.ADMIN); err != nil {
+		return err
+	}
+	if err := checkComputation(req.Name); err != nil {
+		return c.JSON(http.StatusBadRequest, api.ErrorInfo{Error: err.Error()})
+	}
+	if req.Name == "" && req.Key != "" {
+		return c.JSON(http.StatusBadRequest, api.ErrorInfo{Error: "a key requires a computation name"})
+	}
+	if warmupMode(req.Dataset) == WarmDisabled {
+		return c.JSON(http.StatusBadRequest, api.ErrorInfo{Error: fmt.Sprintf("cached results are disabled for dataset %s", req.Dataset)})
+	}
+
+	run := func() error {
+		if req.Name == "" {
+			return manager.RecomputeAll(req.Dataset)
+		}
+		key := req.Key
+		if key == "" {
+			key = manager.DefaultKey(req.Name)
+		}
+		_, err := manager.Recompute(req.Name, req.Dataset, key)
+		return err
+	}
+	if req.Async {
+		go func() {
+			if err := run(); err != nil {
+				fmt.Printf("Error recomputing cached results for dataset %s: %v\n", req.Dataset, err)
+			}
+		}()
+		return c.JSON(http.StatusAccepted, map[string]string{"status": "started"})
+	}
+	if err := run(); err != nil {
+		return c.JSON(http.StatusBadRequest, api.ErrorInfo{Error: err.Error()})
+	}
+	entries, err := manager.Entries(req.Name, req.Dataset)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, api.ErrorInfo{Error: err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"entries": entries})
+}
+
+// evictReq selects the results to evict
+type evictReq struct {
+	Dataset string `json:"dataset,omitempty"`
+	Name    string `json:"name,omitempty"`
+}
+
+// evict drops cached results
+func (ca cypherAPI) evict(c echo.Context) error {
+	// swagger:operation POST /api/cached/admin/evict cached evictCache
+	//
+	// Evicts cached results.
+	//
+	// Drops the results of a computation, or of every computation if no
+	// name is given, together with the results depending on them.  All
+	// datasets are evicted unless one is given.
+	//
+	// ---
+	// parameters:
+	// - in: "body"
+	//   name: "body"
+	//   required: true
+	//   schema:
+	//     type: "object"
+	//     properties:
+	//       dataset:
+	//         type: "string"
+	//         example: "hemibrain"
+	//       name:
+	//         type: "string"
+	//         example: "roiconnectivity"
+	// responses:
+	//   200:
+	//     description: "successful operation"
+	// security:
+	// - Bearer: [admin]
+
+	var req evictReq
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, api.ErrorInfo{Error: "request object not formatted correctly"})
+	}
+	if err := ca.requireAdmin(c, req.Dataset); err != nil {
+		return err
+	}
+	if err := checkComputation(req.Name); err != nil {
+		return c.JSON(http.StatusBadRequest, api.ErrorInfo{Error: err.Error()})
+	}
+	names := []string{req.Name}
+	if req.Name == "" {
+		names = manager.Computations()
+	}
+	for _, name := range names {
+		if err := manager.Evict(name, req.Dataset); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorInfo{Error: err.Error()})
+		}
+	}
+	return c.NoContent(http.StatusOK)
+}
@@ -4,20 +4,21 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/json"
-	"math/rand"
 
 	"github.com/connectome-neuprint/neuPrintHTTP/api"
+	"github.com/connectome-neuprint/neuPrintHTTP/cache"
 	"github.com/connectome-neuprint/neuPrintHTTP/secure"
+	"github.com/connectome-neuprint/neuPrintHTTP/skeleton"
 	"github.com/connectome-neuprint/neuPrintHTTP/storage"
-	"github.com/knightjdr/hclust"
 	"github.com/labstack/echo/v4"
 
 	//"math"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -31,171 +32,194 @@ type cypherAPI struct {
 	Store storage.Store
 }
 
-type CacheType int
+// CacheDir is the directory of the badger database keeping cached results
+// across restarts (set from the cache-dir configuration).  Results are kept
+// in memory only if it is empty.
+var CacheDir string
 
-var cachedResults map[CacheType]map[string]interface{}
-var cacheMux sync.RWMutex
+// CacheWorkers bounds the number of cached computations run at once (set
+// from the cache-workers configuration)
+var CacheWorkers = 2
 
-var datasetLastRefresh map[string]time.Time
-var refreshMux sync.RWMutex
+// RefreshInterval is how often the datasets are checked for edits so
+// their cached results can be recomputed before they are requested
+var RefreshInterval = 1 * time.Hour
 
-var roiConnectivityMux sync.Mutex
-var roiCompletenessMux sync.Mutex
-var dailyTypeMux sync.Mutex
+// warm-up modes of a dataset's cached results
+const (
+	WarmEager    = "eager"    // computed at startup and after each edit
+	WarmLazy     = "lazy"     // computed when first requested
+	WarmDisabled = "disabled" // neither computed nor served
+)
+
+// WarmupModes sets the warm-up mode of each dataset (set from the
+// cache-warmup configuration).  Datasets not listed are warmed eagerly.
+var WarmupModes = map[string]string{}
+
+// warmupMode returns the warm-up mode of a dataset
+func warmupMode(dataset string) string {
+	if mode, ok := WarmupModes[dataset]; ok {
+		return mode
+	}
+	return WarmEager
+}
 
+// names of the cached computations
 const (
-	ROIConn   CacheType = 1
-	ROIComp   CacheType = 2
-	DailyType CacheType = 3
+	ROIConn   = "roiconnectivity"
+	ROIComp   = "roicompleteness"
+	DailyType = "dailytype"
 )
 
+// manager computes and caches the results of the cached endpoints
+var manager *cache.Manager
+
+// encodeJSON wraps a computation returning a value so its result is stored
+// as JSON
+func encodeJSON(compute func(dataset string) (interface{}, error)) cache.Func {
+	return func(dataset, key string) ([]byte, error) {
+		res, err := compute(dataset)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(res)
+	}
+}
+
+// cachedResult returns the result of a computation unless caching is
+// disabled for the dataset
+func cachedResult(name, dataset, key string) ([]byte, error) {
+	if warmupMode(dataset) == WarmDisabled {
+		return nil, fmt.Errorf("cached results are disabled for dataset %s", dataset)
+	}
+	return manager.Get(name, dataset, key)
+}
+
 // setupAPI loads all the endpoints for cached
 func setupAPI(mainapi *api.ConnectomeAPI) error {
-	// setup cache
-	cachedResults = make(map[CacheType]map[string]interface{})
-	datasetLastRefresh = make(map[string]time.Time)
-
 	q := &cypherAPI{mainapi.Store}
+	for dataset, mode := range WarmupModes {
+		if mode != WarmEager && mode != WarmLazy && mode != WarmDisabled {
+			return fmt.Errorf("unknown cache warm-up mode %q for dataset %s", mode, dataset)
+		}
+	}
+
+	cache.Register(cache.Computation{
+		Name: ROIConn,
+		// each clustering is cached under the key of its parameters
+		Compute: func(dataset, key string) ([]byte, error) {
+			values, err := url.ParseQuery(key)
+			if err != nil {
+				return nil, err
+			}
+			params, err := parseClusterParams(values)
+			if err != nil {
+				return nil, err
+			}
+			if len(params.ROIs) > 0 {
+				return nil, fmt.Errorf("clusterings of explicit ROIs are not cached")
+			}
+			res, err := q.getROIConnectivity_int(dataset, params)
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(res)
+		},
+	})
+	cache.Register(cache.Computation{Name: ROIComp, Compute: encodeJSON(q.getROICompleteness_int)})
+	cache.Register(cache.Computation{
+		Name: DailyType,
+		Compute: func(dataset, key string) ([]byte, error) {
+			return q.getDailyType_int(dataset, key)
+		},
+		// a new type is picked each day
+		DefaultKey: func() string { return time.Now().UTC().Format(dateFormat) },
+	})
+
+	var persist cache.Persister
+	if CacheDir != "" {
+		var err error
+		if persist, err = cache.OpenBadger(CacheDir); err != nil {
+			return fmt.Errorf("cannot open cache directory %s: %v", CacheDir, err)
+		}
+	}
+	var err error
+	if manager, err = cache.NewManager(mainapi.Store, persist, CacheWorkers); err != nil {
+		return err
+	}
 
 	// roi conenctivity cache
 	endpoint := "roiconnectivity"
 	mainapi.SetRoute(api.GET, PREFIX+"/"+endpoint, q.getROIConnectivity, api.GuardedRoute)
 	mainapi.SupportedEndpoints[endpoint] = true
-	cachedResults[ROIConn] = make(map[string]interface{})
 
 	// roi completeness cache (TODO: connection completeness)
 	endpoint = "roicompleteness"
 	mainapi.SetRoute(api.GET, PREFIX+"/"+endpoint, q.getROICompleteness, api.GuardedRoute)
 	mainapi.SupportedEndpoints[endpoint] = true
-	cachedResults[ROIComp] = make(map[string]interface{})
 
 	// cell type of the data
 	endpoint = "dailytype"
 	mainapi.SetRoute(api.GET, PREFIX+"/"+endpoint, q.getDailyType, api.GuardedRoute)
 	mainapi.SupportedEndpoints[endpoint] = true
-	cachedResults[DailyType] = make(map[string]interface{})
-
-	go func() {
-		// Initial cache population on startup
-		datasets, err := mainapi.Store.GetDatasets()
-		if err == nil {
-			now := time.Now()
-			for dataset, _ := range datasets {
-				// cache roi connectivity
-				if _, err = q.roiConnectivity(dataset); err != nil {
-					fmt.Printf("Error caching roi connectivity for dataset %s: %v\n", dataset, err)
-				} else {
-					fmt.Printf("Cached roi connectivity for dataset %s\n", dataset)
-				}
-
-				// cache roi completeness
-				if _, err = q.roiCompleteness(dataset); err != nil {
-					fmt.Printf("Error caching roi completeness for dataset %s: %v\n", dataset, err)
-				} else {
-					fmt.Printf("Cached roi completeness for dataset %s\n", dataset)
-				}
 
-				// cache daily type
-				if _, err = q.dailyType(dataset); err != nil {
-					fmt.Printf("Error caching daily type for dataset %s: %v\n", dataset, err)
-				} else {
-					fmt.Printf("Cached daily type for dataset %s\n", dataset)
-				}
-
-				// Mark this dataset as refreshed
-				refreshMux.Lock()
-				datasetLastRefresh[dataset] = now
-				refreshMux.Unlock()
-			}
-		}
-
-		// Check for stale cache every hour
-		ticker := time.NewTicker(1 * time.Hour)
-		defer ticker.Stop()
-
-		for range ticker.C {
-			datasets, err := mainapi.Store.GetDatasets()
-			if err != nil {
-				continue
-			}
-
-			now := time.Now()
-			for dataset := range datasets {
-				refreshMux.RLock()
-				lastRefresh, exists := datasetLastRefresh[dataset]
-				refreshMux.RUnlock()
-
-				if !exists {
-					continue // Skip if we don't have refresh info
-				}
-
-				// Add random jitter: 24 hours + (0-10) * 10 minutes
-				jitterMinutes := rand.Intn(11) * 10 // 0, 10, 20, ..., 100 minutes
-				refreshThreshold := 24*time.Hour + time.Duration(jitterMinutes)*time.Minute
-
-				if now.Sub(lastRefresh) >= refreshThreshold {
-					fmt.Printf("Cache expired for dataset %s (age: %v), clearing cache\n",
-						dataset, now.Sub(lastRefresh))
+	// cell types of previous days
+	endpoint = "dailytype/history"
+	mainapi.SetRoute(api.GET, PREFIX+"/"+endpoint, q.getDailyTypeHistory, api.GuardedRoute)
+	mainapi.SupportedEndpoints[endpoint] = true
 
-					// Clear this dataset's cache
-					cacheMux.Lock()
-					delete(cachedResults[ROIConn], dataset)
-					delete(cachedResults[ROIComp], dataset)
-					delete(cachedResults[DailyType], dataset)
-					cacheMux.Unlock()
+	setupAdmin(mainapi)
 
-					// Don't update refresh time - let it refresh on next access
+	go func() {
+		// compute results missing or out of date at startup and then
+		// whenever a dataset is edited
+		for {
+			if datasets, err := mainapi.Store.GetDatasets(); err == nil {
+				names := make([]string, 0, len(datasets))
+				for dataset := range datasets {
+					if warmupMode(dataset) == WarmEager {
+						names = append(names, dataset)
+					}
 				}
+				sort.Strings(names)
+				manager.Warm(names)
 			}
+			time.Sleep(RefreshInterval)
 		}
 	}()
 
 	return nil
 }
 
-// returns how the ROIs connect to each other
-func (ca cypherAPI) roiConnectivity(dataset string) (res interface{}, err error) {
-	roiConnectivityMux.Lock() // Only one roiConnectivity request at a time
-	defer roiConnectivityMux.Unlock()
-
-	cacheMux.RLock()
-	var ok bool
-	if res, ok = cachedResults[ROIConn][dataset]; ok {
-		cacheMux.RUnlock()
-		return
-	}
-	cacheMux.RUnlock()
-
-	res, err = ca.getROIConnectivity_int(dataset)
-	if err != nil {
-		return nil, err
-	}
-
-	cacheMux.Lock()
-	cachedResults[ROIConn][dataset] = res
-	cacheMux.Unlock()
-
-	// Update refresh timestamp when we populate cache
-	refreshMux.Lock()
-	datasetLastRefresh[dataset] = time.Now()
-	refreshMux.Unlock()
-
-	return
-}
-
 // getROIConnectivity provides web handler for how the ROIs connect to each other
 func (ca cypherAPI) getROIConnectivity(c echo.Context) error {
 	// swagger:operation GET /api/cached/roiconnectivity cached getROIConnectivity
 	//
 	// Gets cached synapse connection projections for all neurons.
 	//
-	// The program caches the region connections for each neuron updating everyday.
+	// The program caches the region connections for each neuron, updating
+	// them when the dataset is edited.  The ROIs are ordered by clustering
+	// their connectivity; each combination of clustering parameters is
+	// cached separately, except clusterings of explicit ROIs, which are
+	// computed for each request.
 	//
 	// ---
 	// parameters:
 	// - in: "query"
 	//   name: "dataset"
 	//   description: "specify dataset name"
+	// - in: "query"
+	//   name: "linkage"
+	//   description: "linkage method: single (default), complete, average or ward"
+	// - in: "query"
+	//   name: "distance"
+	//   description: "distance between ROIs: weight (default), count or normalized (weight as a fraction of each ROI's output)"
+	// - in: "query"
+	//   name: "roiset"
+	//   description: "ROIs to cluster: overview (default), primary or super"
+	// - in: "query"
+	//   name: "rois"
+	//   description: "comma-separated ROIs of the dataset to cluster instead of a ROI set"
 	// responses:
 	//   200:
 	//     description: "successful operation"
@@ -220,6 +244,29 @@ func (ca cypherAPI) getROIConnectivity(c echo.Context) error {
 	//                 weight:
 	//                   type: "number"
 	//                   description: "weighted connection strength between two ROIs"
+	//         dendrogram:
+	//           type: "object"
+	//           description: "ROI clustering; node ids below the number of leaves are leaves and node n+i is the i-th merge"
+	//           properties:
+	//             leaves:
+	//               type: "array"
+	//               items:
+	//                 type: "string"
+	//             merges:
+	//               type: "array"
+	//               items:
+	//                 type: "object"
+	//                 properties:
+	//                   left:
+	//                     type: "integer"
+	//                   right:
+	//                     type: "integer"
+	//                   height:
+	//                     type: "number"
+	//                     description: "distance at which the nodes merge"
+	//                   size:
+	//                     type: "integer"
+	//                     description: "number of leaves in the cluster"
 	// security:
 	// - Bearer: []
 
@@ -228,12 +275,36 @@ func (ca cypherAPI) getROIConnectivity(c echo.Context) error {
 		return err
 	}
 
-	res, err := ca.roiConnectivity(dataset)
+	params, err := parseClusterParams(c.QueryParams())
 	if err != nil {
 		errJSON := api.ErrorInfo{Error: err.Error()}
 		return c.JSON(http.StatusBadRequest, errJSON)
 	}
-	return c.JSON(http.StatusOK, res)
+
+	// clusterings of explicit ROIs are computed for each request
+	if len(params.ROIs) > 0 {
+		if warmupMode(dataset) == WarmDisabled {
+			errJSON := api.ErrorInfo{Error: fmt.Sprintf("cached results are disabled for dataset %s", dataset)}
+			return c.JSON(http.StatusBadRequest, errJSON)
+		}
+		if err := checkROIs(ca.Store.GetMain(dataset), params.ROIs); err != nil {
+			errJSON := api.ErrorInfo{Error: err.Error()}
+			return c.JSON(http.StatusBadRequest, errJSON)
+		}
+		res, err := ca.getROIConnectivity_int(dataset, params)
+		if err != nil {
+			errJSON := api.ErrorInfo{Error: err.Error()}
+			return c.JSON(http.StatusBadRequest, errJSON)
+		}
+		return c.JSON(http.StatusOK, res)
+	}
+
+	res, err := cachedResult(ROIConn, dataset, params.key())
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	return c.Blob(http.StatusOK, echo.MIMEApplicationJSON, res)
 }
 
 type prePost struct {
@@ -247,14 +318,13 @@ type CountWeight struct {
 }
 
 type SortedROI struct {
-	Names   []string                `json:"roi_names"` // names in sorted order based on clustering
-	Weights map[string]*CountWeight `json:"weights"`
+	Names      []string                `json:"roi_names"` // names in sorted order based on clustering
+	Weights    map[string]*CountWeight `json:"weights"`
+	Dendrogram *Dendrogram             `json:"dendrogram,omitempty"`
 }
 
-const MAXVAL = 10000000000
-
 // getROIConnectivity_int implements API to find how ROIs are connected
-func (ca cypherAPI) getROIConnectivity_int(dataset string) (interface{}, error) {
+func (ca cypherAPI) getROIConnectivity_int(dataset string, params clusterParams) (interface{}, error) {
 	cypher := `
 		MATCH (neuron :Neuron)
 		RETURN
@@ -266,52 +336,28 @@ func (ca cypherAPI) getROIConnectivity_int(dataset string) (interface{}, error)
 		return nil, err
 	}
 
-	// Restrict results to the overview ROIs.
-	// If Meta.overviewRois is present, use that list.
-	// Otherwise, use the primaryRois by default.
-	// But
-	cypher2 := `
-		MATCH (meta :Meta)
-		WITH
-			CASE meta.overviewRois
-				WHEN NULL THEN meta.primaryRois
-				ELSE meta.overviewRois
-			END AS overviewRois,
-			meta,
-			apoc.convert.fromJsonMap(meta.roiInfo) AS roiInfo
-		UNWIND overviewRois as roi
-		WITH roiInfo, roi
-		WHERE NOT coalesce(roiInfo[roi]['excludeFromOverview'], FALSE)
-		RETURN collect(roi) as rois
-	`
-
-	res2, err := ca.Store.GetMain(dataset).CypherRequest(cypher2, true)
-	if err != nil {
-		return nil, err
-	}
-
-	superrois := make(map[string]int)
-	roinames := make([]string, 0, 0)
-	if len(res2.Data) > 0 {
-		roiarr := res2.Data[0][0].([]interface{})
-		/*var roiarr []string
-		err := json.Unmarshal([]byte(roistr), &roiarr)
+	// Restrict results to the requested ROIs, by default the overview
+	// ROIs: Meta.overviewRois if present and otherwise the primaryRois.
+	roinames := params.ROIs
+	if len(roinames) == 0 {
+		res2, err := ca.Store.GetMain(dataset).CypherRequest(roiSetQueries[params.ROISet], true)
 		if err != nil {
 			return nil, err
-		}*/
-		for idx, roib := range roiarr {
-			roi := roib.(string)
-			roinames = append(roinames, roi)
-			superrois[roi] = idx
 		}
-	}
-	distmatrix := make([][]float64, len(superrois), len(superrois))
-	for idx, _ := range distmatrix {
-		distmatrix[idx] = make([]float64, len(superrois), len(superrois))
-		for idx2, _ := range distmatrix[idx] {
-			distmatrix[idx][idx2] = MAXVAL
+		roinames = make([]string, 0, 0)
+		if len(res2.Data) > 0 {
+			roiarr, _ := res2.Data[0][0].([]interface{})
+			for _, roib := range roiarr {
+				if roi, ok := roib.(string); ok {
+					roinames = append(roinames, roi)
+				}
+			}
 		}
 	}
+	superrois := make(map[string]int)
+	for idx, roi := range roinames {
+		superrois[roi] = idx
+	}
 
 	roitable := make(map[string]*CountWeight)
 
@@ -361,86 +407,43 @@ func (ca cypherAPI) getROIConnectivity_int(dataset string) (interface{}, error)
 							countweight.Count += 1
 							countweight.Weight += perout
 						}
-						idx1 := superrois[roi2]
-						idx2 := superrois[roi]
-						if roitable[key].Weight < 0.001 {
-							distmatrix[idx1][idx2] = MAXVAL
-						} else {
-							distmatrix[idx1][idx2] = MAXVAL - roitable[key].Weight
-						}
 					}
 				}
 			}
 		}
 	}
 
-	cypher3 := `
-	MATCH (m:Meta)
-	RETURN
-		CASE m.overviewOrder
-			WHEN NULL THEN 'clustered'
-			ELSE m.overviewOrder
-		END AS overviewOrder
-	`
-	res3, err := ca.Store.GetMain(dataset).CypherRequest(cypher3, true)
-	if err != nil {
-		return nil, err
-	}
-	var overviewOrder string
-	if len(res3.Data) > 0 {
-		overviewOrder = res3.Data[0][0].(string)
-	}
-
-	// If the dataset wants the overview ROIs to be auto-ordered,
-	// then use clustering to find the order.
-	// Otherwise, stick with the order given by Meta.overviewRois.
-	if len(distmatrix) > 3 && overviewOrder == "clustered" {
-		// sort roi names by clustering
-		subcluster, err := hclust.Cluster(distmatrix, "single")
+	// The overview keeps the order given by Meta.overviewRois unless the
+	// dataset wants the overview ROIs to be auto-ordered.
+	overviewOrder := "clustered"
+	if params.isDefault() {
+		cypher3 := `
+		MATCH (m:Meta)
+		RETURN
+			CASE m.overviewOrder
+				WHEN NULL THEN 'clustered'
+				ELSE m.overviewOrder
+			END AS overviewOrder
+		`
+		res3, err := ca.Store.GetMain(dataset).CypherRequest(cypher3, true)
 		if err != nil {
 			return nil, err
 		}
-		//optcluster := subcluster
-		optcluster := hclust.Optimize(subcluster, distmatrix, 0)
-		tree, err := hclust.Tree(optcluster, roinames)
-		if err != nil {
-			return nil, err
+		if len(res3.Data) > 0 {
+			overviewOrder, _ = res3.Data[0][0].(string)
 		}
-
-		return SortedROI{Names: tree.Order, Weights: roitable}, err
-	} else {
-		return SortedROI{Names: roinames, Weights: roitable}, err
-	}
-}
-
-// roiCompleteness returns the tracing completeness of each ROI
-func (ca cypherAPI) roiCompleteness(dataset string) (res interface{}, err error) {
-	roiCompletenessMux.Lock() // Only one roiCompleteness request at a time
-	defer roiCompletenessMux.Unlock()
-
-	cacheMux.RLock()
-	var ok bool
-	if res, ok = cachedResults[ROIComp][dataset]; ok {
-		cacheMux.RUnlock()
-		return
 	}
-	cacheMux.RUnlock()
 
-	res, err = ca.getROICompleteness_int(dataset)
+	// sort roi names by clustering
+	distmatrix := distanceMatrix(roinames, roitable, params.Distance)
+	order, dendrogram, err := clusterROIs(roinames, distmatrix, params.Linkage)
 	if err != nil {
 		return nil, err
 	}
-
-	cacheMux.Lock()
-	cachedResults[ROIComp][dataset] = res
-	cacheMux.Unlock()
-
-	// Update refresh timestamp when we populate cache
-	refreshMux.Lock()
-	datasetLastRefresh[dataset] = time.Now()
-	refreshMux.Unlock()
-
-	return
+	if overviewOrder != "clustered" {
+		order = roinames
+	}
+	return SortedROI{Names: order, Weights: roitable, Dendrogram: dendrogram}, nil
 }
 
 // getROICompleteness is web handler that provides the tracing completeness of each ROI
@@ -449,7 +452,8 @@ func (ca cypherAPI) getROICompleteness(c echo.Context) error {
 	//
 	// Gets tracing completeness for each ROI.
 	//
-	// The program updates the completeness numbers each day.  Completeness is defined
+	// The program updates the completeness numbers when the dataset is
+	// edited.  Completeness is defined
 	// as "Traced", "Roughly traced", "Prelim Roughly traced", "final", "final (irrelevant)", "Finalized".
 	//
 	// ---
@@ -486,12 +490,12 @@ func (ca cypherAPI) getROICompleteness(c echo.Context) error {
 		return err
 	}
 
-	res, err := ca.roiCompleteness(dataset)
+	res, err := cachedResult(ROIComp, dataset, "")
 	if err != nil {
 		errJSON := api.ErrorInfo{Error: err.Error()}
 		return c.JSON(http.StatusBadRequest, errJSON)
 	}
-	return c.JSON(http.StatusOK, res)
+	return c.Blob(http.StatusOK, echo.MIMEApplicationJSON, res)
 }
 
 var completeStatuses = []string{"Traced", "Roughly traced", "Prelim Roughly traced", "final", "final (irrelevant)", "Finalized", "Leaves"}
@@ -517,56 +521,24 @@ func (ca cypherAPI) getROICompleteness_int(dataset string) (interface{}, error)
 	return ca.Store.GetMain(dataset).CypherRequest(cypher, true)
 }
 
-type SkeletonResp struct {
-	Columns []string        `json:"columns"`
-	Data    [][]interface{} `json:"data"`
-}
-
-// daily type returns information for a different neeuron each day
-func (ca cypherAPI) dailyType(dataset string) (res []byte, err error) {
-	dailyTypeMux.Lock() // Only one dailyType request at a time
-	defer dailyTypeMux.Unlock()
-
-	cacheMux.RLock()
-	if resc, ok := cachedResults[DailyType][dataset]; ok {
-		res, _ = resc.([]byte)
-		cacheMux.RUnlock()
-		return
-	}
-	cacheMux.RUnlock()
-
-	res, err = ca.getDailyType_int(dataset)
-	if err != nil {
-		return nil, err
-	}
-
-	cacheMux.Lock()
-	cachedResults[DailyType][dataset] = res
-	cacheMux.Unlock()
-
-	// Update refresh timestamp when we populate cache
-	refreshMux.Lock()
-	datasetLastRefresh[dataset] = time.Now()
-	refreshMux.Unlock()
-
-	return
-}
-
 // getDailyType is web handler that provides the information for a different neuron each day
 func (ca cypherAPI) getDailyType(c echo.Context) error {
 	// swagger:operation GET /api/cached/dailytype cached getDailyType
 	//
 	// Gets information for a different neuron type each day.
 	//
-	// The program updates the completeness numbers each day.  A different
-	// cell type is randomly picked and an exemplar is chosen
-	// from this type.
+	// A different cell type is picked each day and an exemplar is chosen
+	// from this type.  The type is picked from the dataset name and the
+	// UTC date, so every server shows the same type on the same day.
 	//
 	// ---
 	// parameters:
 	// - in: "query"
 	//   name: "dataset"
 	//   description: "specify dataset name"
+	// - in: "query"
+	//   name: "date"
+	//   description: "UTC day to show formatted as YYYY-MM-DD (default today); previous days must have been shown"
 	// responses:
 	//   200:
 	//     description: "successful operation"
@@ -593,6 +565,8 @@ func (ca cypherAPI) getDailyType(c echo.Context) error {
 	//         skeleton:
 	//           type: "string"
 	//           description: "SWC contents for the chosen neuron"
+	//   404:
+	//     description: "no type was shown on the given day"
 	// security:
 	// - Bearer: []
 
@@ -601,7 +575,27 @@ func (ca cypherAPI) getDailyType(c echo.Context) error {
 		return err
 	}
 
-	res, err := ca.dailyType(dataset)
+	now := time.Now().UTC()
+	date, err := parseDay(c.QueryParam("date"), now)
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+
+	// previous days are shown only if their type was recorded when served
+	if date != now.Format(dateFormat) {
+		recorded, err := manager.Recorded(DailyType, dataset, date)
+		if err != nil {
+			errJSON := api.ErrorInfo{Error: err.Error()}
+			return c.JSON(http.StatusInternalServerError, errJSON)
+		}
+		if recorded == nil {
+			errJSON := api.ErrorInfo{Error: fmt.Sprintf("no type was shown on %s", date)}
+			return c.JSON(http.StatusNotFound, errJSON)
+		}
+	}
+
+	res, err := cachedResult(DailyType, dataset, date)
 	if err != nil {
 		errJSON := api.ErrorInfo{Error: err.Error()}
 		return c.JSON(http.StatusBadRequest, errJSON)
@@ -610,24 +604,85 @@ func (ca cypherAPI) getDailyType(c echo.Context) error {
 	return c.Blob(http.StatusOK, "application/json", res)
 }
 
-func (ca cypherAPI) getDailyType_int(dataset string) ([]byte, error) {
-	requester := ca.Store.GetMain(dataset)
+// getDailyTypeHistory is web handler listing the cell types of previous days
+func (ca cypherAPI) getDailyTypeHistory(c echo.Context) error {
+	// swagger:operation GET /api/cached/dailytype/history cached getDailyTypeHistory
+	//
+	// Lists the cell types of previous days.
+	//
+	// Lists the type served for each day, most recent first, as recorded
+	// when the day's type was first requested.  Days whose type was never
+	// requested are left out.
+	//
+	// ---
+	// parameters:
+	// - in: "query"
+	//   name: "dataset"
+	//   description: "specify dataset name"
+	// - in: "query"
+	//   name: "date"
+	//   description: "most recent UTC day to list formatted as YYYY-MM-DD (default today)"
+	// - in: "query"
+	//   name: "days"
+	//   description: "number of days to list (default 7)"
+	// responses:
+	//   200:
+	//     description: "successful operation"
+	//     schema:
+	//       type: "array"
+	//       items:
+	//         type: "object"
+	//         properties:
+	//           date:
+	//             type: "string"
+	//           typename:
+	//             type: "string"
+	// security:
+	// - Bearer: []
 
-	// find a random cell typee
-	random_query := "MATCH (n :Neuron) WHERE (n.cropped IS NULL OR not n.cropped) AND n.status IN [\"Traced\",\"Anchor\"] WITH percentileDisc(n.pre, 0.2) AS prethres, percentileDisc(n.post, 0.2) AS postthres MATCH (n :Neuron) WHERE (n.cropped IS NULL OR not n.cropped) AND n.status IN [\"Traced\",\"Anchor\"] AND EXISTS(n.type) AND n.type<>\"\" AND (n.pre > prethres OR n.post > postthres) WITH n.type as type, collect(n.bodyId) as bodylist WITH type, rand() AS randvar RETURN type ORDER BY randvar LIMIT 1"
+	dataset := c.QueryParam("dataset")
+	if err := secure.RequireDatasetAccess(c, dataset, secure.READ); err != nil {
+		return err
+	}
+	if warmupMode(dataset) == WarmDisabled {
+		errJSON := api.ErrorInfo{Error: fmt.Sprintf("cached results are disabled for dataset %s", dataset)}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
 
-	rand_res, err := requester.CypherRequest(random_query, true)
+	date, err := parseDay(c.QueryParam("date"), time.Now().UTC())
 	if err != nil {
-		return nil, err
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	day, _ := time.Parse(dateFormat, date)
+	days := 7
+	if daysStr := c.QueryParam("days"); daysStr != "" {
+		if days, err = strconv.Atoi(daysStr); err != nil || days < 1 || days > MaxHistoryDays {
+			errJSON := api.ErrorInfo{Error: fmt.Sprintf("days must be between 1 and %d", MaxHistoryDays)}
+			return c.JSON(http.StatusBadRequest, errJSON)
+		}
 	}
 
-	if len(rand_res.Data) == 0 {
-		return nil, fmt.Errorf("no cell type exists")
+	history, err := typeHistory(dataset, day, days)
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusInternalServerError, errJSON)
 	}
+	return c.JSON(http.StatusOK, history)
+}
 
-	typename, ok := rand_res.Data[0][0].(string)
-	if !ok {
-		return nil, fmt.Errorf("cell type could not be parsed")
+// getDailyType_int fetches the information for the type of the given day
+func (ca cypherAPI) getDailyType_int(dataset, date string) ([]byte, error) {
+	requester := ca.Store.GetMain(dataset)
+
+	// pick the cell type of the day
+	types, err := candidateTypes(requester)
+	if err != nil {
+		return nil, err
+	}
+	typename, err := dayType(types, dataset, date, time.Now().UTC().Format(dateFormat))
+	if err != nil {
+		return nil, err
 	}
 
 	// get an exemplar body
@@ -695,7 +750,7 @@ func (ca cypherAPI) getDailyType_int(dataset string) ([]byte, error) {
 	}
 
 	// fetch skeleton or empty string
-	skeleton := &SkeletonResp{}
+	var skel *skeleton.Table
 
 	// get key value store
 	store, err := ca.Store.FindStore("skeletons", dataset)
@@ -715,46 +770,12 @@ func (ca cypherAPI) getDailyType_int(dataset string) ([]byte, error) {
 		fmt.Printf("skeleton size retrieved: %d\n", len(res))
 
 		if err == nil && len(res) > 0 {
-			// copied from skeleton API
-			// parse and write out json
-			buffer := bytes.NewBuffer(res)
-
-			data := make([][]interface{}, 0)
-			columns := []string{"rowId", "x", "y", "z", "radius", "link"}
-			for {
-				line, err := buffer.ReadString('\n')
-				if err != nil {
-					break
-				}
-
-				entries := strings.Fields(line)
-				if len(entries) == 0 {
-					continue
-				}
-				// skip comments
-				if entries[0][0] == '#' {
-					continue
-				}
-
-				if len(entries) != 7 {
-					return nil, fmt.Errorf("SWC not formatted properly")
-				}
-
-				rownum, _ := strconv.Atoi(entries[0])
-				xloc, _ := strconv.ParseFloat(entries[2], 64)
-				yloc, _ := strconv.ParseFloat(entries[3], 64)
-				zloc, _ := strconv.ParseFloat(entries[4], 64)
-				radius, _ := strconv.ParseFloat(entries[5], 64)
-				link, _ := strconv.Atoi(entries[6])
-
-				data = append(data, []interface{}{rownum, xloc, yloc, zloc, radius, link})
+			parsed, err := skeleton.ParseStoredSWC(res)
+			if err != nil {
+				return nil, fmt.Errorf("SWC not formatted properly: %v", err)
 			}
-			skeleton = &SkeletonResp{columns, data}
-		} else {
-			skeleton = nil
+			skel = parsed.Table()
 		}
-	} else {
-		skeleton = nil
 	}
 
 	output := make(map[string]interface{})
@@ -762,12 +783,13 @@ func (ca cypherAPI) getDailyType_int(dataset string) ([]byte, error) {
 
 	info := make(map[string]interface{})
 	info["typename"] = typename
+	info["date"] = date
 	info["numtype"] = numtype
 	info["numpre"] = numpre
 	info["numpost"] = numpost
 	info["bodyid"] = strconv.FormatInt(bodyid, 10)
 	output["info"] = info
-	output["skeleton"] = skeleton
+	output["skeleton"] = skel
 
 	// write to json string and compress to gzip
 	json_output, err := json.Marshal(output)
@@ -0,0 +1,261 @@
+package cached
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+	"github.com/knightjdr/hclust"
+)
+
+// linkage methods and distance definitions for clustering ROIs
+var (
+	linkages  = map[string]bool{"single": true, "complete": true, "average": true, "ward": true}
+	distances = map[string]bool{"weight": true, "count": true, "normalized": true}
+)
+
+// roiSetQueries fetch the named ROI sets from :Meta.  The overview set is
+// the overviewRois (primaryRois if missing) not excluded from the overview.
+var roiSetQueries = map[string]string{
+	"overview": `
+		MATCH (meta :Meta)
+		WITH
+			CASE meta.overviewRois
+				WHEN NULL THEN meta.primaryRois
+				ELSE meta.overviewRois
+			END AS overviewRois,
+			meta,
+			apoc.convert.fromJsonMap(meta.roiInfo) AS roiInfo
+		UNWIND overviewRois as roi
+		WITH roiInfo, roi
+		WHERE NOT coalesce(roiInfo[roi]['excludeFromOverview'], FALSE)
+		RETURN collect(roi) as rois
+	`,
+	"primary": "MATCH (m :Meta) RETURN m.primaryRois",
+	"super":   "MATCH (m :Meta) RETURN m.superLevelRois",
+}
+
+// ROIInfoQuery fetches the ROIs of a dataset
+const ROIInfoQuery = "MATCH (m :Meta) RETURN m.roiInfo"
+
+// clusterParams select how ROIs are clustered by their connectivity
+type clusterParams struct {
+	Linkage  string
+	Distance string
+	ROISet   string
+	ROIs     []string // explicit ROIs in sorted order, overriding ROISet
+}
+
+// defaultCluster is the clustering of the overview page
+var defaultCluster = clusterParams{Linkage: "single", Distance: "weight", ROISet: "overview"}
+
+// isDefault reports whether the parameters are the defaults
+func (p clusterParams) isDefault() bool {
+	return p.Linkage == defaultCluster.Linkage && p.Distance == defaultCluster.Distance &&
+		p.ROISet == defaultCluster.ROISet && len(p.ROIs) == 0
+}
+
+// key identifies the cached result for the parameters.  The defaults use
+// the empty key so requests without parameters share the warmed result.
+// Clusterings of explicit ROIs are not cached, so the keys stay few.
+func (p clusterParams) key() string {
+	if p.isDefault() {
+		return ""
+	}
+	values := url.Values{}
+	values.Set("linkage", p.Linkage)
+	values.Set("distance", p.Distance)
+	values.Set("roiset", p.ROISet)
+	return values.Encode()
+}
+
+// checkROIs returns an error naming the given ROIs that are not ROIs of the
+// dataset
+func checkROIs(cypher storage.Cypher, rois []string) error {
+	res, err := cypher.CypherRequest(ROIInfoQuery, true)
+	if err != nil {
+		return err
+	}
+	var known map[string]interface{}
+	if len(res.Data) > 0 && len(res.Data[0]) > 0 {
+		if info, ok := res.Data[0][0].(string); ok {
+			if err := json.Unmarshal([]byte(info), &known); err != nil {
+				return fmt.Errorf("ROI info not formatted properly: %v", err)
+			}
+		}
+	}
+	var unknown []string
+	for _, roi := range rois {
+		if _, ok := known[roi]; !ok {
+			unknown = append(unknown, roi)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown ROIs: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// parseClusterParams reads the linkage, distance, roiset and rois
+// parameters, where rois is a comma-separated list of ROI names
+func parseClusterParams(values url.Values) (clusterParams, error) {
+	params := defaultCluster
+	if linkage := values.Get("linkage"); linkage != "" {
+		if !linkages[linkage] {
+			return params, fmt.Errorf("linkage must be single, complete, average or ward")
+		}
+		params.Linkage = linkage
+	}
+	if distance := values.Get("distance"); distance != "" {
+		if !distances[distance] {
+			return params, fmt.Errorf("distance must be weight, count or normalized")
+		}
+		params.Distance = distance
+	}
+	if roiSet := values.Get("roiset"); roiSet != "" {
+		if _, ok := roiSetQueries[roiSet]; !ok {
+			return params, fmt.Errorf("roiset must be overview, primary or super")
+		}
+		params.ROISet = roiSet
+	}
+	if rois := values.Get("rois"); rois != "" {
+		seen := make(map[string]bool)
+		for _, roi := range strings.Split(rois, ",") {
+			roi = strings.TrimSpace(roi)
+			if roi != "" && !seen[roi] {
+				seen[roi] = true
+				params.ROIs = append(params.ROIs, roi)
+			}
+		}
+		if len(params.ROIs) < 2 {
+			return params, fmt.Errorf("at least two ROIs must be given")
+		}
+		sort.Strings(params.ROIs)
+	}
+	return params, nil
+}
+
+// Merge joins two nodes of a dendrogram.  Nodes below the number of leaves
+// are leaves; node n+i is the cluster formed by the i-th merge.
+type Merge struct {
+	Left   int     `json:"left"`
+	Right  int     `json:"right"`
+	Height float64 `json:"height"` // distance at which the nodes merge
+	Size   int     `json:"size"`   // number of leaves in the cluster
+}
+
+// Dendrogram is the clustering of ROIs by their connectivity
+type Dendrogram struct {
+	Leaves []string `json:"leaves"`
+	Merges []Merge  `json:"merges"`
+}
+
+// distanceMatrix returns the symmetric distances between ROIs from their
+// connection table, where closely connected ROIs are near each other
+func distanceMatrix(names []string, weights map[string]*CountWeight, distance string) [][]float64 {
+	n := len(names)
+	strength := func(in, out string) float64 {
+		countweight, ok := weights[in+"=>"+out]
+		if !ok {
+			return 0
+		}
+		if distance == "count" {
+			return float64(countweight.Count)
+		}
+		return countweight.Weight
+	}
+
+	// the stronger direction of each pair determines the distance
+	totals := make([]float64, n)
+	if distance == "normalized" {
+		for i, in := range names {
+			for _, out := range names {
+				totals[i] += strength(in, out)
+			}
+		}
+	}
+	similarity := make([][]float64, n)
+	maxSimilarity := 0.0
+	for i := range names {
+		similarity[i] = make([]float64, n)
+		for j := range names {
+			ij, ji := strength(names[i], names[j]), strength(names[j], names[i])
+			if distance == "normalized" {
+				ij, ji = 0, 0
+				if totals[i] > 0 {
+					ij = strength(names[i], names[j]) / totals[i]
+				}
+				if totals[j] > 0 {
+					ji = strength(names[j], names[i]) / totals[j]
+				}
+			}
+			similarity[i][j] = max(ij, ji)
+			maxSimilarity = max(maxSimilarity, similarity[i][j])
+		}
+	}
+	if distance == "normalized" {
+		maxSimilarity = 1
+	}
+
+	matrix := make([][]float64, n)
+	for i := range matrix {
+		matrix[i] = make([]float64, n)
+		for j := range matrix[i] {
+			if i != j {
+				matrix[i][j] = maxSimilarity - similarity[i][j]
+			}
+		}
+	}
+	return matrix
+}
+
+// clusterROIs clusters ROIs with the given linkage and returns the leaf
+// order and the dendrogram
+func clusterROIs(names []string, matrix [][]float64, linkage string) ([]string, *Dendrogram, error) {
+	n := len(names)
+	if n < 2 {
+		return names, nil, nil
+	}
+
+	// the clustering extends the rows of the matrix it is given
+	input := make([][]float64, n)
+	for i := range matrix {
+		input[i] = append([]float64(nil), matrix[i]...)
+	}
+	subcluster, err := hclust.Cluster(input, linkage)
+	if err != nil {
+		return nil, nil, err
+	}
+	if n > 3 {
+		subcluster = hclust.Optimize(subcluster, matrix, 0)
+	}
+	tree, err := hclust.Tree(subcluster, names)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// branch lengths are half the merge distance less the child's height
+	dendrogram := &Dendrogram{Leaves: names, Merges: make([]Merge, len(subcluster))}
+	ids := make(map[int]int, len(subcluster))
+	heights := make(map[int]float64, len(subcluster))
+	sizes := make(map[int]int, len(subcluster))
+	child := func(node int) (int, float64, int) {
+		if node < n {
+			return node, 0, 1
+		}
+		return ids[node], heights[node], sizes[node]
+	}
+	for i, merge := range subcluster {
+		left, leftHeight, leftSize := child(merge.Leafa)
+		right, _, rightSize := child(merge.Leafb)
+		height := merge.Lengtha + leftHeight
+		ids[merge.Node] = n + i
+		heights[merge.Node] = height
+		sizes[merge.Node] = leftSize + rightSize
+		dendrogram.Merges[i] = Merge{Left: left, Right: right, Height: 2 * height, Size: leftSize + rightSize}
+	}
+	return tree.Order, dendrogram, nil
+}
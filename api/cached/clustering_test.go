@@ -0,0 +1,130 @@
+package cached
+
+import (
+	"net/url"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestClusterParams(t *testing.T) {
+	params, err := parseClusterParams(url.Values{})
+	if err != nil || params.key() != "" {
+		t.Fatalf("expected default parameters with empty key, got %+v (%v)", params, err)
+	}
+
+	values := url.Values{"linkage": {"ward"}, "distance": {"normalized"}, "rois": {"b, a,b"}}
+	params, err = parseClusterParams(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(params.ROIs, []string{"a", "b"}) {
+		t.Errorf("unexpected ROIs %v", params.ROIs)
+	}
+	params.ROIs = nil
+	keyValues, err := url.ParseQuery(params.key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed, err := parseClusterParams(keyValues); err != nil || !reflect.DeepEqual(parsed, params) {
+		t.Errorf("key %q parsed to %+v (%v)", params.key(), parsed, err)
+	}
+
+	for _, bad := range []url.Values{
+		{"linkage": {"centroid"}},
+		{"distance": {"euclidean"}},
+		{"roiset": {"all"}},
+		{"rois": {"a"}},
+	} {
+		if _, err := parseClusterParams(bad); err == nil {
+			t.Errorf("expected error for %v", bad)
+		}
+	}
+}
+
+func TestCheckROIs(t *testing.T) {
+	cypher := &typesCypher{types: []interface{}{`{"a": {"pre": 1}, "b(R)": {}}`}}
+	if err := checkROIs(cypher, []string{"a", "b(R)"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := checkROIs(cypher, []string{"a", "c", "d"}); err == nil || err.Error() != "unknown ROIs: c, d" {
+		t.Errorf("expected unknown ROIs to be named, got %v", err)
+	}
+	if cypher.query != ROIInfoQuery {
+		t.Errorf("unexpected query %q", cypher.query)
+	}
+}
+
+func TestClusterROIs(t *testing.T) {
+	// a and b are strongly connected, c and d weakly, e barely
+	names := []string{"a", "c", "e", "b", "d"}
+	weights := map[string]*CountWeight{
+		"a=>b": {Count: 10, Weight: 100},
+		"b=>a": {Count: 8, Weight: 60},
+		"c=>d": {Count: 5, Weight: 40},
+		"a=>c": {Count: 2, Weight: 10},
+		"d=>e": {Count: 1, Weight: 1},
+	}
+
+	matrix := distanceMatrix(names, weights, "weight")
+	for i := range matrix {
+		if matrix[i][i] != 0 {
+			t.Errorf("nonzero self distance for %s", names[i])
+		}
+		for j := range matrix {
+			if matrix[i][j] != matrix[j][i] {
+				t.Errorf("asymmetric distance between %s and %s", names[i], names[j])
+			}
+		}
+	}
+	if matrix[0][3] != 0 || matrix[1][4] != 60 {
+		t.Errorf("unexpected distances %v", matrix)
+	}
+
+	for _, linkage := range []string{"single", "complete", "average", "ward"} {
+		order, dendrogram, err := clusterROIs(names, matrix, linkage)
+		if err != nil {
+			t.Fatalf("%s: %v", linkage, err)
+		}
+		sorted := append([]string(nil), order...)
+		sort.Strings(sorted)
+		if !reflect.DeepEqual(sorted, []string{"a", "b", "c", "d", "e"}) {
+			t.Errorf("%s: unexpected order %v", linkage, order)
+		}
+		if len(dendrogram.Merges) != len(names)-1 {
+			t.Fatalf("%s: expected %d merges, got %d", linkage, len(names)-1, len(dendrogram.Merges))
+		}
+		first := dendrogram.Merges[0]
+		if pair := []string{names[first.Left], names[first.Right]}; !reflect.DeepEqual(pair, []string{"a", "b"}) && !reflect.DeepEqual(pair, []string{"b", "a"}) {
+			t.Errorf("%s: expected a and b to merge first, got %v", linkage, pair)
+		}
+		root := dendrogram.Merges[len(dendrogram.Merges)-1]
+		if root.Size != len(names) {
+			t.Errorf("%s: root has %d leaves", linkage, root.Size)
+		}
+		for i, merge := range dendrogram.Merges {
+			for _, node := range []int{merge.Left, merge.Right} {
+				if node >= len(names)+i {
+					t.Errorf("%s: merge %d refers to later node %d", linkage, i, node)
+				}
+				if node >= len(names) && dendrogram.Merges[node-len(names)].Height > merge.Height+1e-9 {
+					t.Errorf("%s: merge %d is lower than its child", linkage, i)
+				}
+			}
+		}
+	}
+
+	// single linkage merges at the smallest distance between the clusters
+	_, dendrogram, _ := clusterROIs(names, matrix, "single")
+	var heights []float64
+	for _, merge := range dendrogram.Merges {
+		heights = append(heights, merge.Height)
+	}
+	if !reflect.DeepEqual(heights, []float64{0, 60, 90, 99}) {
+		t.Errorf("unexpected single linkage heights %v", heights)
+	}
+
+	if _, dendrogram, err := clusterROIs([]string{"a"}, [][]float64{{0}}, "single"); err != nil || dendrogram != nil {
+		t.Errorf("expected no dendrogram for one ROI")
+	}
+}
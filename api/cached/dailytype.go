@@ -0,0 +1,162 @@
+package cached
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+)
+
+// dateFormat is the format of the days the type of the day is keyed by
+const dateFormat = "2006-01-02"
+
+// MaxHistoryDays bounds the days listed by the daily type history
+const MaxHistoryDays = 366
+
+// TypeRules restrict the neurons whose types can be the type of the day
+type TypeRules struct {
+	// Statuses are the allowed neuron statuses; any status if empty
+	Statuses []string
+
+	// AllowCropped admits neurons marked as cropped
+	AllowCropped bool
+
+	// MinPercentile requires neurons to exceed this percentile of the
+	// pre or post synapse counts of the allowed neurons; 0 admits all
+	MinPercentile float64
+}
+
+// DailyTypeRules are the rules for picking the type of the day (set from
+// the dailytype configuration)
+var DailyTypeRules = TypeRules{Statuses: []string{"Traced", "Anchor"}, MinPercentile: 0.2}
+
+// candidateQuery returns the query for the cell types allowed by the rules
+// in sorted order, and its parameters
+func (rules TypeRules) candidateQuery() (string, map[string]interface{}) {
+	conds := []string{}
+	params := map[string]interface{}{}
+	if !rules.AllowCropped {
+		conds = append(conds, "(n.cropped IS NULL OR not n.cropped)")
+	}
+	if len(rules.Statuses) > 0 {
+		statuses := make([]interface{}, len(rules.Statuses))
+		for i, status := range rules.Statuses {
+			statuses[i] = status
+		}
+		conds = append(conds, "n.status IN $statuses")
+		params["statuses"] = statuses
+	}
+	typed := append(append([]string(nil), conds...), "EXISTS(n.type)", "n.type<>\"\"")
+
+	if rules.MinPercentile <= 0 {
+		return "MATCH (n :Neuron) WHERE " + strings.Join(typed, " AND ") + " RETURN DISTINCT n.type AS type ORDER BY type", params
+	}
+	params["percentile"] = rules.MinPercentile
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ") + " "
+	}
+	query := "MATCH (n :Neuron) " + where + "WITH percentileDisc(n.pre, $percentile) AS prethres, percentileDisc(n.post, $percentile) AS postthres " +
+		"MATCH (n :Neuron) WHERE " + strings.Join(typed, " AND ") + " AND (n.pre > prethres OR n.post > postthres) " +
+		"RETURN DISTINCT n.type AS type ORDER BY type"
+	return query, params
+}
+
+// candidateTypes returns the sorted cell types that can be the type of the
+// day for a dataset
+func candidateTypes(requester storage.Cypher) ([]string, error) {
+	query, params := DailyTypeRules.candidateQuery()
+	res, err := requester.CypherRequestWithParams(query, params, true)
+	if err != nil {
+		return nil, err
+	}
+	types := make([]string, 0, len(res.Data))
+	for _, row := range res.Data {
+		if len(row) > 0 {
+			if typename, ok := row[0].(string); ok {
+				types = append(types, typename)
+			}
+		}
+	}
+	if len(types) == 0 {
+		return nil, fmt.Errorf("no cell type exists")
+	}
+	return types, nil
+}
+
+// pickType deterministically picks the type of the day from the sorted
+// candidates, so every server shows the same type for a dataset and date
+func pickType(types []string, dataset, date string) string {
+	hash := fnv.New64a()
+	hash.Write([]byte(dataset + "\x00" + date))
+	return types[hash.Sum64()%uint64(len(types))]
+}
+
+// parseDay returns the day of a date parameter, today if it is empty.
+// Future days are rejected.
+func parseDay(date string, now time.Time) (string, error) {
+	today := now.Format(dateFormat)
+	if date == "" {
+		return today, nil
+	}
+	day, err := time.Parse(dateFormat, date)
+	if err != nil {
+		return "", fmt.Errorf("date must be formatted as YYYY-MM-DD")
+	}
+	if day.Format(dateFormat) > today {
+		return "", fmt.Errorf("date %s is in the future", date)
+	}
+	return day.Format(dateFormat), nil
+}
+
+// TypeOfDay is the type picked for a day
+type TypeOfDay struct {
+	Date     string `json:"date"`
+	TypeName string `json:"typename"`
+}
+
+// dayType returns the type of a day: the type recorded when the day was
+// first served, so it survives edits to the dataset, or else a new pick,
+// which is recorded only if the day is today.  A recorded type that is no
+// longer a candidate is served as a new pick but stays in the history.
+func dayType(types []string, dataset, date, today string) (string, error) {
+	picked := pickType(types, dataset, date)
+	if manager == nil {
+		return picked, nil
+	}
+	var kept []byte
+	var err error
+	if date == today {
+		kept, err = manager.Record(DailyType, dataset, date, []byte(picked))
+	} else {
+		kept, err = manager.Recorded(DailyType, dataset, date)
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, typename := range types {
+		if typename == string(kept) {
+			return typename, nil
+		}
+	}
+	return picked, nil
+}
+
+// typeHistory returns the types recorded for the days before and including
+// the given day, most recent first, skipping days never served
+func typeHistory(dataset string, day time.Time, days int) ([]TypeOfDay, error) {
+	history := []TypeOfDay{}
+	for i := 0; i < days; i++ {
+		date := day.AddDate(0, 0, -i).Format(dateFormat)
+		typename, err := manager.Recorded(DailyType, dataset, date)
+		if err != nil {
+			return nil, err
+		}
+		if typename != nil {
+			history = append(history, TypeOfDay{Date: date, TypeName: string(typename)})
+		}
+	}
+	return history, nil
+}
@@ -0,0 +1,125 @@
+package cached
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/cache"
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+)
+
+// typesCypher answers every query with one row per value of types
+type typesCypher struct {
+	types  []interface{}
+	query  string
+	params map[string]interface{}
+}
+
+func (m *typesCypher) CypherRequest(query string, readonly bool) (storage.CypherResult, error) {
+	return m.CypherRequestWithParams(query, nil, readonly)
+}
+func (m *typesCypher) CypherRequestWithParams(query string, params map[string]interface{}, readonly bool) (storage.CypherResult, error) {
+	m.query, m.params = query, params
+	res := storage.CypherResult{Columns: []string{"type"}}
+	for _, typename := range m.types {
+		res.Data = append(res.Data, []interface{}{typename})
+	}
+	return res, nil
+}
+func (m *typesCypher) StartTrans() (storage.CypherTransaction, error) { return nil, nil }
+
+func TestPickTypeIsDeterministic(t *testing.T) {
+	types := []string{"a", "b", "c", "d", "e", "f", "g"}
+	if pickType(types, "hemibrain", "2024-05-01") != pickType(types, "hemibrain", "2024-05-01") {
+		t.Error("expected the same type for the same dataset and date")
+	}
+	picked := make(map[string]bool)
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 30; i++ {
+		picked[pickType(types, "hemibrain", day.AddDate(0, 0, -i).Format(dateFormat))] = true
+	}
+	if len(picked) < 3 {
+		t.Errorf("expected the type to change between days, got %v", picked)
+	}
+}
+
+func TestTypeHistory(t *testing.T) {
+	defer func(m *cache.Manager) { manager = m }(manager)
+	var err error
+	if manager, err = cache.NewManager(&storage.NoStore{}, nil, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	types := []string{"a", "b", "c", "d", "e", "f", "g"}
+	first, _ := dayType(types, "hemibrain", "2024-05-01", "2024-05-01")
+	dayType(types, "hemibrain", "2024-04-29", "2024-04-29")
+
+	// the served type is kept when the candidates change
+	changed := []string{"h", "i", "j", first}
+	if typename, _ := dayType(changed, "hemibrain", "2024-05-01", "2024-05-02"); typename != first {
+		t.Errorf("expected the recorded type %s, got %s", first, typename)
+	}
+	if typename, _ := dayType([]string{"z"}, "hemibrain", "2024-05-01", "2024-05-02"); typename != "z" {
+		t.Errorf("expected a new pick for a removed type, got %s", typename)
+	}
+
+	// picks for days other than today are not recorded
+	dayType(types, "hemibrain", "2024-04-30", "2024-05-02")
+	if recorded, err := manager.Recorded(DailyType, "hemibrain", "2024-04-30"); err != nil || recorded != nil {
+		t.Errorf("expected no record for a previous day, got %q (%v)", recorded, err)
+	}
+
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	history, err := typeHistory("hemibrain", day, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TypeOfDay{{"2024-05-01", first}, {"2024-04-29", pickType(types, "hemibrain", "2024-04-29")}}
+	if !reflect.DeepEqual(history, want) {
+		t.Errorf("history %v, want %v", history, want)
+	}
+	if history, _ := typeHistory("manc", day, 7); len(history) != 0 {
+		t.Errorf("expected no history for an unserved dataset, got %v", history)
+	}
+}
+
+func TestParseDay(t *testing.T) {
+	now := time.Date(2024, 5, 1, 15, 0, 0, 0, time.UTC)
+	for date, want := range map[string]string{"": "2024-05-01", "2024-05-01": "2024-05-01", "2023-12-31": "2023-12-31"} {
+		if got, err := parseDay(date, now); err != nil || got != want {
+			t.Errorf("parseDay(%q) = %q (%v), want %q", date, got, err, want)
+		}
+	}
+	for _, date := range []string{"2024-05-02", "05/01/2024", "2024-13-01"} {
+		if _, err := parseDay(date, now); err == nil {
+			t.Errorf("expected error for %q", date)
+		}
+	}
+}
+
+func TestCandidateTypes(t *testing.T) {
+	defer func(rules TypeRules) { DailyTypeRules = rules }(DailyTypeRules)
+	cypher := &typesCypher{types: []interface{}{"a", nil, "b"}}
+
+	types, err := candidateTypes(cypher)
+	if err != nil || !reflect.DeepEqual(types, []string{"a", "b"}) {
+		t.Fatalf("unexpected types %v (%v)", types, err)
+	}
+	if !strings.Contains(cypher.query, "percentileDisc") || !strings.Contains(cypher.query, "cropped") ||
+		cypher.params["percentile"] != 0.2 || !reflect.DeepEqual(cypher.params["statuses"], []interface{}{"Traced", "Anchor"}) {
+		t.Errorf("unexpected default query %q with %v", cypher.query, cypher.params)
+	}
+
+	DailyTypeRules = TypeRules{AllowCropped: true}
+	candidateTypes(cypher)
+	if strings.Contains(cypher.query, "percentileDisc") || strings.Contains(cypher.query, "cropped") || strings.Contains(cypher.query, "status") {
+		t.Errorf("unexpected unrestricted query %q", cypher.query)
+	}
+
+	cypher.types = nil
+	if _, err := candidateTypes(cypher); err == nil {
+		t.Error("expected error without types")
+	}
+}
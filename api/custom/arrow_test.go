@@ -31,6 +31,10 @@ func (m MockCypher) CypherRequest(cypher string, useJSONNumbers bool) (storage.C
 	}, nil
 }
 
+func (m MockCypher) CypherRequestWithParams(cypher string, params map[string]interface{}, useJSONNumbers bool) (storage.CypherResult, error) {
+	return m.CypherRequest(cypher, useJSONNumbers)
+}
+
 func (m MockCypher) StartTrans() (storage.CypherTransaction, error) {
 	return nil, nil
 }
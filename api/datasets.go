@@ -0,0 +1,140 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+	"github.com/labstack/echo/v4"
+)
+
+// DatasetHeader reports the dataset version a request was answered from
+const DatasetHeader = "X-Neuprint-Dataset"
+
+// MaxDatasetScanBytes bounds how much of a JSON request body is read
+// looking for the dataset field.  Bodies with the field further in are
+// passed on unresolved.
+const MaxDatasetScanBytes = 1 << 20
+
+// ResolveDatasets rewrites dataset names given without a tag, or with the
+// latest tag, to the version they refer to before the handler sees them.
+// Names are read from the dataset path and query parameters and the
+// top-level dataset field of application/json request bodies, which are
+// read only as far as that field; other bodies are left unread.
+// The resolved name is reported in the DatasetHeader response header.
+func ResolveDatasets(store storage.SimpleStore) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			resolve := func(name string) string {
+				if name == "" {
+					return name
+				}
+				resolved, err := storage.ResolveDataset(store, name)
+				if err != nil {
+					return name
+				}
+				c.Response().Header().Set(DatasetHeader, resolved)
+				return resolved
+			}
+
+			values := c.ParamValues()
+			for i, name := range c.ParamNames() {
+				if name == "dataset" && i < len(values) {
+					values[i] = resolve(values[i])
+				}
+			}
+			c.SetParamValues(values...)
+
+			req := c.Request()
+			if name := req.URL.Query().Get("dataset"); name != "" {
+				if resolved := resolve(name); resolved != name {
+					// the context caches the query once it is read
+					c.QueryParams().Set("dataset", resolved)
+					req.URL.RawQuery = c.QueryParams().Encode()
+				}
+			}
+
+			if req.Body != nil && strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+				req.Body = readCloser{resolveBody(req.Body, resolve), req.Body}
+			}
+			return next(c)
+		}
+	}
+}
+
+// readCloser reads through a reader and closes the original body
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// resolveBody rewrites the top-level dataset field of a streamed JSON
+// object.  Only the start of the body up to the field is read; the rest,
+// and any body that is not an object with a string dataset field, is
+// passed on as it was.
+func resolveBody(body io.Reader, resolve func(string) string) io.Reader {
+	var head bytes.Buffer
+	decoder := json.NewDecoder(io.TeeReader(io.LimitReader(body, MaxDatasetScanBytes), &head))
+	unchanged := func() io.Reader {
+		return io.MultiReader(&head, body)
+	}
+
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return unchanged()
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return unchanged()
+		}
+		if key != "dataset" {
+			if skipValue(decoder) != nil {
+				return unchanged()
+			}
+			continue
+		}
+		start := decoder.InputOffset()
+		value, err := decoder.Token()
+		name, ok := value.(string)
+		if err != nil || !ok {
+			return unchanged()
+		}
+		resolved := resolve(name)
+		if resolved == name {
+			return unchanged()
+		}
+		end := decoder.InputOffset()
+		replacement, _ := json.Marshal(resolved)
+		read := head.Bytes()
+		return io.MultiReader(
+			bytes.NewReader(read[:start]),
+			strings.NewReader(":"),
+			bytes.NewReader(replacement),
+			bytes.NewReader(read[end:]),
+			body,
+		)
+	}
+	return unchanged()
+}
+
+// skipValue reads past the next value in a JSON stream
+func skipValue(decoder *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+	"github.com/labstack/echo/v4"
+)
+
+// versionStore serves versions of hemibrain and an untagged dataset
+type versionStore struct {
+	*storage.NoStore
+}
+
+func (s *versionStore) GetDatasets() (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"hemibrain:v1.0":   map[string]interface{}{"released": "2020-01-21"},
+		"hemibrain:v1.2.1": map[string]interface{}{"released": "2021-05-10"},
+		"fib19":            map[string]interface{}{},
+	}, nil
+}
+
+// seen is what a handler saw of a request
+type seen struct {
+	param, query, rawQuery, body string
+}
+
+// resolveRequest sends a request through the middleware and returns what
+// the handler saw and the response
+func resolveRequest(req *http.Request) (seen, *httptest.ResponseRecorder) {
+	e := echo.New()
+	var got seen
+	handler := func(c echo.Context) error {
+		body, _ := io.ReadAll(c.Request().Body)
+		got = seen{c.Param("dataset"), c.QueryParam("dataset"), c.Request().URL.RawQuery, string(body)}
+		return c.NoContent(http.StatusOK)
+	}
+	resolve := ResolveDatasets(&versionStore{&storage.NoStore{}})
+	e.GET("/api/neurons/:dataset/info", handler, resolve)
+	e.GET("/api/query", handler, resolve)
+	e.POST("/api/query", handler, resolve)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return got, rec
+}
+
+func TestResolveDatasetsParams(t *testing.T) {
+	got, rec := resolveRequest(httptest.NewRequest(http.MethodGet, "/api/neurons/hemibrain/info", nil))
+	if got.param != "hemibrain:v1.2.1" || rec.Header().Get(DatasetHeader) != "hemibrain:v1.2.1" {
+		t.Errorf("unexpected path param %q and header %q", got.param, rec.Header().Get(DatasetHeader))
+	}
+
+	got, rec = resolveRequest(httptest.NewRequest(http.MethodGet, "/api/query?dataset=hemibrain:latest&roi=a+b", nil))
+	if got.query != "hemibrain:v1.2.1" || rec.Header().Get(DatasetHeader) != "hemibrain:v1.2.1" {
+		t.Errorf("unexpected query param %q and header %q", got.query, rec.Header().Get(DatasetHeader))
+	}
+	if got.rawQuery != "dataset=hemibrain%3Av1.2.1&roi=a+b" {
+		t.Errorf("unexpected raw query %q", got.rawQuery)
+	}
+
+	// names that resolve to themselves are left as they were
+	got, rec = resolveRequest(httptest.NewRequest(http.MethodGet, "/api/query?roi=a&dataset=hemibrain:v1.0", nil))
+	if got.query != "hemibrain:v1.0" || got.rawQuery != "roi=a&dataset=hemibrain:v1.0" || rec.Header().Get(DatasetHeader) != "hemibrain:v1.0" {
+		t.Errorf("unexpected query %q (%q) and header %q", got.query, got.rawQuery, rec.Header().Get(DatasetHeader))
+	}
+	got, rec = resolveRequest(httptest.NewRequest(http.MethodGet, "/api/neurons/fib19/info", nil))
+	if got.param != "fib19" || rec.Header().Get(DatasetHeader) != "fib19" {
+		t.Errorf("unexpected path param %q and header %q", got.param, rec.Header().Get(DatasetHeader))
+	}
+}
+
+func TestResolveDatasetsBody(t *testing.T) {
+	post := func(contentType, body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/query", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, contentType)
+		return req
+	}
+
+	got, rec := resolveRequest(post(echo.MIMEApplicationJSON, `{"cypher": "MATCH (n) RETURN n", "dataset": "hemibrain", "x": 1}`))
+	if got.body != `{"cypher": "MATCH (n) RETURN n", "dataset":"hemibrain:v1.2.1", "x": 1}` || rec.Header().Get(DatasetHeader) != "hemibrain:v1.2.1" {
+		t.Errorf("unexpected body %s and header %q", got.body, rec.Header().Get(DatasetHeader))
+	}
+
+	unchanged := `{"dataset": "hemibrain:v1.0",  "cypher": "x"}`
+	if got, _ := resolveRequest(post(echo.MIMEApplicationJSON, unchanged)); got.body != unchanged {
+		t.Errorf("expected a resolved body to be left as it was, got %s", got.body)
+	}
+	other := `{"dataset": "hemibrain"}`
+	for _, contentType := range []string{"", "text/plain", echo.MIMEOctetStream} {
+		got, rec := resolveRequest(post(contentType, other))
+		if got.body != other || rec.Header().Get(DatasetHeader) != "" {
+			t.Errorf("expected a %q body to be left unread, got %s", contentType, got.body)
+		}
+	}
+
+	nested := `{"params": {"dataset": "hemibrain"}, "list": [{"dataset": "hemibrain"}]}`
+	if got, rec := resolveRequest(post(echo.MIMEApplicationJSON, nested)); got.body != nested || rec.Header().Get(DatasetHeader) != "" {
+		t.Errorf("expected only the top-level dataset to be resolved, got %s", got.body)
+	}
+
+	// bodies are streamed rather than read whole, whatever their size
+	tail := make([]byte, 4*MaxDatasetScanBytes)
+	large := io.MultiReader(strings.NewReader(`{"dataset": "hemibrain", "x": "`), bytes.NewReader(tail))
+	req := httptest.NewRequest(http.MethodPost, "/api/query", large)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	got, rec = resolveRequest(req)
+	if rec.Code != http.StatusOK || got.body != `{"dataset":"hemibrain:v1.2.1", "x": "`+string(tail) {
+		t.Errorf("expected a large body to be resolved and passed on, got %d with %d bytes", rec.Code, len(got.body))
+	}
+	late := `{"x": "` + strings.Repeat("a", MaxDatasetScanBytes) + `", "dataset": "hemibrain"}`
+	if got, _ := resolveRequest(post(echo.MIMEApplicationJSON, late)); got.body != late {
+		t.Error("expected a dataset beyond the scanned bytes to be left as it was")
+	}
+
+	// the custom query example sends its body with a GET
+	req = httptest.NewRequest(http.MethodGet, "/api/query", strings.NewReader(`{"dataset": "hemibrain:latest"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if got, _ := resolveRequest(req); got.body != `{"dataset":"hemibrain:v1.2.1"}` {
+		t.Errorf("unexpected GET body %s", got.body)
+	}
+}
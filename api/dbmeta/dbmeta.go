@@ -40,10 +40,21 @@ func setupAPI(mainapi *api.ConnectomeAPI) error {
 		mainapi.SetRoute(api.GET, PREFIX+"/"+endpoint, q.getDatasets, api.NamedExceptionRoute)
 		mainapi.SupportedEndpoints[endpoint] = true
 
+		// dataset versions endpoint
+		endpoint = "versions"
+		mainapi.SetRoute(api.GET, PREFIX+"/"+endpoint+"/:dataset", q.getVersions, api.GuardedRoute)
+		mainapi.SupportedEndpoints[endpoint] = true
+
 		// instances endpoint
 		endpoint = "instances"
 		mainapi.SetRoute(api.GET, PREFIX+"/"+endpoint, q.getDataInstances, api.GuardedRoute)
 		mainapi.SupportedEndpoints[endpoint] = true
+
+		// summary statistics endpoint (computed in the background)
+		endpoint = "summary"
+		mainapi.SetRoute(api.GET, PREFIX+"/"+endpoint+"/:dataset", q.getSummary, api.GuardedRoute)
+		mainapi.SupportedEndpoints[endpoint] = true
+		go q.watchSummaries(SummaryCheckInterval)
 	} else {
 		// meta interface is required by default
 		return fmt.Errorf("metadata interface is not available")
@@ -171,12 +182,12 @@ func (sa storeAPI) getDatasets(c echo.Context) error {
 	// Retrieve DSG identity/client from context. Anonymous callers intentionally
 	// see this named-exception listing pending the dataset-visibility follow-up.
 	var dsgIdentity *secure.DSGIdentity
-	var dsgClient *secure.DSGClient
+	var dsgClient secure.Authorizer
 	if u := c.Get("dsg_identity"); u != nil {
 		dsgIdentity, _ = u.(*secure.DSGIdentity)
 	}
 	if cl := c.Get("dsg_client"); cl != nil {
-		dsgClient, _ = cl.(*secure.DSGClient)
+		dsgClient, _ = cl.(secure.Authorizer)
 	}
 
 	if allData, err := sa.Store.GetDatasets(); err != nil {
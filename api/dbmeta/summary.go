@@ -0,0 +1,339 @@
+package dbmeta
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/api"
+	"github.com/connectome-neuprint/neuPrintHTTP/secure"
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// SummaryStatusQuery counts segments and neurons for each status
+	SummaryStatusQuery = "MATCH (n :Segment) RETURN n.status AS status, count(n) AS segments, sum(CASE WHEN n:Neuron THEN 1 ELSE 0 END) AS neurons"
+
+	// SummaryDistinctQuery counts the distinct neuron types and instances
+	SummaryDistinctQuery = "MATCH (n :Neuron) RETURN count(DISTINCT n.type), count(DISTINCT n.instance)"
+
+	// SummaryMetaQuery fetches the synapse totals and modification stamp
+	SummaryMetaQuery = "MATCH (m :Meta) RETURN m.totalPreCount, m.totalPostCount, m.lastDatabaseEdit"
+
+	// SummaryEdgeQuery counts ConnectsTo edges and the weight distribution.
+	// {quantiles} is replaced by one percentileDisc column per quantile.
+	SummaryEdgeQuery = "MATCH (:Segment)-[e :ConnectsTo]->(:Segment) RETURN count(e), max(e.weight){quantiles}"
+
+	// SummaryTopTypesQuery lists the most common neuron types
+	SummaryTopTypesQuery = "MATCH (n :Neuron) WHERE n.type IS NOT NULL AND n.type <> '' WITH n.type AS type, count(n) AS num RETURN type, num ORDER BY num DESC, type LIMIT $limit"
+
+	// SummaryTopTypes is the number of types listed in a summary
+	SummaryTopTypes = 20
+
+	// SummaryCheckInterval is how often datasets are checked for edits
+	SummaryCheckInterval = 10 * time.Minute
+)
+
+// summaryQuantiles are the ConnectsTo weight quantiles in a summary
+var summaryQuantiles = []float64{0.25, 0.5, 0.75, 0.9, 0.99}
+
+type StatusCount struct {
+	Status   string `json:"status"`
+	Neurons  int64  `json:"neurons"`
+	Segments int64  `json:"segments"`
+}
+
+type WeightQuantile struct {
+	Quantile float64 `json:"quantile"`
+	Weight   int64   `json:"weight"`
+}
+
+type TypeCount struct {
+	Type  string `json:"type"`
+	Count int64  `json:"count"`
+}
+
+// DatasetSummary is the statistical profile of a dataset.  Stale is set when
+// the dataset has been edited since the summary was computed.
+type DatasetSummary struct {
+	Dataset         string           `json:"dataset"`
+	LastEdit        string           `json:"lastDatabaseEdit"`
+	ComputedAt      time.Time        `json:"computedAt"`
+	Stale           bool             `json:"stale"`
+	StatusCounts    []StatusCount    `json:"statusCounts"`
+	NumTypes        int64            `json:"numTypes"`
+	NumInstances    int64            `json:"numInstances"`
+	TotalPre        int64            `json:"totalPre"`
+	TotalPost       int64            `json:"totalPost"`
+	ConnectsToCount int64            `json:"connectsToCount"`
+	MaxWeight       int64            `json:"maxWeight"`
+	WeightQuantiles []WeightQuantile `json:"weightQuantiles"`
+	TopTypes        []TypeCount      `json:"topTypes"`
+}
+
+// summaryPending is returned while a summary is first being computed
+type summaryPending struct {
+	Status string `json:"status"`
+}
+
+var summaries = make(map[string]*DatasetSummary)
+var summaryComputing = make(map[string]bool)
+var summaryMux sync.Mutex
+
+// firstRow returns the single row of a result
+func firstRow(res storage.CypherResult, columns int) ([]interface{}, error) {
+	if len(res.Data) == 0 || len(res.Data[0]) < columns {
+		return nil, fmt.Errorf("unexpected summary query result")
+	}
+	return res.Data[0], nil
+}
+
+// computeSummary runs the summary queries for a dataset
+func computeSummary(cypher storage.Cypher, dataset string) (*DatasetSummary, error) {
+	summary := &DatasetSummary{
+		Dataset:         dataset,
+		StatusCounts:    []StatusCount{},
+		WeightQuantiles: []WeightQuantile{},
+		TopTypes:        []TypeCount{},
+	}
+
+	res, err := cypher.CypherRequest(SummaryMetaQuery, true)
+	if err != nil {
+		return nil, err
+	}
+	row, err := firstRow(res, 3)
+	if err != nil {
+		return nil, err
+	}
+	summary.TotalPre, _ = storage.ToInt64(row[0])
+	summary.TotalPost, _ = storage.ToInt64(row[1])
+	summary.LastEdit, _ = row[2].(string)
+
+	res, err = cypher.CypherRequest(SummaryStatusQuery, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range res.Data {
+		status, _ := row[0].(string)
+		neurons, _ := storage.ToInt64(row[2])
+		segments, _ := storage.ToInt64(row[1])
+		summary.StatusCounts = append(summary.StatusCounts, StatusCount{status, neurons, segments})
+	}
+
+	res, err = cypher.CypherRequest(SummaryDistinctQuery, true)
+	if err != nil {
+		return nil, err
+	}
+	if row, err = firstRow(res, 2); err != nil {
+		return nil, err
+	}
+	summary.NumTypes, _ = storage.ToInt64(row[0])
+	summary.NumInstances, _ = storage.ToInt64(row[1])
+
+	var columns strings.Builder
+	for _, quantile := range summaryQuantiles {
+		fmt.Fprintf(&columns, ", percentileDisc(e.weight, %g)", quantile)
+	}
+	res, err = cypher.CypherRequest(strings.Replace(SummaryEdgeQuery, "{quantiles}", columns.String(), -1), true)
+	if err != nil {
+		return nil, err
+	}
+	if row, err = firstRow(res, 2+len(summaryQuantiles)); err != nil {
+		return nil, err
+	}
+	summary.ConnectsToCount, _ = storage.ToInt64(row[0])
+	summary.MaxWeight, _ = storage.ToInt64(row[1])
+	for i, quantile := range summaryQuantiles {
+		weight, _ := storage.ToInt64(row[2+i])
+		summary.WeightQuantiles = append(summary.WeightQuantiles, WeightQuantile{quantile, weight})
+	}
+
+	res, err = cypher.CypherRequestWithParams(SummaryTopTypesQuery, map[string]interface{}{"limit": int64(SummaryTopTypes)}, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range res.Data {
+		celltype, _ := row[0].(string)
+		neurons, _ := storage.ToInt64(row[1])
+		summary.TopTypes = append(summary.TopTypes, TypeCount{celltype, neurons})
+	}
+
+	summary.ComputedAt = time.Now()
+	return summary, nil
+}
+
+// refreshSummary recomputes the summary for a dataset unless it is already
+// being computed
+func (sa storeAPI) refreshSummary(dataset string) {
+	summaryMux.Lock()
+	if summaryComputing[dataset] {
+		summaryMux.Unlock()
+		return
+	}
+	summaryComputing[dataset] = true
+	summaryMux.Unlock()
+
+	summary, err := computeSummary(sa.Store.GetMain(dataset), dataset)
+
+	summaryMux.Lock()
+	delete(summaryComputing, dataset)
+	if err == nil {
+		summaries[dataset] = summary
+	}
+	summaryMux.Unlock()
+
+	if err != nil {
+		fmt.Printf("Error computing summary for dataset %s: %v\n", dataset, err)
+	} else {
+		fmt.Printf("Computed summary for dataset %s\n", dataset)
+	}
+}
+
+// refreshStaleSummaries recomputes the summaries of datasets that are
+// missing or have been edited
+func (sa storeAPI) refreshStaleSummaries() {
+	datasets, err := sa.Store.GetDatasets()
+	if err != nil {
+		return
+	}
+	for dataset := range datasets {
+		edit, err := storage.LastEdit(sa.Store.GetMain(dataset))
+		if err != nil {
+			continue
+		}
+		summaryMux.Lock()
+		summary, ok := summaries[dataset]
+		summaryMux.Unlock()
+		if !ok || summary.LastEdit != edit {
+			sa.refreshSummary(dataset)
+		}
+	}
+}
+
+// watchSummaries computes every dataset summary and then checks for edits
+// every interval
+func (sa storeAPI) watchSummaries(interval time.Duration) {
+	sa.refreshStaleSummaries()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		sa.refreshStaleSummaries()
+	}
+}
+
+// getSummary returns the precomputed statistics for a dataset
+func (sa storeAPI) getSummary(c echo.Context) error {
+	// swagger:operation GET /api/dbmeta/summary/{dataset} dbmeta getSummary
+	//
+	// Gets summary statistics for a dataset
+	//
+	// Counts of neurons and segments by status, distinct types and
+	// instances, synapse totals, the number of ConnectsTo edges, weight
+	// quantiles and the most common types.  Summaries are computed in the
+	// background and recomputed when the dataset is edited.  A 202 is
+	// returned while the first summary is computed; afterwards an edited
+	// dataset returns the previous summary marked stale until the new one
+	// is ready.
+	//
+	// ---
+	// parameters:
+	// - in: "path"
+	//   name: "dataset"
+	//   schema:
+	//     type: "string"
+	//   required: true
+	//   description: "dataset name"
+	// responses:
+	//   200:
+	//     description: "successful operation"
+	//     schema:
+	//       type: "object"
+	//       properties:
+	//         dataset:
+	//           type: "string"
+	//         lastDatabaseEdit:
+	//           type: "string"
+	//         computedAt:
+	//           type: "string"
+	//         stale:
+	//           type: "boolean"
+	//         statusCounts:
+	//           type: "array"
+	//           items:
+	//             type: "object"
+	//             properties:
+	//               status:
+	//                 type: "string"
+	//               neurons:
+	//                 type: "integer"
+	//               segments:
+	//                 type: "integer"
+	//         numTypes:
+	//           type: "integer"
+	//         numInstances:
+	//           type: "integer"
+	//         totalPre:
+	//           type: "integer"
+	//         totalPost:
+	//           type: "integer"
+	//         connectsToCount:
+	//           type: "integer"
+	//         maxWeight:
+	//           type: "integer"
+	//         weightQuantiles:
+	//           type: "array"
+	//           items:
+	//             type: "object"
+	//             properties:
+	//               quantile:
+	//                 type: "number"
+	//               weight:
+	//                 type: "integer"
+	//         topTypes:
+	//           type: "array"
+	//           items:
+	//             type: "object"
+	//             properties:
+	//               type:
+	//                 type: "string"
+	//               count:
+	//                 type: "integer"
+	//   202:
+	//     description: "summary is being computed"
+	// security:
+	// - Bearer: []
+
+	dataset := c.Param("dataset")
+	if dataset == "" {
+		errJSON := api.ErrorInfo{Error: "parameters not properly provided in uri"}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	if err := secure.RequireDatasetAccess(c, dataset, secure.READ); err != nil {
+		return err
+	}
+
+	edit, err := storage.LastEdit(sa.Store.GetMain(dataset))
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+
+	summaryMux.Lock()
+	summary, ok := summaries[dataset]
+	summaryMux.Unlock()
+	if !ok {
+		go sa.refreshSummary(dataset)
+		return c.JSON(http.StatusAccepted, summaryPending{"computing"})
+	}
+	if summary.LastEdit != edit {
+		go sa.refreshSummary(dataset)
+		stale := *summary
+		stale.Stale = true
+		return c.JSON(http.StatusOK, stale)
+	}
+	return c.JSON(http.StatusOK, summary)
+}
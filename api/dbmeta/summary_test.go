@@ -0,0 +1,107 @@
+package dbmeta
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+)
+
+// summaryCypher answers the summary queries with fixed results
+type summaryCypher struct {
+	lastEdit string
+	queries  int
+}
+
+func (m *summaryCypher) CypherRequest(query string, readonly bool) (storage.CypherResult, error) {
+	return m.CypherRequestWithParams(query, nil, readonly)
+}
+
+func (m *summaryCypher) CypherRequestWithParams(query string, params map[string]interface{}, readonly bool) (storage.CypherResult, error) {
+	m.queries++
+	switch {
+	case query == SummaryMetaQuery:
+		return storage.CypherResult{Data: [][]interface{}{{int64(1000), int64(7000), m.lastEdit}}}, nil
+	case query == SummaryStatusQuery:
+		return storage.CypherResult{Data: [][]interface{}{
+			{"Traced", int64(12), int64(10)},
+			{nil, int64(300), int64(0)},
+		}}, nil
+	case query == SummaryDistinctQuery:
+		return storage.CypherResult{Data: [][]interface{}{{int64(4), int64(6)}}}, nil
+	case strings.HasPrefix(query, "MATCH (:Segment)-[e :ConnectsTo]"):
+		return storage.CypherResult{Data: [][]interface{}{{int64(5000), int64(90), int64(1), int64(2), int64(4), int64(9), int64(40)}}}, nil
+	case query == SummaryTopTypesQuery:
+		return storage.CypherResult{Data: [][]interface{}{{"KC", int64(5)}, {"MBON01", int64(2)}}}, nil
+	case strings.Contains(query, "lastDatabaseEdit"):
+		return storage.CypherResult{Data: [][]interface{}{{m.lastEdit}}}, nil
+	}
+	return storage.CypherResult{}, nil
+}
+
+func (m *summaryCypher) StartTrans() (storage.CypherTransaction, error) {
+	return nil, nil
+}
+
+// summaryStore serves a summaryCypher for one dataset
+type summaryStore struct {
+	mockStoreImpl
+	cypher *summaryCypher
+}
+
+func (m *summaryStore) GetMain(datasets ...string) storage.Cypher {
+	return m.cypher
+}
+
+func (m *summaryStore) GetDatasets() (map[string]interface{}, error) {
+	return map[string]interface{}{"mb6": map[string]interface{}{}}, nil
+}
+
+func TestComputeSummary(t *testing.T) {
+	summary, err := computeSummary(&summaryCypher{lastEdit: "2024-01-01"}, "mb6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.LastEdit != "2024-01-01" || summary.TotalPre != 1000 || summary.TotalPost != 7000 {
+		t.Errorf("unexpected totals: %+v", summary)
+	}
+	if len(summary.StatusCounts) != 2 || summary.StatusCounts[0] != (StatusCount{"Traced", 10, 12}) || summary.StatusCounts[1].Status != "" {
+		t.Errorf("unexpected status counts: %+v", summary.StatusCounts)
+	}
+	if summary.NumTypes != 4 || summary.NumInstances != 6 {
+		t.Errorf("unexpected distinct counts: %+v", summary)
+	}
+	if summary.ConnectsToCount != 5000 || summary.MaxWeight != 90 || len(summary.WeightQuantiles) != len(summaryQuantiles) {
+		t.Errorf("unexpected edge statistics: %+v", summary)
+	}
+	if summary.WeightQuantiles[1] != (WeightQuantile{0.5, 2}) {
+		t.Errorf("unexpected median weight: %+v", summary.WeightQuantiles)
+	}
+	if len(summary.TopTypes) != 2 || summary.TopTypes[0] != (TypeCount{"KC", 5}) {
+		t.Errorf("unexpected top types: %+v", summary.TopTypes)
+	}
+}
+
+func TestRefreshStaleSummaries(t *testing.T) {
+	cypher := &summaryCypher{lastEdit: "2024-01-01"}
+	sa := storeAPI{Store: &summaryStore{cypher: cypher}}
+	delete(summaries, "mb6")
+
+	sa.refreshStaleSummaries()
+	if summaries["mb6"] == nil || summaries["mb6"].LastEdit != "2024-01-01" {
+		t.Fatalf("summary not computed: %+v", summaries["mb6"])
+	}
+
+	// an unedited dataset is not recomputed
+	queries := cypher.queries
+	sa.refreshStaleSummaries()
+	if cypher.queries != queries+1 {
+		t.Errorf("expected only the edit check, got %d queries", cypher.queries-queries)
+	}
+
+	cypher.lastEdit = "2024-02-01"
+	sa.refreshStaleSummaries()
+	if summaries["mb6"].LastEdit != "2024-02-01" {
+		t.Errorf("summary not refreshed after edit: %+v", summaries["mb6"])
+	}
+}
@@ -0,0 +1,127 @@
+package dbmeta
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/api"
+	"github.com/connectome-neuprint/neuPrintHTTP/secure"
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+	"github.com/labstack/echo/v4"
+)
+
+// versionList lists the versions of a dataset
+type versionList struct {
+	Dataset  string                   `json:"dataset"`
+	Default  string                   `json:"default"`
+	Latest   string                   `json:"latest"`
+	Versions []storage.DatasetVersion `json:"versions"`
+}
+
+// getVersions lists the tagged versions of a dataset
+func (sa storeAPI) getVersions(c echo.Context) error {
+	// swagger:operation GET /api/dbmeta/versions/{dataset} dbmeta getDatasetVersions
+	//
+	// Lists the versions of a dataset
+	//
+	// Returns the tags of the dataset the caller can read with their
+	// release dates, oldest first, and which version is the default and
+	// which the latest.  Hidden versions are left out unless hidden is true.  Anywhere a
+	// dataset is given, "hemibrain" means the default version and
+	// "hemibrain:latest" the latest release; the version used is reported
+	// in the X-Neuprint-Dataset response header.
+	//
+	// ---
+	// parameters:
+	// - in: "path"
+	//   name: "dataset"
+	//   required: true
+	//   schema:
+	//     type: "string"
+	//   description: "dataset name, with or without a tag"
+	// - in: "query"
+	//   name: "hidden"
+	//   schema:
+	//     type: "boolean"
+	//   description: "include hidden versions when set to true"
+	// responses:
+	//   200:
+	//     description: "successful operation"
+	//     schema:
+	//       type: "object"
+	//       properties:
+	//         dataset:
+	//           type: "string"
+	//           example: "hemibrain"
+	//         default:
+	//           type: "string"
+	//           example: "hemibrain:v1.2.1"
+	//         latest:
+	//           type: "string"
+	//           example: "hemibrain:v1.2.1"
+	//         versions:
+	//           type: "array"
+	//           items:
+	//             type: "object"
+	//             properties:
+	//               name:
+	//                 type: "string"
+	//               tag:
+	//                 type: "string"
+	//               released:
+	//                 type: "string"
+	//               default:
+	//                 type: "boolean"
+	//               latest:
+	//                 type: "boolean"
+	//               hidden:
+	//                 type: "boolean"
+	//   404:
+	//     description: "unknown dataset"
+	// security:
+	// - Bearer: []
+
+	all, err := storage.Versions(sa.Store)
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	root, _, _ := strings.Cut(c.Param("dataset"), ":")
+	versions, ok := all[strings.ToLower(root)]
+	if !ok {
+		errJSON := api.ErrorInfo{Error: "dataset " + root + " not found"}
+		return c.JSON(http.StatusNotFound, errJSON)
+	}
+
+	names := make([]string, len(versions))
+	for i, version := range versions {
+		names[i] = version.Name
+	}
+	if err := secure.RequireAnyDatasetAccess(c, names, secure.READ); err != nil {
+		return err
+	}
+	readable, err := secure.AccessibleDatasets(c, names, secure.READ)
+	if err != nil {
+		return err
+	}
+	allowed := make(map[string]bool, len(readable))
+	for _, name := range readable {
+		allowed[name] = true
+	}
+
+	includeHidden := c.QueryParam("hidden") == "true"
+	list := versionList{Dataset: root, Versions: []storage.DatasetVersion{}}
+	for _, version := range versions {
+		if !allowed[version.Name] || (version.Hidden && !includeHidden) {
+			continue
+		}
+		list.Versions = append(list.Versions, version)
+		if version.Default {
+			list.Default = version.Name
+		}
+		if version.Latest {
+			list.Latest = version.Name
+		}
+	}
+	return c.JSON(http.StatusOK, list)
+}
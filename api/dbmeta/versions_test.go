@@ -0,0 +1,79 @@
+package dbmeta
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/secure"
+	"github.com/labstack/echo/v4"
+)
+
+// versionsStore serves three versions of hemibrain, one hidden
+type versionsStore struct {
+	mockStoreImpl
+}
+
+func (m *versionsStore) GetDatasets() (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"hemibrain:v1.0": map[string]interface{}{"released": "2020-01-21"},
+		"hemibrain:v1.1": map[string]interface{}{"released": "2020-05-29", "hidden": true},
+		"hemibrain:v1.2": map[string]interface{}{"released": "2021-05-10"},
+	}, nil
+}
+
+func TestGetVersionsFiltersAccess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	auth := `{"users": {"alice@example.org": {"tokens": ["` + secure.HashToken("alice-token") +
+		`"], "datasets": {"hemibrain:v1.0": "view", "hemibrain:v1.1": "view"}}}}`
+	if err := os.WriteFile(path, []byte(auth), 0600); err != nil {
+		t.Fatal(err)
+	}
+	provider, err := secure.NewFileProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sa := storeAPI{Store: &versionsStore{}}
+	get := func(identity *secure.DSGIdentity, query string) versionList {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/api/dbmeta/versions/hemibrain"+query, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("dataset")
+		c.SetParamValues("hemibrain")
+		c.Set("dsg_identity", identity)
+		c.Set("dsg_client", provider)
+		c.Set("dsg_token", "alice-token")
+		if err := sa.getVersions(c); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d (%v)", rec.Code, err)
+		}
+		var list versionList
+		if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+		return list
+	}
+	names := func(list versionList) []string {
+		names := []string{}
+		for _, version := range list.Versions {
+			names = append(names, version.Name)
+		}
+		return names
+	}
+
+	alice := &secure.DSGIdentity{Email: "alice@example.org"}
+	if list := get(alice, ""); len(list.Versions) != 1 || list.Versions[0].Name != "hemibrain:v1.0" || list.Latest != "" || list.Default != "" {
+		t.Errorf("expected only the readable visible version, got %v (default %q, latest %q)", names(list), list.Default, list.Latest)
+	}
+	if list := get(alice, "?hidden=true"); len(list.Versions) != 2 {
+		t.Errorf("expected the readable hidden version to be listed on request, got %v", names(list))
+	}
+	admin := &secure.DSGIdentity{Email: "root@example.org", Admin: true}
+	if list := get(admin, "?hidden=true"); len(list.Versions) != 3 || list.Latest != "hemibrain:v1.2" {
+		t.Errorf("expected admins to see every version, got %v (latest %q)", names(list), list.Latest)
+	}
+}
@@ -0,0 +1,446 @@
+package neurons
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/api"
+	"github.com/connectome-neuprint/neuPrintHTTP/secure"
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// FetchAnnotationsQuery reads the editable properties of bodies
+	FetchAnnotationsQuery = "UNWIND $bodyIds AS id MATCH (n :Segment {bodyId: id}) RETURN n.bodyId, n.type, n.instance, n.status, n.notes"
+
+	// UpdateAnnotationsQuery sets properties of bodies; null values remove
+	// the property
+	UpdateAnnotationsQuery = "UNWIND $rows AS row MATCH (n :Segment {bodyId: row.bodyId}) SET n += row.properties"
+
+	// TouchEditQuery bumps the dataset modification stamp
+	TouchEditQuery = "MATCH (m :Meta) SET m.lastDatabaseEdit = $edit"
+
+	// MaxUpdates bounds the bodies changed by one bulk request
+	MaxUpdates = 1000
+)
+
+// editableProps are the neuron properties the annotation endpoints change,
+// in the order of FetchAnnotationsQuery
+var editableProps = []string{"type", "instance", "status", "notes"}
+
+// EditLevel is the access needed to change annotations (set from the
+// neuron-edit-level configuration)
+var EditLevel = secure.READWRITE
+
+// AllowedStatuses are the statuses a neuron can be given (set from the
+// neuron-statuses configuration)
+var AllowedStatuses = []string{
+	"Traced", "Roughly traced", "Prelim Roughly traced", "Anchor", "Sensory Anchor",
+	"Cervical Anchor", "Soma Anchor", "Leaves", "Orphan", "Assign", "Unimportant",
+}
+
+// namePattern restricts cell type and instance names
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.()+/,'-]*$`)
+
+const (
+	maxNameLength  = 128
+	maxNotesLength = 2000
+)
+
+// validateValue checks a new value for an editable property.  Null
+// removes the property.
+func validateValue(prop string, value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	str, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("%s must be a string or null", prop)
+	}
+	switch prop {
+	case "type", "instance":
+		if len(str) > maxNameLength {
+			return fmt.Errorf("%s is longer than %d characters", prop, maxNameLength)
+		}
+		if prop == "type" && !namePattern.MatchString(str) {
+			return fmt.Errorf("type %q must start with a letter or digit and contain only letters, digits and _.()+/,'-", str)
+		}
+		if prop == "instance" && (str == "" || strings.TrimSpace(str) != str || strings.ContainsAny(str, "\n\r\t")) {
+			return fmt.Errorf("instance %q must be non-empty without surrounding or control whitespace", str)
+		}
+	case "status":
+		for _, status := range AllowedStatuses {
+			if str == status {
+				return nil
+			}
+		}
+		return fmt.Errorf("status %q is not one of %s", str, strings.Join(AllowedStatuses, ", "))
+	case "notes":
+		if len(str) > maxNotesLength {
+			return fmt.Errorf("notes are longer than %d characters", maxNotesLength)
+		}
+	default:
+		return fmt.Errorf("%s cannot be edited (editable: %s)", prop, strings.Join(editableProps, ", "))
+	}
+	return nil
+}
+
+// Update gives new values for properties of a body
+type Update struct {
+	BodyID     int64                  `json:"bodyId"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// Change gives the values of the updated properties of a body before and
+// after an update
+type Change struct {
+	BodyID int64                  `json:"bodyId"`
+	Before map[string]interface{} `json:"before"`
+	After  map[string]interface{} `json:"after"`
+}
+
+// validateUpdates checks every update, returning all the problems found
+func validateUpdates(updates []Update) []string {
+	var problems []string
+	seen := make(map[int64]bool, len(updates))
+	for _, update := range updates {
+		if seen[update.BodyID] {
+			problems = append(problems, fmt.Sprintf("body %d: updated more than once", update.BodyID))
+		}
+		seen[update.BodyID] = true
+		if len(update.Properties) == 0 {
+			problems = append(problems, fmt.Sprintf("body %d: no properties given", update.BodyID))
+		}
+		props := make([]string, 0, len(update.Properties))
+		for prop := range update.Properties {
+			props = append(props, prop)
+		}
+		sort.Strings(props)
+		for _, prop := range props {
+			if err := validateValue(prop, update.Properties[prop]); err != nil {
+				problems = append(problems, fmt.Sprintf("body %d: %v", update.BodyID, err))
+			}
+		}
+	}
+	return problems
+}
+
+// paramRequester runs parameterized queries, in or outside a transaction
+type paramRequester interface {
+	CypherRequestWithParams(string, map[string]interface{}, bool) (storage.CypherResult, error)
+}
+
+// fetchAnnotations returns the editable properties of the bodies found
+func fetchAnnotations(requester paramRequester, bodyIDs []int64) (map[int64]map[string]interface{}, error) {
+	ids := make([]interface{}, len(bodyIDs))
+	for i, id := range bodyIDs {
+		ids[i] = id
+	}
+	res, err := requester.CypherRequestWithParams(FetchAnnotationsQuery, map[string]interface{}{"bodyIds": ids}, true)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[int64]map[string]interface{}, len(res.Data))
+	for _, row := range res.Data {
+		if len(row) != len(editableProps)+1 {
+			return nil, fmt.Errorf("unexpected annotation result")
+		}
+		id, ok := storage.ToInt64(row[0])
+		if !ok {
+			return nil, fmt.Errorf("body id is not an int: %T", row[0])
+		}
+		values := make(map[string]interface{}, len(editableProps))
+		for i, prop := range editableProps {
+			values[prop] = row[i+1]
+		}
+		found[id] = values
+	}
+	return found, nil
+}
+
+// diffUpdates compares updates with the current values, returning the
+// changed bodies, the bodies left unchanged and the bodies not found
+func diffUpdates(updates []Update, current map[int64]map[string]interface{}) ([]Change, []int64, []int64) {
+	var changes []Change
+	var unchanged, missing []int64
+	for _, update := range updates {
+		values, ok := current[update.BodyID]
+		if !ok {
+			missing = append(missing, update.BodyID)
+			continue
+		}
+		change := Change{BodyID: update.BodyID, Before: map[string]interface{}{}, After: map[string]interface{}{}}
+		for prop, value := range update.Properties {
+			if !reflect.DeepEqual(values[prop], value) {
+				change.Before[prop] = values[prop]
+				change.After[prop] = value
+			}
+		}
+		if len(change.After) > 0 {
+			changes = append(changes, change)
+		} else {
+			unchanged = append(unchanged, update.BodyID)
+		}
+	}
+	return changes, unchanged, missing
+}
+
+// missingError lists bodies that are not in the dataset
+type missingError []int64
+
+func (err missingError) Error() string {
+	return fmt.Sprintf("bodies not found: %v", []int64(err))
+}
+
+// writeChanges sets the changed properties
+func writeChanges(requester paramRequester, changes []Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	rows := make([]interface{}, len(changes))
+	for i, change := range changes {
+		rows[i] = map[string]interface{}{"bodyId": change.BodyID, "properties": change.After}
+	}
+	_, err := requester.CypherRequestWithParams(UpdateAnnotationsQuery, map[string]interface{}{"rows": rows}, false)
+	return err
+}
+
+// touchEdit bumps the dataset modification stamp.  The stamp has nanosecond
+// resolution so that edits made within the same second still change it and
+// invalidate cached results.
+func touchEdit(requester paramRequester) error {
+	edit := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err := requester.CypherRequestWithParams(TouchEditQuery, map[string]interface{}{"edit": edit}, false)
+	return err
+}
+
+// applyUpdates changes annotations in a transaction, committing only if
+// every body exists and every write succeeds
+func applyUpdates(cypher storage.Cypher, updates []Update) ([]Change, error) {
+	trans, err := cypher.StartTrans()
+	if err != nil {
+		return nil, err
+	}
+	bodyIDs := make([]int64, len(updates))
+	for i, update := range updates {
+		bodyIDs[i] = update.BodyID
+	}
+	current, err := fetchAnnotations(trans, bodyIDs)
+	if err != nil {
+		trans.Kill()
+		return nil, err
+	}
+	changes, _, missing := diffUpdates(updates, current)
+	if len(missing) > 0 {
+		trans.Kill()
+		return nil, missingError(missing)
+	}
+	if len(changes) > 0 {
+		err = writeChanges(trans, changes)
+		if err == nil {
+			err = touchEdit(trans)
+		}
+		if err != nil {
+			trans.Kill()
+			return nil, err
+		}
+	}
+	if err := trans.Commit(); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// decodeProperties reads a JSON object of new property values
+func decodeProperties(c echo.Context, out interface{}) error {
+	decoder := json.NewDecoder(c.Request().Body)
+	decoder.UseNumber()
+	return decoder.Decode(out)
+}
+
+// runUpdates validates and applies updates, answering the request with
+// the problems found if they are not applied
+func (ca cypherAPI) runUpdates(c echo.Context, dataset string, updates []Update) ([]Change, error) {
+	if problems := validateUpdates(updates); len(problems) > 0 {
+		return nil, c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error":    "invalid update",
+			"problems": problems,
+		})
+	}
+	changes, err := applyUpdates(ca.Store.GetMain(dataset), updates)
+	if missing, ok := err.(missingError); ok {
+		status := http.StatusBadRequest
+		if len(updates) == 1 {
+			status = http.StatusNotFound
+		}
+		errJSON := api.ErrorInfo{Error: missing.Error()}
+		return nil, c.JSON(status, errJSON)
+	}
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return nil, c.JSON(http.StatusInternalServerError, errJSON)
+	}
+	if changes == nil {
+		changes = []Change{}
+	}
+	return changes, nil
+}
+
+// updateNeuron changes the annotations of one body
+func (ca cypherAPI) updateNeuron(c echo.Context) error {
+	// swagger:operation PATCH /api/neurons/{dataset}/{bodyId} neurons updateNeuron
+	//
+	// Update the annotations of a neuron
+	//
+	// Sets the given properties of a body.  Only type, instance, status and
+	// notes can be changed; a null value removes the property.  Statuses
+	// must be one of the configured statuses and types may only contain
+	// letters, digits and _.()+/,'-.  Requires read/write access to the
+	// dataset (admin if so configured).  Returns the changed properties
+	// before and after the update.
+	//
+	// ---
+	// parameters:
+	// - in: "path"
+	//   name: "dataset"
+	//   required: true
+	//   schema:
+	//     type: "string"
+	// - in: "path"
+	//   name: "bodyId"
+	//   required: true
+	//   schema:
+	//     type: "integer"
+	// - in: "body"
+	//   name: "body"
+	//   required: true
+	//   schema:
+	//     type: "object"
+	//     example: {"type": "MBON01", "status": "Traced", "notes": null}
+	// responses:
+	//   200:
+	//     description: "successful operation"
+	//     schema:
+	//       type: "object"
+	//       properties:
+	//         bodyId:
+	//           type: "integer"
+	//         before:
+	//           type: "object"
+	//         after:
+	//           type: "object"
+	//   404:
+	//     description: "body not found"
+	// security:
+	// - Bearer: []
+
+	dataset := c.Param("dataset")
+	if err := secure.RequireDatasetAccess(c, dataset, EditLevel); err != nil {
+		return err
+	}
+	bodyID, err := strconv.ParseInt(c.Param("bodyId"), 10, 64)
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: "body id must be an integer"}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	update := Update{BodyID: bodyID}
+	if err := decodeProperties(c, &update.Properties); err != nil {
+		errJSON := api.ErrorInfo{Error: "request object not formatted correctly"}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+
+	changes, err := ca.runUpdates(c, dataset, []Update{update})
+	if changes == nil {
+		return err
+	}
+	change := Change{BodyID: bodyID, Before: map[string]interface{}{}, After: map[string]interface{}{}}
+	if len(changes) > 0 {
+		change = changes[0]
+	}
+	return c.JSON(http.StatusOK, change)
+}
+
+// updateNeurons changes the annotations of several bodies at once
+func (ca cypherAPI) updateNeurons(c echo.Context) error {
+	// swagger:operation PATCH /api/neurons/{dataset} neurons updateNeurons
+	//
+	// Update the annotations of several neurons
+	//
+	// Applies every update in one transaction: if any body is missing or
+	// any value is invalid nothing is changed.  The same rules as the
+	// single neuron update apply.  Bodies whose values are unchanged are
+	// left out of the result.
+	//
+	// ---
+	// parameters:
+	// - in: "path"
+	//   name: "dataset"
+	//   required: true
+	//   schema:
+	//     type: "string"
+	// - in: "body"
+	//   name: "body"
+	//   required: true
+	//   schema:
+	//     type: "object"
+	//     properties:
+	//       updates:
+	//         type: "array"
+	//         items:
+	//           type: "object"
+	//           properties:
+	//             bodyId:
+	//               type: "integer"
+	//             properties:
+	//               type: "object"
+	//     example: {"updates": [{"bodyId": 5813105172, "properties": {"type": "MBON01"}}]}
+	// responses:
+	//   200:
+	//     description: "successful operation"
+	//     schema:
+	//       type: "object"
+	//       properties:
+	//         updated:
+	//           type: "array"
+	//           items:
+	//             type: "object"
+	//             properties:
+	//               bodyId:
+	//                 type: "integer"
+	//               before:
+	//                 type: "object"
+	//               after:
+	//                 type: "object"
+	// security:
+	// - Bearer: []
+
+	dataset := c.Param("dataset")
+	if err := secure.RequireDatasetAccess(c, dataset, EditLevel); err != nil {
+		return err
+	}
+	var req struct {
+		Updates []Update `json:"updates"`
+	}
+	if err := decodeProperties(c, &req); err != nil {
+		errJSON := api.ErrorInfo{Error: "request object not formatted correctly"}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	if len(req.Updates) == 0 || len(req.Updates) > MaxUpdates {
+		errJSON := api.ErrorInfo{Error: fmt.Sprintf("between 1 and %d updates must be given", MaxUpdates)}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+
+	changes, err := ca.runUpdates(c, dataset, req.Updates)
+	if changes == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"updated": changes})
+}
@@ -0,0 +1,99 @@
+package neurons
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+)
+
+// annotationCypher holds neuron annotations and records the writes made in
+// its transaction
+type annotationCypher struct {
+	bodies    map[int64][]interface{}
+	writes    []string
+	committed bool
+	killed    bool
+}
+
+func (m *annotationCypher) CypherRequest(query string, readonly bool) (storage.CypherResult, error) {
+	return m.CypherRequestWithParams(query, nil, readonly)
+}
+func (m *annotationCypher) CypherRequestWithParams(query string, params map[string]interface{}, readonly bool) (storage.CypherResult, error) {
+	if query != FetchAnnotationsQuery {
+		m.writes = append(m.writes, query)
+		return storage.CypherResult{}, nil
+	}
+	var res storage.CypherResult
+	for _, id := range params["bodyIds"].([]interface{}) {
+		if values, ok := m.bodies[id.(int64)]; ok {
+			res.Data = append(res.Data, append([]interface{}{id}, values...))
+		}
+	}
+	return res, nil
+}
+func (m *annotationCypher) StartTrans() (storage.CypherTransaction, error) { return m, nil }
+func (m *annotationCypher) Commit() error                                  { m.committed = true; return nil }
+func (m *annotationCypher) Kill() error                                    { m.killed = true; return nil }
+
+func TestValidateUpdates(t *testing.T) {
+	valid := []Update{
+		{BodyID: 1, Properties: map[string]interface{}{"type": "MBON01", "status": "Traced", "notes": nil}},
+		{BodyID: 2, Properties: map[string]interface{}{"type": "KCa'b'-ap1", "instance": "KCa'b'-ap1(R)"}},
+	}
+	if problems := validateUpdates(valid); len(problems) != 0 {
+		t.Errorf("unexpected problems %v", problems)
+	}
+
+	invalid := []Update{
+		{BodyID: 1, Properties: map[string]interface{}{"status": "Done"}},
+		{BodyID: 2, Properties: map[string]interface{}{"type": " MBON01"}},
+		{BodyID: 3, Properties: map[string]interface{}{"pre": "5"}},
+		{BodyID: 4, Properties: map[string]interface{}{"notes": 5}},
+		{BodyID: 5},
+		{BodyID: 1, Properties: map[string]interface{}{"type": "MBON01"}},
+	}
+	problems := validateUpdates(invalid)
+	if len(problems) != len(invalid) {
+		t.Fatalf("expected a problem for every update, got %v", problems)
+	}
+	for i, want := range []string{"status", "type", "pre cannot be edited", "notes must be", "no properties", "more than once"} {
+		if !strings.Contains(problems[i], want) {
+			t.Errorf("problem %q does not mention %q", problems[i], want)
+		}
+	}
+}
+
+func TestApplyUpdates(t *testing.T) {
+	cypher := &annotationCypher{bodies: map[int64][]interface{}{
+		1: {"MBON01", "MBON01(y5b'2a)_R", "Traced", nil},
+		2: {"KC", nil, "Anchor", "check"},
+	}}
+	changes, err := applyUpdates(cypher, []Update{
+		{BodyID: 1, Properties: map[string]interface{}{"type": "MBON01", "status": "Roughly traced"}},
+		{BodyID: 2, Properties: map[string]interface{}{"status": "Anchor"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].BodyID != 1 || len(changes[0].After) != 1 ||
+		changes[0].Before["status"] != "Traced" || changes[0].After["status"] != "Roughly traced" {
+		t.Errorf("unexpected changes %+v", changes)
+	}
+	if !cypher.committed || len(cypher.writes) != 2 || cypher.writes[1] != TouchEditQuery {
+		t.Errorf("expected the update and edit stamp to be committed, got %v", cypher.writes)
+	}
+
+	// nothing is written if a body is missing
+	cypher = &annotationCypher{bodies: map[int64][]interface{}{1: {nil, nil, nil, nil}}}
+	_, err = applyUpdates(cypher, []Update{
+		{BodyID: 1, Properties: map[string]interface{}{"type": "a"}},
+		{BodyID: 3, Properties: map[string]interface{}{"type": "b"}},
+	})
+	if missing, ok := err.(missingError); !ok || len(missing) != 1 || missing[0] != 3 {
+		t.Errorf("expected body 3 to be missing, got %v", err)
+	}
+	if !cypher.killed || cypher.committed || len(cypher.writes) != 0 {
+		t.Error("expected the transaction to be rolled back without writes")
+	}
+}
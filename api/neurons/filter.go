@@ -0,0 +1,340 @@
+package neurons
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultLimit is the page size used when a search does not give one
+	DefaultLimit = 1000
+
+	// MaxLimit bounds the page size of a single search
+	MaxLimit = 100000
+)
+
+// defaultReturn is the projection used when a search does not give one
+var defaultReturn = []string{"bodyId", "type", "instance", "status", "pre", "post"}
+
+// Filter is one node of a neuron search filter tree.  A node is either a
+// logical combination (and, or, not), a property comparison, an ROI
+// membership test or a pre/post threshold.
+type Filter struct {
+	And []*Filter `json:"and,omitempty"`
+	Or  []*Filter `json:"or,omitempty"`
+	Not *Filter   `json:"not,omitempty"`
+
+	// property comparison, e.g. {"property": "type", "op": "=~", "value": "MBON.*"}
+	Property string      `json:"property,omitempty"`
+	Op       string      `json:"op,omitempty"`
+	Value    interface{} `json:"value,omitempty"`
+
+	// ROI membership, optionally restricted to neurons with at least
+	// pre_threshold/post_threshold synapses inside the ROI
+	ROI string `json:"roi,omitempty"`
+
+	// synapse thresholds for the whole neuron (or within ROI if given)
+	PreThreshold  *int64 `json:"pre_threshold,omitempty"`
+	PostThreshold *int64 `json:"post_threshold,omitempty"`
+}
+
+// SortKey orders search results by a property
+type SortKey struct {
+	Property   string `json:"property"`
+	Descending bool   `json:"descending,omitempty"`
+}
+
+// SearchParams is the body for the neuron search endpoint
+type SearchParams struct {
+	Dataset     string    `json:"dataset"`
+	AllSegments bool      `json:"all_segments,omitempty"`
+	Filter      *Filter   `json:"filter,omitempty"`
+	Return      []string  `json:"return,omitempty"`
+	Sort        []SortKey `json:"sort,omitempty"`
+	Limit       int       `json:"limit,omitempty"`
+	Offset      int       `json:"offset,omitempty"`
+}
+
+// comparison operators and their cypher form
+var comparisonOps = map[string]string{
+	"=":           "=",
+	"<>":          "<>",
+	"<":           "<",
+	"<=":          "<=",
+	">":           ">",
+	">=":          ">=",
+	"=~":          "=~",
+	"in":          "IN",
+	"contains":    "CONTAINS",
+	"starts_with": "STARTS WITH",
+	"ends_with":   "ENDS WITH",
+}
+
+// compiler accumulates query parameters while walking a filter tree
+type compiler struct {
+	known  map[string]bool
+	params map[string]interface{}
+}
+
+func (c *compiler) addParam(val interface{}) string {
+	name := "p" + strconv.Itoa(len(c.params))
+	c.params[name] = val
+	return "$" + name
+}
+
+func (c *compiler) property(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("property name not specified")
+	}
+	if !c.known[name] {
+		return "", fmt.Errorf("unknown property %q", name)
+	}
+	return "n." + quoteIdentifier(name), nil
+}
+
+// compile converts a filter node into a cypher boolean expression
+func (c *compiler) compile(f *Filter) (string, error) {
+	if f == nil {
+		return "", fmt.Errorf("empty filter")
+	}
+
+	forms := 0
+	if f.And != nil {
+		forms++
+	}
+	if f.Or != nil {
+		forms++
+	}
+	if f.Not != nil {
+		forms++
+	}
+	if f.Property != "" {
+		forms++
+	}
+	if f.ROI != "" {
+		forms++
+	}
+	hasThreshold := f.PreThreshold != nil || f.PostThreshold != nil
+	if forms == 0 && hasThreshold {
+		forms++
+	}
+	if forms != 1 {
+		return "", fmt.Errorf("filter must contain exactly one of and, or, not, property, roi or a threshold")
+	}
+	if hasThreshold && f.ROI == "" && (f.And != nil || f.Or != nil || f.Not != nil || f.Property != "") {
+		return "", fmt.Errorf("thresholds can only be combined with roi")
+	}
+
+	switch {
+	case f.And != nil:
+		return c.compileList(f.And, " AND ")
+	case f.Or != nil:
+		return c.compileList(f.Or, " OR ")
+	case f.Not != nil:
+		expr, err := c.compile(f.Not)
+		if err != nil {
+			return "", err
+		}
+		return "(NOT " + expr + ")", nil
+	case f.Property != "":
+		return c.compileComparison(f)
+	default:
+		return c.compileThresholds(f)
+	}
+}
+
+func (c *compiler) compileList(filters []*Filter, join string) (string, error) {
+	if len(filters) == 0 {
+		return "", fmt.Errorf("logical filter has no terms")
+	}
+	exprs := make([]string, 0, len(filters))
+	for _, sub := range filters {
+		expr, err := c.compile(sub)
+		if err != nil {
+			return "", err
+		}
+		exprs = append(exprs, expr)
+	}
+	return "(" + strings.Join(exprs, join) + ")", nil
+}
+
+func (c *compiler) compileComparison(f *Filter) (string, error) {
+	prop, err := c.property(f.Property)
+	if err != nil {
+		return "", err
+	}
+
+	switch f.Op {
+	case "is_null":
+		return "(" + prop + " IS NULL)", nil
+	case "not_null":
+		return "(" + prop + " IS NOT NULL)", nil
+	}
+
+	cypherOp, ok := comparisonOps[f.Op]
+	if !ok {
+		return "", fmt.Errorf("unsupported operator %q", f.Op)
+	}
+	if f.Value == nil {
+		return "", fmt.Errorf("operator %q on %q requires a value", f.Op, f.Property)
+	}
+	val, err := normalizeValue(f.Value)
+	if err != nil {
+		return "", err
+	}
+
+	switch f.Op {
+	case "in":
+		if _, ok := val.([]interface{}); !ok {
+			return "", fmt.Errorf("operator \"in\" on %q requires a list", f.Property)
+		}
+	case "=~", "contains", "starts_with", "ends_with":
+		if _, ok := val.(string); !ok {
+			return "", fmt.Errorf("operator %q on %q requires a string", f.Op, f.Property)
+		}
+	default:
+		if _, ok := val.([]interface{}); ok {
+			return "", fmt.Errorf("operator %q on %q does not accept a list", f.Op, f.Property)
+		}
+	}
+
+	return "(" + prop + " " + cypherOp + " " + c.addParam(val) + ")", nil
+}
+
+func (c *compiler) compileThresholds(f *Filter) (string, error) {
+	conds := make([]string, 0, 3)
+	preExpr, postExpr := "n.pre", "n.post"
+	if f.ROI != "" {
+		prop, err := c.property(f.ROI)
+		if err != nil {
+			return "", err
+		}
+		conds = append(conds, prop+" = true")
+		roiParam := c.addParam(f.ROI)
+		preExpr = "apoc.convert.fromJsonMap(n.roiInfo)[" + roiParam + "].pre"
+		postExpr = "apoc.convert.fromJsonMap(n.roiInfo)[" + roiParam + "].post"
+	}
+	if f.PreThreshold != nil {
+		conds = append(conds, preExpr+" >= "+c.addParam(*f.PreThreshold))
+	}
+	if f.PostThreshold != nil {
+		conds = append(conds, postExpr+" >= "+c.addParam(*f.PostThreshold))
+	}
+	return "(" + strings.Join(conds, " AND ") + ")", nil
+}
+
+// CompileSearch converts search parameters into a parameterized cypher
+// query.  Every property name is checked against known, the set of
+// properties stored on neurons in the dataset.
+func CompileSearch(params SearchParams, known map[string]bool) (string, map[string]interface{}, error) {
+	c := &compiler{known: known, params: make(map[string]interface{})}
+
+	label := "Neuron"
+	if params.AllSegments {
+		label = "Segment"
+	}
+	cypher := "MATCH (n :" + label + ")"
+
+	if params.Filter != nil {
+		cond, err := c.compile(params.Filter)
+		if err != nil {
+			return "", nil, err
+		}
+		cypher += " WHERE " + cond
+	}
+
+	projection := params.Return
+	if len(projection) == 0 {
+		projection = defaultReturn
+	}
+	columns := make([]string, 0, len(projection))
+	seen := make(map[string]bool)
+	for _, name := range projection {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		prop, err := c.property(name)
+		if err != nil {
+			return "", nil, err
+		}
+		columns = append(columns, prop+" AS "+quoteIdentifier(name))
+	}
+	cypher += " RETURN " + strings.Join(columns, ", ")
+
+	order := make([]string, 0, len(params.Sort)+1)
+	for _, key := range params.Sort {
+		prop, err := c.property(key.Property)
+		if err != nil {
+			return "", nil, err
+		}
+		if key.Descending {
+			prop += " DESC"
+		}
+		order = append(order, prop)
+	}
+	// always break ties by body id so pages are stable
+	order = append(order, "n.bodyId")
+	cypher += " ORDER BY " + strings.Join(order, ", ")
+
+	if params.Offset < 0 {
+		return "", nil, fmt.Errorf("offset must not be negative")
+	}
+	limit := params.Limit
+	if limit == 0 {
+		limit = DefaultLimit
+	}
+	if limit < 0 || limit > MaxLimit {
+		return "", nil, fmt.Errorf("limit must be between 1 and %d", MaxLimit)
+	}
+	cypher += " SKIP " + c.addParam(int64(params.Offset)) + " LIMIT " + c.addParam(int64(limit))
+
+	return cypher, c.params, nil
+}
+
+// quoteIdentifier backtick quotes a property name for use in cypher
+func quoteIdentifier(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// normalizeValue converts decoded JSON values into types the database
+// drivers accept, keeping integers exact.
+func normalizeValue(val interface{}) (interface{}, error) {
+	switch v := val.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", v.String())
+		}
+		return f, nil
+	case float64:
+		if float64(int64(v)) == v {
+			return int64(v), nil
+		}
+		return v, nil
+	case string, bool, int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			nval, err := normalizeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			if _, nested := nval.([]interface{}); nested {
+				return nil, fmt.Errorf("nested lists are not supported")
+			}
+			list[i] = nval
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", val)
+	}
+}
@@ -0,0 +1,114 @@
+package neurons
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+var testKeys = map[string]bool{
+	"bodyId": true, "type": true, "instance": true, "status": true,
+	"pre": true, "post": true, "cropped": true, "roiInfo": true, "MB(R)": true,
+}
+
+func decodeSearch(t *testing.T, body string) SearchParams {
+	var params SearchParams
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&params); err != nil {
+		t.Fatalf("bad test json: %v", err)
+	}
+	return params
+}
+
+func TestCompileSearch(t *testing.T) {
+	params := decodeSearch(t, `{
+		"dataset": "hemibrain",
+		"filter": {"and": [
+			{"property": "type", "op": "=~", "value": "MBON.*"},
+			{"roi": "MB(R)", "pre_threshold": 10},
+			{"not": {"property": "cropped", "op": "=", "value": true}},
+			{"property": "bodyId", "op": "in", "value": [5813105172, 1234]},
+			{"property": "instance", "op": "is_null"}
+		]},
+		"return": ["bodyId", "type"],
+		"sort": [{"property": "pre", "descending": true}],
+		"limit": 10,
+		"offset": 20
+	}`)
+
+	cypher, qparams, err := CompileSearch(params, testKeys)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "MATCH (n :Neuron) WHERE ((n.`type` =~ $p0) AND (n.`MB(R)` = true AND " +
+		"apoc.convert.fromJsonMap(n.roiInfo)[$p1].pre >= $p2) AND (NOT (n.`cropped` = $p3)) AND " +
+		"(n.`bodyId` IN $p4) AND (n.`instance` IS NULL)) " +
+		"RETURN n.`bodyId` AS `bodyId`, n.`type` AS `type` " +
+		"ORDER BY n.`pre` DESC, n.bodyId SKIP $p5 LIMIT $p6"
+	if cypher != expected {
+		t.Errorf("unexpected cypher:\n got %s\nwant %s", cypher, expected)
+	}
+
+	if qparams["p0"] != "MBON.*" || qparams["p1"] != "MB(R)" || qparams["p2"] != int64(10) || qparams["p3"] != true {
+		t.Errorf("unexpected parameters: %v", qparams)
+	}
+	ids, ok := qparams["p4"].([]interface{})
+	if !ok || len(ids) != 2 || ids[0] != int64(5813105172) {
+		t.Errorf("body ids not kept exact: %v", qparams["p4"])
+	}
+	if qparams["p5"] != int64(20) || qparams["p6"] != int64(10) {
+		t.Errorf("unexpected paging parameters: %v %v", qparams["p5"], qparams["p6"])
+	}
+}
+
+func TestCompileSearchDefaults(t *testing.T) {
+	cypher, qparams, err := CompileSearch(SearchParams{Dataset: "hemibrain", AllSegments: true}, testKeys)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(cypher, "MATCH (n :Segment) RETURN n.`bodyId` AS `bodyId`, n.`type` AS `type`") {
+		t.Errorf("unexpected cypher: %s", cypher)
+	}
+	if strings.Contains(cypher, "WHERE") {
+		t.Errorf("empty filter should not add a WHERE clause: %s", cypher)
+	}
+	if qparams["p1"] != int64(DefaultLimit) {
+		t.Errorf("expected default limit, got %v", qparams["p1"])
+	}
+}
+
+func TestCompileSearchErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown property":   `{"filter": {"property": "secret", "op": "=", "value": 1}}`,
+		"injected property":  `{"filter": {"property": "type) DETACH DELETE n //", "op": "=", "value": 1}}`,
+		"unknown return":     `{"return": ["bodyId", "password"]}`,
+		"unknown sort":       `{"sort": [{"property": "nope"}]}`,
+		"unknown roi":        `{"filter": {"roi": "XYZ"}}`,
+		"bad operator":       `{"filter": {"property": "type", "op": "LIKE", "value": "a"}}`,
+		"missing value":      `{"filter": {"property": "type", "op": "="}}`,
+		"in needs list":      `{"filter": {"property": "bodyId", "op": "in", "value": 1}}`,
+		"regex needs string": `{"filter": {"property": "type", "op": "=~", "value": 3}}`,
+		"list for =":         `{"filter": {"property": "type", "op": "=", "value": ["a"]}}`,
+		"nested list":        `{"filter": {"property": "type", "op": "in", "value": [["a"]]}}`,
+		"object value":       `{"filter": {"property": "type", "op": "=", "value": {"a": 1}}}`,
+		"two forms":          `{"filter": {"property": "type", "op": "is_null", "roi": "MB(R)"}}`,
+		"empty node":         `{"filter": {}}`,
+		"empty and":          `{"filter": {"and": []}}`,
+		"threshold with and": `{"filter": {"and": [{"property": "type", "op": "is_null"}], "pre_threshold": 3}}`,
+		"negative offset":    `{"offset": -1}`,
+		"limit too large":    `{"limit": 1000000}`,
+	}
+	for name, body := range tests {
+		params := decodeSearch(t, body)
+		if _, _, err := CompileSearch(params, testKeys); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	if got := quoteIdentifier("a`b"); got != "`a``b`" {
+		t.Errorf("unexpected quoting: %s", got)
+	}
+}
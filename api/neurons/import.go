@@ -0,0 +1,433 @@
+package neurons
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/connectome-neuprint/neuPrintHTTP/api"
+	"github.com/connectome-neuprint/neuPrintHTTP/secure"
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// MaxImportRows bounds the rows of one import
+	MaxImportRows = 100000
+
+	// maxImportBytes bounds the size of an uploaded table
+	maxImportBytes = 64 << 20
+)
+
+// ImportBatchSize is the number of rows read or written by one query of
+// an import
+var ImportBatchSize = 500
+
+// ImportReport summarizes an annotation import
+type ImportReport struct {
+	Rows      int      `json:"rows"`
+	Skipped   int      `json:"skipped"`
+	Applied   bool     `json:"applied"`
+	Batches   int      `json:"batches"`
+	Unknown   []int64  `json:"unknownBodies"`
+	Unchanged []int64  `json:"unchanged"`
+	Changed   []Change `json:"changed"`
+	Invalid   []string `json:"invalid"`
+}
+
+// importColumns finds the body id column and the property columns of a
+// table header
+func importColumns(names []string) (int, map[int]string, error) {
+	idCol := -1
+	props := make(map[int]string)
+	seen := make(map[string]bool)
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		if seen[name] {
+			return 0, nil, fmt.Errorf("column %s is given more than once", name)
+		}
+		seen[name] = true
+		if name == "bodyId" {
+			idCol = i
+			continue
+		}
+		editable := false
+		for _, prop := range editableProps {
+			editable = editable || prop == name
+		}
+		if !editable {
+			return 0, nil, fmt.Errorf("column %s cannot be imported (editable: %s)", name, strings.Join(editableProps, ", "))
+		}
+		props[i] = name
+	}
+	if idCol < 0 {
+		return 0, nil, fmt.Errorf("no bodyId column")
+	}
+	if len(props) == 0 {
+		return 0, nil, fmt.Errorf("no property columns")
+	}
+	return idCol, props, nil
+}
+
+// importTable collects the rows of an uploaded table.  Empty cells leave a
+// property unchanged; rows without values are skipped.
+type importTable struct {
+	updates  []Update
+	problems []string
+	rows     int
+	skipped  int
+}
+
+// add records a row
+func (table *importTable) add(update Update) error {
+	table.rows++
+	if table.rows > MaxImportRows {
+		return fmt.Errorf("more than %d rows", MaxImportRows)
+	}
+	if len(update.Properties) == 0 {
+		table.skipped++
+	} else {
+		table.updates = append(table.updates, update)
+	}
+	return nil
+}
+
+// readCSV reads a CSV table with a header row
+func readCSV(r io.Reader) (*importTable, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CSV header: %v", err)
+	}
+	idCol, props, err := importColumns(header)
+	if err != nil {
+		return nil, err
+	}
+
+	table := &importTable{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return table, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CSV: %v", err)
+		}
+		bodyID, err := strconv.ParseInt(strings.TrimSpace(record[idCol]), 10, 64)
+		if err != nil {
+			table.rows++
+			table.problems = append(table.problems, fmt.Sprintf("line %d: body id %q is not an integer", line, record[idCol]))
+			continue
+		}
+		update := Update{BodyID: bodyID, Properties: make(map[string]interface{})}
+		for col, prop := range props {
+			if value := strings.TrimSpace(record[col]); value != "" {
+				update.Properties[prop] = value
+			}
+		}
+		if err := table.add(update); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readArrow reads an Arrow IPC stream with an integer bodyId column and
+// string property columns
+func readArrow(r io.Reader) (*importTable, error) {
+	reader, err := ipc.NewReader(r, ipc.WithAllocator(memory.NewGoAllocator()))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read Arrow stream: %v", err)
+	}
+	defer reader.Release()
+
+	fields := reader.Schema().Fields()
+	names := make([]string, len(fields))
+	for i, field := range fields {
+		names[i] = field.Name
+	}
+	idCol, props, err := importColumns(names)
+	if err != nil {
+		return nil, err
+	}
+	switch fields[idCol].Type.ID() {
+	case arrow.INT64, arrow.UINT64, arrow.INT32, arrow.UINT32:
+	default:
+		return nil, fmt.Errorf("bodyId column must be an integer, not %s", fields[idCol].Type)
+	}
+	for col, prop := range props {
+		if id := fields[col].Type.ID(); id != arrow.STRING && id != arrow.LARGE_STRING {
+			return nil, fmt.Errorf("%s column must be a string, not %s", prop, fields[col].Type)
+		}
+	}
+
+	table := &importTable{}
+	for reader.Next() {
+		record := reader.Record()
+		for row := 0; row < int(record.NumRows()); row++ {
+			ids := record.Column(idCol)
+			if ids.IsNull(row) {
+				table.rows++
+				table.problems = append(table.problems, fmt.Sprintf("row %d: no body id", table.rows))
+				continue
+			}
+			var bodyID int64
+			switch ids := ids.(type) {
+			case *array.Int64:
+				bodyID = ids.Value(row)
+			case *array.Uint64:
+				bodyID = int64(ids.Value(row))
+			case *array.Int32:
+				bodyID = int64(ids.Value(row))
+			case *array.Uint32:
+				bodyID = int64(ids.Value(row))
+			}
+			update := Update{BodyID: bodyID, Properties: make(map[string]interface{})}
+			for col, prop := range props {
+				switch values := record.Column(col).(type) {
+				case *array.String:
+					if values.IsValid(row) && values.Value(row) != "" {
+						update.Properties[prop] = values.Value(row)
+					}
+				case *array.LargeString:
+					if values.IsValid(row) && values.Value(row) != "" {
+						update.Properties[prop] = values.Value(row)
+					}
+				}
+			}
+			if err := table.add(update); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := reader.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read Arrow stream: %v", err)
+	}
+	return table, nil
+}
+
+// diffImport compares the updates with the dataset a batch at a time
+func diffImport(requester paramRequester, updates []Update, report *ImportReport) error {
+	for start := 0; start < len(updates); start += ImportBatchSize {
+		batch := updates[start:min(start+ImportBatchSize, len(updates))]
+		bodyIDs := make([]int64, len(batch))
+		for i, update := range batch {
+			bodyIDs[i] = update.BodyID
+		}
+		current, err := fetchAnnotations(requester, bodyIDs)
+		if err != nil {
+			return err
+		}
+		changes, unchanged, missing := diffUpdates(batch, current)
+		report.Changed = append(report.Changed, changes...)
+		report.Unchanged = append(report.Unchanged, unchanged...)
+		report.Unknown = append(report.Unknown, missing...)
+	}
+	return nil
+}
+
+// importUpdates diffs valid updates against the dataset and, if asked,
+// applies them.  The changes are written in batches within one transaction
+// that is only committed if every body exists and every batch succeeds.
+func importUpdates(cypher storage.Cypher, updates []Update, apply bool, report *ImportReport) error {
+	if !apply {
+		return diffImport(cypher, updates, report)
+	}
+
+	trans, err := cypher.StartTrans()
+	if err != nil {
+		return err
+	}
+	if err := diffImport(trans, updates, report); err != nil {
+		trans.Kill()
+		return err
+	}
+	if len(report.Unknown) > 0 {
+		trans.Kill()
+		return missingError(report.Unknown)
+	}
+	if len(report.Changed) == 0 {
+		trans.Kill()
+		report.Applied = true
+		return nil
+	}
+	for start := 0; start < len(report.Changed); start += ImportBatchSize {
+		batch := report.Changed[start:min(start+ImportBatchSize, len(report.Changed))]
+		if err := writeChanges(trans, batch); err != nil {
+			trans.Kill()
+			return fmt.Errorf("batch %d failed: %v", report.Batches+1, err)
+		}
+		report.Batches++
+	}
+	if err := touchEdit(trans); err != nil {
+		trans.Kill()
+		return err
+	}
+	if err := trans.Commit(); err != nil {
+		return err
+	}
+	report.Applied = true
+	return nil
+}
+
+// importNeurons updates neuron annotations from an uploaded table
+func (ca cypherAPI) importNeurons(c echo.Context) error {
+	// swagger:operation POST /api/neurons/{dataset}/import neurons importNeurons
+	//
+	// Import neuron annotations from a table
+	//
+	// Reads a CSV table (with a header row) or an Arrow IPC stream with a
+	// bodyId column and type, instance, status or notes columns.  Empty
+	// cells leave a property unchanged and rows without values are
+	// skipped.  Values follow the rules of the single neuron update.
+	//
+	// By default nothing is changed and the report shows the bodies not
+	// found, the rows already up to date, the values that would change and
+	// the rows that are invalid.  With apply=true the changes are written
+	// in batches within one transaction: if any row is invalid or any body
+	// is missing nothing is written.
+	//
+	// ---
+	// consumes:
+	// - text/csv
+	// - application/vnd.apache.arrow.stream
+	// parameters:
+	// - in: "path"
+	//   name: "dataset"
+	//   required: true
+	//   schema:
+	//     type: "string"
+	// - in: "query"
+	//   name: "format"
+	//   description: "csv or arrow (default from the content type, else csv)"
+	// - in: "query"
+	//   name: "apply"
+	//   description: "write the changes instead of only reporting them"
+	//   schema:
+	//     type: "boolean"
+	// - in: "body"
+	//   name: "body"
+	//   required: true
+	//   description: "table of annotations"
+	// responses:
+	//   200:
+	//     description: "successful operation"
+	//     schema:
+	//       type: "object"
+	//       properties:
+	//         rows:
+	//           type: "integer"
+	//         skipped:
+	//           type: "integer"
+	//           description: "rows without values"
+	//         applied:
+	//           type: "boolean"
+	//         batches:
+	//           type: "integer"
+	//           description: "write batches run"
+	//         unknownBodies:
+	//           type: "array"
+	//           items:
+	//             type: "integer"
+	//         unchanged:
+	//           type: "array"
+	//           items:
+	//             type: "integer"
+	//         changed:
+	//           type: "array"
+	//           items:
+	//             type: "object"
+	//             properties:
+	//               bodyId:
+	//                 type: "integer"
+	//               before:
+	//                 type: "object"
+	//               after:
+	//                 type: "object"
+	//         invalid:
+	//           type: "array"
+	//           items:
+	//             type: "string"
+	//   400:
+	//     description: "the table cannot be read, or it cannot be applied (the report is returned)"
+	// security:
+	// - Bearer: []
+
+	dataset := c.Param("dataset")
+	if err := secure.RequireDatasetAccess(c, dataset, EditLevel); err != nil {
+		return err
+	}
+	apply := false
+	if value := c.QueryParam("apply"); value != "" {
+		var err error
+		if apply, err = strconv.ParseBool(value); err != nil {
+			errJSON := api.ErrorInfo{Error: "apply must be true or false"}
+			return c.JSON(http.StatusBadRequest, errJSON)
+		}
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "csv"
+		if strings.Contains(c.Request().Header.Get(echo.HeaderContentType), "arrow") {
+			format = "arrow"
+		}
+	}
+	body := http.MaxBytesReader(c.Response(), c.Request().Body, maxImportBytes)
+	var table *importTable
+	var err error
+	switch format {
+	case "csv":
+		table, err = readCSV(body)
+	case "arrow":
+		table, err = readArrow(body)
+	default:
+		errJSON := api.ErrorInfo{Error: "format must be csv or arrow"}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+
+	report := ImportReport{
+		Rows:      table.rows,
+		Skipped:   table.skipped,
+		Unknown:   []int64{},
+		Unchanged: []int64{},
+		Changed:   []Change{},
+		Invalid:   append([]string{}, table.problems...),
+	}
+	report.Invalid = append(report.Invalid, validateUpdates(table.updates)...)
+	if apply && len(report.Invalid) > 0 {
+		return c.JSON(http.StatusBadRequest, report)
+	}
+
+	// a dry run still diffs the rows that are valid
+	valid := table.updates
+	if len(report.Invalid) > 0 {
+		valid = nil
+		for _, update := range table.updates {
+			if len(validateUpdates([]Update{update})) == 0 {
+				valid = append(valid, update)
+			}
+		}
+	}
+
+	err = importUpdates(ca.Store.GetMain(dataset), valid, apply, &report)
+	if _, ok := err.(missingError); ok {
+		return c.JSON(http.StatusBadRequest, report)
+	}
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusInternalServerError, errJSON)
+	}
+	return c.JSON(http.StatusOK, report)
+}
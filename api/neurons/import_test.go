@@ -0,0 +1,109 @@
+package neurons
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestReadCSV(t *testing.T) {
+	table, err := readCSV(strings.NewReader("bodyId,type,status\n1,MBON01,Traced\n2,,\nx,KC,Anchor\n3,KC,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.rows != 4 || table.skipped != 1 || len(table.updates) != 2 || len(table.problems) != 1 {
+		t.Fatalf("unexpected table %+v", table)
+	}
+	if table.updates[1].BodyID != 3 || len(table.updates[1].Properties) != 1 || table.updates[1].Properties["type"] != "KC" {
+		t.Errorf("unexpected update %+v", table.updates[1])
+	}
+	if !strings.Contains(table.problems[0], "line 4") {
+		t.Errorf("unexpected problem %q", table.problems[0])
+	}
+
+	for _, header := range []string{"type,status", "bodyId", "bodyId,pre", "bodyId,type,type"} {
+		if _, err := readCSV(strings.NewReader(header + "\n")); err == nil {
+			t.Errorf("expected header %q to be rejected", header)
+		}
+	}
+}
+
+func TestReadArrow(t *testing.T) {
+	allocator := memory.NewGoAllocator()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "bodyId", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "status", Type: arrow.BinaryTypes.String, Nullable: true},
+	}, nil)
+	builder := array.NewRecordBuilder(allocator, schema)
+	defer builder.Release()
+	builder.Field(0).(*array.Int64Builder).AppendValues([]int64{5813105172, 2}, nil)
+	builder.Field(1).(*array.StringBuilder).AppendValues([]string{"Traced", ""}, []bool{true, false})
+	record := builder.NewRecord()
+	defer record.Release()
+
+	var buf bytes.Buffer
+	writer := ipc.NewWriter(&buf, ipc.WithSchema(schema))
+	if err := writer.Write(record); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+
+	table, err := readArrow(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.rows != 2 || table.skipped != 1 || len(table.updates) != 1 ||
+		table.updates[0].BodyID != 5813105172 || table.updates[0].Properties["status"] != "Traced" {
+		t.Errorf("unexpected table %+v", table)
+	}
+}
+
+func TestImportUpdates(t *testing.T) {
+	defer func(size int) { ImportBatchSize = size }(ImportBatchSize)
+	ImportBatchSize = 2
+
+	bodies := map[int64][]interface{}{
+		1: {"KC", nil, "Traced", nil},
+		2: {"KC", nil, "Traced", nil},
+		3: {"KC", nil, "Traced", nil},
+	}
+	updates := []Update{
+		{BodyID: 1, Properties: map[string]interface{}{"type": "KCg"}},
+		{BodyID: 2, Properties: map[string]interface{}{"type": "KC"}},
+		{BodyID: 3, Properties: map[string]interface{}{"status": "Anchor"}},
+	}
+
+	// a dry run writes nothing
+	cypher := &annotationCypher{bodies: bodies}
+	var report ImportReport
+	if err := importUpdates(cypher, updates, false, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Applied || len(report.Changed) != 2 || len(report.Unchanged) != 1 || report.Unchanged[0] != 2 || len(cypher.writes) != 0 {
+		t.Errorf("unexpected dry run %+v", report)
+	}
+
+	report = ImportReport{}
+	if err := importUpdates(cypher, updates, true, &report); err != nil {
+		t.Fatal(err)
+	}
+	if !report.Applied || report.Batches != 1 || !cypher.committed || len(cypher.writes) != 2 {
+		t.Errorf("unexpected import %+v (writes %v)", report, cypher.writes)
+	}
+
+	// an unknown body rolls back the whole import
+	cypher = &annotationCypher{bodies: bodies}
+	report = ImportReport{}
+	updates = append(updates, Update{BodyID: 4, Properties: map[string]interface{}{"type": "KC"}})
+	if err := importUpdates(cypher, updates, true, &report); err == nil {
+		t.Error("expected the unknown body to fail the import")
+	}
+	if report.Applied || cypher.committed || !cypher.killed || len(cypher.writes) != 0 || len(report.Unknown) != 1 {
+		t.Errorf("unexpected failed import %+v", report)
+	}
+}
@@ -0,0 +1,196 @@
+package neurons
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/api"
+	"github.com/connectome-neuprint/neuPrintHTTP/secure"
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+	"github.com/labstack/echo/v4"
+)
+
+func init() {
+	api.RegisterAPI(PREFIX, setupAPI)
+}
+
+const PREFIX = "/neurons"
+
+const (
+	// NeuronKeysQuery lists every property stored on neurons
+	NeuronKeysQuery = "MATCH (n :Neuron) UNWIND KEYS(n) AS x RETURN DISTINCT x AS pname"
+)
+
+type cypherAPI struct {
+	Store storage.Store
+}
+
+// datasetKeys caches the neuron properties for a dataset until it is edited
+type datasetKeys struct {
+	lastEdit string
+	keys     map[string]bool
+}
+
+var keyCache = make(map[string]datasetKeys)
+var keyMux sync.Mutex
+
+// setupAPI sets up the typed neuron endpoints
+func setupAPI(mainapi *api.ConnectomeAPI) error {
+	q := &cypherAPI{mainapi.Store}
+
+	endPoint := "search"
+	mainapi.SupportedEndpoints[endPoint] = true
+	mainapi.SetRoute(api.POST, PREFIX+"/"+endPoint, q.searchNeurons, api.GuardedRoute)
+
+	// annotation updates are audited and need an authenticated user; the
+	// handlers check the configured access level for the dataset
+	mainapi.SetAdminRoute(api.PATCH, PREFIX+"/:dataset/:bodyId", q.updateNeuron)
+	mainapi.SetAdminRoute(api.PATCH, PREFIX+"/:dataset", q.updateNeurons)
+	mainapi.SetAdminRoute(api.POST, PREFIX+"/:dataset/import", q.importNeurons)
+	mainapi.SupportedEndpoints["annotate"] = true
+	return nil
+}
+
+// knownProperties returns the properties stored on neurons in a dataset,
+// refreshing the cached list when the dataset has been edited.
+func (ca cypherAPI) knownProperties(dataset string) (map[string]bool, error) {
+	cypher := ca.Store.GetMain(dataset)
+	edit, err := storage.LastEdit(cypher)
+	if err != nil {
+		return nil, err
+	}
+
+	keyMux.Lock()
+	cached, ok := keyCache[dataset]
+	keyMux.Unlock()
+	if ok && cached.lastEdit == edit {
+		return cached.keys, nil
+	}
+
+	res, err := cypher.CypherRequest(NeuronKeysQuery, true)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool, len(res.Data))
+	for _, row := range res.Data {
+		if key, ok := row[0].(string); ok {
+			keys[key] = true
+		}
+	}
+
+	keyMux.Lock()
+	keyCache[dataset] = datasetKeys{edit, keys}
+	keyMux.Unlock()
+	return keys, nil
+}
+
+// searchNeurons runs a filter tree search against neurons or segments
+func (ca cypherAPI) searchNeurons(c echo.Context) error {
+	// swagger:operation POST /api/neurons/search neurons searchNeurons
+	//
+	// Search neurons with a JSON filter
+	//
+	// The filter is a tree of "and", "or" and "not" nodes over property
+	// comparisons (=, <>, <, <=, >, >=, =~, in, contains, starts_with,
+	// ends_with, is_null, not_null), ROI membership and pre/post thresholds.
+	// Property names are checked against the properties stored for the
+	// dataset and values are passed to the database as query parameters.
+	//
+	// ---
+	// parameters:
+	// - in: "body"
+	//   name: "body"
+	//   required: true
+	//   schema:
+	//     type: "object"
+	//     required: ["dataset"]
+	//     properties:
+	//       dataset:
+	//         type: "string"
+	//         description: "dataset name"
+	//         example: "hemibrain"
+	//       all_segments:
+	//         type: "boolean"
+	//         description: "search all segments instead of neurons"
+	//       filter:
+	//         type: "object"
+	//         description: "filter tree"
+	//         example: {"and": [{"property": "type", "op": "=~", "value": "MBON.*"}, {"roi": "MB(R)", "pre_threshold": 10}, {"not": {"property": "cropped", "op": "=", "value": true}}]}
+	//       return:
+	//         type: "array"
+	//         items:
+	//           type: "string"
+	//         description: "properties to return (default bodyId, type, instance, status, pre, post)"
+	//       sort:
+	//         type: "array"
+	//         items:
+	//           type: "object"
+	//           properties:
+	//             property:
+	//               type: "string"
+	//             descending:
+	//               type: "boolean"
+	//         description: "sort order (ties are broken by body id)"
+	//       limit:
+	//         type: "integer"
+	//         description: "maximum number of rows (default 1000)"
+	//       offset:
+	//         type: "integer"
+	//         description: "number of rows to skip"
+	// responses:
+	//   200:
+	//     description: "successful operation"
+	//     schema:
+	//       type: "object"
+	//       properties:
+	//         columns:
+	//           type: "array"
+	//           items:
+	//             type: "string"
+	//           description: "Name of each result column"
+	//         data:
+	//           type: "array"
+	//           items:
+	//             type: "array"
+	//             items:
+	//               type: "null"
+	//               description: "Cell value"
+	//             description: "Table row"
+	//           description: "Table of results"
+	// security:
+	// - Bearer: []
+
+	var params SearchParams
+	decoder := json.NewDecoder(c.Request().Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&params); err != nil {
+		errJSON := api.ErrorInfo{Error: "request object not formatted correctly"}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	c.Set("dataset", params.Dataset)
+	if err := secure.RequireDatasetAccess(c, params.Dataset, secure.READ); err != nil {
+		return err
+	}
+
+	known, err := ca.knownProperties(params.Dataset)
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+
+	cypher, qparams, err := CompileSearch(params, known)
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: fmt.Sprintf("invalid search: %v", err)}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	c.Set("debug", cypher)
+
+	if data, err := ca.Store.GetMain(params.Dataset).CypherRequestWithParams(cypher, qparams, true); err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	} else {
+		return c.JSON(http.StatusOK, data)
+	}
+}
@@ -0,0 +1,783 @@
+package npexplorer
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+)
+
+const (
+	CellTypeQuery = "MATCH (n :Neuron {type: $type})-[x :ConnectsTo]-(m) RETURN n.bodyId AS bodyId, n.instance AS instance, x.weight AS weight, m.bodyId AS bodyId2, m.type AS type2, (startNode(x) = n) as isOutput, n.status AS body1status, m.status AS body2status, m.cropped AS iscropped2, n.cropped AS iscropped1"
+
+	// ignore connections after the top 25% (with some error margin)
+	importanceCutoff = 0.25
+
+	// number of connection errors reasonably possible based on proofreading
+	tracingAccuracy = 5
+
+	// fraction of the group weight a neuron weight must be within to match
+	matchCutoff = 0.5
+
+	// connections weaker than this are not used to compare neurons
+	cellTypeMinWeight = 3
+)
+
+var errNoCellType = fmt.Errorf("no cell type exists")
+
+// statuses of neurons that are used in the analysis
+var cellTypePrimaryStatuses = map[string]bool{
+	"Traced": true,
+	"Anchor": true,
+}
+
+// CellTypeConnection is a connection between a neuron of the cell type and
+// a partner.  Partners without a type are identified by body id.
+type CellTypeConnection struct {
+	Weight  int
+	BodyId  int64
+	Partner string
+	HasType bool
+}
+
+// UnmarshalJSON reads a connection written as [weight, bodyId, partner, hastype]
+func (conn *CellTypeConnection) UnmarshalJSON(data []byte) error {
+	fields := []interface{}{&conn.Weight, &conn.BodyId, &conn.Partner, &conn.HasType}
+	return json.Unmarshal(data, &fields)
+}
+
+// CellTypeData summarizes the connectivity of the neurons of a cell type
+type CellTypeData struct {
+	NeuronInstance       map[int64]string               `json:"neuron_instance"`
+	UniqueNeurons        []int64                        `json:"unique_neurons"`
+	GoodNeurons          []int64                        `json:"good_neurons"`
+	OutputSize           map[int64]int                  `json:"output_size"`
+	InputSize            map[int64]int                  `json:"input_size"`
+	OutputComp           map[int64]int                  `json:"output_comp"`
+	InputComp            map[int64]int                  `json:"input_comp"`
+	CellTypeListsOutputs map[int64][]CellTypeConnection `json:"celltype_lists_outputs"`
+	CellTypeListsInputs  map[int64][]CellTypeConnection `json:"celltype_lists_inputs"`
+}
+
+// SplitTable is a table stored as index, columns and row data
+type SplitTable struct {
+	Index   []interface{}   `json:"index"`
+	Columns []interface{}   `json:"columns"`
+	Data    [][]interface{} `json:"data"`
+}
+
+type CellTypeNeuronInfo struct {
+	InputSize    int    `json:"input-size"`
+	OutputSize   int    `json:"output-size"`
+	InputComp    int    `json:"input-comp"`
+	OutputComp   *int   `json:"output-comp,omitempty"`
+	Reference    bool   `json:"reference"`
+	InstanceName string `json:"instance-name"`
+}
+
+// CellTypeResult is the canonical connectivity of a cell type.  The
+// comparison between neurons is only given when there is more than one.
+type CellTypeResult struct {
+	NeuronInfo     map[int64]CellTypeNeuronInfo `json:"neuroninfo"`
+	CentroidNeuron int64                        `json:"centroid-neuron"`
+	NeuronInputs   map[int64]SplitTable         `json:"neuron-inputs"`
+	NeuronOutputs  map[int64]SplitTable         `json:"neuron-outputs"`
+	*CellTypeComparison
+}
+
+type CellTypeComparison struct {
+	DistMatrix          SplitTable           `json:"dist-matrix"`
+	AverageDistance     float64              `json:"average-distance"`
+	NeuronMissedInputs  map[int64]SplitTable `json:"neuron-missed-inputs"`
+	NeuronMissedOutputs map[int64]SplitTable `json:"neuron-missed-outputs"`
+	CommonInputs        SplitTable           `json:"common-inputs"`
+	CommonOutputs       SplitTable           `json:"common-outputs"`
+	CommonInputsMed     SplitTable           `json:"common-inputs-med"`
+	CommonOutputsMed    SplitTable           `json:"common-outputs-med"`
+}
+
+// cellTypeEntry is a connection being ranked
+type cellTypeEntry struct {
+	CellTypeConnection
+	important bool
+	examined  bool
+}
+
+// featureTable holds the weight of each neuron's connection to each
+// ranked partner
+type featureTable struct {
+	neurons  []int64
+	names    []string
+	features [][]float64
+}
+
+func (ft *featureTable) rows(neurons []int64) [][]float64 {
+	pos := make(map[int64]int, len(ft.neurons))
+	for i, neuron := range ft.neurons {
+		pos[neuron] = i
+	}
+	rows := make([][]float64, len(neurons))
+	for i, neuron := range neurons {
+		rows[i] = ft.features[pos[neuron]]
+	}
+	return rows
+}
+
+// sortedUnique returns the distinct body ids in ascending order
+func sortedUnique(ids []int64) []int64 {
+	seen := make(map[int64]bool, len(ids))
+	res := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			res = append(res, id)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+// sortCellTypeLists orders each neuron's connections from strongest to
+// weakest and marks the important ones: those up to roughly the weight that
+// reaches importanceCutoff of the total (but at least 6 connections).
+func sortCellTypeLists(lists map[int64][]CellTypeConnection) map[int64][]*cellTypeEntry {
+	res := make(map[int64][]*cellTypeEntry, len(lists))
+	for bodyId, conns := range lists {
+		entries := make([]*cellTypeEntry, len(conns))
+		total := 0
+		for i, conn := range conns {
+			entries[i] = &cellTypeEntry{CellTypeConnection: conn}
+			total += conn.Weight
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			if entries[i].Weight != entries[j].Weight {
+				return entries[i].Weight > entries[j].Weight
+			}
+			return entries[i].BodyId > entries[j].BodyId
+		})
+
+		importantCount := 0
+		count := 0
+		threshold := 0.0
+		for _, entry := range entries {
+			weight := float64(entry.Weight)
+			if weight < threshold && importantCount > 5 {
+				break
+			}
+			importantCount++
+			entry.important = true
+
+			count += entry.Weight
+			if threshold == 0 && float64(count) > float64(total)*importanceCutoff {
+				threshold = weight - math.Sqrt(weight)
+			}
+		}
+		res[bodyId] = entries
+	}
+	return res
+}
+
+// newFeatureTable ranks the partners of the working set from the strongest
+// important connection down, matching each partner once per neuron, and
+// tabulates every neuron's weight to each ranked partner.
+func newFeatureTable(lists map[int64][]*cellTypeEntry, workingSet, neurons []int64) *featureTable {
+	type queued struct {
+		neuron int64
+		entry  *cellTypeEntry
+	}
+	var queue []queued
+	for _, neuron := range workingSet {
+		for _, entry := range lists[neuron] {
+			entry.examined = false
+			if entry.important {
+				queue = append(queue, queued{neuron, entry})
+			}
+		}
+	}
+	sort.SliceStable(queue, func(i, j int) bool {
+		a, b := queue[i], queue[j]
+		if a.entry.Weight != b.entry.Weight {
+			return a.entry.Weight > b.entry.Weight
+		}
+		if a.entry.BodyId != b.entry.BodyId {
+			return a.entry.BodyId > b.entry.BodyId
+		}
+		return a.neuron > b.neuron
+	})
+
+	type rankKey struct {
+		partner string
+		hasType bool
+	}
+	var rank []rankKey
+	for _, item := range queue {
+		if item.entry.examined {
+			continue
+		}
+		key := rankKey{item.entry.Partner, item.entry.HasType}
+		rank = append(rank, key)
+
+		for _, neuron := range workingSet {
+			for _, entry := range lists[neuron] {
+				if !entry.examined && entry.Partner == key.partner && entry.HasType == key.hasType {
+					entry.examined = true
+					break
+				}
+			}
+		}
+	}
+
+	ft := &featureTable{
+		neurons:  neurons,
+		names:    make([]string, len(rank)),
+		features: make([][]float64, len(neurons)),
+	}
+	for i, key := range rank {
+		ft.names[i] = key.partner
+	}
+	for i, neuron := range neurons {
+		matches := make(map[rankKey][]int)
+		for _, entry := range lists[neuron] {
+			key := rankKey{entry.Partner, entry.HasType}
+			matches[key] = append(matches[key], entry.Weight)
+		}
+		ft.features[i] = make([]float64, len(rank))
+		for j, key := range rank {
+			if weights := matches[key]; len(weights) > 0 {
+				ft.features[i][j] = float64(weights[0])
+				matches[key] = weights[1:]
+			}
+		}
+	}
+	return ft
+}
+
+// normalizeFeatures squashes the weights with a sigmoid and scales the
+// input and output features of each neuron to unit length
+func normalizeFeatures(inputs, outputs [][]float64) [][]float64 {
+	squash := func(rows [][]float64) [][]float64 {
+		res := make([][]float64, len(rows))
+		for i, row := range rows {
+			res[i] = make([]float64, len(row))
+			norm := 0.0
+			for j, val := range row {
+				res[i][j] = 1 / (1 + math.Exp(-((val - 17) / 20)))
+				norm += res[i][j] * res[i][j]
+			}
+			if norm = math.Sqrt(norm); norm > 0 {
+				for j := range res[i] {
+					res[i][j] /= norm
+				}
+			}
+		}
+		return res
+	}
+
+	inCols, outCols := 0, 0
+	if len(inputs) > 0 {
+		inCols, outCols = len(inputs[0]), len(outputs[0])
+	}
+	switch {
+	case inCols == 0 && outCols == 0:
+		return inputs
+	case inCols == 0:
+		return squash(outputs)
+	case outCols == 0:
+		return squash(inputs)
+	}
+
+	in, out := squash(inputs), squash(outputs)
+	scale := math.Sqrt(0.5)
+	res := make([][]float64, len(in))
+	for i := range in {
+		res[i] = make([]float64, 0, inCols+outCols)
+		for _, val := range in[i] {
+			res[i] = append(res[i], val*scale)
+		}
+		for _, val := range out[i] {
+			res[i] = append(res[i], val*scale)
+		}
+	}
+	return res
+}
+
+// median returns the median of each column
+func median(rows [][]float64, ncols int) []float64 {
+	res := make([]float64, ncols)
+	for j := 0; j < ncols; j++ {
+		col := make([]float64, len(rows))
+		for i, row := range rows {
+			col[i] = row[j]
+		}
+		sort.Float64s(col)
+		n := len(col)
+		if n == 0 {
+			continue
+		}
+		if n%2 == 1 {
+			res[j] = col[n/2]
+		} else {
+			res[j] = (col[n/2-1] + col[n/2]) / 2
+		}
+	}
+	return res
+}
+
+// orderByMedian reorders the features by decreasing median over the working
+// set (ties keep the later feature first) and returns the medians
+func (ft *featureTable) orderByMedian(workingSet []int64) []float64 {
+	med := median(ft.rows(workingSet), len(ft.names))
+	order := make([]int, len(med))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return med[order[i]] < med[order[j]] })
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+
+	names := make([]string, len(order))
+	sortedMed := make([]float64, len(order))
+	for k, idx := range order {
+		names[k] = ft.names[idx]
+		sortedMed[k] = med[idx]
+	}
+	for i, row := range ft.features {
+		newRow := make([]float64, len(order))
+		for k, idx := range order {
+			newRow[k] = row[idx]
+		}
+		ft.features[i] = newRow
+	}
+	ft.names = names
+	return sortedMed
+}
+
+func (ft *featureTable) splitTable() SplitTable {
+	table := SplitTable{
+		Index:   make([]interface{}, len(ft.neurons)),
+		Columns: make([]interface{}, len(ft.names)),
+		Data:    make([][]interface{}, len(ft.neurons)),
+	}
+	for i, neuron := range ft.neurons {
+		table.Index[i] = neuron
+		table.Data[i] = make([]interface{}, len(ft.names))
+		for j, val := range ft.features[i] {
+			table.Data[i][j] = val
+		}
+	}
+	for j, name := range ft.names {
+		table.Columns[j] = name
+	}
+	return table
+}
+
+func medianTable(names []string, med []float64) SplitTable {
+	table := SplitTable{
+		Index:   make([]interface{}, len(names)),
+		Columns: []interface{}{"median"},
+		Data:    make([][]interface{}, len(names)),
+	}
+	for i, name := range names {
+		table.Index[i] = name
+		table.Data[i] = []interface{}{med[i]}
+	}
+	return table
+}
+
+func newSplitTable(columns []interface{}, rows [][]interface{}) SplitTable {
+	table := SplitTable{
+		Index:   make([]interface{}, len(rows)),
+		Columns: columns,
+		Data:    rows,
+	}
+	for i := range rows {
+		table.Index[i] = i
+	}
+	if table.Data == nil {
+		table.Data = [][]interface{}{}
+	}
+	return table
+}
+
+// withinMatch reports whether a neuron weight is consistent with a group weight
+func withinMatch(group, weight float64) bool {
+	return (group > weight*matchCutoff && group*matchCutoff < weight) || math.Abs(group-weight) <= tracingAccuracy
+}
+
+// cellTypeMatches compares each neuron's important connections to the group
+// medians and lists the group partners each neuron is missing
+func cellTypeMatches(lists map[int64][]*cellTypeEntry, names []string, med []float64) (map[int64]SplitTable, map[int64]SplitTable) {
+	matched := make(map[int64]SplitTable, len(lists))
+	missed := make(map[int64]SplitTable, len(lists))
+	for bodyId, entries := range lists {
+		used := make(map[int]bool)
+		neuronToGroup := make([]float64, len(entries))
+		groupWeight := make(map[int]float64)
+		for idx, entry := range entries {
+			weight := float64(entry.Weight)
+			matchId := -1
+			matchVal := 0.0
+			for fid, name := range names {
+				if name != entry.Partner || used[fid] {
+					continue
+				}
+				if matchId == -1 {
+					matchId = fid
+					matchVal = med[fid]
+				}
+				if withinMatch(med[fid], weight) {
+					matchId = fid
+					matchVal = med[fid]
+					break
+				}
+			}
+			used[matchId] = true
+			neuronToGroup[idx] = matchVal
+			groupWeight[matchId] = matchVal
+		}
+
+		var rows [][]interface{}
+		for idx, entry := range entries {
+			if !entry.important {
+				break
+			}
+			good := withinMatch(neuronToGroup[idx], float64(entry.Weight))
+			rows = append(rows, []interface{}{entry.Partner, entry.Weight, neuronToGroup[idx], good})
+		}
+		matched[bodyId] = newSplitTable([]interface{}{"type", "neuron weight", "group weight", "good match"}, rows)
+
+		var missedRows [][]interface{}
+		for fid, name := range names {
+			weight := groupWeight[fid]
+			if med[fid]*matchCutoff >= weight && med[fid] > weight+tracingAccuracy {
+				missedRows = append(missedRows, []interface{}{name, med[fid], weight})
+			}
+		}
+		missed[bodyId] = newSplitTable([]interface{}{"type", "group weight", "neuron weight"}, missedRows)
+	}
+	return matched, missed
+}
+
+// CanonicalCellType determines the typical connectivity of a cell type.
+//
+// Reference neurons are the good neurons with at least half the connections
+// of the largest one.  Each neuron is described by its weights to the
+// strongest partners of the reference neurons; the centroid neuron is the
+// one closest to the mean of the references and every neuron's important
+// connections are compared to the reference medians.
+func CanonicalCellType(data *CellTypeData) *CellTypeResult {
+	numConn := func(neuron int64) int {
+		return data.InputSize[neuron] + data.OutputSize[neuron]
+	}
+
+	good := sortedUnique(data.GoodNeurons)
+	maxConn := 0
+	for _, neuron := range good {
+		if conn := numConn(neuron); conn > maxConn {
+			maxConn = conn
+		}
+	}
+	reference := make(map[int64]bool)
+	var workingSet []int64
+	for _, neuron := range good {
+		if float64(numConn(neuron)) >= float64(maxConn)*0.5 {
+			reference[neuron] = true
+			workingSet = append(workingSet, neuron)
+		}
+	}
+
+	// reference neurons come first
+	var neurons []int64
+	unique := sortedUnique(data.UniqueNeurons)
+	for _, neuron := range unique {
+		if reference[neuron] {
+			neurons = append(neurons, neuron)
+		}
+	}
+	for _, neuron := range unique {
+		if !reference[neuron] {
+			neurons = append(neurons, neuron)
+		}
+	}
+	if len(workingSet) == 0 {
+		workingSet = neurons
+	}
+
+	result := &CellTypeResult{
+		NeuronInfo:     make(map[int64]CellTypeNeuronInfo, len(neurons)),
+		CentroidNeuron: -1,
+	}
+	for _, neuron := range neurons {
+		info := CellTypeNeuronInfo{
+			InputSize:    data.InputSize[neuron],
+			OutputSize:   data.OutputSize[neuron],
+			InputComp:    data.InputComp[neuron],
+			Reference:    reference[neuron],
+			InstanceName: data.NeuronInstance[neuron],
+		}
+		if comp, ok := data.OutputComp[neuron]; ok {
+			info.OutputComp = &comp
+		}
+		result.NeuronInfo[neuron] = info
+	}
+
+	inputLists := sortCellTypeLists(data.CellTypeListsInputs)
+	outputLists := sortCellTypeLists(data.CellTypeListsOutputs)
+	inputs := newFeatureTable(inputLists, workingSet, neurons)
+	outputs := newFeatureTable(outputLists, workingSet, neurons)
+
+	// the centroid is the reference closest to the mean reference
+	allFeatures := normalizeFeatures(inputs.features, outputs.features)
+	workingFeatures := normalizeFeatures(inputs.rows(workingSet), outputs.rows(workingSet))
+	if len(workingFeatures) > 0 {
+		avg := make([]float64, len(workingFeatures[0]))
+		for _, row := range workingFeatures {
+			for j, val := range row {
+				avg[j] += val
+			}
+		}
+		for j := range avg {
+			avg[j] /= float64(len(workingFeatures))
+		}
+		minVal := math.MaxFloat64
+		for i, row := range workingFeatures {
+			dist := 0.0
+			for j, val := range row {
+				dist += (avg[j] - val) * (avg[j] - val)
+			}
+			if dist < minVal {
+				minVal = dist
+				result.CentroidNeuron = workingSet[i]
+			}
+		}
+	}
+
+	inputMed := inputs.orderByMedian(workingSet)
+	outputMed := outputs.orderByMedian(workingSet)
+
+	result.NeuronInputs, _ = cellTypeMatches(inputLists, inputs.names, inputMed)
+	result.NeuronOutputs, _ = cellTypeMatches(outputLists, outputs.names, outputMed)
+	if len(neurons) <= 1 {
+		return result
+	}
+
+	comparison := &CellTypeComparison{
+		DistMatrix: SplitTable{
+			Index:   make([]interface{}, len(neurons)),
+			Columns: make([]interface{}, len(neurons)),
+			Data:    make([][]interface{}, len(neurons)),
+		},
+		CommonInputs:     inputs.splitTable(),
+		CommonOutputs:    outputs.splitTable(),
+		CommonInputsMed:  medianTable(inputs.names, inputMed),
+		CommonOutputsMed: medianTable(outputs.names, outputMed),
+	}
+	total := 0.0
+	for i, neuron := range neurons {
+		comparison.DistMatrix.Index[i] = neuron
+		comparison.DistMatrix.Columns[i] = neuron
+		comparison.DistMatrix.Data[i] = make([]interface{}, len(neurons))
+		for j := range neurons {
+			dist := 0.0
+			for k := range allFeatures[i] {
+				diff := allFeatures[i][k] - allFeatures[j][k]
+				dist += diff * diff
+			}
+			dist = math.Sqrt(dist)
+			comparison.DistMatrix.Data[i][j] = dist
+			total += dist
+		}
+	}
+	n := float64(len(neurons))
+	comparison.AverageDistance = total / (n*n - n)
+	_, comparison.NeuronMissedInputs = cellTypeMatches(inputLists, inputs.names, inputMed)
+	_, comparison.NeuronMissedOutputs = cellTypeMatches(outputLists, outputs.names, outputMed)
+	result.CellTypeComparison = comparison
+	return result
+}
+
+// newCellTypeData collects the connectivity of each neuron of a cell type
+// from the rows of CellTypeQuery
+func newCellTypeData(res storage.CypherResult) (*CellTypeData, error) {
+	data := &CellTypeData{
+		NeuronInstance:       make(map[int64]string),
+		OutputSize:           make(map[int64]int),
+		InputSize:            make(map[int64]int),
+		OutputComp:           make(map[int64]int),
+		InputComp:            make(map[int64]int),
+		CellTypeListsOutputs: make(map[int64][]CellTypeConnection),
+		CellTypeListsInputs:  make(map[int64][]CellTypeConnection),
+	}
+	unique := make(map[int64]bool)
+	good := make(map[int64]bool)
+
+	for _, row := range res.Data {
+		bodyId, ok := row[0].(int64)
+		if !ok {
+			return nil, fmt.Errorf("body id1 not parsed properly")
+		}
+		bodyId2, ok := row[3].(int64)
+		if !ok {
+			return nil, fmt.Errorf("body id2 not parsed properly")
+		}
+		weight64, ok := row[2].(int64)
+		if !ok {
+			return nil, fmt.Errorf("weight not parsed properly")
+		}
+		weight := int(weight64)
+		isOutput, ok := row[5].(bool)
+		if !ok {
+			return nil, fmt.Errorf("output direction not parsed properly")
+		}
+		instance, _ := row[1].(string)
+		partnerType, _ := row[4].(string)
+		status, _ := row[6].(string)
+		status2, _ := row[7].(string)
+		isCropped2, _ := row[8].(bool)
+		isCropped1, _ := row[9].(bool)
+
+		data.NeuronInstance[bodyId] = instance
+
+		// ignore neurons that have not been traced
+		if !cellTypePrimaryStatuses[status] {
+			continue
+		}
+		unique[bodyId] = true
+		if isOutput {
+			data.OutputSize[bodyId] += weight
+		} else {
+			data.InputSize[bodyId] += weight
+		}
+
+		// ignore connections to partners that have not been traced
+		if !cellTypePrimaryStatuses[status2] {
+			continue
+		}
+		if isOutput {
+			data.OutputComp[bodyId] += weight
+		} else {
+			data.InputComp[bodyId] += weight
+		}
+
+		hasType := true
+		if partnerType == "" {
+			partnerType = strconv.FormatInt(bodyId2, 10)
+			hasType = false
+		}
+
+		// ignore untyped fragments and weak connections
+		if !hasType && isCropped2 {
+			continue
+		}
+		if weight < cellTypeMinWeight {
+			continue
+		}
+
+		if !isCropped1 {
+			good[bodyId] = true
+		}
+		conn := CellTypeConnection{weight, bodyId2, partnerType, hasType}
+		if isOutput {
+			data.CellTypeListsOutputs[bodyId] = append(data.CellTypeListsOutputs[bodyId], conn)
+		} else {
+			data.CellTypeListsInputs[bodyId] = append(data.CellTypeListsInputs[bodyId], conn)
+		}
+	}
+
+	for bodyId := range unique {
+		data.UniqueNeurons = append(data.UniqueNeurons, bodyId)
+	}
+	for bodyId := range good {
+		data.GoodNeurons = append(data.GoodNeurons, bodyId)
+	}
+	return data, nil
+}
+
+// MaxCellTypeCache bounds the number of cell type results kept; the least
+// recently used result is dropped first
+const MaxCellTypeCache = 256
+
+// cellTypeCacheEntry is a cell type result for a version of the dataset
+type cellTypeCacheEntry struct {
+	key      string
+	lastEdit string
+	result   *CellTypeResult
+}
+
+// cellTypeCache holds the entries by dataset and cell type, and
+// cellTypeOrder the entries most recently used first
+var cellTypeCache = make(map[string]*list.Element)
+var cellTypeOrder = list.New()
+var cellTypeMux sync.Mutex
+
+// cachedCellType returns the cached result for a key if it is for the
+// given edit of the dataset
+func cachedCellType(key, edit string) *CellTypeResult {
+	cellTypeMux.Lock()
+	defer cellTypeMux.Unlock()
+	elem, ok := cellTypeCache[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*cellTypeCacheEntry)
+	if entry.lastEdit != edit {
+		cellTypeOrder.Remove(elem)
+		delete(cellTypeCache, key)
+		return nil
+	}
+	cellTypeOrder.MoveToFront(elem)
+	return entry.result
+}
+
+// storeCellType caches a result, dropping the least recently used results
+// beyond MaxCellTypeCache
+func storeCellType(key, edit string, result *CellTypeResult) {
+	cellTypeMux.Lock()
+	defer cellTypeMux.Unlock()
+	if elem, ok := cellTypeCache[key]; ok {
+		cellTypeOrder.Remove(elem)
+	}
+	cellTypeCache[key] = cellTypeOrder.PushFront(&cellTypeCacheEntry{key, edit, result})
+	for cellTypeOrder.Len() > MaxCellTypeCache {
+		oldest := cellTypeOrder.Back()
+		cellTypeOrder.Remove(oldest)
+		delete(cellTypeCache, oldest.Value.(*cellTypeCacheEntry).key)
+	}
+}
+
+// ExplorerCellType implements API to find the canonical connectivity of a
+// cell type.  Results are cached until the dataset is edited.
+func (store cypherAPI) ExplorerCellType(dataset, celltype string) (*CellTypeResult, error) {
+	cypher := store.Store.GetMain(dataset)
+	edit, err := storage.LastEdit(cypher)
+	if err != nil {
+		return nil, err
+	}
+
+	key := dataset + "\x00" + celltype
+	if cached := cachedCellType(key, edit); cached != nil {
+		return cached, nil
+	}
+
+	res, err := cypher.CypherRequestWithParams(CellTypeQuery, map[string]interface{}{"type": celltype}, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Data) == 0 {
+		return nil, errNoCellType
+	}
+	data, err := newCellTypeData(res)
+	if err != nil {
+		return nil, err
+	}
+	result := CanonicalCellType(data)
+
+	storeCellType(key, edit, result)
+	return result, nil
+}
@@ -0,0 +1,136 @@
+package npexplorer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+)
+
+// compareJSON reports the first difference between two decoded JSON values,
+// allowing for floating point rounding
+func compareJSON(path string, got, want interface{}) error {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok || len(g) != len(w) {
+			return fmt.Errorf("%s: got %v, want %v", path, got, want)
+		}
+		for key, val := range w {
+			if err := compareJSON(path+"/"+key, g[key], val); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok || len(g) != len(w) {
+			return fmt.Errorf("%s: got %v, want %v", path, got, want)
+		}
+		for i := range w {
+			if err := compareJSON(fmt.Sprintf("%s[%d]", path, i), g[i], w[i]); err != nil {
+				return err
+			}
+		}
+	case float64:
+		g, ok := got.(float64)
+		if !ok || math.Abs(g-w) > 1e-9 {
+			return fmt.Errorf("%s: got %v, want %v", path, got, want)
+		}
+	default:
+		if got != want {
+			return fmt.Errorf("%s: got %v, want %v", path, got, want)
+		}
+	}
+	return nil
+}
+
+// The golden outputs in testdata/celltype were produced by the former
+// canonical_celltype.py script from the matching inputs.
+func TestCanonicalCellTypeGolden(t *testing.T) {
+	for _, name := range []string{"typical", "noreference", "single"} {
+		raw, err := os.ReadFile(filepath.Join("testdata", "celltype", name+".input.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data CellTypeData
+		if err := json.Unmarshal(raw, &data); err != nil {
+			t.Fatalf("%s: bad input: %v", name, err)
+		}
+
+		result, err := json.Marshal(CanonicalCellType(&data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var got, want interface{}
+		json.Unmarshal(result, &got)
+		raw, err = os.ReadFile(filepath.Join("testdata", "celltype", name+".golden.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(raw, &want); err != nil {
+			t.Fatalf("%s: bad golden file: %v", name, err)
+		}
+		if err := compareJSON(name, got, want); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestExplorerCellTypeCache(t *testing.T) {
+	edit := "2024-01-01"
+	mock := &mockCypher{respond: func(query string, params map[string]interface{}) storage.CypherResult {
+		if query == storage.LastEditQuery {
+			return storage.CypherResult{Data: [][]interface{}{{edit}}}
+		}
+		return storage.CypherResult{Data: [][]interface{}{
+			{int64(1), "A_R", int64(10), int64(100), "B", true, "Traced", "Traced", false, false},
+			{int64(1), "A_R", int64(5), int64(101), nil, false, "Traced", "Traced", false, false},
+			{int64(2), "A_L", int64(8), int64(100), "B", true, "Traced", "Traced", false, false},
+		}}
+	}}
+	api := cypherAPI{&mockStore{mock}}
+
+	res, err := api.ExplorerCellType("cachetest", "A")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.NeuronInfo) != 2 || res.CellTypeComparison == nil {
+		t.Errorf("unexpected result: %v", res)
+	}
+	if mock.params[1]["type"] != "A" {
+		t.Errorf("cell type not passed as a parameter: %v", mock.params[1])
+	}
+
+	if _, err := api.ExplorerCellType("cachetest", "A"); err != nil || len(mock.queries) != 3 {
+		t.Errorf("expected cached result, queries: %v", mock.queries)
+	}
+	edit = "2024-02-01"
+	if _, err := api.ExplorerCellType("cachetest", "A"); err != nil || len(mock.queries) != 5 {
+		t.Errorf("expected recomputation after edit, queries: %v", mock.queries)
+	}
+}
+
+func TestCellTypeCacheBound(t *testing.T) {
+	result := &CellTypeResult{}
+	for i := 0; i < MaxCellTypeCache+10; i++ {
+		storeCellType("bound\x00"+strconv.Itoa(i), "e1", result)
+		if i == MaxCellTypeCache-1 {
+			// used recently, so kept
+			cachedCellType("bound\x000", "e1")
+		}
+	}
+	if len(cellTypeCache) > MaxCellTypeCache || cellTypeOrder.Len() != len(cellTypeCache) {
+		t.Errorf("cache holds %d results, the maximum is %d", len(cellTypeCache), MaxCellTypeCache)
+	}
+	if cachedCellType("bound\x000", "e1") == nil || cachedCellType("bound\x001", "e1") != nil {
+		t.Error("expected the least recently used results to be dropped")
+	}
+	if cachedCellType("bound\x000", "e2") != nil || cachedCellType("bound\x000", "e1") != nil {
+		t.Error("expected results of an old edit to be dropped")
+	}
+}
@@ -0,0 +1,97 @@
+package npexplorer
+
+import (
+	"fmt"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+)
+
+const (
+	// MaxSetBodies bounds the number of bodies a neuron set can resolve to
+	MaxSetBodies = 50000
+
+	// ExpandChunkSize is the number of bodies expanded per query
+	ExpandChunkSize = 500
+
+	NeuronSetQuery = "MATCH (n :Segment) WHERE n.bodyId IN $bodyIds OR n.type IN $types OR any(pattern IN $instances WHERE n.instance =~ pattern) RETURN n.bodyId AS bodyId, n.type AS type, n.instance AS instance, n.status AS status LIMIT $limit"
+)
+
+// NeuronSet selects bodies by id, by type or by instance regex.  A body
+// matching any of the criteria is part of the set.
+type NeuronSet struct {
+	BodyIds   []int64  `json:"bodyIds,omitempty"`
+	Types     []string `json:"types,omitempty"`
+	Instances []string `json:"instances,omitempty"`
+}
+
+func (ns NeuronSet) empty() bool {
+	return len(ns.BodyIds) == 0 && len(ns.Types) == 0 && len(ns.Instances) == 0
+}
+
+// neuronMember is a body in a resolved neuron set
+type neuronMember struct {
+	BodyId   int64
+	Type     string
+	Instance string
+	Status   string
+}
+
+// weightedEdge is the (ROI restricted) weight between two bodies
+type weightedEdge struct {
+	Pre    int64
+	Post   int64
+	Weight int64
+}
+
+// weightExpr returns the cypher expression for the weight of connection e,
+// counting only synapses inside roi when one is given
+func weightExpr(roi string) string {
+	if roi == "" {
+		return "e.weight"
+	}
+	return "coalesce(apoc.convert.fromJsonMap(e.roiInfo)[$roi].post, 0)"
+}
+
+// resolveNeuronSet resolves a neuron set into bodies.  At most one body
+// more than MaxSetBodies is fetched to tell that a set is too large.
+func resolveNeuronSet(cypher storage.Cypher, set NeuronSet) ([]neuronMember, error) {
+	bodyIds := make([]interface{}, len(set.BodyIds))
+	for i, bodyId := range set.BodyIds {
+		bodyIds[i] = bodyId
+	}
+	types := make([]interface{}, len(set.Types))
+	for i, celltype := range set.Types {
+		types[i] = celltype
+	}
+	instances := make([]interface{}, len(set.Instances))
+	for i, pattern := range set.Instances {
+		instances[i] = pattern
+	}
+	params := map[string]interface{}{
+		"bodyIds":   bodyIds,
+		"types":     types,
+		"instances": instances,
+		"limit":     int64(MaxSetBodies + 1),
+	}
+
+	res, err := cypher.CypherRequestWithParams(NeuronSetQuery, params, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Data) > MaxSetBodies {
+		return nil, fmt.Errorf("neuron set contains more than %d bodies", MaxSetBodies)
+	}
+
+	members := make([]neuronMember, 0, len(res.Data))
+	for _, row := range res.Data {
+		bodyId, ok := row[0].(int64)
+		if !ok {
+			return nil, fmt.Errorf("body id not parsed properly")
+		}
+		celltype, _ := row[1].(string)
+		instance, _ := row[2].(string)
+		status, _ := row[3].(string)
+		members = append(members, neuronMember{bodyId, celltype, instance, status})
+	}
+	return members, nil
+}
@@ -0,0 +1,276 @@
+package npexplorer
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+)
+
+const (
+	// MatrixChunkSize is the number of presynaptic bodies fetched per query
+	MatrixChunkSize = 500
+
+	// MaxDenseCells bounds the number of cells of a dense matrix
+	MaxDenseCells = 1000000
+
+	MatrixEdgesQuery = "MATCH (a :Segment)-[e :ConnectsTo]->(b :Segment) WHERE a.bodyId IN $pre AND b.bodyId IN $post WITH a, b, {weight} AS weight WHERE weight >= $minWeight RETURN a.bodyId AS pre, b.bodyId AS post, weight"
+)
+
+type ConnectivityMatrixParams struct {
+	DatasetParams
+	Pre       NeuronSet `json:"pre"`
+	Post      NeuronSet `json:"post"`
+	ROI       string    `json:"roi,omitempty"`
+	MinWeight int       `json:"min_weight,omitempty"`
+	GroupBy   string    `json:"group_by,omitempty"`
+	Sparse    bool      `json:"sparse,omitempty"`
+	Format    string    `json:"format,omitempty"`
+}
+
+// ConnectivityMatrix holds the grouped weights between two neuron sets.  When
+// Sparse is set the weights are in COO form (RowIndex, ColIndex, Weight),
+// otherwise Matrix holds one row per element of Rows.
+type ConnectivityMatrix struct {
+	Rows     []string  `json:"rows"`
+	Columns  []string  `json:"columns"`
+	Matrix   [][]int64 `json:"matrix,omitempty"`
+	RowIndex []int     `json:"row_index,omitempty"`
+	ColIndex []int     `json:"col_index,omitempty"`
+	Weight   []int64   `json:"weight,omitempty"`
+}
+
+// groupLabel returns the matrix label for a body.  Bodies without a type
+// or instance fall back to their body id.
+func groupLabel(member neuronMember, groupBy string) string {
+	switch groupBy {
+	case "type":
+		if member.Type != "" {
+			return member.Type
+		}
+	case "instance":
+		if member.Instance != "" {
+			return member.Instance
+		}
+	}
+	return strconv.FormatInt(member.BodyId, 10)
+}
+
+// sortedLabels returns the distinct labels for a neuron set in a stable order
+func sortedLabels(members []neuronMember, groupBy string) []string {
+	seen := make(map[string]bool)
+	labels := make([]string, 0, len(members))
+	for _, member := range members {
+		label := groupLabel(member, groupBy)
+		if !seen[label] {
+			seen[label] = true
+			labels = append(labels, label)
+		}
+	}
+	if groupBy == "body" {
+		sort.Slice(labels, func(i, j int) bool {
+			a, _ := strconv.ParseInt(labels[i], 10, 64)
+			b, _ := strconv.ParseInt(labels[j], 10, 64)
+			return a < b
+		})
+	} else {
+		sort.Strings(labels)
+	}
+	return labels
+}
+
+// buildMatrix sums body to body weights into the grouped matrix
+func buildMatrix(pre, post []neuronMember, edges []weightedEdge, groupBy string, sparse bool) *ConnectivityMatrix {
+	res := &ConnectivityMatrix{
+		Rows:    sortedLabels(pre, groupBy),
+		Columns: sortedLabels(post, groupBy),
+	}
+	rowIndex := make(map[string]int, len(res.Rows))
+	for i, label := range res.Rows {
+		rowIndex[label] = i
+	}
+	colIndex := make(map[string]int, len(res.Columns))
+	for i, label := range res.Columns {
+		colIndex[label] = i
+	}
+	preRow := make(map[int64]int, len(pre))
+	for _, member := range pre {
+		preRow[member.BodyId] = rowIndex[groupLabel(member, groupBy)]
+	}
+	postCol := make(map[int64]int, len(post))
+	for _, member := range post {
+		postCol[member.BodyId] = colIndex[groupLabel(member, groupBy)]
+	}
+
+	type cell struct{ row, col int }
+	weights := make(map[cell]int64)
+	for _, edge := range edges {
+		row, ok := preRow[edge.Pre]
+		if !ok {
+			continue
+		}
+		col, ok := postCol[edge.Post]
+		if !ok {
+			continue
+		}
+		weights[cell{row, col}] += edge.Weight
+	}
+
+	if !sparse {
+		res.Matrix = make([][]int64, len(res.Rows))
+		for i := range res.Matrix {
+			res.Matrix[i] = make([]int64, len(res.Columns))
+		}
+		for pos, weight := range weights {
+			res.Matrix[pos.row][pos.col] = weight
+		}
+		return res
+	}
+
+	cells := make([]cell, 0, len(weights))
+	for pos := range weights {
+		cells = append(cells, pos)
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i].row != cells[j].row {
+			return cells[i].row < cells[j].row
+		}
+		return cells[i].col < cells[j].col
+	})
+	res.RowIndex = make([]int, len(cells))
+	res.ColIndex = make([]int, len(cells))
+	res.Weight = make([]int64, len(cells))
+	for i, pos := range cells {
+		res.RowIndex[i] = pos.row
+		res.ColIndex[i] = pos.col
+		res.Weight[i] = weights[pos]
+	}
+	return res
+}
+
+// matrixEdges fetches the connections from pre to post, MatrixChunkSize
+// presynaptic bodies at a time.
+func matrixEdges(cypher storage.Cypher, pre, post []neuronMember, roi string, minWeight int) ([]weightedEdge, error) {
+	query := MatrixEdgesQuery
+	query = strings.Replace(query, "{weight}", weightExpr(roi), -1)
+
+	postIds := make([]interface{}, len(post))
+	for i, member := range post {
+		postIds[i] = member.BodyId
+	}
+
+	var edges []weightedEdge
+	for start := 0; start < len(pre); start += MatrixChunkSize {
+		end := start + MatrixChunkSize
+		if end > len(pre) {
+			end = len(pre)
+		}
+		preIds := make([]interface{}, 0, end-start)
+		for _, member := range pre[start:end] {
+			preIds = append(preIds, member.BodyId)
+		}
+		params := map[string]interface{}{
+			"pre":       preIds,
+			"post":      postIds,
+			"minWeight": int64(minWeight),
+		}
+		if roi != "" {
+			params["roi"] = roi
+		}
+
+		res, err := cypher.CypherRequestWithParams(query, params, true)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range res.Data {
+			preId, ok1 := row[0].(int64)
+			postId, ok2 := row[1].(int64)
+			weight, ok3 := row[2].(int64)
+			if !ok1 || !ok2 || !ok3 {
+				return nil, fmt.Errorf("connection not parsed properly")
+			}
+			edges = append(edges, weightedEdge{preId, postId, weight})
+		}
+	}
+	return edges, nil
+}
+
+// ExplorerConnectivityMatrix implements API to build a connectivity matrix
+// between two sets of neurons
+func (store cypherAPI) ExplorerConnectivityMatrix(params ConnectivityMatrixParams) (*ConnectivityMatrix, error) {
+	if params.Pre.empty() || params.Post.empty() {
+		return nil, fmt.Errorf("pre and post neuron sets must be specified")
+	}
+	switch params.GroupBy {
+	case "":
+		params.GroupBy = "body"
+	case "body", "type", "instance":
+	default:
+		return nil, fmt.Errorf("group_by must be body, type or instance")
+	}
+	if params.MinWeight < 1 {
+		params.MinWeight = 1
+	}
+
+	cypher := store.Store.GetMain(params.Dataset)
+	pre, err := resolveNeuronSet(cypher, params.Pre)
+	if err != nil {
+		return nil, err
+	}
+	post, err := resolveNeuronSet(cypher, params.Post)
+	if err != nil {
+		return nil, err
+	}
+	if !params.Sparse {
+		rows, cols := len(sortedLabels(pre, params.GroupBy)), len(sortedLabels(post, params.GroupBy))
+		if rows*cols > MaxDenseCells {
+			return nil, fmt.Errorf("dense matrix would have %d cells, the maximum is %d; use sparse=true", rows*cols, MaxDenseCells)
+		}
+	}
+	edges, err := matrixEdges(cypher, pre, post, params.ROI, params.MinWeight)
+	if err != nil {
+		return nil, err
+	}
+	return buildMatrix(pre, post, edges, params.GroupBy, params.Sparse), nil
+}
+
+// arrowRecord converts the matrix into an arrow table.  Dense matrices have a
+// "pre" label column followed by one column per post label; sparse matrices
+// have "pre", "post" and "weight" columns.
+func (cm *ConnectivityMatrix) arrowRecord(allocator memory.Allocator) arrow.Record {
+	if cm.Matrix == nil {
+		schema := arrow.NewSchema([]arrow.Field{
+			{Name: "pre", Type: arrow.BinaryTypes.String},
+			{Name: "post", Type: arrow.BinaryTypes.String},
+			{Name: "weight", Type: arrow.PrimitiveTypes.Int64},
+		}, nil)
+		builder := array.NewRecordBuilder(allocator, schema)
+		defer builder.Release()
+		for i := range cm.Weight {
+			builder.Field(0).(*array.StringBuilder).Append(cm.Rows[cm.RowIndex[i]])
+			builder.Field(1).(*array.StringBuilder).Append(cm.Columns[cm.ColIndex[i]])
+			builder.Field(2).(*array.Int64Builder).Append(cm.Weight[i])
+		}
+		return builder.NewRecord()
+	}
+
+	fields := make([]arrow.Field, 0, len(cm.Columns)+1)
+	fields = append(fields, arrow.Field{Name: "pre", Type: arrow.BinaryTypes.String})
+	for _, label := range cm.Columns {
+		fields = append(fields, arrow.Field{Name: label, Type: arrow.PrimitiveTypes.Int64})
+	}
+	builder := array.NewRecordBuilder(allocator, arrow.NewSchema(fields, nil))
+	defer builder.Release()
+	for i, label := range cm.Rows {
+		builder.Field(0).(*array.StringBuilder).Append(label)
+		for j, weight := range cm.Matrix[i] {
+			builder.Field(j + 1).(*array.Int64Builder).Append(weight)
+		}
+	}
+	return builder.NewRecord()
+}
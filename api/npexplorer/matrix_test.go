@@ -0,0 +1,146 @@
+package npexplorer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+)
+
+// mockCypher answers each request with a callback and records the queries
+type mockCypher struct {
+	respond func(query string, params map[string]interface{}) storage.CypherResult
+	queries []string
+	params  []map[string]interface{}
+}
+
+func (m *mockCypher) CypherRequest(query string, readonly bool) (storage.CypherResult, error) {
+	return m.CypherRequestWithParams(query, nil, readonly)
+}
+
+func (m *mockCypher) CypherRequestWithParams(query string, params map[string]interface{}, readonly bool) (storage.CypherResult, error) {
+	m.queries = append(m.queries, query)
+	m.params = append(m.params, params)
+	return m.respond(query, params), nil
+}
+
+func (m *mockCypher) StartTrans() (storage.CypherTransaction, error) {
+	return nil, nil
+}
+
+var testMembers = []neuronMember{
+	{BodyId: 30, Type: "KC", Instance: "KC_R"},
+	{BodyId: 10, Type: "KC", Instance: "KC_L"},
+	{BodyId: 20, Type: "", Instance: ""},
+}
+
+var testTargets = []neuronMember{
+	{BodyId: 100, Type: "MBON01", Instance: "MBON01_R"},
+	{BodyId: 200, Type: "MBON02", Instance: "MBON02_R"},
+}
+
+var testEdges = []weightedEdge{
+	{Pre: 10, Post: 100, Weight: 5},
+	{Pre: 30, Post: 100, Weight: 7},
+	{Pre: 20, Post: 200, Weight: 3},
+	{Pre: 99, Post: 200, Weight: 50},
+}
+
+func TestBuildMatrixDense(t *testing.T) {
+	res := buildMatrix(testMembers, testTargets, testEdges, "body", false)
+	if !reflect.DeepEqual(res.Rows, []string{"10", "20", "30"}) {
+		t.Errorf("unexpected rows: %v", res.Rows)
+	}
+	if !reflect.DeepEqual(res.Columns, []string{"100", "200"}) {
+		t.Errorf("unexpected columns: %v", res.Columns)
+	}
+	expected := [][]int64{{5, 0}, {0, 3}, {7, 0}}
+	if !reflect.DeepEqual(res.Matrix, expected) {
+		t.Errorf("unexpected matrix: %v", res.Matrix)
+	}
+}
+
+func TestBuildMatrixGroupedSparse(t *testing.T) {
+	res := buildMatrix(testMembers, testTargets, testEdges, "type", true)
+	if !reflect.DeepEqual(res.Rows, []string{"20", "KC"}) {
+		t.Errorf("unexpected rows: %v", res.Rows)
+	}
+	if !reflect.DeepEqual(res.RowIndex, []int{0, 1}) || !reflect.DeepEqual(res.ColIndex, []int{1, 0}) ||
+		!reflect.DeepEqual(res.Weight, []int64{3, 12}) {
+		t.Errorf("unexpected coo entries: %v %v %v", res.RowIndex, res.ColIndex, res.Weight)
+	}
+	if res.Matrix != nil {
+		t.Errorf("sparse result should not have a dense matrix")
+	}
+
+	record := res.arrowRecord(memory.DefaultAllocator)
+	defer record.Release()
+	if record.NumRows() != 2 || record.NumCols() != 3 {
+		t.Fatalf("unexpected arrow shape %d x %d", record.NumRows(), record.NumCols())
+	}
+	if record.Column(0).(*array.String).Value(1) != "KC" || record.Column(2).(*array.Int64).Value(1) != 12 {
+		t.Errorf("unexpected arrow contents")
+	}
+}
+
+func TestMatrixEdgesChunked(t *testing.T) {
+	pre := make([]neuronMember, MatrixChunkSize+1)
+	for i := range pre {
+		pre[i] = neuronMember{BodyId: int64(i + 1)}
+	}
+	mock := &mockCypher{respond: func(query string, params map[string]interface{}) storage.CypherResult {
+		ids := params["pre"].([]interface{})
+		return storage.CypherResult{
+			Columns: []string{"pre", "post", "weight"},
+			Data:    [][]interface{}{{ids[0], int64(100), int64(4)}},
+		}
+	}}
+
+	edges, err := matrixEdges(mock, pre, testTargets, "MB(R)", 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mock.queries) != 2 {
+		t.Fatalf("expected 2 chunked queries, got %d", len(mock.queries))
+	}
+	if len(mock.params[1]["pre"].([]interface{})) != 1 {
+		t.Errorf("unexpected size of last chunk")
+	}
+	if mock.params[0]["roi"] != "MB(R)" || mock.params[0]["minWeight"] != int64(3) {
+		t.Errorf("unexpected parameters: %v", mock.params[0])
+	}
+	if len(edges) != 2 || edges[1].Pre != int64(MatrixChunkSize+1) {
+		t.Errorf("unexpected edges: %v", edges)
+	}
+}
+
+func TestMatrixDenseCap(t *testing.T) {
+	types := make(map[int64]string, 2002)
+	var pre, post []int64
+	for body := int64(1); body <= 2002; body++ {
+		types[body] = "T"
+		if body <= 1001 {
+			pre = append(pre, body)
+		} else {
+			post = append(post, body)
+		}
+	}
+	cypher := graphCypher(nil, types, nil)
+	api := cypherAPI{&mockStore{cypher}}
+
+	params := ConnectivityMatrixParams{Pre: NeuronSet{BodyIds: pre}, Post: NeuronSet{BodyIds: post}}
+	if _, err := api.ExplorerConnectivityMatrix(params); err == nil || !strings.Contains(err.Error(), "sparse") {
+		t.Errorf("expected an oversized dense matrix to be rejected, got %v", err)
+	}
+	params.GroupBy = "type"
+	if _, err := api.ExplorerConnectivityMatrix(params); err != nil {
+		t.Errorf("unexpected error for a grouped matrix: %v", err)
+	}
+	params.GroupBy, params.Sparse = "body", true
+	if _, err := api.ExplorerConnectivityMatrix(params); err != nil {
+		t.Errorf("unexpected error for a sparse matrix: %v", err)
+	}
+}
@@ -0,0 +1,370 @@
+package npexplorer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+)
+
+const (
+	// MaxNeighborhoodHops bounds the number of hops from the seeds
+	MaxNeighborhoodHops = 4
+
+	// MaxNeighborhoodNodes bounds the number of neurons in a neighborhood
+	MaxNeighborhoodNodes = 10000
+
+	NeighborQuery = "MATCH (a :Segment)-[e :ConnectsTo]->(b :Segment) WHERE {side}.bodyId IN $ids WITH {other} AS n, {weight} AS weight WHERE weight >= $minWeight AND NOT n.bodyId IN $known{status} RETURN DISTINCT n.bodyId AS bodyId, n.type AS type, n.instance AS instance, n.status AS status LIMIT $limit"
+)
+
+// errNeighborhoodTooLarge is returned when a neighborhood exceeds
+// MaxNeighborhoodNodes
+var errNeighborhoodTooLarge = fmt.Errorf("neighborhood contains more than %d neurons, increase min_weight or decrease hops", MaxNeighborhoodNodes)
+
+// HopFilter restricts the neurons added at one hop to those connected with
+// at least MinWeight and, if given, with one of Statuses
+type HopFilter struct {
+	MinWeight int      `json:"min_weight,omitempty"`
+	Statuses  []string `json:"statuses,omitempty"`
+}
+
+type NeighborhoodParams struct {
+	DatasetParams
+	Seeds         NeuronSet   `json:"seeds"`
+	Direction     string      `json:"direction,omitempty"`
+	Hops          []HopFilter `json:"hops,omitempty"`
+	ROI           string      `json:"roi,omitempty"`
+	MinEdgeWeight int         `json:"min_edge_weight,omitempty"`
+	Format        string      `json:"format,omitempty"`
+	ArrowTable    string      `json:"arrow_table,omitempty"`
+}
+
+// NeighborhoodNode is a neuron in the neighborhood with the number of hops
+// from the nearest seed
+type NeighborhoodNode struct {
+	BodyId   int64  `json:"bodyId"`
+	Type     string `json:"type"`
+	Instance string `json:"instance"`
+	Status   string `json:"status"`
+	Hop      int    `json:"hop"`
+}
+
+type NeighborhoodEdge struct {
+	Pre    int64 `json:"pre"`
+	Post   int64 `json:"post"`
+	Weight int64 `json:"weight"`
+}
+
+// Neighborhood is the subgraph induced by the neurons reached from the seeds
+type Neighborhood struct {
+	Nodes []NeighborhoodNode `json:"nodes"`
+	Edges []NeighborhoodEdge `json:"edges"`
+}
+
+// ExplorerNeighborhood implements API to extract the neurons within a number
+// of hops of a set of seeds, along with every connection between them
+func (store cypherAPI) ExplorerNeighborhood(params NeighborhoodParams) (*Neighborhood, error) {
+	if params.Seeds.empty() {
+		return nil, fmt.Errorf("seeds must be specified")
+	}
+	var upstream, downstream bool
+	switch params.Direction {
+	case "", "both":
+		upstream, downstream = true, true
+	case "upstream":
+		upstream = true
+	case "downstream":
+		downstream = true
+	default:
+		return nil, fmt.Errorf("direction must be upstream, downstream or both")
+	}
+	if len(params.Hops) == 0 {
+		params.Hops = []HopFilter{{}}
+	}
+	if len(params.Hops) > MaxNeighborhoodHops {
+		return nil, fmt.Errorf("at most %d hops are supported", MaxNeighborhoodHops)
+	}
+	if params.MinEdgeWeight < 1 {
+		params.MinEdgeWeight = 1
+	}
+
+	cypher := store.Store.GetMain(params.Dataset)
+	seeds, err := resolveNeuronSet(cypher, params.Seeds)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[int64]NeighborhoodNode)
+	frontier := make([]int64, 0, len(seeds))
+	for _, member := range seeds {
+		nodes[member.BodyId] = NeighborhoodNode{member.BodyId, member.Type, member.Instance, member.Status, 0}
+		frontier = append(frontier, member.BodyId)
+	}
+
+	if len(nodes) > MaxNeighborhoodNodes {
+		return nil, errNeighborhoodTooLarge
+	}
+
+	for hop, filter := range params.Hops {
+		if len(frontier) == 0 {
+			break
+		}
+		minWeight := filter.MinWeight
+		if minWeight < 1 {
+			minWeight = 1
+		}
+
+		var added []int64
+		for _, down := range []bool{true, false} {
+			if (down && !downstream) || (!down && !upstream) {
+				continue
+			}
+			known := make([]int64, 0, len(nodes))
+			for bodyId := range nodes {
+				known = append(known, bodyId)
+			}
+			remaining := MaxNeighborhoodNodes - len(nodes)
+			members, err := expandNeighbors(cypher, frontier, down, params.ROI, minWeight, filter.Statuses, known, remaining)
+			if err != nil {
+				return nil, err
+			}
+			if len(members) > remaining {
+				return nil, errNeighborhoodTooLarge
+			}
+			for _, member := range members {
+				nodes[member.BodyId] = NeighborhoodNode{member.BodyId, member.Type, member.Instance, member.Status, hop + 1}
+				added = append(added, member.BodyId)
+			}
+		}
+		frontier = added
+	}
+
+	result := &Neighborhood{
+		Nodes: make([]NeighborhoodNode, 0, len(nodes)),
+		Edges: []NeighborhoodEdge{},
+	}
+	members := make([]neuronMember, 0, len(nodes))
+	for _, node := range nodes {
+		result.Nodes = append(result.Nodes, node)
+		members = append(members, neuronMember{BodyId: node.BodyId})
+	}
+	sort.Slice(result.Nodes, func(i, j int) bool {
+		if result.Nodes[i].Hop != result.Nodes[j].Hop {
+			return result.Nodes[i].Hop < result.Nodes[j].Hop
+		}
+		return result.Nodes[i].BodyId < result.Nodes[j].BodyId
+	})
+
+	edges, err := matrixEdges(cypher, members, members, params.ROI, params.MinEdgeWeight)
+	if err != nil {
+		return nil, err
+	}
+	for _, edge := range edges {
+		result.Edges = append(result.Edges, NeighborhoodEdge{edge.Pre, edge.Post, edge.Weight})
+	}
+	sort.Slice(result.Edges, func(i, j int) bool {
+		if result.Edges[i].Pre != result.Edges[j].Pre {
+			return result.Edges[i].Pre < result.Edges[j].Pre
+		}
+		return result.Edges[i].Post < result.Edges[j].Post
+	})
+	return result, nil
+}
+
+// expandNeighbors fetches the bodies not already known that are connected
+// downstream or upstream of the given bodies with at least
+// minWeight and, if given, have one of the statuses, ExpandChunkSize bodies
+// per query.  The queries stop once more than limit bodies are found.
+func expandNeighbors(cypher storage.Cypher, ids []int64, downstream bool, roi string, minWeight int, statuses []string, knownIds []int64, limit int) ([]neuronMember, error) {
+	query := NeighborQuery
+	if downstream {
+		query = strings.Replace(query, "{side}", "a", -1)
+		query = strings.Replace(query, "{other}", "b", -1)
+	} else {
+		query = strings.Replace(query, "{side}", "b", -1)
+		query = strings.Replace(query, "{other}", "a", -1)
+	}
+	query = strings.Replace(query, "{weight}", weightExpr(roi), -1)
+	var allowed []interface{}
+	if len(statuses) > 0 {
+		query = strings.Replace(query, "{status}", " AND n.status IN $statuses", -1)
+		for _, status := range statuses {
+			allowed = append(allowed, status)
+		}
+	} else {
+		query = strings.Replace(query, "{status}", "", -1)
+	}
+
+	known := make([]interface{}, 0, len(knownIds))
+	for _, bodyId := range knownIds {
+		known = append(known, bodyId)
+	}
+	var members []neuronMember
+	for start := 0; start < len(ids) && len(members) <= limit; start += ExpandChunkSize {
+		end := start + ExpandChunkSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		chunk := make([]interface{}, 0, end-start)
+		for _, id := range ids[start:end] {
+			chunk = append(chunk, id)
+		}
+		params := map[string]interface{}{
+			"ids":       chunk,
+			"minWeight": int64(minWeight),
+			"known":     known,
+			"limit":     int64(limit - len(members) + 1),
+		}
+		if roi != "" {
+			params["roi"] = roi
+		}
+		if len(allowed) > 0 {
+			params["statuses"] = allowed
+		}
+
+		res, err := cypher.CypherRequestWithParams(query, params, true)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range res.Data {
+			bodyId, ok := row[0].(int64)
+			if !ok {
+				return nil, fmt.Errorf("body id not parsed properly")
+			}
+			celltype, _ := row[1].(string)
+			instance, _ := row[2].(string)
+			status, _ := row[3].(string)
+			members = append(members, neuronMember{bodyId, celltype, instance, status})
+			known = append(known, bodyId)
+		}
+	}
+	return members, nil
+}
+
+// arrowRecord converts the nodes or the edges of the neighborhood into an
+// arrow table
+func (nb *Neighborhood) arrowRecord(table string, allocator memory.Allocator) arrow.Record {
+	if table == "nodes" {
+		schema := arrow.NewSchema([]arrow.Field{
+			{Name: "bodyId", Type: arrow.PrimitiveTypes.Int64},
+			{Name: "type", Type: arrow.BinaryTypes.String},
+			{Name: "instance", Type: arrow.BinaryTypes.String},
+			{Name: "status", Type: arrow.BinaryTypes.String},
+			{Name: "hop", Type: arrow.PrimitiveTypes.Int64},
+		}, nil)
+		builder := array.NewRecordBuilder(allocator, schema)
+		defer builder.Release()
+		for _, node := range nb.Nodes {
+			builder.Field(0).(*array.Int64Builder).Append(node.BodyId)
+			builder.Field(1).(*array.StringBuilder).Append(node.Type)
+			builder.Field(2).(*array.StringBuilder).Append(node.Instance)
+			builder.Field(3).(*array.StringBuilder).Append(node.Status)
+			builder.Field(4).(*array.Int64Builder).Append(int64(node.Hop))
+		}
+		return builder.NewRecord()
+	}
+
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "pre", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "post", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "weight", Type: arrow.PrimitiveTypes.Int64},
+	}, nil)
+	builder := array.NewRecordBuilder(allocator, schema)
+	defer builder.Release()
+	for _, edge := range nb.Edges {
+		builder.Field(0).(*array.Int64Builder).Append(edge.Pre)
+		builder.Field(1).(*array.Int64Builder).Append(edge.Post)
+		builder.Field(2).(*array.Int64Builder).Append(edge.Weight)
+	}
+	return builder.NewRecord()
+}
+
+// graphML element types
+type graphMLKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	Name     string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLDoc struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+// writeGraphML writes the neighborhood as a directed GraphML graph
+func (nb *Neighborhood) writeGraphML(w io.Writer) error {
+	doc := graphMLDoc{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{"type", "node", "type", "string"},
+			{"instance", "node", "instance", "string"},
+			{"status", "node", "status", "string"},
+			{"hop", "node", "hop", "int"},
+			{"weight", "edge", "weight", "long"},
+		},
+		Graph: graphMLGraph{
+			ID:          "neighborhood",
+			EdgeDefault: "directed",
+			Nodes:       make([]graphMLNode, 0, len(nb.Nodes)),
+			Edges:       make([]graphMLEdge, 0, len(nb.Edges)),
+		},
+	}
+	for _, node := range nb.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			ID: strconv.FormatInt(node.BodyId, 10),
+			Data: []graphMLData{
+				{"type", node.Type},
+				{"instance", node.Instance},
+				{"status", node.Status},
+				{"hop", strconv.Itoa(node.Hop)},
+			},
+		})
+	}
+	for _, edge := range nb.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			Source: strconv.FormatInt(edge.Pre, 10),
+			Target: strconv.FormatInt(edge.Post, 10),
+			Data:   []graphMLData{{"weight", strconv.FormatInt(edge.Weight, 10)}},
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(doc)
+}
@@ -0,0 +1,125 @@
+package npexplorer
+
+import (
+	"bytes"
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func neighborhoodIds(nb *Neighborhood) []int64 {
+	ids := make([]int64, len(nb.Nodes))
+	for i, node := range nb.Nodes {
+		ids[i] = node.BodyId
+	}
+	return ids
+}
+
+func TestExplorerNeighborhood(t *testing.T) {
+	statuses := map[int64]string{3: "Orphan"}
+	api := cypherAPI{&mockStore{graphCypher(pathEdges, pathTypes, statuses)}}
+
+	// downstream of 1, dropping the orphan at the first hop and weak
+	// connections at the second
+	res, err := api.ExplorerNeighborhood(NeighborhoodParams{
+		Seeds:     NeuronSet{BodyIds: []int64{1}},
+		Direction: "downstream",
+		Hops:      []HopFilter{{Statuses: []string{"Traced"}}, {MinWeight: 15}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(neighborhoodIds(res), []int64{1, 2, 4, 6}) {
+		t.Errorf("unexpected nodes: %v", res.Nodes)
+	}
+	if res.Nodes[3].Hop != 2 || res.Nodes[3].Type != "D" {
+		t.Errorf("unexpected node: %v", res.Nodes[3])
+	}
+	expected := []NeighborhoodEdge{{1, 2, 10}, {1, 4, 20}, {4, 6, 20}}
+	if !reflect.DeepEqual(res.Edges, expected) {
+		t.Errorf("unexpected edges: %v", res.Edges)
+	}
+
+	// a single unfiltered hop upstream of 5
+	res, err = api.ExplorerNeighborhood(NeighborhoodParams{
+		Seeds:     NeuronSet{BodyIds: []int64{5}},
+		Direction: "upstream",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(neighborhoodIds(res), []int64{5, 2, 3, 6}) {
+		t.Errorf("unexpected nodes: %v", res.Nodes)
+	}
+
+	if _, err := api.ExplorerNeighborhood(NeighborhoodParams{Seeds: NeuronSet{BodyIds: []int64{5}}, Direction: "sideways"}); err == nil {
+		t.Errorf("expected error for bad direction")
+	}
+}
+
+func TestNeighborhoodBudget(t *testing.T) {
+	// a hub with more partners than the node budget
+	edges := make([]weightedEdge, 0, MaxNeighborhoodNodes+10)
+	types := map[int64]string{1: "hub"}
+	for body := int64(2); body < MaxNeighborhoodNodes+12; body++ {
+		edges = append(edges, weightedEdge{1, body, 5})
+		types[body] = "partner"
+	}
+	cypher := graphCypher(edges, types, nil)
+	api := cypherAPI{&mockStore{cypher}}
+
+	_, err := api.ExplorerNeighborhood(NeighborhoodParams{Seeds: NeuronSet{BodyIds: []int64{1}}, Direction: "downstream"})
+	if err != errNeighborhoodTooLarge {
+		t.Errorf("expected the node budget to be exceeded, got %v", err)
+	}
+	var limits []int64
+	for i, params := range cypher.params {
+		if strings.Contains(cypher.queries[i], "$known") {
+			limits = append(limits, params["limit"].(int64))
+		}
+	}
+	if !reflect.DeepEqual(limits, []int64{MaxNeighborhoodNodes}) {
+		t.Errorf("expected one query limited to the remaining budget, got limits %v", limits)
+	}
+
+	res, err := api.ExplorerNeighborhood(NeighborhoodParams{
+		Seeds:     NeuronSet{BodyIds: []int64{1}},
+		Direction: "upstream",
+	})
+	if err != nil || len(res.Nodes) != 1 {
+		t.Errorf("unexpected upstream neighborhood %v (%v)", res, err)
+	}
+}
+
+func TestNeighborhoodFormats(t *testing.T) {
+	nb := &Neighborhood{
+		Nodes: []NeighborhoodNode{{1, "A", "A_R", "Traced", 0}, {2, "B", "", "Traced", 1}},
+		Edges: []NeighborhoodEdge{{1, 2, 7}},
+	}
+
+	var buf bytes.Buffer
+	if err := nb.writeGraphML(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var doc graphMLDoc
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid graphml: %v", err)
+	}
+	if len(doc.Graph.Nodes) != 2 || len(doc.Graph.Edges) != 1 || doc.Graph.Edges[0].Data[0].Value != "7" {
+		t.Errorf("unexpected graphml: %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), `edgedefault="directed"`) {
+		t.Errorf("graph should be directed")
+	}
+
+	nodes := nb.arrowRecord("nodes", memory.DefaultAllocator)
+	defer nodes.Release()
+	edges := nb.arrowRecord("edges", memory.DefaultAllocator)
+	defer edges.Release()
+	if nodes.NumRows() != 2 || nodes.NumCols() != 5 || edges.NumRows() != 1 || edges.NumCols() != 3 {
+		t.Errorf("unexpected arrow tables")
+	}
+}
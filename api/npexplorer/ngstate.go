@@ -0,0 +1,339 @@
+package npexplorer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// NgSynapseQuery finds a synapse on a body
+	NgSynapseQuery = "MATCH (n :Segment {bodyId: $bodyId})-[:Contains]->(:SynapseSet)-[:Contains]->(s :Synapse) {roi_cond} RETURN s.location.x, s.location.y, s.location.z LIMIT 1"
+
+	// NgConnectionSynapseQuery finds a presynaptic site of a connection
+	NgConnectionSynapseQuery = "MATCH (a :Segment {bodyId: $bodyId})<-[:From]-(cs :ConnectionSet)-[:To]->(b :Segment {bodyId: $partner}) MATCH (cs)-[:Contains]->(s :Synapse) WHERE s.type = 'pre' {roi_and} RETURN s.location.x, s.location.y, s.location.z LIMIT 1"
+
+	// NgROIFileSuffix names the file in the layer directory that maps ROI
+	// names to segment ids in the ROI layer, e.g. hemibrain-rois.json
+	NgROIFileSuffix = "-rois.json"
+
+	// DefaultNgURL is the neuroglancer instance used for shareable links
+	DefaultNgURL = "https://neuroglancer-demo.appspot.com/"
+)
+
+// LayerDir is the directory of neuroglancer layer templates (ng-dir), one
+// <dataset>.json per dataset
+var LayerDir string
+
+var ngColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// NgSynapse picks a synapse on a body, optionally one connecting to partner
+// or in an ROI, to center the view on
+type NgSynapse struct {
+	BodyId  int64  `json:"bodyId"`
+	Partner int64  `json:"partner,omitempty"`
+	ROI     string `json:"roi,omitempty"`
+}
+
+type NgStateParams struct {
+	DatasetParams
+	BodyIds  []int64           `json:"bodyIds,omitempty"`
+	Colors   map[string]string `json:"colors,omitempty"`
+	ROIs     []string          `json:"rois,omitempty"`
+	Position []float64         `json:"position,omitempty"`
+	Synapse  *NgSynapse        `json:"synapse,omitempty"`
+	Layer    string            `json:"layer,omitempty"`
+	Format   string            `json:"format,omitempty"`
+	NgURL    string            `json:"ng_url,omitempty"`
+}
+
+// ngROILayer maps ROI names to the segments of the ROI layer
+type ngROILayer struct {
+	Layer string           `json:"layer"`
+	IDs   map[string]int64 `json:"ids"`
+}
+
+// ngTemplateFile returns the path of a file in the layer directory for a
+// dataset
+func ngTemplateFile(dataset, suffix string) (string, error) {
+	if LayerDir == "" {
+		return "", fmt.Errorf("neuroglancer layers are not configured")
+	}
+	filename := dataset + suffix
+	if dataset == "" || filepath.Base(filename) != filename || strings.HasPrefix(filename, ".") {
+		return "", fmt.Errorf("invalid dataset name")
+	}
+	return filepath.Join(LayerDir, filename), nil
+}
+
+// loadNgTemplate reads the layer template for a dataset.  The template is a
+// neuroglancer state, or only its list of layers.  Layers given as an object
+// keyed by name (the older neuroglancer form) are listed in name order.
+func loadNgTemplate(dataset string) (map[string]interface{}, []map[string]interface{}, error) {
+	path, err := ngTemplateFile(dataset, ".json")
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("no neuroglancer layers for dataset %s", dataset)
+	}
+	var template interface{}
+	if err := json.Unmarshal(data, &template); err != nil {
+		return nil, nil, fmt.Errorf("neuroglancer layers for dataset %s not formatted properly", dataset)
+	}
+
+	state := make(map[string]interface{})
+	var rawLayers interface{}
+	switch val := template.(type) {
+	case []interface{}:
+		rawLayers = val
+	case map[string]interface{}:
+		state = val
+		rawLayers = val["layers"]
+	}
+
+	var layers []map[string]interface{}
+	switch val := rawLayers.(type) {
+	case []interface{}:
+		for _, item := range val {
+			if layer, ok := item.(map[string]interface{}); ok {
+				layers = append(layers, layer)
+			}
+		}
+	case map[string]interface{}:
+		names := make([]string, 0, len(val))
+		for name := range val {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if layer, ok := val[name].(map[string]interface{}); ok {
+				layer["name"] = name
+				layers = append(layers, layer)
+			}
+		}
+	default:
+		return nil, nil, fmt.Errorf("neuroglancer layers for dataset %s contain no layer list", dataset)
+	}
+	return state, layers, nil
+}
+
+// loadNgROIs reads the ROI segment ids for a dataset
+func loadNgROIs(dataset string) (*ngROILayer, error) {
+	path, err := ngTemplateFile(dataset, NgROIFileSuffix)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("no ROI segments available for dataset %s", dataset)
+	}
+	var rois ngROILayer
+	if err := json.Unmarshal(data, &rois); err != nil || rois.Layer == "" {
+		return nil, fmt.Errorf("ROI segments for dataset %s not formatted properly", dataset)
+	}
+	return &rois, nil
+}
+
+func ngLayerName(layer map[string]interface{}) string {
+	name, _ := layer["name"].(string)
+	return name
+}
+
+func isSegmentationLayer(layer map[string]interface{}) bool {
+	layerType, _ := layer["type"].(string)
+	return strings.HasPrefix(layerType, "segmentation")
+}
+
+// toFloat converts a coordinate returned by the database
+func toFloat(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// synapsePosition finds the location of the requested synapse
+func (store cypherAPI) synapsePosition(dataset string, synapse NgSynapse) ([]float64, error) {
+	params := map[string]interface{}{"bodyId": synapse.BodyId}
+	query := NgSynapseQuery
+	roiCond, roiAnd := "", ""
+	if synapse.ROI != "" {
+		params["roi"] = synapse.ROI
+		roiCond, roiAnd = "WHERE s[$roi] = true", "AND s[$roi] = true"
+	}
+	if synapse.Partner != 0 {
+		query = NgConnectionSynapseQuery
+		params["partner"] = synapse.Partner
+	}
+	query = strings.Replace(query, "{roi_cond}", roiCond, -1)
+	query = strings.Replace(query, "{roi_and}", roiAnd, -1)
+
+	res, err := store.Store.GetMain(dataset).CypherRequestWithParams(query, params, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Data) == 0 || len(res.Data[0]) != 3 {
+		return nil, fmt.Errorf("no synapse found for body %d", synapse.BodyId)
+	}
+	position := make([]float64, 3)
+	for i, val := range res.Data[0] {
+		coord, ok := toFloat(val)
+		if !ok {
+			return nil, fmt.Errorf("synapse location not parsed properly")
+		}
+		position[i] = coord
+	}
+	return position, nil
+}
+
+// ExplorerNgState implements API to build a neuroglancer state showing
+// bodies and ROIs from the dataset's layer template
+func (store cypherAPI) ExplorerNgState(params NgStateParams) (map[string]interface{}, error) {
+	if len(params.BodyIds) == 0 && len(params.ROIs) == 0 {
+		return nil, fmt.Errorf("bodyIds or rois must be specified")
+	}
+	if params.Position != nil && len(params.Position) != 3 {
+		return nil, fmt.Errorf("position must be [x, y, z]")
+	}
+	if params.Position != nil && params.Synapse != nil {
+		return nil, fmt.Errorf("only one of position and synapse can be specified")
+	}
+	bodies := make(map[string]bool, len(params.BodyIds))
+	for _, body := range params.BodyIds {
+		bodies[strconv.FormatInt(body, 10)] = true
+	}
+	for body, color := range params.Colors {
+		if !bodies[body] {
+			return nil, fmt.Errorf("color given for body %s that is not in bodyIds", body)
+		}
+		if !ngColorPattern.MatchString(color) {
+			return nil, fmt.Errorf("color %q must be formatted as #rrggbb", color)
+		}
+	}
+
+	state, layers, err := loadNgTemplate(params.Dataset)
+	if err != nil {
+		return nil, err
+	}
+	var rois *ngROILayer
+	if len(params.ROIs) > 0 {
+		if rois, err = loadNgROIs(params.Dataset); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(params.BodyIds) > 0 {
+		var target map[string]interface{}
+		for _, layer := range layers {
+			name := ngLayerName(layer)
+			if params.Layer != "" {
+				if name == params.Layer {
+					target = layer
+					break
+				}
+			} else if isSegmentationLayer(layer) && (rois == nil || name != rois.Layer) {
+				target = layer
+				break
+			}
+		}
+		if target == nil {
+			return nil, fmt.Errorf("no segmentation layer found for bodies")
+		}
+		segments := make([]string, 0, len(params.BodyIds))
+		for _, body := range params.BodyIds {
+			segments = append(segments, strconv.FormatInt(body, 10))
+		}
+		target["segments"] = segments
+		if len(params.Colors) > 0 {
+			colors, _ := target["segmentColors"].(map[string]interface{})
+			if colors == nil {
+				colors = make(map[string]interface{})
+			}
+			for body, color := range params.Colors {
+				colors[body] = color
+			}
+			target["segmentColors"] = colors
+		}
+	}
+
+	if rois != nil {
+		var target map[string]interface{}
+		for _, layer := range layers {
+			if ngLayerName(layer) == rois.Layer {
+				target = layer
+				break
+			}
+		}
+		if target == nil {
+			return nil, fmt.Errorf("ROI layer %s not in neuroglancer layers", rois.Layer)
+		}
+		segments := make([]string, 0, len(params.ROIs))
+		for _, roi := range params.ROIs {
+			id, ok := rois.IDs[roi]
+			if !ok {
+				return nil, fmt.Errorf("ROI %s has no segment", roi)
+			}
+			segments = append(segments, strconv.FormatInt(id, 10))
+		}
+		target["segments"] = segments
+		target["visible"] = true
+	}
+
+	position := params.Position
+	if params.Synapse != nil {
+		if position, err = store.synapsePosition(params.Dataset, *params.Synapse); err != nil {
+			return nil, err
+		}
+	}
+	if position != nil {
+		if navigation, ok := state["navigation"].(map[string]interface{}); ok {
+			// older neuroglancer states nest the position in the pose
+			pose, _ := navigation["pose"].(map[string]interface{})
+			if pose == nil {
+				pose = make(map[string]interface{})
+				navigation["pose"] = pose
+			}
+			pos, _ := pose["position"].(map[string]interface{})
+			if pos == nil {
+				pos = make(map[string]interface{})
+				pose["position"] = pos
+			}
+			pos["voxelCoordinates"] = position
+		} else {
+			state["position"] = position
+		}
+	}
+
+	state["layers"] = layers
+	return state, nil
+}
+
+// ngURL encodes a state as a shareable neuroglancer link
+func ngURL(base string, state map[string]interface{}) (string, error) {
+	if base == "" {
+		base = DefaultNgURL
+	}
+	parsed, err := url.Parse(base)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("ng_url must be an http or https url")
+	}
+	parsed.Fragment = ""
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	return parsed.String() + "#!" + url.PathEscape(string(data)), nil
+}
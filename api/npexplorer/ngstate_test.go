@@ -0,0 +1,163 @@
+package npexplorer
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+)
+
+const testNgLayers = `{
+	"dimensions": {"x": [8e-9, "m"], "y": [8e-9, "m"], "z": [8e-9, "m"]},
+	"layers": [
+		{"type": "image", "source": "precomputed://gs://bucket/emdata", "name": "emdata"},
+		{"type": "segmentation", "source": "precomputed://gs://bucket/roi", "name": "roi", "visible": false},
+		{"type": "segmentation", "source": "precomputed://gs://bucket/segmentation", "name": "segmentation", "segmentColors": {"1": "#00ff00"}}
+	]
+}`
+
+const testNgROIs = `{"layer": "roi", "ids": {"MB(R)": 7, "EB": 12}}`
+
+// setupNgDir writes layer templates for the "test" dataset
+func setupNgDir(t *testing.T, files map[string]string) {
+	dir := t.TempDir()
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	previous := LayerDir
+	LayerDir = dir
+	t.Cleanup(func() { LayerDir = previous })
+}
+
+func ngLayer(state map[string]interface{}, name string) map[string]interface{} {
+	for _, layer := range state["layers"].([]map[string]interface{}) {
+		if layer["name"] == name {
+			return layer
+		}
+	}
+	return nil
+}
+
+func TestExplorerNgState(t *testing.T) {
+	setupNgDir(t, map[string]string{"test.json": testNgLayers, "test-rois.json": testNgROIs})
+	store := cypherAPI{Store: &mockStore{}}
+
+	state, err := store.ExplorerNgState(NgStateParams{
+		DatasetParams: DatasetParams{"test"},
+		BodyIds:       []int64{5813105172, 1100404581},
+		Colors:        map[string]string{"5813105172": "#ff0000"},
+		ROIs:          []string{"EB"},
+		Position:      []float64{100, 200, 300},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seg := ngLayer(state, "segmentation")
+	if !reflect.DeepEqual(seg["segments"], []string{"5813105172", "1100404581"}) {
+		t.Errorf("unexpected segments: %v", seg["segments"])
+	}
+	colors := seg["segmentColors"].(map[string]interface{})
+	if colors["5813105172"] != "#ff0000" || colors["1"] != "#00ff00" {
+		t.Errorf("unexpected colors: %v", colors)
+	}
+	roi := ngLayer(state, "roi")
+	if !reflect.DeepEqual(roi["segments"], []string{"12"}) || roi["visible"] != true {
+		t.Errorf("unexpected ROI layer: %v", roi)
+	}
+	if !reflect.DeepEqual(state["position"], []float64{100, 200, 300}) {
+		t.Errorf("unexpected position: %v", state["position"])
+	}
+	if state["dimensions"] == nil {
+		t.Error("template properties should be kept")
+	}
+
+	// bad requests
+	for _, params := range []NgStateParams{
+		{DatasetParams: DatasetParams{"test"}},
+		{DatasetParams: DatasetParams{"test"}, BodyIds: []int64{1}, Colors: map[string]string{"2": "#ff0000"}},
+		{DatasetParams: DatasetParams{"test"}, BodyIds: []int64{1}, Colors: map[string]string{"1": "red"}},
+		{DatasetParams: DatasetParams{"test"}, ROIs: []string{"AL(R)"}},
+		{DatasetParams: DatasetParams{"test"}, BodyIds: []int64{1}, Layer: "missing"},
+		{DatasetParams: DatasetParams{"test"}, BodyIds: []int64{1}, Position: []float64{1, 2}},
+		{DatasetParams: DatasetParams{"../test"}, BodyIds: []int64{1}},
+		{DatasetParams: DatasetParams{"other"}, BodyIds: []int64{1}},
+	} {
+		if _, err := store.ExplorerNgState(params); err == nil {
+			t.Errorf("expected error for %+v", params)
+		}
+	}
+}
+
+func TestExplorerNgStateSynapse(t *testing.T) {
+	// older template: layers keyed by name and a navigation pose
+	setupNgDir(t, map[string]string{"test.json": `{
+		"layers": {"seg": {"type": "segmentation"}, "em": {"type": "image"}},
+		"navigation": {"zoomFactor": 8}
+	}`})
+	cypher := &mockCypher{respond: func(query string, params map[string]interface{}) storage.CypherResult {
+		return storage.CypherResult{Data: [][]interface{}{{int64(10), int64(20), 30.5}}}
+	}}
+	store := cypherAPI{Store: &mockStore{cypher: cypher}}
+
+	state, err := store.ExplorerNgState(NgStateParams{
+		DatasetParams: DatasetParams{"test"},
+		BodyIds:       []int64{5},
+		Synapse:       &NgSynapse{BodyId: 5, Partner: 6, ROI: "EB"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(cypher.queries[0], ":ConnectionSet") || !strings.Contains(cypher.queries[0], "s[$roi] = true") {
+		t.Errorf("unexpected synapse query: %s", cypher.queries[0])
+	}
+	if cypher.params[0]["partner"] != int64(6) || cypher.params[0]["roi"] != "EB" {
+		t.Errorf("unexpected synapse parameters: %v", cypher.params[0])
+	}
+	layers := state["layers"].([]map[string]interface{})
+	if layers[0]["name"] != "em" || layers[1]["name"] != "seg" {
+		t.Errorf("layers should be listed by name: %v", layers)
+	}
+	pose := state["navigation"].(map[string]interface{})["pose"].(map[string]interface{})
+	coords := pose["position"].(map[string]interface{})["voxelCoordinates"]
+	if !reflect.DeepEqual(coords, []float64{10, 20, 30.5}) {
+		t.Errorf("unexpected position: %v", coords)
+	}
+}
+
+func TestNgURL(t *testing.T) {
+	state := map[string]interface{}{"layers": []interface{}{}, "position": []float64{1, 2, 3}}
+	link, err := ngURL("https://example.org/ng/#!old", state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := "https://example.org/ng/#!"
+	if !strings.HasPrefix(link, prefix) {
+		t.Fatalf("unexpected link: %s", link)
+	}
+	decoded, err := url.PathUnescape(strings.TrimPrefix(link, prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roundtrip map[string]interface{}
+	if err := json.Unmarshal([]byte(decoded), &roundtrip); err != nil {
+		t.Fatalf("link does not hold a JSON state: %v", err)
+	}
+	if !reflect.DeepEqual(roundtrip["position"], []interface{}{1.0, 2.0, 3.0}) {
+		t.Errorf("unexpected position: %v", roundtrip["position"])
+	}
+
+	if link, _ := ngURL("", state); !strings.HasPrefix(link, DefaultNgURL+"#!") {
+		t.Errorf("default neuroglancer not used: %s", link)
+	}
+	if _, err := ngURL("javascript:alert(1)", state); err == nil {
+		t.Error("expected error for non-http url")
+	}
+}
@@ -1,12 +1,11 @@
 package npexplorer
 
 import (
-	"encoding/json"
-	"io"
 	"net/http"
-	"os/exec"
-	"strconv"
 
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
 	"github.com/connectome-neuprint/neuPrintHTTP/api"
 	"github.com/connectome-neuprint/neuPrintHTTP/secure"
 	"github.com/connectome-neuprint/neuPrintHTTP/storage"
@@ -63,6 +62,18 @@ func setupAPI(mainapi *api.ConnectomeAPI) error {
 	endPoint = "celltype"
 	mainapi.SetRoute(api.GET, PREFIX+"/"+endPoint+"/:dataset/:type", q.getCellType, api.GuardedRoute)
 	mainapi.SetRoute(api.POST, PREFIX+"/"+endPoint+"/:dataset/:type", q.getCellType, api.GuardedRoute)
+	endPoint = "connectivitymatrix"
+	mainapi.SupportedEndpoints[endPoint] = true
+	mainapi.SetRoute(api.POST, PREFIX+"/"+endPoint, q.getConnectivityMatrix, api.GuardedRoute)
+	endPoint = "paths"
+	mainapi.SupportedEndpoints[endPoint] = true
+	mainapi.SetRoute(api.POST, PREFIX+"/"+endPoint, q.getPaths, api.GuardedRoute)
+	endPoint = "neighborhood"
+	mainapi.SupportedEndpoints[endPoint] = true
+	mainapi.SetRoute(api.POST, PREFIX+"/"+endPoint, q.getNeighborhood, api.GuardedRoute)
+	endPoint = "ngstate"
+	mainapi.SupportedEndpoints[endPoint] = true
+	mainapi.SetRoute(api.POST, PREFIX+"/"+endPoint, q.getNgState, api.GuardedRoute)
 	return nil
 }
 
@@ -187,235 +198,15 @@ func (ca *cypherAPI) getCellType(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, errJSON)
 	}
 
-	cypher := "MATCH (n :Neuron {type: \"" + celltype + "\"})-[x :ConnectsTo]-(m) RETURN n.bodyId AS bodyId, n.instance AS instance, x.weight AS weight, m.bodyId AS bodyId2, m.type AS type2, (startNode(x) = n) as isOutput, n.status AS body1status, m.status AS body2status, m.cropped AS iscropped2, n.cropped AS iscropped1"
-
-	res, err := ca.Store.GetMain(dataset).CypherRequest(cypher, true)
-	if err != nil {
+	data, err := ca.ExplorerCellType(dataset, celltype)
+	if err == errNoCellType {
 		errJSON := api.ErrorInfo{Error: err.Error()}
 		return c.JSON(http.StatusBadRequest, errJSON)
+	} else if err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusInternalServerError, errJSON)
 	}
-
-	// nothing exists
-	if len(res.Data) == 0 {
-		errJSON := api.ErrorInfo{Error: "no cell type exists"}
-		return c.JSON(http.StatusBadRequest, errJSON)
-	}
-
-	// constants
-	primary_statuses := map[string]bool{
-		"Traced": true,
-		"Anchor": true,
-	}
-	minweight := 3
-
-	neuron_instance := make(map[int64]string)
-	unique_neurons := make(map[int64]struct{})
-	good_neurons := make(map[int64]struct{})
-	output_size := make(map[int64]int)
-	input_size := make(map[int64]int)
-	output_comp := make(map[int64]int)
-	input_comp := make(map[int64]int)
-	celltype_lists_outputs := make(map[int64][]interface{})
-	celltype_lists_inputs := make(map[int64][]interface{})
-
-	for _, row := range res.Data {
-
-		bodyid_t, ok := row[0].(int64)
-		if !ok {
-			errJSON := api.ErrorInfo{Error: "body id1 not parsed properly"}
-			return c.JSON(http.StatusBadRequest, errJSON)
-		}
-		bodyid := int64(bodyid_t)
-
-		bodyid2_t, ok := row[3].(int64)
-		if !ok {
-			errJSON := api.ErrorInfo{Error: "body id2 not parsed properly"}
-			return c.JSON(http.StatusBadRequest, errJSON)
-		}
-		bodyid2 := int64(bodyid2_t)
-
-		instance, ok := row[1].(string)
-		if !ok {
-			instance = ""
-		}
-
-		weight_t, ok := row[2].(int64)
-		if !ok {
-			errJSON := api.ErrorInfo{Error: "weight not parsed properly"}
-			return c.JSON(http.StatusBadRequest, errJSON)
-		}
-		weight := int(weight_t)
-
-		type_status, ok := row[6].(string)
-		if !ok {
-			type_status = ""
-		}
-
-		type_status2, ok := row[7].(string)
-		if !ok {
-			type_status2 = ""
-		}
-
-		is_output, ok := row[5].(bool)
-		if !ok {
-			errJSON := api.ErrorInfo{Error: "output direction not parsed properly"}
-			return c.JSON(http.StatusBadRequest, errJSON)
-		}
-
-		conntype, ok := row[4].(string)
-		if !ok {
-			conntype = ""
-		}
-
-		is_cropped2, ok := row[8].(bool)
-		if !ok {
-			is_cropped2 = false
-		}
-
-		is_cropped1, ok := row[9].(bool)
-		if !ok {
-			is_cropped1 = false
-		}
-
-		neuron_instance[bodyid] = instance
-
-		// ingore neuron types have not been traced in any way:
-		exists := primary_statuses[type_status]
-		if !exists {
-			continue
-		}
-
-		unique_neurons[bodyid] = struct{}{}
-
-		if is_output {
-			if _, exists := output_size[bodyid]; !exists {
-				output_size[bodyid] = 0
-			}
-			output_size[bodyid] += weight
-		}
-		if !is_output {
-			if _, exists := input_size[bodyid]; !exists {
-				input_size[bodyid] = 0
-			}
-			input_size[bodyid] += weight
-		}
-
-		// might as well ignore connection as well if not to traced
-		exists2 := primary_statuses[type_status2]
-		if !exists2 {
-			continue
-		}
-
-		// add stats if traced
-		if is_output {
-			if _, exists := output_comp[bodyid]; !exists {
-				output_comp[bodyid] = 0
-			}
-			output_comp[bodyid] += weight
-		}
-		if !is_output {
-			if _, exists := input_comp[bodyid]; !exists {
-				input_comp[bodyid] = 0
-			}
-			input_comp[bodyid] += weight
-		}
-
-		hastype := true
-		if conntype == "" {
-			conntype = strconv.Itoa(int(bodyid2))
-			hastype = false
-		}
-
-		// don't consider the edge for something that is leaves and has not type
-		if !hastype && is_cropped2 {
-			continue
-		}
-
-		//  don't consider a weak edge
-		if weight < minweight {
-			continue
-		}
-
-		// if type_status in connection_status:
-		if !is_cropped1 {
-			/*
-				// make sure name exclusions are not in the instance name
-				//name_exclusions := ".*_L"
-				// hack for hemibrain
-				if len(dataset) >= len("hemibrain") && ("hemibrain" == dataset[0:len("hemibrain")]) {
-					if len(instance) < 2 || instance[len(instance)-1] != 'L' || instance[len(instance)-2] != '_' {
-						good_neurons[bodyid] = struct{}{}
-					}
-				} else {*/
-			good_neurons[bodyid] = struct{}{}
-			//}
-		}
-
-		if is_output {
-			arritem := [...]interface{}{weight, bodyid2, conntype, hastype}
-			arr, exists := celltype_lists_outputs[bodyid]
-			if !exists {
-				arr = make([]interface{}, 0, 1)
-			}
-			arr = append(arr, arritem)
-			celltype_lists_outputs[bodyid] = arr
-		} else {
-			arritem := [...]interface{}{weight, bodyid2, conntype, hastype}
-			arr, exists := celltype_lists_inputs[bodyid]
-			if !exists {
-				arr = make([]interface{}, 0, 1)
-			}
-			arr = append(arr, arritem)
-			celltype_lists_inputs[bodyid] = arr
-		}
-	}
-
-	// save results to map
-	unique_neurons_list := make([]int64, 0, len(unique_neurons))
-	good_neurons_list := make([]int64, 0, len(good_neurons))
-	for body, _ := range unique_neurons {
-		unique_neurons_list = append(unique_neurons_list, body)
-	}
-	for body, _ := range good_neurons {
-		good_neurons_list = append(good_neurons_list, body)
-	}
-
-	pythondata := make(map[string]interface{})
-	pythondata["neuron_instance"] = neuron_instance
-	pythondata["unique_neurons"] = unique_neurons_list
-	pythondata["good_neurons"] = good_neurons_list
-	pythondata["output_size"] = output_size
-	pythondata["input_size"] = input_size
-	pythondata["output_comp"] = output_comp
-	pythondata["input_comp"] = input_comp
-	pythondata["celltype_lists_outputs"] = celltype_lists_outputs
-	pythondata["celltype_lists_inputs"] = celltype_lists_inputs
-
-	pdstring, _ := json.Marshal(pythondata)
-
-	// call python function
-	//cmd := exec.Command("echo", "'"+string(pdstring)+"'", "|", "python", "-W", "ignore", "canonical_celltype.py")
-	cmd := exec.Command("python", "-W", "ignore", "canonical_celltype.py")
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		errJSON := errorInfo{err.Error()}
-		return c.JSON(http.StatusBadRequest, errJSON)
-	}
-
-	go func() {
-		defer stdin.Close()
-		io.WriteString(stdin, string(pdstring))
-	}()
-
-	res2, err := cmd.CombinedOutput()
-
-	if err != nil {
-		errJSON := errorInfo{err.Error()}
-		return c.JSON(http.StatusBadRequest, errJSON)
-	}
-	// return json
-	return c.JSONBlob(http.StatusOK, res2)
-
+	return c.JSON(http.StatusOK, data)
 }
 
 func (ca *cypherAPI) getSimpleConnections(c echo.Context) error {
@@ -507,3 +298,397 @@ func (ca *cypherAPI) getCompleteness(c echo.Context) error {
 		return c.JSON(http.StatusOK, data)
 	}
 }
+
+func (ca *cypherAPI) getConnectivityMatrix(c echo.Context) error {
+	// swagger:operation POST /api/npexplorer/connectivitymatrix npexplorer getConnectivityMatrix
+	//
+	// Get the connectivity matrix between two sets of neurons
+	//
+	// Each neuron set is the union of the given body ids, cell types and
+	// instance patterns (regex).  Weights can be restricted to an ROI and
+	// are summed over the bodies in each group.  Connections weaker than
+	// min_weight (after ROI restriction) are dropped before grouping.
+	//
+	// ---
+	// parameters:
+	// - in: "body"
+	//   name: "body"
+	//   required: true
+	//   schema:
+	//     type: "object"
+	//     required: ["dataset", "pre", "post"]
+	//     properties:
+	//       dataset:
+	//         type: "string"
+	//         description: "dataset name"
+	//         example: "hemibrain"
+	//       pre:
+	//         type: "object"
+	//         description: "presynaptic neuron set"
+	//         example: {"types": ["KCab"]}
+	//       post:
+	//         type: "object"
+	//         description: "postsynaptic neuron set"
+	//         example: {"bodyIds": [612371421], "instances": ["MBON0.*"]}
+	//       roi:
+	//         type: "string"
+	//         description: "only count synapses in this ROI"
+	//       min_weight:
+	//         type: "integer"
+	//         description: "minimum weight of a body to body connection"
+	//       group_by:
+	//         type: "string"
+	//         enum: ["body", "type", "instance"]
+	//         description: "how rows and columns are grouped (default body)"
+	//       sparse:
+	//         type: "boolean"
+	//         description: "return COO entries (row_index, col_index, weight) instead of a dense matrix; required above 1000000 cells"
+	//       format:
+	//         type: "string"
+	//         enum: ["json", "arrow"]
+	//         description: "response format (default json)"
+	// produces:
+	// - application/json
+	// - application/vnd.apache.arrow.stream
+	// responses:
+	//   200:
+	//     description: "connectivity matrix"
+	//     schema:
+	//       type: "object"
+	//       properties:
+	//         rows:
+	//           type: "array"
+	//           items:
+	//             type: "string"
+	//         columns:
+	//           type: "array"
+	//           items:
+	//             type: "string"
+	//         matrix:
+	//           type: "array"
+	//           items:
+	//             type: "array"
+	//             items:
+	//               type: "integer"
+	// security:
+	// - Bearer: []
+
+	var reqObject ConnectivityMatrixParams
+	c.Bind(&reqObject)
+	c.Set("dataset", reqObject.Dataset)
+	if err := secure.RequireDatasetAccess(c, reqObject.Dataset, secure.READ); err != nil {
+		return err
+	}
+	if reqObject.Format != "" && reqObject.Format != "json" && reqObject.Format != "arrow" {
+		errJSON := errorInfo{"format must be json or arrow"}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+
+	data, err := ca.ExplorerConnectivityMatrix(reqObject)
+	if err != nil {
+		errJSON := errorInfo{err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	if reqObject.Format == "arrow" {
+		return writeArrow(c, data.arrowRecord(memory.DefaultAllocator))
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+func (ca *cypherAPI) getPaths(c echo.Context) error {
+	// swagger:operation POST /api/npexplorer/paths npexplorer getPaths
+	//
+	// Find paths between two sets of neurons
+	//
+	// Returns paths of at most max_hops connections, each of weight at
+	// least min_weight (counting only synapses in roi if given).  Mode
+	// "shortest" returns the paths with the fewest hops, "weighted" the
+	// paths with the lowest cost (sum of 1/weight) and "all" every simple
+	// path ordered by hops and then cost.  The search is bounded; requests
+	// that reach too many neurons fail and "truncated" is set when path
+	// enumeration stopped early.
+	//
+	// ---
+	// parameters:
+	// - in: "body"
+	//   name: "body"
+	//   required: true
+	//   schema:
+	//     type: "object"
+	//     required: ["dataset", "sources", "targets"]
+	//     properties:
+	//       dataset:
+	//         type: "string"
+	//         description: "dataset name"
+	//         example: "hemibrain"
+	//       sources:
+	//         type: "object"
+	//         description: "neuron set (bodyIds, types, instances) where paths start"
+	//         example: {"bodyIds": [5813105172]}
+	//       targets:
+	//         type: "object"
+	//         description: "neuron set (bodyIds, types, instances) where paths end"
+	//         example: {"types": ["MBON01"]}
+	//       max_hops:
+	//         type: "integer"
+	//         description: "maximum number of connections in a path (default 3, at most 6)"
+	//       min_weight:
+	//         type: "integer"
+	//         description: "minimum weight of each connection"
+	//       roi:
+	//         type: "string"
+	//         description: "only count synapses in this ROI"
+	//       mode:
+	//         type: "string"
+	//         enum: ["shortest", "weighted", "all"]
+	//         description: "how paths are selected and ordered (default shortest)"
+	//       limit:
+	//         type: "integer"
+	//         description: "maximum number of paths returned (default 10)"
+	// responses:
+	//   200:
+	//     description: "paths with the weight of each connection"
+	//     schema:
+	//       type: "object"
+	//       properties:
+	//         paths:
+	//           type: "array"
+	//           items:
+	//             type: "object"
+	//         neurons:
+	//           type: "array"
+	//           items:
+	//             type: "object"
+	//         truncated:
+	//           type: "boolean"
+	// security:
+	// - Bearer: []
+
+	var reqObject PathsParams
+	c.Bind(&reqObject)
+	c.Set("dataset", reqObject.Dataset)
+	if err := secure.RequireDatasetAccess(c, reqObject.Dataset, secure.READ); err != nil {
+		return err
+	}
+	if data, err := ca.ExplorerPaths(reqObject); err != nil {
+		errJSON := errorInfo{err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	} else {
+		return c.JSON(http.StatusOK, data)
+	}
+}
+
+func (ca *cypherAPI) getNeighborhood(c echo.Context) error {
+	// swagger:operation POST /api/npexplorer/neighborhood npexplorer getNeighborhood
+	//
+	// Get the upstream and/or downstream neighborhood of a set of neurons
+	//
+	// Starting from the seeds, each entry in "hops" adds the partners
+	// connected with at least min_weight (counting only synapses in roi if
+	// given) and, if statuses is given, with one of those statuses.  The
+	// result is the subgraph induced by the neurons found: every connection
+	// between them of at least min_edge_weight.
+	//
+	// ---
+	// parameters:
+	// - in: "body"
+	//   name: "body"
+	//   required: true
+	//   schema:
+	//     type: "object"
+	//     required: ["dataset", "seeds"]
+	//     properties:
+	//       dataset:
+	//         type: "string"
+	//         description: "dataset name"
+	//         example: "hemibrain"
+	//       seeds:
+	//         type: "object"
+	//         description: "neuron set (bodyIds, types, instances) to start from"
+	//         example: {"bodyIds": [5813105172]}
+	//       direction:
+	//         type: "string"
+	//         enum: ["upstream", "downstream", "both"]
+	//         description: "direction of connections followed (default both)"
+	//       hops:
+	//         type: "array"
+	//         items:
+	//           type: "object"
+	//           properties:
+	//             min_weight:
+	//               type: "integer"
+	//             statuses:
+	//               type: "array"
+	//               items:
+	//                 type: "string"
+	//         description: "filter for each hop (default one unfiltered hop, at most 4)"
+	//         example: [{"min_weight": 10, "statuses": ["Traced"]}, {"min_weight": 30}]
+	//       roi:
+	//         type: "string"
+	//         description: "only count synapses in this ROI"
+	//       min_edge_weight:
+	//         type: "integer"
+	//         description: "minimum weight of connections in the returned subgraph"
+	//       format:
+	//         type: "string"
+	//         enum: ["json", "arrow", "graphml"]
+	//         description: "response format (default json)"
+	//       arrow_table:
+	//         type: "string"
+	//         enum: ["edges", "nodes"]
+	//         description: "table returned for arrow format (default edges)"
+	// produces:
+	// - application/json
+	// - application/vnd.apache.arrow.stream
+	// - application/graphml+xml
+	// responses:
+	//   200:
+	//     description: "neighborhood subgraph"
+	//     schema:
+	//       type: "object"
+	//       properties:
+	//         nodes:
+	//           type: "array"
+	//           items:
+	//             type: "object"
+	//         edges:
+	//           type: "array"
+	//           items:
+	//             type: "object"
+	// security:
+	// - Bearer: []
+
+	var reqObject NeighborhoodParams
+	c.Bind(&reqObject)
+	c.Set("dataset", reqObject.Dataset)
+	if err := secure.RequireDatasetAccess(c, reqObject.Dataset, secure.READ); err != nil {
+		return err
+	}
+	switch reqObject.Format {
+	case "", "json", "arrow", "graphml":
+	default:
+		errJSON := errorInfo{"format must be json, arrow or graphml"}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+
+	data, err := ca.ExplorerNeighborhood(reqObject)
+	if err != nil {
+		errJSON := errorInfo{err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	switch reqObject.Format {
+	case "arrow":
+		return writeArrow(c, data.arrowRecord(reqObject.ArrowTable, memory.DefaultAllocator))
+	case "graphml":
+		c.Response().Header().Set(echo.HeaderContentType, "application/graphml+xml")
+		c.Response().WriteHeader(http.StatusOK)
+		return data.writeGraphML(c.Response().Writer)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+func (ca *cypherAPI) getNgState(c echo.Context) error {
+	// swagger:operation POST /api/npexplorer/ngstate npexplorer getNgState
+	//
+	// Build a neuroglancer state for bodies and ROIs
+	//
+	// The bodies are selected in the first segmentation layer of the
+	// dataset's neuroglancer layers (or in "layer") and the ROIs in the ROI
+	// layer.  The view is centered on position or on a synapse of a body,
+	// optionally one onto partner or in an ROI.  Format "url" returns a
+	// shareable link instead of the state.
+	//
+	// ---
+	// parameters:
+	// - in: "body"
+	//   name: "body"
+	//   required: true
+	//   schema:
+	//     type: "object"
+	//     required: ["dataset"]
+	//     properties:
+	//       dataset:
+	//         type: "string"
+	//         description: "dataset name"
+	//         example: "hemibrain"
+	//       bodyIds:
+	//         type: "array"
+	//         items:
+	//           type: "integer"
+	//         description: "bodies to show"
+	//         example: [5813105172]
+	//       colors:
+	//         type: "object"
+	//         description: "color (#rrggbb) for each body id"
+	//         example: {"5813105172": "#ff0000"}
+	//       rois:
+	//         type: "array"
+	//         items:
+	//           type: "string"
+	//         description: "ROIs to show"
+	//         example: ["MB(R)"]
+	//       position:
+	//         type: "array"
+	//         items:
+	//           type: "number"
+	//         description: "voxel coordinates to center on"
+	//       synapse:
+	//         type: "object"
+	//         description: "synapse to center on (bodyId, optional partner and roi)"
+	//         example: {"bodyId": 5813105172, "partner": 1100404581}
+	//       layer:
+	//         type: "string"
+	//         description: "name of the layer for the bodies"
+	//       format:
+	//         type: "string"
+	//         enum: ["state", "url"]
+	//         description: "response format (default state)"
+	//       ng_url:
+	//         type: "string"
+	//         description: "neuroglancer instance for links"
+	// responses:
+	//   200:
+	//     description: "neuroglancer JSON state, or an object with the url"
+	//     schema:
+	//       type: "object"
+	// security:
+	// - Bearer: []
+
+	var reqObject NgStateParams
+	c.Bind(&reqObject)
+	c.Set("dataset", reqObject.Dataset)
+	if err := secure.RequireDatasetAccess(c, reqObject.Dataset, secure.READ); err != nil {
+		return err
+	}
+	switch reqObject.Format {
+	case "", "state", "url":
+	default:
+		errJSON := errorInfo{"format must be state or url"}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+
+	state, err := ca.ExplorerNgState(reqObject)
+	if err != nil {
+		errJSON := errorInfo{err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	if reqObject.Format == "url" {
+		link, err := ngURL(reqObject.NgURL, state)
+		if err != nil {
+			errJSON := errorInfo{err.Error()}
+			return c.JSON(http.StatusBadRequest, errJSON)
+		}
+		return c.JSON(http.StatusOK, map[string]string{"url": link})
+	}
+	return c.JSON(http.StatusOK, state)
+}
+
+// writeArrow streams a record in Arrow IPC format
+func writeArrow(c echo.Context, record arrow.Record) error {
+	defer record.Release()
+	c.Response().Header().Set(echo.HeaderContentType, "application/vnd.apache.arrow.stream")
+	c.Response().WriteHeader(http.StatusOK)
+	writer := ipc.NewWriter(c.Response().Writer, ipc.WithSchema(record.Schema()))
+	defer writer.Close()
+	return writer.Write(record)
+}
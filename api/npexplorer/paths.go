@@ -0,0 +1,395 @@
+package npexplorer
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// MaxPathHops bounds the length of paths that can be searched
+	MaxPathHops = 6
+
+	// MaxPathNodes bounds the number of bodies visited while searching
+	MaxPathNodes = 20000
+
+	// MaxPathSteps bounds the work done enumerating paths
+	MaxPathSteps = 1000000
+
+	// DefaultPathLimit is the number of paths returned when no limit is given
+	DefaultPathLimit = 10
+
+	// MaxPathLimit bounds the number of paths returned
+	MaxPathLimit = 1000
+)
+
+type PathsParams struct {
+	DatasetParams
+	Sources   NeuronSet `json:"sources"`
+	Targets   NeuronSet `json:"targets"`
+	MaxHops   int       `json:"max_hops,omitempty"`
+	MinWeight int       `json:"min_weight,omitempty"`
+	ROI       string    `json:"roi,omitempty"`
+	Mode      string    `json:"mode,omitempty"`
+	Limit     int       `json:"limit,omitempty"`
+}
+
+// Path is a chain of bodies with the weight of each connection.  Cost is
+// the sum of the inverse weights, so paths through strong connections are
+// cheaper.
+type Path struct {
+	BodyIds []int64 `json:"bodyIds"`
+	Weights []int64 `json:"weights"`
+	Hops    int     `json:"hops"`
+	Cost    float64 `json:"cost"`
+}
+
+type PathNeuron struct {
+	BodyId   int64  `json:"bodyId"`
+	Type     string `json:"type"`
+	Instance string `json:"instance"`
+}
+
+type PathsResult struct {
+	Paths   []Path       `json:"paths"`
+	Neurons []PathNeuron `json:"neurons"`
+
+	// Truncated is set when the enumeration bound was hit, in which case
+	// better paths may exist
+	Truncated bool `json:"truncated"`
+}
+
+// pathGraph is the part of the connectome explored between the sources
+// and targets
+type pathGraph struct {
+	out map[int64]map[int64]int64
+}
+
+func newPathGraph() *pathGraph {
+	return &pathGraph{out: make(map[int64]map[int64]int64)}
+}
+
+func (pg *pathGraph) add(edges []weightedEdge) {
+	for _, edge := range edges {
+		if edge.Pre == edge.Post {
+			continue
+		}
+		partners, ok := pg.out[edge.Pre]
+		if !ok {
+			partners = make(map[int64]int64)
+			pg.out[edge.Pre] = partners
+		}
+		partners[edge.Post] = edge.Weight
+	}
+}
+
+// distanceTo returns the number of hops from each body to the nearest
+// target, up to maxHops
+func (pg *pathGraph) distanceTo(targets map[int64]bool, maxHops int) map[int64]int {
+	in := make(map[int64][]int64)
+	for pre, partners := range pg.out {
+		for post := range partners {
+			in[post] = append(in[post], pre)
+		}
+	}
+	dist := make(map[int64]int)
+	frontier := make([]int64, 0, len(targets))
+	for target := range targets {
+		dist[target] = 0
+		frontier = append(frontier, target)
+	}
+	for hop := 1; hop <= maxHops && len(frontier) > 0; hop++ {
+		var next []int64
+		for _, body := range frontier {
+			for _, pre := range in[body] {
+				if _, seen := dist[pre]; !seen {
+					dist[pre] = hop
+					next = append(next, pre)
+				}
+			}
+		}
+		frontier = next
+	}
+	return dist
+}
+
+// shortestHops returns the least number of hops, at most maxHops, from a
+// source to a target, or 0 if no target is reached
+func (pg *pathGraph) shortestHops(sources []int64, targets map[int64]bool, maxHops int) int {
+	seen := make(map[int64]bool, len(sources))
+	frontier := append([]int64(nil), sources...)
+	for _, source := range sources {
+		seen[source] = true
+	}
+	for hop := 1; hop <= maxHops && len(frontier) > 0; hop++ {
+		var next []int64
+		for _, body := range frontier {
+			for post := range pg.out[body] {
+				if targets[post] {
+					return hop
+				}
+				if !seen[post] {
+					seen[post] = true
+					next = append(next, post)
+				}
+			}
+		}
+		frontier = next
+	}
+	return 0
+}
+
+// enumerate lists the simple paths of at most maxHops from a source to a
+// target.  The search stops at the first target reached and gives up after
+// maxSteps, returning true if it did.
+func (pg *pathGraph) enumerate(sources []int64, targets map[int64]bool, maxHops, maxSteps int) ([]Path, bool) {
+	dist := pg.distanceTo(targets, maxHops)
+	var paths []Path
+	steps := 0
+	truncated := false
+
+	onPath := make(map[int64]bool)
+	var bodies []int64
+	var weights []int64
+	var visit func(body int64)
+	visit = func(body int64) {
+		if truncated {
+			return
+		}
+		steps++
+		if steps > maxSteps {
+			truncated = true
+			return
+		}
+		if len(bodies) > 1 && targets[body] {
+			path := Path{
+				BodyIds: append([]int64(nil), bodies...),
+				Weights: append([]int64(nil), weights...),
+				Hops:    len(weights),
+			}
+			for _, weight := range weights {
+				path.Cost += 1.0 / float64(weight)
+			}
+			paths = append(paths, path)
+			return
+		}
+
+		partners := make([]int64, 0, len(pg.out[body]))
+		for post := range pg.out[body] {
+			partners = append(partners, post)
+		}
+		sort.Slice(partners, func(i, j int) bool { return partners[i] < partners[j] })
+		for _, post := range partners {
+			remaining, ok := dist[post]
+			if !ok || onPath[post] || len(weights)+1+remaining > maxHops {
+				continue
+			}
+			onPath[post] = true
+			bodies = append(bodies, post)
+			weights = append(weights, pg.out[body][post])
+			visit(post)
+			bodies = bodies[:len(bodies)-1]
+			weights = weights[:len(weights)-1]
+			onPath[post] = false
+		}
+	}
+
+	for _, source := range sources {
+		onPath[source] = true
+		bodies = append(bodies[:0], source)
+		weights = weights[:0]
+		visit(source)
+		onPath[source] = false
+	}
+	return paths, truncated
+}
+
+// rankPaths orders paths for the search mode and keeps the best limit
+func rankPaths(paths []Path, mode string, limit int) []Path {
+	if mode == "shortest" && len(paths) > 0 {
+		minHops := paths[0].Hops
+		for _, path := range paths {
+			if path.Hops < minHops {
+				minHops = path.Hops
+			}
+		}
+		shortest := paths[:0]
+		for _, path := range paths {
+			if path.Hops == minHops {
+				shortest = append(shortest, path)
+			}
+		}
+		paths = shortest
+	}
+
+	sort.SliceStable(paths, func(i, j int) bool {
+		if mode == "all" && paths[i].Hops != paths[j].Hops {
+			return paths[i].Hops < paths[j].Hops
+		}
+		return paths[i].Cost < paths[j].Cost
+	})
+	if len(paths) > limit {
+		paths = paths[:limit]
+	}
+	return paths
+}
+
+// ExplorerPaths implements API to find paths between two sets of neurons.
+//
+// The connectome is explored breadth first from the sources for half of the
+// hops and back from the targets for the other half, so every path of at
+// most max_hops lies in the explored graph.  Each hop fetches at most the
+// bodies left in the MaxPathNodes budget and the search fails once it is
+// exceeded.  In shortest mode only paths of the least number of hops found
+// breadth first are enumerated.
+func (store cypherAPI) ExplorerPaths(params PathsParams) (*PathsResult, error) {
+	if params.Sources.empty() || params.Targets.empty() {
+		return nil, fmt.Errorf("sources and targets must be specified")
+	}
+	if params.MaxHops == 0 {
+		params.MaxHops = 3
+	}
+	if params.MaxHops < 1 || params.MaxHops > MaxPathHops {
+		return nil, fmt.Errorf("max_hops must be between 1 and %d", MaxPathHops)
+	}
+	if params.MinWeight < 1 {
+		params.MinWeight = 1
+	}
+	switch params.Mode {
+	case "":
+		params.Mode = "shortest"
+	case "shortest", "weighted", "all":
+	default:
+		return nil, fmt.Errorf("mode must be shortest, weighted or all")
+	}
+	if params.Limit == 0 {
+		params.Limit = DefaultPathLimit
+	}
+	if params.Limit < 1 || params.Limit > MaxPathLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %d", MaxPathLimit)
+	}
+
+	cypher := store.Store.GetMain(params.Dataset)
+	sources, err := resolveNeuronSet(cypher, params.Sources)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := resolveNeuronSet(cypher, params.Targets)
+	if err != nil {
+		return nil, err
+	}
+
+	graph := newPathGraph()
+	visited := make(map[int64]bool)
+	errTooLarge := fmt.Errorf("path search reached more than %d neurons, increase min_weight or decrease max_hops", MaxPathNodes)
+	explore := func(members []neuronMember, hops int, downstream bool) error {
+		frontier := make([]int64, 0, len(members))
+		seen := make(map[int64]bool)
+		for _, member := range members {
+			seen[member.BodyId] = true
+			visited[member.BodyId] = true
+			frontier = append(frontier, member.BodyId)
+		}
+		if len(visited) > MaxPathNodes {
+			return errTooLarge
+		}
+		for hop := 0; hop < hops && len(frontier) > 0; hop++ {
+			// bodies seen by the other exploration do not count against
+			// the budget, so they are allowed on top of the remaining nodes
+			known := make([]int64, 0, len(seen))
+			shared := 0
+			for body := range seen {
+				known = append(known, body)
+			}
+			for body := range visited {
+				if !seen[body] {
+					shared++
+				}
+			}
+			remaining := MaxPathNodes - len(visited)
+			partners, err := expandNeighbors(cypher, frontier, downstream, params.ROI, params.MinWeight, nil, known, remaining+shared)
+			if err != nil {
+				return err
+			}
+			var next []int64
+			for _, partner := range partners {
+				seen[partner.BodyId] = true
+				visited[partner.BodyId] = true
+				next = append(next, partner.BodyId)
+			}
+			if len(visited) > MaxPathNodes {
+				return errTooLarge
+			}
+
+			// connections from the frontier to any body seen so far
+			frontierMembers := make([]neuronMember, len(frontier))
+			for i, body := range frontier {
+				frontierMembers[i] = neuronMember{BodyId: body}
+			}
+			seenMembers := make([]neuronMember, 0, len(seen))
+			for body := range seen {
+				seenMembers = append(seenMembers, neuronMember{BodyId: body})
+			}
+			var edges []weightedEdge
+			if downstream {
+				edges, err = matrixEdges(cypher, frontierMembers, seenMembers, params.ROI, params.MinWeight)
+			} else {
+				edges, err = matrixEdges(cypher, seenMembers, frontierMembers, params.ROI, params.MinWeight)
+			}
+			if err != nil {
+				return err
+			}
+			graph.add(edges)
+			frontier = next
+		}
+		return nil
+	}
+	if err := explore(sources, (params.MaxHops+1)/2, true); err != nil {
+		return nil, err
+	}
+	if err := explore(targets, params.MaxHops/2, false); err != nil {
+		return nil, err
+	}
+
+	sourceIds := make([]int64, len(sources))
+	for i, member := range sources {
+		sourceIds[i] = member.BodyId
+	}
+	targetIds := make(map[int64]bool, len(targets))
+	for _, member := range targets {
+		targetIds[member.BodyId] = true
+	}
+	maxHops := params.MaxHops
+	if params.Mode == "shortest" {
+		if hops := graph.shortestHops(sourceIds, targetIds, maxHops); hops > 0 {
+			maxHops = hops
+		}
+	}
+	paths, truncated := graph.enumerate(sourceIds, targetIds, maxHops, MaxPathSteps)
+	paths = rankPaths(paths, params.Mode, params.Limit)
+
+	result := &PathsResult{Paths: paths, Neurons: []PathNeuron{}, Truncated: truncated}
+	if result.Paths == nil {
+		result.Paths = []Path{}
+	}
+	onPath := make(map[int64]bool)
+	var pathIds []int64
+	for _, path := range paths {
+		for _, body := range path.BodyIds {
+			if !onPath[body] {
+				onPath[body] = true
+				pathIds = append(pathIds, body)
+			}
+		}
+	}
+	if len(pathIds) > 0 {
+		members, err := resolveNeuronSet(cypher, NeuronSet{BodyIds: pathIds})
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			result.Neurons = append(result.Neurons, PathNeuron{member.BodyId, member.Type, member.Instance})
+		}
+		sort.Slice(result.Neurons, func(i, j int) bool { return result.Neurons[i].BodyId < result.Neurons[j].BodyId })
+	}
+	return result, nil
+}
@@ -0,0 +1,224 @@
+package npexplorer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+)
+
+// mockStore serves a single mock cypher connection
+type mockStore struct {
+	cypher *mockCypher
+}
+
+func (m *mockStore) GetMain(datasets ...string) storage.Cypher         { return m.cypher }
+func (m *mockStore) GetDataset(dataset string) (storage.Cypher, error) { return m.cypher, nil }
+func (m *mockStore) GetStores() []storage.SimpleStore                  { return nil }
+func (m *mockStore) GetInstances() map[string]storage.SimpleStore      { return nil }
+func (m *mockStore) GetTypes() map[string][]storage.SimpleStore        { return nil }
+func (m *mockStore) FindStore(typename, dataset string) (storage.SimpleStore, error) {
+	return nil, nil
+}
+func (m *mockStore) GetVersion() (string, error)                  { return "0.5.0", nil }
+func (m *mockStore) GetDatabase() (string, string, error)         { return "mock", "mock", nil }
+func (m *mockStore) GetDatasets() (map[string]interface{}, error) { return nil, nil }
+func (m *mockStore) GetType() string                              { return "mock" }
+func (m *mockStore) GetInstance() string                          { return "mock" }
+
+// graphCypher answers neuron set, expansion and edge queries from an edge
+// list.  Bodies are "Traced" unless given another status.
+func graphCypher(edges []weightedEdge, types map[int64]string, statuses map[int64]string) *mockCypher {
+	return &mockCypher{respond: func(query string, params map[string]interface{}) storage.CypherResult {
+		if query == NeuronSetQuery {
+			res := storage.CypherResult{Columns: []string{"bodyId", "type", "instance", "status"}}
+			wanted := make(map[interface{}]bool)
+			for _, id := range params["bodyIds"].([]interface{}) {
+				wanted[id] = true
+			}
+			for _, celltype := range params["types"].([]interface{}) {
+				wanted[celltype] = true
+			}
+			for body, celltype := range types {
+				if int64(len(res.Data)) >= params["limit"].(int64) {
+					break
+				}
+				if wanted[body] || wanted[celltype] {
+					status, ok := statuses[body]
+					if !ok {
+						status = "Traced"
+					}
+					res.Data = append(res.Data, []interface{}{body, celltype, nil, status})
+				}
+			}
+			return res
+		}
+
+		idSet := func(name string) map[interface{}]bool {
+			ids := make(map[interface{}]bool)
+			if list, ok := params[name].([]interface{}); ok {
+				for _, id := range list {
+					ids[id] = true
+				}
+			}
+			return ids
+		}
+		ids, pre, post := idSet("ids"), idSet("pre"), idSet("post")
+		downstream := strings.Contains(query, "a.bodyId IN $ids")
+		upstream := strings.Contains(query, "b.bodyId IN $ids")
+
+		if strings.Contains(query, "$known") {
+			known, allowed := idSet("known"), idSet("statuses")
+			res := storage.CypherResult{Columns: []string{"bodyId", "type", "instance", "status"}}
+			for _, edge := range edges {
+				partner := edge.Post
+				if upstream {
+					partner = edge.Pre
+				}
+				status, ok := statuses[partner]
+				if !ok {
+					status = "Traced"
+				}
+				if edge.Weight < params["minWeight"].(int64) || known[partner] || (len(allowed) > 0 && !allowed[status]) ||
+					!((downstream && ids[edge.Pre]) || (upstream && ids[edge.Post])) || int64(len(res.Data)) >= params["limit"].(int64) {
+					continue
+				}
+				known[partner] = true
+				res.Data = append(res.Data, []interface{}{partner, types[partner], nil, status})
+			}
+			return res
+		}
+		res := storage.CypherResult{Columns: []string{"pre", "post", "weight"}}
+		for _, edge := range edges {
+			if edge.Weight < params["minWeight"].(int64) {
+				continue
+			}
+			if (downstream && ids[edge.Pre]) || (upstream && ids[edge.Post]) || (pre[edge.Pre] && post[edge.Post]) {
+				res.Data = append(res.Data, []interface{}{edge.Pre, edge.Post, edge.Weight})
+			}
+		}
+		return res
+	}}
+}
+
+var pathEdges = []weightedEdge{
+	{1, 2, 10}, {2, 5, 10},
+	{1, 3, 2}, {3, 5, 2},
+	{1, 4, 20}, {4, 6, 20}, {6, 5, 20},
+	{5, 1, 30},
+}
+
+var pathTypes = map[int64]string{1: "A", 2: "B", 3: "B", 4: "C", 5: "T", 6: "D"}
+
+func TestEnumeratePaths(t *testing.T) {
+	graph := newPathGraph()
+	graph.add(pathEdges)
+	paths, truncated := graph.enumerate([]int64{1}, map[int64]bool{5: true}, 3, MaxPathSteps)
+	if truncated {
+		t.Errorf("unexpected truncation")
+	}
+	if len(paths) != 3 {
+		t.Fatalf("expected 3 paths, got %v", paths)
+	}
+
+	shortest := rankPaths(append([]Path(nil), paths...), "shortest", 10)
+	if len(shortest) != 2 || !reflect.DeepEqual(shortest[0].BodyIds, []int64{1, 2, 5}) {
+		t.Errorf("unexpected shortest paths: %v", shortest)
+	}
+	weighted := rankPaths(append([]Path(nil), paths...), "weighted", 1)
+	if len(weighted) != 1 || !reflect.DeepEqual(weighted[0].BodyIds, []int64{1, 4, 6, 5}) {
+		t.Errorf("unexpected weighted path: %v", weighted)
+	}
+
+	paths, _ = graph.enumerate([]int64{1}, map[int64]bool{5: true}, 2, MaxPathSteps)
+	if len(paths) != 2 {
+		t.Errorf("hop bound not applied: %v", paths)
+	}
+	if _, truncated = graph.enumerate([]int64{1}, map[int64]bool{5: true}, 3, 2); !truncated {
+		t.Errorf("step bound not applied")
+	}
+}
+
+func TestExplorerPaths(t *testing.T) {
+	api := cypherAPI{&mockStore{graphCypher(pathEdges, pathTypes, nil)}}
+	res, err := api.ExplorerPaths(PathsParams{
+		Sources:   NeuronSet{BodyIds: []int64{1}},
+		Targets:   NeuronSet{Types: []string{"T"}},
+		MaxHops:   3,
+		MinWeight: 5,
+		Mode:      "all",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Paths) != 2 || !reflect.DeepEqual(res.Paths[0].Weights, []int64{10, 10}) ||
+		!reflect.DeepEqual(res.Paths[1].BodyIds, []int64{1, 4, 6, 5}) {
+		t.Errorf("unexpected paths: %v", res.Paths)
+	}
+	if len(res.Neurons) != 5 || res.Neurons[4].Type != "D" {
+		t.Errorf("unexpected neurons: %v", res.Neurons)
+	}
+
+	if _, err := api.ExplorerPaths(PathsParams{Sources: NeuronSet{BodyIds: []int64{1}}, Targets: NeuronSet{BodyIds: []int64{5}}, MaxHops: 10}); err == nil {
+		t.Errorf("expected error for too many hops")
+	}
+}
+
+func TestResolveNeuronSetCap(t *testing.T) {
+	// a type with more bodies than a neuron set may hold
+	types := make(map[int64]string, MaxSetBodies+5)
+	for body := int64(1); body <= MaxSetBodies+5; body++ {
+		types[body] = "big"
+	}
+	cypher := graphCypher(nil, types, nil)
+	if _, err := resolveNeuronSet(cypher, NeuronSet{Types: []string{"big"}}); err == nil {
+		t.Fatal("expected error for an oversized neuron set")
+	}
+	if len(cypher.queries) != 1 || cypher.params[0]["limit"] != int64(MaxSetBodies+1) {
+		t.Errorf("expected one members query limited to the cap, got %d queries with %v", len(cypher.queries), cypher.params[0]["limit"])
+	}
+}
+
+func TestShortestHops(t *testing.T) {
+	graph := newPathGraph()
+	graph.add(pathEdges)
+	targets := map[int64]bool{5: true}
+	if hops := graph.shortestHops([]int64{1}, targets, 3); hops != 2 {
+		t.Errorf("expected 2 hops, got %d", hops)
+	}
+	if hops := graph.shortestHops([]int64{4}, targets, 1); hops != 0 {
+		t.Errorf("expected no target within 1 hop, got %d", hops)
+	}
+
+	// bounded to the shortest length, a truncated enumeration only finds
+	// shortest paths
+	paths, _ := graph.enumerate([]int64{1}, targets, 2, 4)
+	for _, path := range paths {
+		if path.Hops != 2 {
+			t.Errorf("unexpected path %v", path)
+		}
+	}
+}
+
+func TestExplorerPathsBudget(t *testing.T) {
+	// a hub with more partners than the node budget
+	edges := make([]weightedEdge, 0, MaxPathNodes+10)
+	types := map[int64]string{1: "hub"}
+	for body := int64(2); body < MaxPathNodes+12; body++ {
+		edges = append(edges, weightedEdge{1, body, 5})
+		types[body] = "partner"
+	}
+	cypher := graphCypher(edges, types, nil)
+	api := cypherAPI{&mockStore{cypher}}
+
+	_, err := api.ExplorerPaths(PathsParams{Sources: NeuronSet{BodyIds: []int64{1}}, Targets: NeuronSet{BodyIds: []int64{2}}})
+	if err == nil || !strings.Contains(err.Error(), "more than") {
+		t.Errorf("expected the node budget to be exceeded, got %v", err)
+	}
+	for i, params := range cypher.params {
+		if strings.Contains(cypher.queries[i], "$known") && params["limit"].(int64) > MaxPathNodes+1 {
+			t.Errorf("expected hop queries limited to the budget, got limit %v", params["limit"])
+		}
+	}
+}
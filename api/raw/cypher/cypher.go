@@ -1,10 +1,7 @@
 package cypuer
 
 import (
-	"fmt"
 	"net/http"
-	"strconv"
-	"sync"
 
 	"github.com/connectome-neuprint/neuPrintHTTP/api"
 	"github.com/connectome-neuprint/neuPrintHTTP/secure"
@@ -23,43 +20,10 @@ type cypherAPI struct {
 	Store storage.Store
 }
 
-var TransactionNum = 1
-
-type transactionState struct {
-	transaction storage.CypherTransaction
-	dataset     string
-}
-
-var CypherTransaction map[int]transactionState
-var mux sync.Mutex
-
-func setTransaction(trans storage.CypherTransaction, dataset string) int {
-	mux.Lock()
-	defer mux.Unlock()
-	CypherTransaction[TransactionNum] = transactionState{transaction: trans, dataset: dataset}
-	TransactionNum += 1
-	return TransactionNum - 1
-}
-func getTransaction(transid int) (transactionState, error) {
-	mux.Lock()
-	defer mux.Unlock()
-
-	trans, ok := CypherTransaction[transid]
-	if !ok {
-		return transactionState{}, fmt.Errorf("Transaction id not found")
-	}
-	return trans, nil
-}
-func deleteTransaction(transid int) {
-	mux.Lock()
-	defer mux.Unlock()
-	delete(CypherTransaction, transid)
-}
-
 // setupAPI sets up the optionally supported custom endpoints
 func setupAPI(mainapi *api.ConnectomeAPI) error {
 	q := &cypherAPI{mainapi.Store}
-	CypherTransaction = make(map[int]transactionState)
+	go reapTransactions()
 
 	// custom endpoint
 	endPoint := "cypher"
@@ -74,6 +38,7 @@ func setupAPI(mainapi *api.ConnectomeAPI) error {
 	// commit trans
 	endPoint = "transaction/:id/commit"
 	mainapi.SetAdminRoute(api.POST, PREFIX+"/"+endPoint, q.commitTrans)
+	mainapi.SetAdminRoute(api.PUT, PREFIX+"/transaction/:id", q.commitTrans)
 
 	// execute trans query
 	endPoint = "transaction/:id/cypher"
@@ -82,6 +47,13 @@ func setupAPI(mainapi *api.ConnectomeAPI) error {
 	// kill trans
 	endPoint = "transaction/:id/kill"
 	mainapi.SetAdminRoute(api.POST, PREFIX+"/"+endPoint, q.killTrans)
+	mainapi.SetAdminRoute(api.DELETE, PREFIX+"/transaction/:id", q.killTrans)
+
+	// list and force-kill transactions of any user
+	endPoint = "transactions"
+	mainapi.SetAdminRoute(api.GET, PREFIX+"/"+endPoint, q.listTrans)
+	endPoint = "transactions/:id/kill"
+	mainapi.SetAdminRoute(api.POST, PREFIX+"/"+endPoint, q.forceKillTrans)
 
 	return nil
 }
@@ -99,7 +71,7 @@ type datasetReq struct {
 }
 
 type transResp struct {
-	TransId int `json:"transaction_id"`
+	TransId string `json:"transaction_id"`
 }
 
 // startTrans starts a transaction
@@ -108,7 +80,10 @@ func (ca cypherAPI) startTrans(c echo.Context) error {
 	//
 	// Start a cypher transaction.
 	//
-	// Starts and transaction and returns an id.
+	// Starts and transaction and returns an id.  Only the user starting the
+	// transaction can use it.  Transactions unused for longer than the idle
+	// timeout (5 minutes by default) are rolled back, and each user can have
+	// a limited number of open transactions.
 	//
 	// ---
 	// parameters:
@@ -130,8 +105,8 @@ func (ca cypherAPI) startTrans(c echo.Context) error {
 	//       type: "object"
 	//       properties:
 	//         transaction_id:
-	//           type: "integer"
-	//           description: "transcation id"
+	//           type: "string"
+	//           description: "opaque transaction id"
 	// security:
 	// - Bearer: [admin]
 
@@ -153,7 +128,12 @@ func (ca cypherAPI) startTrans(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, errJSON)
 	}
 
-	transid := setTransaction(trans, req.Dataset)
+	transid, err := setTransaction(trans, req.Dataset, requestOwner(c))
+	if err != nil {
+		trans.Kill()
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusTooManyRequests, errJSON)
+	}
 	resp := &transResp{transid}
 
 	return c.JSON(http.StatusOK, resp)
@@ -166,13 +146,14 @@ func (ca cypherAPI) commitTrans(c echo.Context) error {
 	// Commits transaction.
 	//
 	// Commits and removes transaction.  If there is an error, the transaction will still be deleted.
+	// Also available as PUT /api/raw/cypher/transaction/:id.
 	//
 	// ---
 	// parameters:
 	// - in: "path"
 	//   name: "id"
 	//   schema:
-	//     type: "integer"
+	//     type: "string"
 	//   required: true
 	//   description: "transaction id"
 	// responses:
@@ -181,23 +162,20 @@ func (ca cypherAPI) commitTrans(c echo.Context) error {
 	// security:
 	// - Bearer: [admin]
 
-	id := c.Param("id")
-	tid, err := strconv.Atoi(id)
-	if err != nil {
-		errJSON := api.ErrorInfo{Error: err.Error()}
-		return c.JSON(http.StatusBadRequest, errJSON)
-	}
-
-	state, err := getTransaction(tid)
+	state, err := acquire(c.Param("id"), requestOwner(c))
 	if err != nil {
 		errJSON := api.ErrorInfo{Error: err.Error()}
 		return c.JSON(http.StatusBadRequest, errJSON)
 	}
+	defer state.release()
 	if err := secure.RequireDatasetAccess(c, state.dataset, secure.ADMIN); err != nil {
 		return err
 	}
 
-	defer deleteTransaction(tid)
+	if !state.close() {
+		errJSON := api.ErrorInfo{Error: errNotFound.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
 	if err := state.transaction.Commit(); err != nil {
 		errJSON := api.ErrorInfo{Error: err.Error()}
 		return c.JSON(http.StatusBadRequest, errJSON)
@@ -214,13 +192,14 @@ func (ca cypherAPI) killTrans(c echo.Context) error {
 	// Kill transaction.
 	//
 	// This will rollback the specified transaction.  If there is an error, the transaction will still be deleted.
+	// Also available as DELETE /api/raw/cypher/transaction/:id.
 	//
 	// ---
 	// parameters:
 	// - in: "path"
 	//   name: "id"
 	//   schema:
-	//     type: "integer"
+	//     type: "string"
 	//   required: true
 	//   description: "transaction id"
 	// responses:
@@ -229,14 +208,14 @@ func (ca cypherAPI) killTrans(c echo.Context) error {
 	// security:
 	// - Bearer: [admin]
 
-	id := c.Param("id")
-	tid, err := strconv.Atoi(id)
-	if err != nil {
-		errJSON := api.ErrorInfo{Error: err.Error()}
-		return c.JSON(http.StatusBadRequest, errJSON)
-	}
+	return ca.kill(c, false)
+}
 
-	state, err := getTransaction(tid)
+// kill rolls back the transaction in the path, which must belong to the
+// caller unless force is set.  It does not wait for a request running in
+// the transaction, which fails once the transaction is rolled back.
+func (ca cypherAPI) kill(c echo.Context, force bool) error {
+	state, err := lookup(c.Param("id"), requestOwner(c), force)
 	if err != nil {
 		errJSON := api.ErrorInfo{Error: err.Error()}
 		return c.JSON(http.StatusBadRequest, errJSON)
@@ -245,7 +224,10 @@ func (ca cypherAPI) killTrans(c echo.Context) error {
 		return err
 	}
 
-	defer deleteTransaction(tid)
+	if !state.close() {
+		errJSON := api.ErrorInfo{Error: errNotFound.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
 	if err := state.transaction.Kill(); err != nil {
 		errJSON := api.ErrorInfo{Error: err.Error()}
 		return c.JSON(http.StatusBadRequest, errJSON)
@@ -255,6 +237,99 @@ func (ca cypherAPI) killTrans(c echo.Context) error {
 	return c.JSON(http.StatusOK, successJSON)
 }
 
+// forceKillTrans kills a transaction of any user
+func (ca cypherAPI) forceKillTrans(c echo.Context) error {
+	// swagger:operation POST /api/raw/cypher/transactions/:id/kill raw-cypher forceKillTrans
+	//
+	// Kill any user's transaction.
+	//
+	// Rolls back a transaction regardless of which user started it.
+	// Requires admin access to the transaction's dataset.
+	//
+	// ---
+	// parameters:
+	// - in: "path"
+	//   name: "id"
+	//   schema:
+	//     type: "string"
+	//   required: true
+	//   description: "transaction id"
+	// responses:
+	//   200:
+	//     description: "successful operation"
+	// security:
+	// - Bearer: [admin]
+
+	return ca.kill(c, true)
+}
+
+// listTrans lists the open transactions
+func (ca cypherAPI) listTrans(c echo.Context) error {
+	// swagger:operation GET /api/raw/cypher/transactions raw-cypher listTrans
+	//
+	// List open transactions.
+	//
+	// Lists the open transactions of a dataset, or of all datasets if none
+	// is given.  Requires admin access to the listed datasets.
+	//
+	// ---
+	// parameters:
+	// - in: "query"
+	//   name: "dataset"
+	//   description: "only list transactions of this dataset"
+	// responses:
+	//   200:
+	//     description: "successful operation"
+	//     schema:
+	//       type: "array"
+	//       items:
+	//         type: "object"
+	//         properties:
+	//           transaction_id:
+	//             type: "string"
+	//           dataset:
+	//             type: "string"
+	//           owner:
+	//             type: "string"
+	//           created:
+	//             type: "string"
+	//           lastUsed:
+	//             type: "string"
+	//           active:
+	//             type: "boolean"
+	//             description: "whether a request is using the transaction"
+	// security:
+	// - Bearer: [admin]
+
+	dataset := c.QueryParam("dataset")
+	if dataset != "" {
+		if err := secure.RequireDatasetAccess(c, dataset, secure.ADMIN); err != nil {
+			return err
+		}
+	} else {
+		datasets, err := ca.Store.GetDatasets()
+		if err != nil {
+			errJSON := api.ErrorInfo{Error: err.Error()}
+			return c.JSON(http.StatusBadRequest, errJSON)
+		}
+		names := make([]string, 0, len(datasets))
+		for name := range datasets {
+			names = append(names, name)
+		}
+		if err := secure.RequireDatasetsAccess(c, names, secure.ADMIN); err != nil {
+			return err
+		}
+	}
+
+	infos := []TransactionInfo{}
+	for _, info := range listTransactions() {
+		if dataset == "" || info.Dataset == dataset {
+			infos = append(infos, info)
+		}
+	}
+	return c.JSON(http.StatusOK, infos)
+}
+
 // execTranCypher enables custom cypher queries
 func (ca cypherAPI) execTranCypher(c echo.Context) error {
 	// swagger:operation POST /api/raw/cypher/transaction/:id/cypher raw-cypher execTranCypher
@@ -268,7 +343,7 @@ func (ca cypherAPI) execTranCypher(c echo.Context) error {
 	// - in: "path"
 	//   name: "id"
 	//   schema:
-	//     type: "integer"
+	//     type: "string"
 	//   required: true
 	//   description: "transaction id"
 	// - in: "body"
@@ -320,18 +395,12 @@ func (ca cypherAPI) execTranCypher(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, errJSON)
 	}
 
-	id := c.Param("id")
-	tid, err := strconv.Atoi(id)
-	if err != nil {
-		errJSON := api.ErrorInfo{Error: err.Error()}
-		return c.JSON(http.StatusBadRequest, errJSON)
-	}
-
-	state, err := getTransaction(tid)
+	state, err := acquire(c.Param("id"), requestOwner(c))
 	if err != nil {
 		errJSON := api.ErrorInfo{Error: err.Error()}
 		return c.JSON(http.StatusBadRequest, errJSON)
 	}
+	defer state.release()
 	if req.Dataset != "" && req.Dataset != state.dataset {
 		return c.JSON(http.StatusBadRequest, api.ErrorInfo{Error: "transaction dataset does not match request"})
 	}
@@ -0,0 +1,213 @@
+package cypuer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/secure"
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+	"github.com/labstack/echo/v4"
+)
+
+// IdleTimeout is how long a transaction may go unused before it is rolled
+// back (set from the cypher-transaction-ttl configuration)
+var IdleTimeout = 5 * time.Minute
+
+// MaxPerUser bounds the open transactions of each user (set from the
+// cypher-transaction-max configuration)
+var MaxPerUser = 5
+
+// errNotFound is returned for unknown transactions and for transactions
+// owned by another user, so ids cannot be probed
+var errNotFound = fmt.Errorf("Transaction id not found")
+
+// transactionState is an open transaction.  The lock is held while the
+// transaction is used, so requests for a transaction run one at a time.
+// Closing does not need the lock, so a transaction can be killed while a
+// request is running in it.
+type transactionState struct {
+	transaction storage.CypherTransaction
+	id          string
+	dataset     string
+	owner       string
+	created     time.Time
+
+	mux      sync.Mutex
+	lastUsed time.Time
+	closed   atomic.Bool
+}
+
+// transactions holds the open transactions by id
+var transactions = make(map[string]*transactionState)
+var mux sync.Mutex
+
+// TransactionInfo describes an open transaction
+type TransactionInfo struct {
+	ID       string    `json:"transaction_id"`
+	Dataset  string    `json:"dataset"`
+	Owner    string    `json:"owner"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"lastUsed"`
+	Active   bool      `json:"active"` // whether a request is using it
+}
+
+// requestOwner returns the identity that owns the transactions started by
+// a request
+func requestOwner(c echo.Context) string {
+	if identity, ok := c.Get("dsg_identity").(*secure.DSGIdentity); ok && identity != nil {
+		return identity.Email
+	}
+	email, _ := c.Get("email").(string)
+	return email
+}
+
+// newTransactionID returns a random opaque id
+func newTransactionID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// setTransaction records a new transaction for its owner, failing if the
+// owner has too many open transactions
+func setTransaction(trans storage.CypherTransaction, dataset, owner string) (string, error) {
+	id, err := newTransactionID()
+	if err != nil {
+		return "", err
+	}
+	mux.Lock()
+	defer mux.Unlock()
+	open := 0
+	for _, state := range transactions {
+		if state.owner == owner {
+			open++
+		}
+	}
+	if open >= MaxPerUser {
+		return "", fmt.Errorf("too many open transactions (at most %d)", MaxPerUser)
+	}
+	now := time.Now()
+	transactions[id] = &transactionState{
+		transaction: trans,
+		id:          id,
+		dataset:     dataset,
+		owner:       owner,
+		created:     now,
+		lastUsed:    now,
+	}
+	return id, nil
+}
+
+// lookup returns a transaction without locking it.  Only the owner can see
+// a transaction unless force is set.
+func lookup(id, owner string, force bool) (*transactionState, error) {
+	mux.Lock()
+	state, ok := transactions[id]
+	mux.Unlock()
+	if !ok || (!force && state.owner != owner) || state.closed.Load() {
+		return nil, errNotFound
+	}
+	return state, nil
+}
+
+// acquire returns a transaction of the owner locked for use
+func acquire(id, owner string) (*transactionState, error) {
+	state, err := lookup(id, owner, false)
+	if err != nil {
+		return nil, err
+	}
+	state.mux.Lock()
+	if state.closed.Load() {
+		state.mux.Unlock()
+		return nil, errNotFound
+	}
+	return state, nil
+}
+
+// release marks a transaction as used and unlocks it
+func (state *transactionState) release() {
+	state.lastUsed = time.Now()
+	state.mux.Unlock()
+}
+
+// close removes a transaction, returning false if it was already closed.
+// Only the caller that closes a transaction may commit or kill it.
+func (state *transactionState) close() bool {
+	if !state.closed.CompareAndSwap(false, true) {
+		return false
+	}
+	mux.Lock()
+	delete(transactions, state.id)
+	mux.Unlock()
+	return true
+}
+
+// listTransactions describes the open transactions sorted by creation
+func listTransactions() []TransactionInfo {
+	mux.Lock()
+	states := make([]*transactionState, 0, len(transactions))
+	for _, state := range transactions {
+		states = append(states, state)
+	}
+	mux.Unlock()
+
+	infos := make([]TransactionInfo, 0, len(states))
+	for _, state := range states {
+		info := TransactionInfo{ID: state.id, Dataset: state.dataset, Owner: state.owner, Created: state.created}
+		if state.mux.TryLock() {
+			info.LastUsed = state.lastUsed
+			state.mux.Unlock()
+		} else {
+			info.Active = true
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Created.Before(infos[j].Created) })
+	return infos
+}
+
+// reapIdle rolls back the transactions unused since the cutoff.  Locked
+// transactions are in use and skipped.
+func reapIdle(cutoff time.Time) {
+	mux.Lock()
+	states := make([]*transactionState, 0, len(transactions))
+	for _, state := range transactions {
+		states = append(states, state)
+	}
+	mux.Unlock()
+
+	for _, state := range states {
+		if !state.mux.TryLock() {
+			continue
+		}
+		if !state.lastUsed.Before(cutoff) || !state.close() {
+			state.mux.Unlock()
+			continue
+		}
+		if err := state.transaction.Kill(); err != nil {
+			fmt.Printf("Error rolling back idle transaction for %s: %v\n", state.owner, err)
+		} else {
+			fmt.Printf("Rolled back transaction for %s idle since %s\n", state.owner, state.lastUsed.Format(time.RFC3339))
+		}
+		state.mux.Unlock()
+	}
+}
+
+// reapTransactions periodically rolls back idle transactions
+func reapTransactions() {
+	for {
+		interval := IdleTimeout / 4
+		if interval < time.Second {
+			interval = time.Second
+		}
+		time.Sleep(interval)
+		reapIdle(time.Now().Add(-IdleTimeout))
+	}
+}
@@ -0,0 +1,139 @@
+package cypuer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+)
+
+// mockTrans records how a transaction ended
+type mockTrans struct {
+	killed    bool
+	committed bool
+}
+
+func (m *mockTrans) CypherRequest(string, bool) (storage.CypherResult, error) {
+	return storage.CypherResult{}, nil
+}
+func (m *mockTrans) CypherRequestWithParams(string, map[string]interface{}, bool) (storage.CypherResult, error) {
+	return storage.CypherResult{}, nil
+}
+func (m *mockTrans) Kill() error   { m.killed = true; return nil }
+func (m *mockTrans) Commit() error { m.committed = true; return nil }
+
+func resetTransactions() {
+	mux.Lock()
+	transactions = make(map[string]*transactionState)
+	mux.Unlock()
+}
+
+func TestTransactionOwnership(t *testing.T) {
+	resetTransactions()
+	id, err := setTransaction(&mockTrans{}, "hemibrain", "alice@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := setTransaction(&mockTrans{}, "hemibrain", "alice@example.org")
+	if len(id) != 32 || id == other {
+		t.Errorf("expected distinct opaque ids, got %q and %q", id, other)
+	}
+
+	if _, err := acquire(id, "bob@example.org"); err != errNotFound {
+		t.Errorf("expected another user's transaction to be hidden, got %v", err)
+	}
+	state, err := acquire(id, "alice@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.release()
+
+	// forced access ignores the owner
+	if _, err := lookup(id, "bob@example.org", false); err != errNotFound {
+		t.Errorf("expected another user's transaction to be hidden, got %v", err)
+	}
+	state, err = lookup(id, "bob@example.org", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.close() || state.close() {
+		t.Error("expected a transaction to be closed exactly once")
+	}
+	if _, err := acquire(id, "alice@example.org"); err != errNotFound {
+		t.Errorf("expected closed transaction to be gone, got %v", err)
+	}
+	if infos := listTransactions(); len(infos) != 1 || infos[0].ID != other || infos[0].Owner != "alice@example.org" {
+		t.Errorf("unexpected transactions %v", infos)
+	}
+}
+
+func TestTransactionLimit(t *testing.T) {
+	resetTransactions()
+	defer func(max int) { MaxPerUser = max }(MaxPerUser)
+	MaxPerUser = 2
+	for i := 0; i < 2; i++ {
+		if _, err := setTransaction(&mockTrans{}, "hemibrain", "alice@example.org"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := setTransaction(&mockTrans{}, "hemibrain", "alice@example.org"); err == nil {
+		t.Error("expected the limit to be enforced")
+	}
+	if _, err := setTransaction(&mockTrans{}, "hemibrain", "bob@example.org"); err != nil {
+		t.Errorf("limit applied to another user: %v", err)
+	}
+}
+
+func TestReapIdle(t *testing.T) {
+	resetTransactions()
+	idle, busy, fresh := &mockTrans{}, &mockTrans{}, &mockTrans{}
+	idleID, _ := setTransaction(idle, "hemibrain", "alice@example.org")
+	busyID, _ := setTransaction(busy, "hemibrain", "alice@example.org")
+	freshID, _ := setTransaction(fresh, "hemibrain", "alice@example.org")
+
+	past := time.Now().Add(-time.Hour)
+	for _, id := range []string{idleID, busyID} {
+		transactions[id].lastUsed = past
+	}
+	state, _ := acquire(busyID, "alice@example.org")
+
+	reapIdle(time.Now().Add(-time.Minute))
+	if !idle.killed || busy.killed || fresh.killed {
+		t.Errorf("unexpected kills: idle=%v busy=%v fresh=%v", idle.killed, busy.killed, fresh.killed)
+	}
+	if _, err := acquire(idleID, "alice@example.org"); err != errNotFound {
+		t.Error("reaped transaction still open")
+	}
+	if infos := listTransactions(); len(infos) != 2 || !(infos[0].Active || infos[1].Active) {
+		t.Errorf("expected the busy transaction to be listed as active: %v", infos)
+	}
+	state.release()
+	if _, err := acquire(freshID, "alice@example.org"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCloseRunningTransaction(t *testing.T) {
+	resetTransactions()
+	id, _ := setTransaction(&mockTrans{}, "hemibrain", "alice@example.org")
+	running, err := acquire(id, "alice@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a forced kill does not wait for the running request
+	state, err := lookup(id, "bob@example.org", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.close() {
+		t.Fatal("expected the running transaction to be closed")
+	}
+	if running.close() {
+		t.Error("expected the running request to see the transaction closed")
+	}
+	running.release()
+	if _, err := acquire(id, "alice@example.org"); err != errNotFound {
+		t.Errorf("expected the killed transaction to be gone, got %v", err)
+	}
+}
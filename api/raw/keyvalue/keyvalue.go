@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/connectome-neuprint/neuPrintHTTP/api"
+	"github.com/connectome-neuprint/neuPrintHTTP/audit"
 	"github.com/connectome-neuprint/neuPrintHTTP/secure"
 	"github.com/connectome-neuprint/neuPrintHTTP/storage"
 	"github.com/labstack/echo/v4"
@@ -152,6 +153,7 @@ func (ma masterAPI) setKV(c echo.Context) error {
 		errJSON := api.ErrorInfo{Error: "error reading binary data"}
 		return c.JSON(http.StatusBadRequest, errJSON)
 	}
+	audit.SetPrevious(c, func() ([]byte, error) { return kvstore.Get([]byte(keyname)) })
 	err = kvstore.Set([]byte(keyname), body)
 	if err != nil {
 		errJSON := api.ErrorInfo{Error: err.Error()}
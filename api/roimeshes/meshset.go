@@ -0,0 +1,165 @@
+package roimeshes
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/mesh"
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+)
+
+// ROIQuery fetches the ROIs of a dataset and its modification stamp
+const ROIQuery = "MATCH (m :Meta) RETURN m.lastDatabaseEdit, m.roiInfo"
+
+// MeshSet holds the parsed and indexed ROI meshes of a dataset.  Meshes
+// are loaded on first use.  A new set is created when the dataset is
+// edited or a mesh is uploaded, so callers can compare sets to tell
+// whether results computed from the meshes are stale.
+type MeshSet struct {
+	// LastEdit is the lastDatabaseEdit stamp the set was created for
+	LastEdit string
+
+	// ROIs are the ROI names of the dataset in sorted order
+	ROIs []string
+
+	kvstore storage.KeyValue
+	mux     sync.Mutex
+	meshes  map[string]*mesh.Mesh
+	loading map[string]chan struct{}
+}
+
+// meshSets holds the current mesh set for each dataset
+var meshSets = make(map[string]*MeshSet)
+var meshSetMux sync.Mutex
+
+// invalidate drops the mesh set for a dataset
+func invalidate(dataset string) {
+	meshSetMux.Lock()
+	delete(meshSets, dataset)
+	meshSetMux.Unlock()
+}
+
+// Mesh returns the parsed mesh for an ROI, or nil if the ROI is not one of
+// the dataset's or has no valid mesh.  Meshes are fetched and parsed
+// outside the set's lock, so different ROIs load at once; a mesh that
+// could not be fetched is tried again on the next call.
+func (set *MeshSet) Mesh(roi string) *mesh.Mesh {
+	if i := sort.SearchStrings(set.ROIs, roi); i == len(set.ROIs) || set.ROIs[i] != roi {
+		return nil
+	}
+	for {
+		set.mux.Lock()
+		if roiMesh, ok := set.meshes[roi]; ok {
+			set.mux.Unlock()
+			return roiMesh
+		}
+		loading, ok := set.loading[roi]
+		if !ok {
+			break
+		}
+		set.mux.Unlock()
+		<-loading
+	}
+	loading := make(chan struct{})
+	set.loading[roi] = loading
+	set.mux.Unlock()
+
+	roiMesh, found := set.load(roi)
+
+	set.mux.Lock()
+	if found {
+		set.meshes[roi] = roiMesh
+	}
+	delete(set.loading, roi)
+	set.mux.Unlock()
+	close(loading)
+	return roiMesh
+}
+
+// load fetches and indexes the mesh of an ROI.  found is false if the
+// mesh could not be fetched, rather than being absent or invalid.
+func (set *MeshSet) load(roi string) (roiMesh *mesh.Mesh, found bool) {
+	data, err := set.kvstore.Get([]byte(roi))
+	if err == storage.ErrKeyNotFound {
+		return nil, true
+	}
+	if err != nil {
+		return nil, false
+	}
+	if parsed, err := mesh.ParseOBJ(data); err == nil && len(parsed.Faces) > 0 {
+		parsed.Index()
+		return parsed, true
+	}
+	return nil, true
+}
+
+// meshStore returns the key value store holding the ROI meshes for a
+// dataset
+func meshStore(store storage.Store, dataset string) (storage.KeyValue, error) {
+	found, err := store.FindStore("roimeshes", dataset)
+	if err != nil {
+		return nil, err
+	}
+	kvstore, ok := found.(storage.KeyValue)
+	if !ok {
+		return nil, fmt.Errorf("database doesn't support keyvalue")
+	}
+	return kvstore, nil
+}
+
+// DatasetMeshes returns the mesh set for a dataset, replacing it when the
+// dataset has been edited
+func DatasetMeshes(store storage.Store, dataset string) (*MeshSet, error) {
+	kvstore, err := meshStore(store, dataset)
+	if err != nil {
+		return nil, err
+	}
+	res, err := store.GetMain(dataset).CypherRequest(ROIQuery, true)
+	if err != nil {
+		return nil, err
+	}
+	var edit, roiInfo string
+	if len(res.Data) > 0 && len(res.Data[0]) == 2 {
+		edit, _ = res.Data[0][0].(string)
+		roiInfo, _ = res.Data[0][1].(string)
+	}
+
+	meshSetMux.Lock()
+	defer meshSetMux.Unlock()
+	if set, ok := meshSets[dataset]; ok && set.LastEdit == edit {
+		return set, nil
+	}
+	set := &MeshSet{LastEdit: edit, kvstore: kvstore, meshes: make(map[string]*mesh.Mesh), loading: make(map[string]chan struct{})}
+	if roiInfo != "" {
+		var info map[string]interface{}
+		if err := json.Unmarshal([]byte(roiInfo), &info); err != nil {
+			return nil, fmt.Errorf("roiInfo is not formatted correctly")
+		}
+		for roi := range info {
+			set.ROIs = append(set.ROIs, roi)
+		}
+		sort.Strings(set.ROIs)
+	}
+	meshSets[dataset] = set
+	return set, nil
+}
+
+// Contains returns the ROIs whose meshes contain each point
+func (set *MeshSet) Contains(rois []string, points [][3]float64) [][]string {
+	meshes := make([]*mesh.Mesh, len(rois))
+	for i, roi := range rois {
+		meshes[i] = set.Mesh(roi)
+	}
+	result := make([][]string, len(points))
+	for p, point := range points {
+		result[p] = []string{}
+		for i, roiMesh := range meshes {
+			if roiMesh != nil && roiMesh.Contains(point[0], point[1], point[2]) {
+				result[p] = append(result[p], rois[i])
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,157 @@
+package roimeshes
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+)
+
+// cubeOBJ is a closed cube from 0 to 10
+const cubeOBJ = `v 0 0 0
+v 10 0 0
+v 10 10 0
+v 0 10 0
+v 0 0 10
+v 10 0 10
+v 10 10 10
+v 0 10 10
+f 1 4 3 2
+f 5 6 7 8
+f 1 2 6 5
+f 2 3 7 6
+f 3 4 8 7
+f 4 1 5 8
+`
+
+// shiftedCube moves the cube by 5 along x
+const shiftedCube = `v 5 0 0
+v 15 0 0
+v 15 10 0
+v 5 10 0
+v 5 0 10
+v 15 0 10
+v 15 10 10
+v 5 10 10
+f 1 4 3 2
+f 5 6 7 8
+f 1 2 6 5
+f 2 3 7 6
+f 3 4 8 7
+f 4 1 5 8
+`
+
+// meshKV serves ROI meshes and counts fetches
+type meshKV struct {
+	meshes map[string][]byte
+	gets   int
+	down   bool
+}
+
+func (kv *meshKV) Get(key []byte) ([]byte, error) {
+	kv.gets++
+	if kv.down {
+		return nil, fmt.Errorf("store unavailable")
+	}
+	if val, ok := kv.meshes[string(key)]; ok {
+		return val, nil
+	}
+	return nil, storage.ErrKeyNotFound
+}
+func (kv *meshKV) Set(key, val []byte) error                    { kv.meshes[string(key)] = val; return nil }
+func (kv *meshKV) GetVersion() (string, error)                  { return "0.1", nil }
+func (kv *meshKV) GetDatabase() (string, string, error)         { return "mock", "mock", nil }
+func (kv *meshKV) GetDatasets() (map[string]interface{}, error) { return nil, nil }
+func (kv *meshKV) GetType() string                              { return "mock" }
+func (kv *meshKV) GetInstance() string                          { return "mock" }
+
+// metaCypher answers the ROI query with a fixed edit stamp
+type metaCypher struct {
+	edit string
+}
+
+func (m *metaCypher) CypherRequest(query string, readonly bool) (storage.CypherResult, error) {
+	roiInfo := `{"b": {"pre": 1}, "a": {"pre": 2}, "none": {}}`
+	return storage.CypherResult{Columns: []string{"edit", "roiInfo"}, Data: [][]interface{}{{m.edit, roiInfo}}}, nil
+}
+func (m *metaCypher) CypherRequestWithParams(query string, params map[string]interface{}, readonly bool) (storage.CypherResult, error) {
+	return m.CypherRequest(query, readonly)
+}
+func (m *metaCypher) StartTrans() (storage.CypherTransaction, error) { return nil, nil }
+
+type meshStoreMock struct {
+	cypher *metaCypher
+	kv     *meshKV
+}
+
+func (m *meshStoreMock) GetMain(datasets ...string) storage.Cypher         { return m.cypher }
+func (m *meshStoreMock) GetDataset(dataset string) (storage.Cypher, error) { return m.cypher, nil }
+func (m *meshStoreMock) GetStores() []storage.SimpleStore                  { return nil }
+func (m *meshStoreMock) GetInstances() map[string]storage.SimpleStore      { return nil }
+func (m *meshStoreMock) GetTypes() map[string][]storage.SimpleStore        { return nil }
+func (m *meshStoreMock) FindStore(typename, dataset string) (storage.SimpleStore, error) {
+	return m.kv, nil
+}
+func (m *meshStoreMock) GetVersion() (string, error)                  { return "0.1", nil }
+func (m *meshStoreMock) GetDatabase() (string, string, error)         { return "mock", "mock", nil }
+func (m *meshStoreMock) GetDatasets() (map[string]interface{}, error) { return nil, nil }
+func (m *meshStoreMock) GetType() string                              { return "mock" }
+func (m *meshStoreMock) GetInstance() string                          { return "mock" }
+
+func TestMeshSetContains(t *testing.T) {
+	store := &meshStoreMock{&metaCypher{"v1"}, &meshKV{meshes: map[string][]byte{"a": []byte(cubeOBJ), "b": []byte(shiftedCube)}}}
+	set, err := DatasetMeshes(store, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(set.ROIs, []string{"a", "b", "none"}) {
+		t.Errorf("unexpected ROIs: %v", set.ROIs)
+	}
+
+	got := set.Contains(set.ROIs, [][3]float64{{2, 5, 5}, {7, 5, 5}, {12, 5, 5}, {20, 5, 5}})
+	want := [][]string{{"a"}, {"a", "b"}, {"b"}, {}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	// meshes are parsed once per set
+	gets := store.kv.gets
+	set.Contains([]string{"a", "none"}, [][3]float64{{1, 1, 1}})
+	if store.kv.gets != gets {
+		t.Errorf("expected cached meshes, got %d fetches", store.kv.gets-gets)
+	}
+	if again, _ := DatasetMeshes(store, "test"); again != set {
+		t.Error("expected the same set until the dataset is edited")
+	}
+	invalidate("test")
+	if again, _ := DatasetMeshes(store, "test"); again == set {
+		t.Error("expected a new set after a mesh upload")
+	}
+}
+
+func TestMeshSetLookups(t *testing.T) {
+	store := &meshStoreMock{&metaCypher{"v1"}, &meshKV{meshes: map[string][]byte{"a": []byte(cubeOBJ)}}}
+	set, err := DatasetMeshes(store, "lookups")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// names that are not ROIs of the dataset are neither fetched nor kept
+	if set.Mesh("unknown") != nil || store.kv.gets != 0 || len(set.meshes) != 0 {
+		t.Errorf("unexpected lookup of an unknown ROI: %d fetches, %d kept", store.kv.gets, len(set.meshes))
+	}
+
+	// a failed fetch is tried again
+	store.kv.down = true
+	if set.Mesh("a") != nil {
+		t.Error("expected no mesh while the store is down")
+	}
+	store.kv.down = false
+	if set.Mesh("a") == nil || store.kv.gets != 2 {
+		t.Errorf("expected the mesh to be fetched again, got %d fetches", store.kv.gets)
+	}
+	if set.Mesh("none") != nil || set.Mesh("none") != nil || store.kv.gets != 3 {
+		t.Errorf("expected an absent mesh to be kept, got %d fetches", store.kv.gets)
+	}
+}
@@ -1,10 +1,15 @@
 package roimeshes
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 	"net/http"
 
 	"github.com/connectome-neuprint/neuPrintHTTP/api"
+	"github.com/connectome-neuprint/neuPrintHTTP/api/rois"
+	"github.com/connectome-neuprint/neuPrintHTTP/audit"
+	"github.com/connectome-neuprint/neuPrintHTTP/mesh"
 	"github.com/connectome-neuprint/neuPrintHTTP/secure"
 	"github.com/connectome-neuprint/neuPrintHTTP/storage"
 	"github.com/labstack/echo/v4"
@@ -30,6 +35,11 @@ func setupAPI(mainapi *api.ConnectomeAPI) error {
 
 	mainapi.SetRoute(api.GET, PREFIX+"/"+endPoint+"/:dataset/:roi", q.getMesh, api.GuardedRoute)
 	mainapi.SetAdminRoute(api.POST, PREFIX+"/"+endPoint+"/:dataset/:roi", q.setMesh)
+
+	// point in ROI endpoint
+	endPoint = "contains"
+	mainapi.SupportedEndpoints[endPoint] = true
+	mainapi.SetRoute(api.POST, PREFIX+"/"+endPoint+"/:dataset", q.getContains, api.GuardedRoute)
 	return nil
 }
 
@@ -39,7 +49,10 @@ func (ma masterAPI) getMesh(c echo.Context) error {
 	//
 	// Get mesh for given ROI
 	//
-	// The meshes are stored in OBJ format
+	// The meshes are stored in OBJ format and returned as stored unless
+	// another format is requested.  PLY is binary little endian, ngmesh is
+	// the neuroglancer legacy single resolution mesh encoding and JSON lists
+	// the vertices and zero-based triangle indices.
 	//
 	// ---
 	// parameters:
@@ -55,9 +68,14 @@ func (ma masterAPI) getMesh(c echo.Context) error {
 	//     type: "string"
 	//   required: true
 	//   description: "roi name"
+	// - in: "query"
+	//   name: "format"
+	//   type: "string"
+	//   enum: ["obj", "ply", "ngmesh", "json"]
+	//   description: "response format (default is the stored OBJ)"
 	// responses:
 	//   200:
-	//     description: "binary OBJ file"
+	//     description: "OBJ, PLY, neuroglancer mesh or JSON"
 	// security:
 	// - Bearer: []
 
@@ -72,16 +90,11 @@ func (ma masterAPI) getMesh(c echo.Context) error {
 		return err
 	}
 
-	store, err := ma.Store.FindStore("roimeshes", dataset)
+	kvstore, err := meshStore(ma.Store, dataset)
 	if err != nil {
 		errJSON := api.ErrorInfo{Error: err.Error()}
 		return c.JSON(http.StatusBadRequest, errJSON)
 	}
-	kvstore, ok := store.(storage.KeyValue)
-	if !ok {
-		errJSON := api.ErrorInfo{Error: "database doesn't support keyvalue"}
-		return c.JSON(http.StatusBadRequest, errJSON)
-	}
 
 	// fetch the value
 	res, err := kvstore.Get([]byte(roiname))
@@ -90,7 +103,33 @@ func (ma masterAPI) getMesh(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, errJSON)
 	}
 
-	return c.Blob(http.StatusOK, "text/plain", res)
+	format := c.QueryParam("format")
+	if format == "" || format == "obj" {
+		return c.Blob(http.StatusOK, "text/plain", res)
+	}
+	if format != "ply" && format != "ngmesh" && format != "json" {
+		errJSON := api.ErrorInfo{Error: "format must be obj, ply, ngmesh or json"}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+
+	roiMesh, err := mesh.ParseOBJ(res)
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: "OBJ not formatted properly: " + err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	if format == "json" {
+		return c.JSON(http.StatusOK, roiMesh.JSON())
+	}
+	var buf bytes.Buffer
+	if format == "ply" {
+		err = roiMesh.WritePLY(&buf)
+	} else {
+		err = roiMesh.WriteNgMesh(&buf)
+	}
+	if err != nil {
+		return err
+	}
+	return c.Blob(http.StatusOK, "application/octet-stream", buf.Bytes())
 }
 
 // setMesh posts the mesh at the roi
@@ -135,16 +174,11 @@ func (ma masterAPI) setMesh(c echo.Context) error {
 		return err
 	}
 
-	store, err := ma.Store.FindStore("roimeshes", dataset)
+	kvstore, err := meshStore(ma.Store, dataset)
 	if err != nil {
 		errJSON := api.ErrorInfo{Error: err.Error()}
 		return c.JSON(http.StatusBadRequest, errJSON)
 	}
-	kvstore, ok := store.(storage.KeyValue)
-	if !ok {
-		errJSON := api.ErrorInfo{Error: "database doesn't support keyvalue"}
-		return c.JSON(http.StatusBadRequest, errJSON)
-	}
 
 	// post the value
 	body, err := io.ReadAll(c.Request().Body)
@@ -152,10 +186,110 @@ func (ma masterAPI) setMesh(c echo.Context) error {
 		errJSON := api.ErrorInfo{Error: "error reading binary data"}
 		return c.JSON(http.StatusBadRequest, errJSON)
 	}
+	audit.SetPrevious(c, func() ([]byte, error) { return kvstore.Get([]byte(roiname)) })
 	err = kvstore.Set([]byte(roiname), body)
 	if err != nil {
 		errJSON := api.ErrorInfo{Error: err.Error()}
 		return c.JSON(http.StatusBadRequest, errJSON)
 	}
+	// mesh availability is part of the ROI catalog
+	rois.Invalidate(dataset)
+	invalidate(dataset)
 	return c.String(http.StatusOK, "")
 }
+
+// MaxContainsPoints bounds the number of points in a point in ROI request
+const MaxContainsPoints = 100000
+
+type containsParams struct {
+	Points [][3]float64 `json:"points"`
+	ROIs   []string     `json:"rois,omitempty"`
+}
+
+type containsResp struct {
+	ROIs [][]string `json:"rois"`
+}
+
+// getContains finds the ROI meshes containing each point
+func (ma masterAPI) getContains(c echo.Context) error {
+	// swagger:operation POST /api/roimeshes/contains/{dataset} roimeshes getContains
+	//
+	// Find the ROIs containing points
+	//
+	// Tests each point against the ROI meshes, using a spatial index of the
+	// mesh faces, and returns the names of the ROIs containing it in the
+	// order of the points.  All ROIs of the dataset with a mesh are tested
+	// unless a list is given.
+	//
+	// ---
+	// parameters:
+	// - in: "path"
+	//   name: "dataset"
+	//   schema:
+	//     type: "string"
+	//   required: true
+	//   description: "dataset name"
+	// - in: "body"
+	//   name: "body"
+	//   required: true
+	//   schema:
+	//     type: "object"
+	//     required: ["points"]
+	//     properties:
+	//       points:
+	//         type: "array"
+	//         items:
+	//           type: "array"
+	//           items:
+	//             type: "number"
+	//         example: [[14000, 20000, 19000]]
+	//       rois:
+	//         type: "array"
+	//         items:
+	//           type: "string"
+	//         description: "ROIs to test (default all)"
+	// responses:
+	//   200:
+	//     description: "ROIs containing each point"
+	//     schema:
+	//       type: "object"
+	//       properties:
+	//         rois:
+	//           type: "array"
+	//           items:
+	//             type: "array"
+	//             items:
+	//               type: "string"
+	// security:
+	// - Bearer: []
+
+	dataset := c.Param("dataset")
+	if dataset == "" {
+		errJSON := api.ErrorInfo{Error: "parameters not properly provided in uri"}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	if err := secure.RequireDatasetAccess(c, dataset, secure.READ); err != nil {
+		return err
+	}
+
+	var params containsParams
+	if err := c.Bind(&params); err != nil {
+		errJSON := api.ErrorInfo{Error: "request object not formatted correctly"}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	if len(params.Points) == 0 || len(params.Points) > MaxContainsPoints {
+		errJSON := api.ErrorInfo{Error: fmt.Sprintf("between 1 and %d points must be given", MaxContainsPoints)}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+
+	set, err := DatasetMeshes(ma.Store, dataset)
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	names := params.ROIs
+	if len(names) == 0 {
+		names = set.ROIs
+	}
+	return c.JSON(http.StatusOK, containsResp{set.Contains(names, params.Points)})
+}
@@ -0,0 +1,132 @@
+package rois
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// ROI is a node in the ROI hierarchy
+type ROI struct {
+	Name       string `json:"name"`
+	Pre        int64  `json:"pre"`
+	Post       int64  `json:"post"`
+	Primary    bool   `json:"primary"`
+	Overview   bool   `json:"overview"`
+	NumNeurons int64  `json:"numNeurons"`
+	HasMesh    bool   `json:"hasMesh"`
+	Children   []*ROI `json:"children,omitempty"`
+}
+
+// Catalog is the ROI hierarchy for a dataset.  ROIs found in roiInfo but
+// not in the hierarchy are listed in Unplaced.
+type Catalog struct {
+	Dataset   string `json:"dataset"`
+	LastEdit  string `json:"lastDatabaseEdit"`
+	Hierarchy *ROI   `json:"hierarchy"`
+	Unplaced  []*ROI `json:"unplaced,omitempty"`
+}
+
+// roiCounts is the per-ROI entry of :Meta.roiInfo
+type roiCounts struct {
+	Pre                 int64 `json:"pre"`
+	Post                int64 `json:"post"`
+	ExcludeFromOverview bool  `json:"excludeFromOverview"`
+}
+
+// hierarchyNode is the stored form of :Meta.roiHierarchy
+type hierarchyNode struct {
+	Name     string          `json:"name"`
+	Children []hierarchyNode `json:"children"`
+}
+
+// roiMeta holds the :Meta properties describing the ROIs of a dataset
+type roiMeta struct {
+	Dataset       string
+	LastEdit      string
+	Hierarchy     string
+	ROIInfo       string
+	PrimaryROIs   []string
+	OverviewROIs  []string
+	TotalPre      int64
+	TotalPost     int64
+	NeuronCounts  map[string]int64
+	MeshAvailable func(roi string) bool
+}
+
+// buildCatalog parses the stored hierarchy and annotates every ROI with its
+// synapse totals, flags, neuron count and mesh availability.  Overview ROIs
+// default to the primary ROIs, as for the cached ROI connectivity.
+func buildCatalog(meta roiMeta) (*Catalog, error) {
+	info := make(map[string]roiCounts)
+	if meta.ROIInfo != "" {
+		if err := json.Unmarshal([]byte(meta.ROIInfo), &info); err != nil {
+			return nil, fmt.Errorf("roiInfo not formatted properly: %v", err)
+		}
+	}
+	var tree hierarchyNode
+	if meta.Hierarchy != "" {
+		if err := json.Unmarshal([]byte(meta.Hierarchy), &tree); err != nil {
+			return nil, fmt.Errorf("roiHierarchy not formatted properly: %v", err)
+		}
+	}
+
+	primary := make(map[string]bool, len(meta.PrimaryROIs))
+	for _, roi := range meta.PrimaryROIs {
+		primary[roi] = true
+	}
+	overviewList := meta.OverviewROIs
+	if overviewList == nil {
+		overviewList = meta.PrimaryROIs
+	}
+	overview := make(map[string]bool, len(overviewList))
+	for _, roi := range overviewList {
+		if !info[roi].ExcludeFromOverview {
+			overview[roi] = true
+		}
+	}
+
+	placed := make(map[string]bool)
+	var convert func(node hierarchyNode) *ROI
+	convert = func(node hierarchyNode) *ROI {
+		roi := &ROI{
+			Name:       node.Name,
+			Pre:        info[node.Name].Pre,
+			Post:       info[node.Name].Post,
+			Primary:    primary[node.Name],
+			Overview:   overview[node.Name],
+			NumNeurons: meta.NeuronCounts[node.Name],
+		}
+		if meta.MeshAvailable != nil {
+			roi.HasMesh = meta.MeshAvailable(node.Name)
+		}
+		placed[node.Name] = true
+		for _, child := range node.Children {
+			roi.Children = append(roi.Children, convert(child))
+		}
+		return roi
+	}
+
+	catalog := &Catalog{Dataset: meta.Dataset, LastEdit: meta.LastEdit}
+	if tree.Name == "" {
+		tree.Name = meta.Dataset
+	}
+	catalog.Hierarchy = convert(tree)
+	// the root is the whole dataset rather than an ROI
+	catalog.Hierarchy.Pre = meta.TotalPre
+	catalog.Hierarchy.Post = meta.TotalPost
+	catalog.Hierarchy.Primary = false
+	catalog.Hierarchy.Overview = false
+
+	names := make([]string, 0, len(info))
+	for name := range info {
+		if !placed[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		catalog.Unplaced = append(catalog.Unplaced, convert(hierarchyNode{Name: name}))
+	}
+	return catalog, nil
+}
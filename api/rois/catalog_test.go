@@ -0,0 +1,140 @@
+package rois
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+)
+
+const testHierarchy = `{"name": "hemibrain", "children": [
+	{"name": "MB(R)", "children": [{"name": "CA(R)"}, {"name": "PED(R)"}]},
+	{"name": "EB"}
+]}`
+
+const testROIInfo = `{
+	"MB(R)": {"pre": 100, "post": 400},
+	"CA(R)": {"pre": 60, "post": 300},
+	"PED(R)": {"pre": 40, "post": 100},
+	"EB": {"pre": 30, "post": 90, "excludeFromOverview": true},
+	"AB(L)": {"pre": 1, "post": 2}
+}`
+
+func TestBuildCatalog(t *testing.T) {
+	meta := roiMeta{
+		Dataset:      "hemibrain",
+		LastEdit:     "2024-01-01",
+		Hierarchy:    testHierarchy,
+		ROIInfo:      testROIInfo,
+		PrimaryROIs:  []string{"CA(R)", "PED(R)", "EB"},
+		TotalPre:     131,
+		TotalPost:    492,
+		NeuronCounts: map[string]int64{"MB(R)": 7, "CA(R)": 5, "EB": 2},
+		MeshAvailable: func(roi string) bool {
+			return roi == "MB(R)" || roi == "EB"
+		},
+	}
+	catalog, err := buildCatalog(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := catalog.Hierarchy
+	if root.Name != "hemibrain" || root.Pre != 131 || root.Post != 492 || len(root.Children) != 2 {
+		t.Fatalf("unexpected root: %+v", root)
+	}
+	mb := root.Children[0]
+	if mb.Name != "MB(R)" || mb.Pre != 100 || mb.Post != 400 || mb.NumNeurons != 7 || !mb.HasMesh || mb.Primary {
+		t.Errorf("unexpected MB(R): %+v", mb)
+	}
+	ca := mb.Children[0]
+	if ca.Name != "CA(R)" || !ca.Primary || !ca.Overview || ca.NumNeurons != 5 || ca.HasMesh {
+		t.Errorf("unexpected CA(R): %+v", ca)
+	}
+	eb := root.Children[1]
+	if !eb.Primary || eb.Overview {
+		t.Errorf("EB should be primary but excluded from the overview: %+v", eb)
+	}
+	if len(catalog.Unplaced) != 1 || catalog.Unplaced[0].Name != "AB(L)" || catalog.Unplaced[0].Post != 2 {
+		t.Errorf("unexpected unplaced ROIs: %+v", catalog.Unplaced)
+	}
+}
+
+func TestBuildCatalogOverview(t *testing.T) {
+	meta := roiMeta{
+		Dataset:      "hemibrain",
+		Hierarchy:    testHierarchy,
+		ROIInfo:      testROIInfo,
+		PrimaryROIs:  []string{"CA(R)", "PED(R)"},
+		OverviewROIs: []string{"MB(R)"},
+	}
+	catalog, err := buildCatalog(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mb := catalog.Hierarchy.Children[0]
+	if !mb.Overview || mb.Children[0].Overview || mb.HasMesh {
+		t.Errorf("overview ROIs should replace the primary ROIs: %+v", mb)
+	}
+}
+
+func TestBuildCatalogNoHierarchy(t *testing.T) {
+	catalog, err := buildCatalog(roiMeta{Dataset: "mb6", ROIInfo: `{"b": {}, "a": {}}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if catalog.Hierarchy.Name != "mb6" || len(catalog.Hierarchy.Children) != 0 {
+		t.Errorf("unexpected root: %+v", catalog.Hierarchy)
+	}
+	if len(catalog.Unplaced) != 2 || catalog.Unplaced[0].Name != "a" {
+		t.Errorf("unexpected unplaced ROIs: %+v", catalog.Unplaced)
+	}
+
+	if _, err := buildCatalog(roiMeta{Hierarchy: "{"}); err == nil {
+		t.Error("expected error for malformed hierarchy")
+	}
+}
+
+// meshStore lists its meshes and fails any fetch of one
+type meshStore struct {
+	*storage.NoStore
+	t    *testing.T
+	down bool
+}
+
+func (s *meshStore) Get(key []byte) ([]byte, error) {
+	s.t.Errorf("mesh %s fetched", key)
+	return nil, storage.ErrKeyNotFound
+}
+func (s *meshStore) Set(key, value []byte) error { return nil }
+func (s *meshStore) Keys() ([]string, error) {
+	if s.down {
+		return nil, fmt.Errorf("mesh store unavailable")
+	}
+	return []string{"MB(R)", "EB"}, nil
+}
+
+// meshAPIStore finds the mesh store
+type meshAPIStore struct {
+	*storage.NoStore
+	meshes *meshStore
+}
+
+func (s *meshAPIStore) FindStore(storeType, dataset string) (storage.SimpleStore, error) {
+	return s.meshes, nil
+}
+
+func TestMeshLookupListsKeys(t *testing.T) {
+	meshes := &meshStore{NoStore: &storage.NoStore{}, t: t}
+	ca := cypherAPI{&meshAPIStore{&storage.NoStore{}, meshes}}
+	available, err := ca.meshLookup("hemibrain")
+	if err != nil || available == nil || !available("MB(R)") || !available("EB") || available("CA(R)") {
+		t.Errorf("unexpected mesh availability (%v)", err)
+	}
+
+	// an unavailable store is not a store without meshes
+	meshes.down = true
+	if _, err := ca.meshLookup("hemibrain"); err == nil {
+		t.Error("expected a failed listing to be an error")
+	}
+}
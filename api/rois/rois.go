@@ -0,0 +1,236 @@
+package rois
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/api"
+	"github.com/connectome-neuprint/neuPrintHTTP/secure"
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+	"github.com/labstack/echo/v4"
+)
+
+func init() {
+	api.RegisterAPI(PREFIX, setupAPI)
+}
+
+const PREFIX = "/rois"
+
+const (
+	// MetaQuery fetches the ROI description stored on the :Meta node
+	MetaQuery = "MATCH (m :Meta) RETURN m.dataset, m.lastDatabaseEdit, m.roiHierarchy, m.roiInfo, m.primaryRois, m.overviewRois, m.totalPreCount, m.totalPostCount"
+
+	// NeuronCountQuery counts the neurons with synapses in each ROI
+	NeuronCountQuery = "MATCH (n :Neuron) WHERE n.roiInfo IS NOT NULL UNWIND keys(apoc.convert.fromJsonMap(n.roiInfo)) AS roi RETURN roi, count(n)"
+)
+
+type cypherAPI struct {
+	Store storage.Store
+}
+
+// catalogCache holds the ROI catalog for each dataset until it is edited
+var catalogCache = make(map[string]*Catalog)
+var catalogMux sync.Mutex
+
+// buildMux allows only one catalog to be computed at a time
+var buildMux sync.Mutex
+
+// setupAPI sets up the ROI catalog endpoint
+func setupAPI(mainapi *api.ConnectomeAPI) error {
+	q := &cypherAPI{mainapi.Store}
+
+	endPoint := "rois"
+	mainapi.SupportedEndpoints[endPoint] = true
+	mainapi.SetRoute(api.GET, PREFIX+"/:dataset", q.getROIs, api.GuardedRoute)
+	return nil
+}
+
+// Invalidate drops the cached catalog for a dataset, e.g., after a mesh
+// is uploaded without the dataset being edited
+func Invalidate(dataset string) {
+	catalogMux.Lock()
+	delete(catalogCache, dataset)
+	catalogMux.Unlock()
+}
+
+// toStrings converts a list of names returned by the database.  A missing
+// list is returned as nil.
+func toStrings(val interface{}) []string {
+	list, ok := val.([]interface{})
+	if !ok {
+		return nil
+	}
+	names := make([]string, 0, len(list))
+	for _, item := range list {
+		if name, ok := item.(string); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// meshLookup reports whether the roimeshes store has a mesh for an ROI,
+// from a listing of its keys where the store supports it.  Datasets
+// without a mesh store have no meshes; a listing that fails is an error
+// rather than a dataset without meshes.
+func (ca cypherAPI) meshLookup(dataset string) (func(string) bool, error) {
+	store, err := ca.Store.FindStore("roimeshes", dataset)
+	if err != nil || store == nil {
+		return nil, nil
+	}
+	kvstore, ok := store.(storage.KeyValue)
+	if !ok {
+		return nil, nil
+	}
+	if lister, ok := store.(storage.KeyLister); ok {
+		keys, err := lister.Keys()
+		if err != nil {
+			return nil, err
+		}
+		meshes := make(map[string]bool, len(keys))
+		for _, key := range keys {
+			meshes[key] = true
+		}
+		return func(roi string) bool { return meshes[roi] }, nil
+	}
+	return func(roi string) bool {
+		res, err := kvstore.Get([]byte(roi))
+		return err == nil && len(res) > 0
+	}, nil
+}
+
+// ROICatalog returns the ROI catalog for a dataset, recomputing it when the
+// dataset has been edited
+func (ca cypherAPI) ROICatalog(dataset string) (*Catalog, error) {
+	cypher := ca.Store.GetMain(dataset)
+	edit, err := storage.LastEdit(cypher)
+	if err != nil {
+		return nil, err
+	}
+
+	catalogMux.Lock()
+	cached, ok := catalogCache[dataset]
+	catalogMux.Unlock()
+	if ok && cached.LastEdit == edit {
+		return cached, nil
+	}
+
+	buildMux.Lock()
+	defer buildMux.Unlock()
+
+	res, err := cypher.CypherRequest(MetaQuery, true)
+	if err != nil {
+		return nil, err
+	}
+	meta := roiMeta{Dataset: dataset, NeuronCounts: make(map[string]int64)}
+	if len(res.Data) > 0 && len(res.Data[0]) == 8 {
+		row := res.Data[0]
+		meta.LastEdit, _ = row[1].(string)
+		meta.Hierarchy, _ = row[2].(string)
+		meta.ROIInfo, _ = row[3].(string)
+		meta.PrimaryROIs = toStrings(row[4])
+		meta.OverviewROIs = toStrings(row[5])
+		meta.TotalPre, _ = storage.ToInt64(row[6])
+		meta.TotalPost, _ = storage.ToInt64(row[7])
+	}
+
+	counts, err := cypher.CypherRequest(NeuronCountQuery, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range counts.Data {
+		if roi, ok := row[0].(string); ok {
+			meta.NeuronCounts[roi], _ = storage.ToInt64(row[1])
+		}
+	}
+	if meta.MeshAvailable, err = ca.meshLookup(dataset); err != nil {
+		return nil, err
+	}
+
+	catalog, err := buildCatalog(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	catalogMux.Lock()
+	catalogCache[dataset] = catalog
+	catalogMux.Unlock()
+	return catalog, nil
+}
+
+// getROIs returns the ROI hierarchy for a dataset
+func (ca cypherAPI) getROIs(c echo.Context) error {
+	// swagger:operation GET /api/rois/{dataset} rois getROIs
+	//
+	// Get the ROI hierarchy for a dataset
+	//
+	// Each ROI lists its synapse totals, whether it is a primary or overview
+	// ROI, the number of neurons with synapses in it and whether a mesh is
+	// available from /api/roimeshes.  ROIs that are not in the stored
+	// hierarchy are listed separately.  Results are cached until the dataset
+	// is edited.
+	//
+	// ---
+	// parameters:
+	// - in: "path"
+	//   name: "dataset"
+	//   schema:
+	//     type: "string"
+	//   required: true
+	//   description: "dataset name"
+	// responses:
+	//   200:
+	//     description: "successful operation"
+	//     schema:
+	//       type: "object"
+	//       properties:
+	//         dataset:
+	//           type: "string"
+	//         lastDatabaseEdit:
+	//           type: "string"
+	//         hierarchy:
+	//           type: "object"
+	//           description: "root of the ROI tree"
+	//           properties:
+	//             name:
+	//               type: "string"
+	//             pre:
+	//               type: "integer"
+	//             post:
+	//               type: "integer"
+	//             primary:
+	//               type: "boolean"
+	//             overview:
+	//               type: "boolean"
+	//             numNeurons:
+	//               type: "integer"
+	//             hasMesh:
+	//               type: "boolean"
+	//             children:
+	//               type: "array"
+	//               items:
+	//                 type: "object"
+	//         unplaced:
+	//           type: "array"
+	//           items:
+	//             type: "object"
+	//           description: "ROIs missing from the hierarchy"
+	// security:
+	// - Bearer: []
+
+	dataset := c.Param("dataset")
+	if dataset == "" {
+		errJSON := api.ErrorInfo{Error: "parameters not properly provided in uri"}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	if err := secure.RequireDatasetAccess(c, dataset, secure.READ); err != nil {
+		return err
+	}
+
+	res, err := ca.ROICatalog(dataset)
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	return c.JSON(http.StatusOK, res)
+}
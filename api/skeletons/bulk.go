@@ -0,0 +1,320 @@
+package skeletons
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/connectome-neuprint/neuPrintHTTP/api"
+	"github.com/connectome-neuprint/neuPrintHTTP/secure"
+	"github.com/connectome-neuprint/neuPrintHTTP/skeleton"
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// MaxBulkSkeletons bounds the number of body ids in a bulk request
+	MaxBulkSkeletons = 20000
+
+	// BulkWorkers is the number of concurrent key value fetches
+	BulkWorkers = 8
+)
+
+// MaxBulkBytes caps the total SWC size of a bulk download (set from the
+// bulk-skeleton-bytes configuration)
+var MaxBulkBytes int64 = 512 << 20
+
+type BulkParams struct {
+	BodyIds []int64 `json:"bodyIds"`
+	Format  string  `json:"format,omitempty"`
+}
+
+// BulkManifest lists the skeletons included in a bulk download.  Invalid
+// bodies have a stored skeleton that could not be parsed (arrow only).
+type BulkManifest struct {
+	Dataset string  `json:"dataset"`
+	Found   []int64 `json:"found"`
+	Missing []int64 `json:"missing"`
+	Invalid []int64 `json:"invalid,omitempty"`
+}
+
+// errBulkTooLarge is returned when the skeletons exceed MaxBulkBytes
+var errBulkTooLarge = fmt.Errorf("requested skeletons exceed the bulk download size limit")
+
+// fetchSkeletons fetches the SWC for each body concurrently, leaving out
+// bodies without a skeleton.  The fetch stops once the total size exceeds
+// maxBytes or a fetch fails for another reason than a missing key.
+func fetchSkeletons(kvstore storage.KeyValue, bodyIds []int64, maxBytes int64) (map[int64][]byte, error) {
+	found := make(map[int64][]byte, len(bodyIds))
+	var total int64
+	var fetchErr error
+	var mux sync.Mutex
+
+	jobs := make(chan int64)
+	var wg sync.WaitGroup
+	for i := 0; i < BulkWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for bodyId := range jobs {
+				res, err := kvstore.Get([]byte(strconv.FormatInt(bodyId, 10) + "_swc"))
+				if err != nil && err != storage.ErrKeyNotFound {
+					mux.Lock()
+					if fetchErr == nil {
+						fetchErr = fmt.Errorf("could not fetch skeleton for body %d: %w", bodyId, err)
+					}
+					mux.Unlock()
+					continue
+				}
+				if len(res) == 0 {
+					continue
+				}
+				mux.Lock()
+				found[bodyId] = res
+				total += int64(len(res))
+				mux.Unlock()
+			}
+		}()
+	}
+
+	seen := make(map[int64]bool, len(bodyIds))
+	for _, bodyId := range bodyIds {
+		mux.Lock()
+		stop := total > maxBytes || fetchErr != nil
+		mux.Unlock()
+		if stop {
+			break
+		}
+		if !seen[bodyId] {
+			seen[bodyId] = true
+			jobs <- bodyId
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	if total > maxBytes {
+		return nil, errBulkTooLarge
+	}
+	return found, nil
+}
+
+// newManifest lists the found and missing bodies in request order
+func newManifest(dataset string, bodyIds []int64, found map[int64][]byte) *BulkManifest {
+	manifest := &BulkManifest{Dataset: dataset, Found: []int64{}, Missing: []int64{}}
+	seen := make(map[int64]bool, len(bodyIds))
+	for _, bodyId := range bodyIds {
+		if seen[bodyId] {
+			continue
+		}
+		seen[bodyId] = true
+		if _, ok := found[bodyId]; ok {
+			manifest.Found = append(manifest.Found, bodyId)
+		} else {
+			manifest.Missing = append(manifest.Missing, bodyId)
+		}
+	}
+	return manifest
+}
+
+// writeZip writes one SWC per body followed by manifest.json
+func writeZip(w io.Writer, manifest *BulkManifest, found map[int64][]byte) error {
+	archive := zip.NewWriter(w)
+	for _, bodyId := range manifest.Found {
+		entry, err := archive.Create(strconv.FormatInt(bodyId, 10) + ".swc")
+		if err != nil {
+			return err
+		}
+		if _, err := entry.Write(found[bodyId]); err != nil {
+			return err
+		}
+	}
+	entry, err := archive.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(entry).Encode(manifest); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// writeTar writes one SWC per body followed by manifest.json
+func writeTar(w io.Writer, manifest *BulkManifest, found map[int64][]byte) error {
+	archive := tar.NewWriter(w)
+	now := time.Now()
+	add := func(name string, data []byte) error {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: now}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := archive.Write(data)
+		return err
+	}
+	for _, bodyId := range manifest.Found {
+		if err := add(strconv.FormatInt(bodyId, 10)+".swc", found[bodyId]); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := add("manifest.json", data); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// bulkArrowRecord parses the skeletons into one table.  The manifest is
+// kept in the schema metadata.
+func bulkArrowRecord(manifest *BulkManifest, found map[int64][]byte) (arrow.Record, error) {
+	var bodyIds []int64
+	var skels []*skeleton.Skeleton
+	for _, bodyId := range manifest.Found {
+		skel, err := skeleton.ParseStoredSWC(found[bodyId])
+		if err != nil {
+			manifest.Invalid = append(manifest.Invalid, bodyId)
+			continue
+		}
+		bodyIds = append(bodyIds, bodyId)
+		skels = append(skels, skel)
+	}
+	manifest.Found = bodyIds
+	if manifest.Found == nil {
+		manifest.Found = []int64{}
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	metadata := arrow.NewMetadata([]string{"manifest"}, []string{string(data)})
+	return skeleton.ArrowTable(memory.DefaultAllocator, bodyIds, skels, metadata), nil
+}
+
+// getBulkSkeletons fetches the skeletons for a list of bodies
+func (ma masterAPI) getBulkSkeletons(c echo.Context) error {
+	// swagger:operation POST /api/skeletons/bulk/{dataset} skeletons getBulkSkeletons
+	//
+	// Get skeletons for a list of body ids
+	//
+	// Returns a zip or tar archive with one SWC per body and a manifest.json
+	// listing the bodies found and missing, or a single Arrow table of
+	// skeleton nodes with a bodyId column and the manifest in the schema
+	// metadata.  Requests whose skeletons exceed the configured size limit
+	// fail with 413.
+	//
+	// ---
+	// parameters:
+	// - in: "path"
+	//   name: "dataset"
+	//   schema:
+	//     type: "string"
+	//   required: true
+	//   description: "dataset name"
+	// - in: "body"
+	//   name: "body"
+	//   required: true
+	//   schema:
+	//     type: "object"
+	//     required: ["bodyIds"]
+	//     properties:
+	//       bodyIds:
+	//         type: "array"
+	//         items:
+	//           type: "integer"
+	//         example: [5813105172, 1100404581]
+	//       format:
+	//         type: "string"
+	//         enum: ["zip", "tar", "arrow"]
+	//         description: "response format (default zip)"
+	// produces:
+	// - application/zip
+	// - application/x-tar
+	// - application/vnd.apache.arrow.stream
+	// responses:
+	//   200:
+	//     description: "archive of SWC files or Arrow table"
+	//   413:
+	//     description: "skeletons exceed the size limit"
+	// security:
+	// - Bearer: []
+
+	dataset := c.Param("dataset")
+	if dataset == "" {
+		errJSON := api.ErrorInfo{Error: "parameters not properly provided in uri"}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	if err := secure.RequireDatasetAccess(c, dataset, secure.READ); err != nil {
+		return err
+	}
+
+	var params BulkParams
+	if err := c.Bind(&params); err != nil {
+		errJSON := api.ErrorInfo{Error: "request object not formatted correctly"}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	if len(params.BodyIds) == 0 || len(params.BodyIds) > MaxBulkSkeletons {
+		errJSON := api.ErrorInfo{Error: fmt.Sprintf("between 1 and %d body ids must be given", MaxBulkSkeletons)}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	switch params.Format {
+	case "":
+		params.Format = "zip"
+	case "zip", "tar", "arrow":
+	default:
+		errJSON := api.ErrorInfo{Error: "format must be zip, tar or arrow"}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+
+	kvstore, err := ma.skeletonStore(dataset)
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	found, err := fetchSkeletons(kvstore, params.BodyIds, MaxBulkBytes)
+	if err == errBulkTooLarge {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusRequestEntityTooLarge, errJSON)
+	} else if err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusInternalServerError, errJSON)
+	}
+	manifest := newManifest(dataset, params.BodyIds, found)
+
+	resp := c.Response()
+	switch params.Format {
+	case "arrow":
+		record, err := bulkArrowRecord(manifest, found)
+		if err != nil {
+			return err
+		}
+		defer record.Release()
+		resp.Header().Set(echo.HeaderContentType, "application/vnd.apache.arrow.stream")
+		resp.WriteHeader(http.StatusOK)
+		writer := ipc.NewWriter(resp.Writer, ipc.WithSchema(record.Schema()))
+		defer writer.Close()
+		return writer.Write(record)
+	case "tar":
+		resp.Header().Set(echo.HeaderContentType, "application/x-tar")
+		resp.Header().Set(echo.HeaderContentDisposition, "attachment; filename=\"skeletons.tar\"")
+		resp.WriteHeader(http.StatusOK)
+		return writeTar(resp.Writer, manifest, found)
+	}
+	resp.Header().Set(echo.HeaderContentType, "application/zip")
+	resp.Header().Set(echo.HeaderContentDisposition, "attachment; filename=\"skeletons.zip\"")
+	resp.WriteHeader(http.StatusOK)
+	return writeZip(resp.Writer, manifest, found)
+}
@@ -0,0 +1,149 @@
+package skeletons
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+)
+
+// memoryKV is an in-memory key value store
+type memoryKV struct {
+	mux    sync.Mutex
+	values map[string][]byte
+	gets   int
+	fail   string
+}
+
+func (kv *memoryKV) Get(key []byte) ([]byte, error) {
+	kv.mux.Lock()
+	defer kv.mux.Unlock()
+	kv.gets++
+	if string(key) == kv.fail {
+		return nil, fmt.Errorf("store unavailable")
+	}
+	val, ok := kv.values[string(key)]
+	if !ok {
+		return nil, storage.ErrKeyNotFound
+	}
+	return val, nil
+}
+
+func (kv *memoryKV) Set(key, val []byte) error {
+	kv.mux.Lock()
+	defer kv.mux.Unlock()
+	kv.values[string(key)] = val
+	return nil
+}
+
+func testKV() *memoryKV {
+	return &memoryKV{values: map[string][]byte{
+		"10_swc": []byte("# units: nm\n1 1 0 0 0 1 -1\n2 3 1 0 0 1 1\n"),
+		"20_swc": []byte("1 1 5 5 5 2 -1\n"),
+		"30_swc": []byte("not an swc\n"),
+	}}
+}
+
+func TestFetchSkeletons(t *testing.T) {
+	kv := testKV()
+	found, err := fetchSkeletons(kv, []int64{20, 10, 99, 10}, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 || kv.gets != 3 {
+		t.Errorf("expected 2 skeletons from 3 fetches, got %d from %d", len(found), kv.gets)
+	}
+
+	manifest := newManifest("test", []int64{20, 10, 99, 10}, found)
+	if !reflect.DeepEqual(manifest.Found, []int64{20, 10}) || !reflect.DeepEqual(manifest.Missing, []int64{99}) {
+		t.Errorf("unexpected manifest: %+v", manifest)
+	}
+
+	if _, err := fetchSkeletons(testKV(), []int64{10, 20}, 20); err != errBulkTooLarge {
+		t.Errorf("expected size limit error, got %v", err)
+	}
+
+	// a failed fetch is an error rather than a missing skeleton
+	failing := testKV()
+	failing.fail = "20_swc"
+	if _, err := fetchSkeletons(failing, []int64{10, 20, 99}, 1000); err == nil || err == errBulkTooLarge {
+		t.Errorf("expected fetch error, got %v", err)
+	}
+}
+
+func TestBulkArchives(t *testing.T) {
+	found, _ := fetchSkeletons(testKV(), []int64{10, 20, 99}, 1000)
+	manifest := newManifest("test", []int64{10, 20, 99}, found)
+
+	var zipped bytes.Buffer
+	if err := writeZip(&zipped, manifest, found); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := zip.NewReader(bytes.NewReader(zipped.Bytes()), int64(zipped.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"10.swc", "20.swc", "manifest.json"}) {
+		t.Errorf("unexpected zip entries: %v", names)
+	}
+	entry, _ := reader.File[2].Open()
+	var zipManifest BulkManifest
+	if err := json.NewDecoder(entry).Decode(&zipManifest); err != nil || !reflect.DeepEqual(zipManifest.Missing, []int64{99}) {
+		t.Errorf("unexpected zip manifest: %+v (%v)", zipManifest, err)
+	}
+
+	var tarred bytes.Buffer
+	if err := writeTar(&tarred, manifest, found); err != nil {
+		t.Fatal(err)
+	}
+	archive := tar.NewReader(&tarred)
+	header, err := archive.Next()
+	if err != nil || header.Name != "10.swc" {
+		t.Fatalf("unexpected tar entry: %v %v", header, err)
+	}
+	data, _ := io.ReadAll(archive)
+	if string(data) != string(found[10]) {
+		t.Errorf("unexpected tar contents: %q", data)
+	}
+}
+
+func TestBulkArrowRecord(t *testing.T) {
+	found, _ := fetchSkeletons(testKV(), []int64{10, 20, 30, 99}, 1000)
+	manifest := newManifest("test", []int64{10, 20, 30, 99}, found)
+	record, err := bulkArrowRecord(manifest, found)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer record.Release()
+
+	if record.NumRows() != 3 || record.ColumnName(0) != "bodyId" {
+		t.Fatalf("unexpected table: %v", record.Schema())
+	}
+	if got := record.Column(0).(*array.Int64).Int64Values(); !reflect.DeepEqual(got, []int64{10, 10, 20}) {
+		t.Errorf("unexpected body ids: %v", got)
+	}
+	if !reflect.DeepEqual(manifest.Invalid, []int64{30}) || !reflect.DeepEqual(manifest.Found, []int64{10, 20}) {
+		t.Errorf("unexpected manifest: %+v", manifest)
+	}
+	idx := record.Schema().Metadata().FindKey("manifest")
+	if idx < 0 {
+		t.Fatal("manifest missing from schema metadata")
+	}
+	var stored BulkManifest
+	json.Unmarshal([]byte(record.Schema().Metadata().Values()[idx]), &stored)
+	if !reflect.DeepEqual(stored.Missing, []int64{99}) {
+		t.Errorf("unexpected stored manifest: %+v", stored)
+	}
+}
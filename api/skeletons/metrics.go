@@ -0,0 +1,406 @@
+package skeletons
+
+import (
+	"container/list"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/api"
+	"github.com/connectome-neuprint/neuPrintHTTP/api/roimeshes"
+	"github.com/connectome-neuprint/neuPrintHTTP/secure"
+	"github.com/connectome-neuprint/neuPrintHTTP/skeleton"
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+	"github.com/labstack/echo/v4"
+)
+
+// PrimaryROIQuery fetches the primary ROIs used for per-ROI cable length
+const PrimaryROIQuery = "MATCH (m :Meta) RETURN m.primaryRois"
+
+// roiRegions are the primary ROI meshes of a dataset
+type roiRegions struct {
+	set     *roimeshes.MeshSet
+	names   []string
+	regions []skeleton.Region
+}
+
+// MaxCachedMetrics bounds the number of bodies whose metrics are kept; the
+// least recently used are dropped first
+const MaxCachedMetrics = 50000
+
+// metricsKey identifies the metrics of a body in a dataset
+type metricsKey struct {
+	dataset string
+	bodyId  int64
+}
+
+// metricsEntry holds the metrics of a body computed with the ROI meshes in
+// regions, and the epoch of the last write of its skeleton
+type metricsEntry struct {
+	key         metricsKey
+	metrics     *skeleton.Metrics
+	regions     *roiRegions
+	invalidated uint64
+}
+
+// metricsCache holds the metrics for each dataset and body until the
+// skeleton or the ROI meshes change, metricsOrder the entries most recently
+// used first.  metricsEpoch counts skeleton writes, so metrics computed from
+// a replaced skeleton are not stored; metricsDropped is the latest write
+// among the dropped entries.
+var metricsCache = make(map[metricsKey]*list.Element)
+var metricsOrder = list.New()
+var metricsEpoch, metricsDropped uint64
+var regionCache = make(map[string]*roiRegions)
+var metricsMux sync.Mutex
+
+// invalidateMetrics drops the cached metrics for a body
+func invalidateMetrics(dataset string, bodyId int64) {
+	metricsMux.Lock()
+	defer metricsMux.Unlock()
+	metricsEpoch++
+	entry := metricsEntryFor(metricsKey{dataset, bodyId})
+	entry.metrics, entry.regions, entry.invalidated = nil, nil, metricsEpoch
+}
+
+// cachedMetrics returns the cached metrics for a body or nil, and the epoch
+// to store computed metrics with
+func cachedMetrics(dataset string, bodyId int64) (*skeleton.Metrics, uint64) {
+	metricsMux.Lock()
+	defer metricsMux.Unlock()
+	elem, ok := metricsCache[metricsKey{dataset, bodyId}]
+	if !ok {
+		return nil, metricsEpoch
+	}
+	entry := elem.Value.(*metricsEntry)
+	if entry.metrics == nil || entry.regions != regionCache[dataset] {
+		return nil, metricsEpoch
+	}
+	metricsOrder.MoveToFront(elem)
+	return entry.metrics, metricsEpoch
+}
+
+// storeMetrics caches the metrics computed at the given epoch with the given
+// regions, unless the skeleton was written or the regions have changed since
+func storeMetrics(dataset string, bodyId int64, epoch uint64, regions *roiRegions, metrics *skeleton.Metrics) {
+	metricsMux.Lock()
+	defer metricsMux.Unlock()
+	if regionCache[dataset] != regions {
+		return
+	}
+	key := metricsKey{dataset, bodyId}
+	if elem, ok := metricsCache[key]; ok {
+		if elem.Value.(*metricsEntry).invalidated > epoch {
+			return
+		}
+	} else if metricsDropped > epoch {
+		// the write may have been dropped with its entry
+		return
+	}
+	entry := metricsEntryFor(key)
+	entry.metrics, entry.regions = metrics, regions
+}
+
+// metricsEntryFor returns the entry for a key as the most recently used,
+// adding it and dropping the least recently used entries if needed.  The
+// caller holds the lock.
+func metricsEntryFor(key metricsKey) *metricsEntry {
+	if elem, ok := metricsCache[key]; ok {
+		metricsOrder.MoveToFront(elem)
+		return elem.Value.(*metricsEntry)
+	}
+	entry := &metricsEntry{key: key}
+	metricsCache[key] = metricsOrder.PushFront(entry)
+	for metricsOrder.Len() > MaxCachedMetrics {
+		oldest := metricsOrder.Back()
+		dropped := oldest.Value.(*metricsEntry)
+		metricsOrder.Remove(oldest)
+		delete(metricsCache, dropped.key)
+		if dropped.invalidated > metricsDropped {
+			metricsDropped = dropped.invalidated
+		}
+	}
+	return entry
+}
+
+// regions returns the primary ROI meshes for a dataset.  Cached metrics are
+// no longer served once the dataset mesh set changes, i.e., when the dataset
+// is edited or a mesh uploaded.  Datasets without ROI meshes return nil.
+func (ma masterAPI) regions(dataset string) (*roiRegions, error) {
+	if store, err := ma.Store.FindStore("roimeshes", dataset); err != nil || store == nil {
+		return nil, nil
+	}
+	set, err := roimeshes.DatasetMeshes(ma.Store, dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	metricsMux.Lock()
+	cached, ok := regionCache[dataset]
+	metricsMux.Unlock()
+	if ok && cached.set == set {
+		return cached, nil
+	}
+
+	res, err := ma.Store.GetMain(dataset).CypherRequest(PrimaryROIQuery, true)
+	if err != nil {
+		return nil, err
+	}
+	loaded := &roiRegions{set: set}
+	if len(res.Data) > 0 && len(res.Data[0]) == 1 {
+		list, _ := res.Data[0][0].([]interface{})
+		for _, item := range list {
+			name, ok := item.(string)
+			if !ok {
+				continue
+			}
+			if roiMesh := set.Mesh(name); roiMesh != nil {
+				loaded.names = append(loaded.names, name)
+				loaded.regions = append(loaded.regions, roiMesh)
+			}
+		}
+	}
+
+	// metrics computed with the old meshes are stale
+	metricsMux.Lock()
+	if cached, ok := regionCache[dataset]; !ok || cached.set != set {
+		regionCache[dataset] = loaded
+	}
+	loaded = regionCache[dataset]
+	metricsMux.Unlock()
+	return loaded, nil
+}
+
+// computeMetrics parses an SWC and computes its metrics
+func computeMetrics(swc []byte, regions *roiRegions) (*skeleton.Metrics, error) {
+	skel, err := skeleton.ParseStoredSWC(swc)
+	if err != nil {
+		return nil, err
+	}
+	metrics := skel.Metrics()
+	if regions != nil && len(regions.regions) > 0 {
+		metrics.ROICable = skel.RegionCable(regions.names, regions.regions)
+	}
+	return metrics, nil
+}
+
+// BulkMetrics are the metrics for a list of bodies.  Invalid bodies have a
+// stored skeleton that could not be parsed.
+type BulkMetrics struct {
+	Dataset string                      `json:"dataset"`
+	Metrics map[int64]*skeleton.Metrics `json:"metrics"`
+	Missing []int64                     `json:"missing"`
+	Invalid []int64                     `json:"invalid,omitempty"`
+}
+
+// bulkMetrics returns the metrics for each body, computing those not cached
+func bulkMetrics(dataset string, kvstore storage.KeyValue, regions *roiRegions, bodyIds []int64) (*BulkMetrics, error) {
+	result := &BulkMetrics{Dataset: dataset, Metrics: make(map[int64]*skeleton.Metrics), Missing: []int64{}}
+	var uncached []int64
+	gens := make(map[int64]uint64)
+	for _, bodyId := range bodyIds {
+		metrics, gen := cachedMetrics(dataset, bodyId)
+		if metrics != nil {
+			result.Metrics[bodyId] = metrics
+		} else {
+			uncached = append(uncached, bodyId)
+			gens[bodyId] = gen
+		}
+	}
+
+	found, err := fetchSkeletons(kvstore, uncached, MaxBulkBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var mux sync.Mutex
+	jobs := make(chan int64)
+	var wg sync.WaitGroup
+	for i := 0; i < BulkWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for bodyId := range jobs {
+				metrics, err := computeMetrics(found[bodyId], regions)
+				mux.Lock()
+				if err != nil {
+					result.Invalid = append(result.Invalid, bodyId)
+				} else {
+					result.Metrics[bodyId] = metrics
+				}
+				mux.Unlock()
+				if err == nil {
+					storeMetrics(dataset, bodyId, gens[bodyId], regions, metrics)
+				}
+			}
+		}()
+	}
+	for bodyId := range found {
+		jobs <- bodyId
+	}
+	close(jobs)
+	wg.Wait()
+
+	seen := make(map[int64]bool, len(uncached))
+	for _, bodyId := range uncached {
+		if _, ok := found[bodyId]; !ok && !seen[bodyId] {
+			result.Missing = append(result.Missing, bodyId)
+		}
+		seen[bodyId] = true
+	}
+	return result, nil
+}
+
+// getMetrics returns the morphology metrics for a skeleton
+func (ma masterAPI) getMetrics(c echo.Context) error {
+	// swagger:operation GET /api/skeletons/metrics/{dataset}/{id} skeletons getMetrics
+	//
+	// Get morphology metrics for the skeleton of a body
+	//
+	// Returns the total cable length, branch point and tip counts, the
+	// number of segments of each Strahler order, the longest path from the
+	// root and the bounding box, in the units of the stored skeleton.  When
+	// meshes are stored for the primary ROIs, the cable length in each ROI is
+	// included.  Results are cached until the skeleton or ROI meshes change.
+	//
+	// ---
+	// parameters:
+	// - in: "path"
+	//   name: "dataset"
+	//   schema:
+	//     type: "string"
+	//   required: true
+	//   description: "dataset name"
+	// - in: "path"
+	//   name: "id"
+	//   schema:
+	//     type: "string"
+	//   required: true
+	//   description: "body id"
+	// responses:
+	//   200:
+	//     description: "skeleton metrics"
+	// security:
+	// - Bearer: []
+
+	dataset := c.Param("dataset")
+	bodyid := c.Param("id")
+	if dataset == "" || bodyid == "" {
+		errJSON := api.ErrorInfo{Error: "parameters not properly provided in uri"}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	if err := secure.RequireDatasetAccess(c, dataset, secure.READ); err != nil {
+		return err
+	}
+	bodyId, err := strconv.ParseInt(bodyid, 10, 64)
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: "body id should be an integer"}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+
+	kvstore, err := ma.skeletonStore(dataset)
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	regions, err := ma.regions(dataset)
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	metrics, gen := cachedMetrics(dataset, bodyId)
+	if metrics != nil {
+		return c.JSON(http.StatusOK, metrics)
+	}
+
+	res, err := kvstore.Get([]byte(bodyid + "_swc"))
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	metrics, err = computeMetrics(res, regions)
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: "SWC not formatted properly: " + err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	storeMetrics(dataset, bodyId, gen, regions, metrics)
+	return c.JSON(http.StatusOK, metrics)
+}
+
+// getBulkMetrics returns the morphology metrics for a list of skeletons
+func (ma masterAPI) getBulkMetrics(c echo.Context) error {
+	// swagger:operation POST /api/skeletons/metrics/{dataset} skeletons getBulkMetrics
+	//
+	// Get morphology metrics for the skeletons of several bodies
+	//
+	// Returns the metrics of /api/skeletons/metrics/{dataset}/{id} keyed by
+	// body id, along with the bodies without a stored skeleton.
+	//
+	// ---
+	// parameters:
+	// - in: "path"
+	//   name: "dataset"
+	//   schema:
+	//     type: "string"
+	//   required: true
+	//   description: "dataset name"
+	// - in: "body"
+	//   name: "body"
+	//   required: true
+	//   schema:
+	//     type: "object"
+	//     required: ["bodyIds"]
+	//     properties:
+	//       bodyIds:
+	//         type: "array"
+	//         items:
+	//           type: "integer"
+	//         example: [5813105172, 1100404581]
+	// responses:
+	//   200:
+	//     description: "metrics for each body"
+	//   413:
+	//     description: "skeletons exceed the size limit"
+	// security:
+	// - Bearer: []
+
+	dataset := c.Param("dataset")
+	if dataset == "" {
+		errJSON := api.ErrorInfo{Error: "parameters not properly provided in uri"}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	if err := secure.RequireDatasetAccess(c, dataset, secure.READ); err != nil {
+		return err
+	}
+
+	var params BulkParams
+	if err := c.Bind(&params); err != nil {
+		errJSON := api.ErrorInfo{Error: "request object not formatted correctly"}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	if len(params.BodyIds) == 0 || len(params.BodyIds) > MaxBulkSkeletons {
+		errJSON := api.ErrorInfo{Error: fmt.Sprintf("between 1 and %d body ids must be given", MaxBulkSkeletons)}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+
+	kvstore, err := ma.skeletonStore(dataset)
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	regions, err := ma.regions(dataset)
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	result, err := bulkMetrics(dataset, kvstore, regions, params.BodyIds)
+	if err == errBulkTooLarge {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusRequestEntityTooLarge, errJSON)
+	} else if err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusInternalServerError, errJSON)
+	}
+	return c.JSON(http.StatusOK, result)
+}
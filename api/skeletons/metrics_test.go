@@ -0,0 +1,96 @@
+package skeletons
+
+import (
+	"container/list"
+	"reflect"
+	"testing"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/skeleton"
+)
+
+func TestBulkMetrics(t *testing.T) {
+	kv := testKV()
+	result, err := bulkMetrics("test", kv, nil, []int64{10, 20, 30, 99, 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Metrics) != 2 || result.Metrics[10].Nodes != 2 || result.Metrics[10].CableLength != 1 {
+		t.Errorf("unexpected metrics: %+v", result.Metrics)
+	}
+	if !reflect.DeepEqual(result.Missing, []int64{99}) || !reflect.DeepEqual(result.Invalid, []int64{30}) {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	// cached metrics are not fetched again until the skeleton is replaced
+	gets := kv.gets
+	if _, err := bulkMetrics("test", kv, nil, []int64{10, 20}); err != nil || kv.gets != gets {
+		t.Errorf("expected cached metrics, got %d fetches (%v)", kv.gets-gets, err)
+	}
+	invalidateMetrics("test", 10)
+	if metrics, _ := cachedMetrics("test", 10); metrics != nil {
+		t.Error("expected body 10 to be invalidated")
+	}
+	if metrics, _ := cachedMetrics("test", 20); metrics == nil {
+		t.Error("expected body 20 to stay cached")
+	}
+}
+
+func TestStaleMetrics(t *testing.T) {
+	metrics := bodyMetrics(t)
+
+	// metrics computed from a skeleton replaced meanwhile are discarded
+	_, gen := cachedMetrics("stale", 10)
+	invalidateMetrics("stale", 10)
+	storeMetrics("stale", 10, gen, nil, metrics)
+	if cached, _ := cachedMetrics("stale", 10); cached != nil {
+		t.Error("expected metrics of a replaced skeleton to be discarded")
+	}
+	_, gen = cachedMetrics("stale", 10)
+	storeMetrics("stale", 10, gen, &roiRegions{}, metrics)
+	if cached, _ := cachedMetrics("stale", 10); cached != nil {
+		t.Error("expected metrics of replaced regions to be discarded")
+	}
+	storeMetrics("stale", 10, gen, nil, metrics)
+	if cached, _ := cachedMetrics("stale", 10); cached != metrics {
+		t.Error("expected current metrics to be cached")
+	}
+}
+
+func TestMetricsCacheBound(t *testing.T) {
+	metricsCache, metricsOrder = make(map[metricsKey]*list.Element), list.New()
+	metrics := bodyMetrics(t)
+	_, epoch := cachedMetrics("bound", 0)
+	invalidateMetrics("bound", 0)
+	for body := int64(1); body <= MaxCachedMetrics+1; body++ {
+		_, current := cachedMetrics("bound", body)
+		storeMetrics("bound", body, current, nil, metrics)
+	}
+	if len(metricsCache) > MaxCachedMetrics || metricsOrder.Len() != len(metricsCache) {
+		t.Errorf("cache holds %d bodies, the maximum is %d", len(metricsCache), MaxCachedMetrics)
+	}
+	if cached, _ := cachedMetrics("bound", 1); cached != nil {
+		t.Error("expected the least recently used metrics to be dropped")
+	}
+	if cached, _ := cachedMetrics("bound", MaxCachedMetrics); cached != metrics {
+		t.Error("expected recent metrics to be kept")
+	}
+
+	// metrics computed before a write whose entry was dropped are discarded
+	storeMetrics("bound", 0, epoch, nil, metrics)
+	if cached, _ := cachedMetrics("bound", 0); cached != nil {
+		t.Error("expected metrics of a replaced skeleton to be discarded")
+	}
+}
+
+// bodyMetrics computes the metrics of body 10 without caching them
+func bodyMetrics(t *testing.T) *skeleton.Metrics {
+	res, err := testKV().Get([]byte("10_swc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics, err := computeMetrics(res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metrics
+}
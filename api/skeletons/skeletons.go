@@ -2,13 +2,18 @@ package skeletons
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
-	"strings"
+	"time"
 
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
 	"github.com/connectome-neuprint/neuPrintHTTP/api"
+	"github.com/connectome-neuprint/neuPrintHTTP/audit"
 	"github.com/connectome-neuprint/neuPrintHTTP/secure"
+	"github.com/connectome-neuprint/neuPrintHTTP/skeleton"
 	"github.com/connectome-neuprint/neuPrintHTTP/storage"
 	"github.com/labstack/echo/v4"
 )
@@ -19,6 +24,20 @@ func init() {
 
 const PREFIX = "/skeletons"
 
+// MaxRoots is the number of roots allowed in an uploaded skeleton (set from
+// the skeleton-max-roots configuration)
+var MaxRoots = 1
+
+// uploadResp reports the result of validating an uploaded skeleton
+type uploadResp struct {
+	Valid  bool                 `json:"valid"`
+	Error  string               `json:"error,omitempty"`
+	Errors []*skeleton.SWCError `json:"errors,omitempty"`
+	Nodes  int                  `json:"nodes"`
+	Roots  int                  `json:"roots"`
+	SWC    string               `json:"swc,omitempty"`
+}
+
 type masterAPI struct {
 	Store storage.Store
 }
@@ -33,12 +52,32 @@ func setupAPI(mainapi *api.ConnectomeAPI) error {
 
 	mainapi.SetRoute(api.GET, PREFIX+"/"+endPoint+"/:dataset/:id", q.getSkeleton, api.GuardedRoute)
 	mainapi.SetAdminRoute(api.POST, PREFIX+"/"+endPoint+"/:dataset/:id", q.setSkeleton)
+
+	// bulk skeleton endpoint
+	endPoint = "bulk"
+	mainapi.SupportedEndpoints[endPoint] = true
+	mainapi.SetRoute(api.POST, PREFIX+"/"+endPoint+"/:dataset", q.getBulkSkeletons, api.GuardedRoute)
+
+	// skeleton metrics endpoints
+	endPoint = "metrics"
+	mainapi.SupportedEndpoints[endPoint] = true
+	mainapi.SetRoute(api.GET, PREFIX+"/"+endPoint+"/:dataset/:id", q.getMetrics, api.GuardedRoute)
+	mainapi.SetRoute(api.POST, PREFIX+"/"+endPoint+"/:dataset", q.getBulkMetrics, api.GuardedRoute)
 	return nil
 }
 
-type SkeletonResp struct {
-	Columns []string        `json:"columns"`
-	Data    [][]interface{} `json:"data"`
+// skeletonStore returns the key value store holding the skeletons for a
+// dataset
+func (ma masterAPI) skeletonStore(dataset string) (storage.KeyValue, error) {
+	store, err := ma.Store.FindStore("skeletons", dataset)
+	if err != nil {
+		return nil, err
+	}
+	kvstore, ok := store.(storage.KeyValue)
+	if !ok {
+		return nil, fmt.Errorf("database doesn't support keyvalue")
+	}
+	return kvstore, nil
 }
 
 // getSkeleton fetches the skeleton at the given body id
@@ -48,7 +87,15 @@ func (ma masterAPI) getSkeleton(c echo.Context) error {
 	// Get skeleton for given body id
 	//
 	// The skeletons are stored as swc but the default response is a table
-	// of skeleton nodes.
+	// of skeleton nodes (including the SWC node type) along with the SWC
+	// header.  Arrow returns a node table with navis column names and
+	// precomputed returns the neuroglancer precomputed skeleton encoding
+	// with radius and type vertex attributes.
+	//
+	// Derived skeletons can be requested without storing them.  The units
+	// are converted first (using the dataset voxel size when converting
+	// from or to voxels), then fragments are healed, twigs pruned and the
+	// skeleton downsampled, with lengths in the requested units.
 	//
 	// ---
 	// parameters:
@@ -66,10 +113,27 @@ func (ma masterAPI) getSkeleton(c echo.Context) error {
 	//   description: "body id"
 	// - in: "query"
 	//   name: "format"
-	//   description: "specify response format (\"swc\" or nothing)"
+	//   description: "specify response format (\"swc\", \"json\", \"arrow\" or \"precomputed\")"
+	// - in: "query"
+	//   name: "downsample"
+	//   type: "number"
+	//   description: "keep branch points and tips and drop intermediate nodes closer than this path length"
+	// - in: "query"
+	//   name: "prune_twigs"
+	//   type: "number"
+	//   description: "remove terminal branches shorter than this length"
+	// - in: "query"
+	//   name: "heal"
+	//   type: "boolean"
+	//   description: "join disconnected fragments with minimum spanning edges"
+	// - in: "query"
+	//   name: "units"
+	//   type: "string"
+	//   enum: ["voxels", "nm", "um"]
+	//   description: "convert coordinates and radii to these units"
 	// responses:
 	//   200:
-	//     description: "binary swc file if \"format=swc\" specified or JSON"
+	//     description: "binary swc, Arrow or precomputed data if requested or JSON"
 	//     schema:
 	//       type: "object"
 	//       properties:
@@ -87,6 +151,14 @@ func (ma masterAPI) getSkeleton(c echo.Context) error {
 	//               description: "Cell value"
 	//             description: "Table row"
 	//           description: "Table of skeleton nodes"
+	//         header:
+	//           type: "array"
+	//           items:
+	//             type: "string"
+	//           description: "SWC header comments"
+	//         metadata:
+	//           type: "object"
+	//           description: "key/value header entries such as units"
 	// security:
 	// - Bearer: []
 
@@ -107,16 +179,11 @@ func (ma masterAPI) getSkeleton(c echo.Context) error {
 	}
 
 	// get key value store
-	store, err := ma.Store.FindStore("skeletons", dataset)
+	kvstore, err := ma.skeletonStore(dataset)
 	if err != nil {
 		errJSON := api.ErrorInfo{Error: err.Error()}
 		return c.JSON(http.StatusBadRequest, errJSON)
 	}
-	kvstore, ok := store.(storage.KeyValue)
-	if !ok {
-		errJSON := api.ErrorInfo{Error: "database doesn't support keyvalue"}
-		return c.JSON(http.StatusBadRequest, errJSON)
-	}
 
 	// fetch the value
 	keystr := bodyid + "_swc"
@@ -126,48 +193,61 @@ func (ma masterAPI) getSkeleton(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, errJSON)
 	}
 
+	params, err := parseTransform(c.QueryParam)
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+
 	// if swc fommat specified, return binary
-	if c.QueryParam("format") == "swc" {
+	format := c.QueryParam("format")
+	if format == "swc" && params == nil {
 		return c.Blob(http.StatusOK, "text/plain", res)
 
 	}
 
-	// parse and write out json
-	buffer := bytes.NewBuffer(res)
-
-	data := make([][]interface{}, 0)
-	columns := []string{"rowId", "x", "y", "z", "radius", "link"}
-	for {
-		line, err := buffer.ReadString('\n')
-		if err != nil {
-			break
-		}
-
-		entries := strings.Fields(line)
-		if len(entries) == 0 {
-			continue
+	skel, err := skeleton.ParseStoredSWC(res)
+	if err != nil {
+		errJSON := api.ErrorInfo{Error: "SWC not formatted properly: " + err.Error()}
+		return c.JSON(http.StatusBadRequest, errJSON)
+	}
+	if params != nil {
+		voxels := func() ([3]float64, error) {
+			return voxelSize(ma.Store.GetMain(dataset))
 		}
-		// skip comments
-		if entries[0][0] == '#' {
-			continue
+		if err := params.transform(skel, voxels); err != nil {
+			errJSON := api.ErrorInfo{Error: err.Error()}
+			return c.JSON(http.StatusBadRequest, errJSON)
 		}
+	}
 
-		if len(entries) != 7 {
-			errJSON := api.ErrorInfo{Error: "SWC not formatted properly"}
+	switch format {
+	case "arrow":
+		record := skel.ArrowRecord(memory.DefaultAllocator)
+		defer record.Release()
+		c.Response().Header().Set(echo.HeaderContentType, "application/vnd.apache.arrow.stream")
+		c.Response().WriteHeader(http.StatusOK)
+		writer := ipc.NewWriter(c.Response().Writer, ipc.WithSchema(record.Schema()))
+		defer writer.Close()
+		return writer.Write(record)
+	case "precomputed":
+		var buf bytes.Buffer
+		if err := skel.WritePrecomputed(&buf); err != nil {
+			errJSON := api.ErrorInfo{Error: err.Error()}
 			return c.JSON(http.StatusBadRequest, errJSON)
 		}
-
-		rownum, _ := strconv.Atoi(entries[0])
-		xloc, _ := strconv.ParseFloat(entries[2], 64)
-		yloc, _ := strconv.ParseFloat(entries[3], 64)
-		zloc, _ := strconv.ParseFloat(entries[4], 64)
-		radius, _ := strconv.ParseFloat(entries[5], 64)
-		link, _ := strconv.Atoi(entries[6])
-
-		data = append(data, []interface{}{rownum, xloc, yloc, zloc, radius, link})
+		return c.Blob(http.StatusOK, "application/octet-stream", buf.Bytes())
+	case "swc":
+		var buf bytes.Buffer
+		if err := skel.WriteSWC(&buf); err != nil {
+			return err
+		}
+		return c.Blob(http.StatusOK, "text/plain", buf.Bytes())
+	case "", "json":
+		return c.JSON(http.StatusOK, skel.Table())
 	}
-	jsonresp := SkeletonResp{columns, data}
-	return c.JSON(http.StatusOK, jsonresp)
+	errJSON := api.ErrorInfo{Error: "format must be swc, json, arrow or precomputed"}
+	return c.JSON(http.StatusBadRequest, errJSON)
 }
 
 // setSkeleton posts the skeleton at the given body id
@@ -176,7 +256,12 @@ func (ma masterAPI) setSkeleton(c echo.Context) error {
 	//
 	// Post skeleton for the given body id
 	//
-	// The skeletons are stored as swc.
+	// The skeletons are stored as swc.  Uploads are checked for seven
+	// columns per node, unique sample ids, existing parents, the configured
+	// number of roots (default 1), cycles and non-finite values; problems
+	// are reported with their line numbers.  Normalizing renumbers the nodes
+	// depth first from the root and records the uploader and upload time in
+	// the header.
 	//
 	// ---
 	// parameters:
@@ -192,12 +277,36 @@ func (ma masterAPI) setSkeleton(c echo.Context) error {
 	//     type: "string"
 	//   required: true
 	//   description: "body id"
+	// - in: "query"
+	//   name: "normalize"
+	//   type: "boolean"
+	//   description: "renumber the nodes and add provenance headers before storing"
+	// - in: "query"
+	//   name: "dry_run"
+	//   type: "boolean"
+	//   description: "validate (and normalize) without storing"
 	// - in: "body"
 	//   name: "swc"
 	//   description: "skeleton in SWC format"
 	// responses:
 	//   200:
-	//     description: "successful operation"
+	//     description: "successful operation (validation summary for dry runs)"
+	//   400:
+	//     description: "invalid skeleton"
+	//     schema:
+	//       type: "object"
+	//       properties:
+	//         error:
+	//           type: "string"
+	//         errors:
+	//           type: "array"
+	//           items:
+	//             type: "object"
+	//             properties:
+	//               line:
+	//                 type: "integer"
+	//               message:
+	//                 type: "string"
 	// security:
 	// - Bearer: []
 
@@ -212,33 +321,69 @@ func (ma masterAPI) setSkeleton(c echo.Context) error {
 		return err
 	}
 
-	if _, err := strconv.ParseInt(bodyid, 10, 64); err != nil {
+	bodyId, err := strconv.ParseInt(bodyid, 10, 64)
+	if err != nil {
 		errJSON := api.ErrorInfo{Error: "body id should be an integer"}
 		return c.JSON(http.StatusBadRequest, errJSON)
 	}
 
 	// get key value store
-	store, err := ma.Store.FindStore("skeletons", dataset)
+	kvstore, err := ma.skeletonStore(dataset)
 	if err != nil {
 		errJSON := api.ErrorInfo{Error: err.Error()}
 		return c.JSON(http.StatusBadRequest, errJSON)
 	}
-	kvstore, ok := store.(storage.KeyValue)
-	if !ok {
-		errJSON := api.ErrorInfo{Error: "database doesn't support keyvalue"}
-		return c.JSON(http.StatusBadRequest, errJSON)
-	}
-	// post the value
-	keystr := bodyid + "_swc"
 	body, err := io.ReadAll(c.Request().Body)
 	if err != nil {
 		errJSON := api.ErrorInfo{Error: "error reading binary data"}
 		return c.JSON(http.StatusBadRequest, errJSON)
 	}
+
+	// validate the skeleton
+	skel, err := skeleton.ParseSWC(body)
+	if err != nil {
+		resp := uploadResp{Error: err.Error()}
+		if swcErr, ok := err.(*skeleton.SWCError); ok {
+			resp.Errors = []*skeleton.SWCError{swcErr}
+		}
+		return c.JSON(http.StatusBadRequest, resp)
+	}
+	if problems := skel.Validate(MaxRoots); len(problems) > 0 {
+		resp := uploadResp{Error: skeleton.JoinErrors(problems), Errors: problems, Nodes: len(skel.Nodes), Roots: len(skel.Roots())}
+		return c.JSON(http.StatusBadRequest, resp)
+	}
+
+	normalize := c.QueryParam("normalize") == "true"
+	if normalize {
+		var uploader string
+		if identity, ok := c.Get("dsg_identity").(*secure.DSGIdentity); ok {
+			uploader = identity.Email
+		}
+		skel.Renumber()
+		skel.Stamp(uploader, time.Now())
+		var buf bytes.Buffer
+		if err := skel.WriteSWC(&buf); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+
+	if c.QueryParam("dry_run") == "true" {
+		resp := uploadResp{Valid: true, Nodes: len(skel.Nodes), Roots: len(skel.Roots())}
+		if normalize {
+			resp.SWC = string(body)
+		}
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	// post the value
+	keystr := bodyid + "_swc"
+	audit.SetPrevious(c, func() ([]byte, error) { return kvstore.Get([]byte(keystr)) })
 	err = kvstore.Set([]byte(keystr), body)
 	if err != nil {
 		errJSON := api.ErrorInfo{Error: err.Error()}
 		return c.JSON(http.StatusBadRequest, errJSON)
 	}
+	invalidateMetrics(dataset, bodyId)
 	return c.String(http.StatusOK, "")
 }
@@ -0,0 +1,159 @@
+package skeletons
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/skeleton"
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+)
+
+// VoxelQuery fetches the voxel size used to convert skeleton coordinates
+const VoxelQuery = "MATCH (m :Meta) RETURN m.voxelSize, m.voxelUnits"
+
+// unitScale is the size of each unit in nanometers
+var unitScale = map[string]float64{"nm": 1, "um": 1000}
+
+// canonicalUnits maps unit names to voxels, nm or um
+func canonicalUnits(units string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(units)) {
+	case "", "voxel", "voxels":
+		return "voxels", true
+	case "nm", "nanometer", "nanometers":
+		return "nm", true
+	case "um", "µm", "micron", "microns", "micrometer", "micrometers":
+		return "um", true
+	}
+	return "", false
+}
+
+// transformParams are the getSkeleton options for derived skeletons
+type transformParams struct {
+	Downsample float64
+	PruneTwigs float64
+	Heal       bool
+	Units      string
+}
+
+// parseTransform reads the transformation options from the query
+// parameters.  Nil is returned if no transformation is requested.
+func parseTransform(query func(string) string) (*transformParams, error) {
+	params := &transformParams{Heal: query("heal") == "true"}
+	for name, val := range map[string]*float64{"downsample": &params.Downsample, "prune_twigs": &params.PruneTwigs} {
+		if query(name) == "" {
+			continue
+		}
+		num, err := strconv.ParseFloat(query(name), 64)
+		if err != nil || num <= 0 {
+			return nil, fmt.Errorf("%s must be a positive number", name)
+		}
+		*val = num
+	}
+	if query("units") != "" {
+		units, ok := canonicalUnits(query("units"))
+		if !ok {
+			return nil, fmt.Errorf("units must be voxels, nm or um")
+		}
+		params.Units = units
+	}
+	if *params == (transformParams{}) {
+		return nil, nil
+	}
+	return params, nil
+}
+
+// voxelSize returns the voxel size of a dataset in nanometers
+func voxelSize(cypher storage.Cypher) ([3]float64, error) {
+	var size [3]float64
+	res, err := cypher.CypherRequest(VoxelQuery, true)
+	if err != nil {
+		return size, err
+	}
+	if len(res.Data) == 0 || len(res.Data[0]) != 2 {
+		return size, fmt.Errorf("dataset has no voxel size")
+	}
+	list, ok := res.Data[0][0].([]interface{})
+	if !ok || len(list) != 3 {
+		return size, fmt.Errorf("dataset has no voxel size")
+	}
+	scale := 1.0
+	if units, ok := res.Data[0][1].(string); ok {
+		if canonical, ok := canonicalUnits(units); ok && canonical != "voxels" {
+			scale = unitScale[canonical]
+		}
+	}
+	for i, val := range list {
+		switch v := val.(type) {
+		case float64:
+			size[i] = v * scale
+		case int64:
+			size[i] = float64(v) * scale
+		default:
+			return size, fmt.Errorf("dataset voxel size is not numeric")
+		}
+	}
+	return size, nil
+}
+
+// convertUnits scales the skeleton from the units in its header (voxels if
+// none are given) to the requested units.  The voxel size is only fetched
+// when converting to or from voxels.
+func convertUnits(skel *skeleton.Skeleton, units string, voxels func() ([3]float64, error)) error {
+	from, ok := canonicalUnits(skel.Metadata["units"])
+	if !ok {
+		return fmt.Errorf("skeleton units %q cannot be converted", skel.Metadata["units"])
+	}
+	if from == units {
+		return nil
+	}
+
+	// factor to nanometers and then to the target units
+	factor := [3]float64{1, 1, 1}
+	if from == "voxels" || units == "voxels" {
+		size, err := voxels()
+		if err != nil {
+			return err
+		}
+		if from == "voxels" {
+			factor = size
+		} else {
+			factor = [3]float64{1 / size[0], 1 / size[1], 1 / size[2]}
+		}
+	}
+	if from != "voxels" {
+		for i := range factor {
+			factor[i] *= unitScale[from]
+		}
+	}
+	if units != "voxels" {
+		for i := range factor {
+			factor[i] /= unitScale[units]
+		}
+	}
+	// radii use the mean of the axis scales
+	radius := (factor[0] + factor[1] + factor[2]) / 3
+	skel.Scale(factor, radius, units)
+	return nil
+}
+
+// transform applies the requested options: unit conversion first so the
+// downsampling and pruning lengths are in the requested units, then
+// healing, pruning and downsampling
+func (params *transformParams) transform(skel *skeleton.Skeleton, voxels func() ([3]float64, error)) error {
+	if params.Units != "" {
+		if err := convertUnits(skel, params.Units, voxels); err != nil {
+			return err
+		}
+	}
+	if params.Heal {
+		skel.Heal()
+	}
+	if params.PruneTwigs > 0 {
+		skel.PruneTwigs(params.PruneTwigs)
+	}
+	if params.Downsample > 0 {
+		skel.Downsample(params.Downsample)
+	}
+	return nil
+}
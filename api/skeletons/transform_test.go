@@ -0,0 +1,52 @@
+package skeletons
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/skeleton"
+)
+
+func TestParseTransform(t *testing.T) {
+	query := func(values map[string]string) func(string) string {
+		return func(name string) string { return values[name] }
+	}
+	if params, err := parseTransform(query(nil)); params != nil || err != nil {
+		t.Errorf("expected no transformation, got %+v %v", params, err)
+	}
+	params, err := parseTransform(query(map[string]string{"downsample": "2.5", "heal": "true", "units": "µm"}))
+	if err != nil || *params != (transformParams{Downsample: 2.5, Heal: true, Units: "um"}) {
+		t.Errorf("unexpected params: %+v %v", params, err)
+	}
+	for _, values := range []map[string]string{{"prune_twigs": "-1"}, {"downsample": "far"}, {"units": "inches"}} {
+		if _, err := parseTransform(query(values)); err == nil {
+			t.Errorf("expected error for %v", values)
+		}
+	}
+}
+
+func TestConvertUnits(t *testing.T) {
+	voxels := func() ([3]float64, error) { return [3]float64{8, 8, 16}, nil }
+	skel, _ := skeleton.ParseSWC([]byte("1 1 1 2 3 2 -1\n"))
+	if err := convertUnits(skel, "um", voxels); err != nil {
+		t.Fatal(err)
+	}
+	node := skel.Nodes[0]
+	if math.Abs(node.X-0.008) > 1e-12 || math.Abs(node.Z-0.048) > 1e-12 || math.Abs(node.Radius-0.02133333333) > 1e-9 {
+		t.Errorf("unexpected converted node: %+v", node)
+	}
+	if skel.Metadata["units"] != "um" {
+		t.Errorf("units not recorded: %v", skel.Header)
+	}
+
+	// nanometers to micrometers does not need the voxel size
+	nm, _ := skeleton.ParseSWC([]byte("# units: nanometers\n1 1 1000 2000 3000 500 -1\n"))
+	failing := func() ([3]float64, error) { return [3]float64{}, fmt.Errorf("no voxel size") }
+	if err := convertUnits(nm, "um", failing); err != nil || nm.Nodes[0].Y != 2 {
+		t.Errorf("unexpected conversion: %+v %v", nm.Nodes[0], err)
+	}
+	if err := convertUnits(nm, "voxels", failing); err == nil {
+		t.Error("expected error without a voxel size")
+	}
+}
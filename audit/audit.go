@@ -0,0 +1,222 @@
+/*
+   Records data mutations in an append-only log.  Each record holds the
+   hash of the record before it, so editing or removing a record breaks the
+   chain and is detected when the log is opened or queried.
+*/
+
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Record is a logged mutation
+type Record struct {
+	Seq           int64     `json:"seq"`
+	Time          time.Time `json:"time"`
+	User          string    `json:"user"`
+	Dataset       string    `json:"dataset,omitempty"`
+	Operation     string    `json:"operation"`
+	Target        string    `json:"target,omitempty"`
+	PayloadHash   string    `json:"payloadHash"` // of the request body as read by the handler
+	PreviousValue string    `json:"previousValueHash,omitempty"`
+	Status        int       `json:"status"`
+	Outcome       string    `json:"outcome"`
+	Error         string    `json:"error,omitempty"`
+	PrevHash      string    `json:"prevHash"`
+	Hash          string    `json:"hash"`
+}
+
+// Hash returns the hex SHA-256 hash of data
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// chainHash returns the hash of a record, which covers every field but the
+// hash itself
+func (rec Record) chainHash() (string, error) {
+	rec.Hash = ""
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+	return Hash(data), nil
+}
+
+// Log is a hash-chained audit log file
+type Log struct {
+	path string
+
+	mux      sync.Mutex
+	file     *os.File
+	seq      int64
+	lastHash string
+	size     int64 // bytes of complete records written
+	torn     error // set when a failed write could not be undone
+}
+
+// Default is the log mutations are recorded to; nothing is recorded if it
+// is nil
+var Default *Log
+
+// Open opens (or creates) the log at path, verifying its chain.  An
+// incomplete last line, left by a write that failed part way, is dropped.
+func Open(path string) (*Log, error) {
+	log := &Log{path: path}
+	var err error
+	log.seq, log.lastHash, log.size, err = log.scan(-1, func(Record) {})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if log.file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600); err != nil {
+		return nil, err
+	}
+	info, err := log.file.Stat()
+	if err != nil {
+		log.file.Close()
+		return nil, err
+	}
+	if info.Size() > log.size {
+		fmt.Printf("Dropping incomplete last line of audit log %s (%d bytes)\n", path, info.Size()-log.size)
+		if err := log.file.Truncate(log.size); err != nil {
+			log.file.Close()
+			return nil, err
+		}
+	}
+	return log, nil
+}
+
+// Close closes the log file
+func (log *Log) Close() error {
+	log.mux.Lock()
+	defer log.mux.Unlock()
+	return log.file.Close()
+}
+
+// scan reads and verifies the records in the first size bytes of the log,
+// or all of it if size is negative, and returns the sequence number and
+// hash of the last record and the bytes of complete records.  A last line
+// without a newline is incomplete and skipped.
+func (log *Log) scan(size int64, fn func(Record)) (int64, string, int64, error) {
+	file, err := os.Open(log.path)
+	if err != nil {
+		return 0, "", 0, err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if size >= 0 {
+		reader = io.LimitReader(file, size)
+	}
+	var seq, complete int64
+	lastHash := ""
+	buffered := bufio.NewReaderSize(reader, 64*1024)
+	for line := 1; ; line++ {
+		data, err := buffered.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, "", 0, err
+		}
+		var rec Record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return 0, "", 0, fmt.Errorf("audit log %s line %d is not a record: %v", log.path, line, err)
+		}
+		hash, err := rec.chainHash()
+		if err != nil {
+			return 0, "", 0, err
+		}
+		if rec.PrevHash != lastHash || rec.Hash != hash || rec.Seq != seq+1 {
+			return 0, "", 0, fmt.Errorf("audit log %s is broken at line %d", log.path, line)
+		}
+		seq, lastHash = rec.Seq, rec.Hash
+		complete += int64(len(data))
+		fn(rec)
+	}
+	return seq, lastHash, complete, nil
+}
+
+// Append chains a record to the log and writes it.  A record that fails to
+// be written is cut from the file, so the log always ends with a complete
+// record.
+func (log *Log) Append(rec Record) (Record, error) {
+	log.mux.Lock()
+	defer log.mux.Unlock()
+	if log.torn != nil {
+		return rec, log.torn
+	}
+	rec.Seq = log.seq + 1
+	rec.PrevHash = log.lastHash
+	hash, err := rec.chainHash()
+	if err != nil {
+		return rec, err
+	}
+	rec.Hash = hash
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return rec, err
+	}
+	n, err := log.file.Write(append(data, '\n'))
+	if err == nil {
+		err = log.file.Sync()
+	}
+	if err != nil {
+		if n > 0 {
+			if terr := log.file.Truncate(log.size); terr != nil {
+				log.torn = fmt.Errorf("audit log %s has an incomplete record that could not be removed: %v", log.path, terr)
+			}
+		}
+		return rec, err
+	}
+	log.size += int64(n)
+	log.seq, log.lastHash = rec.Seq, rec.Hash
+	return rec, nil
+}
+
+// Filter selects records; empty fields match all
+type Filter struct {
+	Dataset string
+	User    string
+	From    time.Time
+	To      time.Time
+}
+
+// matches reports whether a record passes the filter
+func (filter Filter) matches(rec Record) bool {
+	return (filter.Dataset == "" || rec.Dataset == filter.Dataset) &&
+		(filter.User == "" || rec.User == filter.User) &&
+		(filter.From.IsZero() || !rec.Time.Before(filter.From)) &&
+		(filter.To.IsZero() || rec.Time.Before(filter.To))
+}
+
+// Query returns the records passing the filter in order, after verifying
+// the chain.  It reads the records written when it was called, without
+// holding up appends.
+func (log *Log) Query(filter Filter) ([]Record, error) {
+	log.mux.Lock()
+	size, lastHash := log.size, log.lastHash
+	log.mux.Unlock()
+
+	records := []Record{}
+	_, hash, _, err := log.scan(size, func(rec Record) {
+		if filter.matches(rec) {
+			records = append(records, rec)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if hash != lastHash {
+		return nil, fmt.Errorf("audit log %s does not end with the last record written", log.path)
+	}
+	return records, nil
+}
@@ -0,0 +1,219 @@
+package audit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/secure"
+	"github.com/labstack/echo/v4"
+)
+
+func TestChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i, user := range []string{"alice", "bob", "alice"} {
+		rec := Record{Time: start.Add(time.Duration(i) * time.Hour), User: user, Dataset: "hemibrain", Operation: "POST /api/test"}
+		if _, err := log.Append(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log.Close()
+
+	// a reopened log continues the chain
+	log, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := log.Append(Record{Time: start.Add(3 * time.Hour), User: "carol", Dataset: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Seq != 4 || rec.PrevHash == "" {
+		t.Errorf("unexpected chained record %+v", rec)
+	}
+
+	records, err := log.Query(Filter{User: "alice"})
+	if err != nil || len(records) != 2 || records[1].Seq != 3 {
+		t.Errorf("unexpected user records %v (%v)", records, err)
+	}
+	records, _ = log.Query(Filter{Dataset: "hemibrain", From: start.Add(time.Hour), To: start.Add(2 * time.Hour)})
+	if len(records) != 1 || records[0].User != "bob" {
+		t.Errorf("unexpected time range records %v", records)
+	}
+	log.Close()
+
+	// editing a record breaks the chain
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, []byte(strings.Replace(string(data), `"user":"bob"`, `"user":"eve"`, 1)), 0600)
+	if _, err := Open(path); err == nil {
+		t.Error("expected edited log to fail verification")
+	}
+
+	// so does removing one
+	lines := strings.SplitAfter(string(data), "\n")
+	os.WriteFile(path, []byte(lines[0]+strings.Join(lines[2:], "")), 0600)
+	if _, err := Open(path); err == nil {
+		t.Error("expected log with a removed record to fail verification")
+	}
+}
+
+func TestIncompleteRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Append(Record{Time: time.Now(), User: "alice", Operation: "POST /api/test"})
+	log.Close()
+
+	// a write that failed part way leaves a partial line
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	file.WriteString(`{"seq":2,"time":"20`)
+	file.Close()
+
+	log, err = Open(path)
+	if err != nil {
+		t.Fatalf("expected the partial record to be dropped, got %v", err)
+	}
+	defer log.Close()
+	if rec, err := log.Append(Record{Time: time.Now(), User: "bob", Operation: "POST /api/test"}); err != nil || rec.Seq != 2 {
+		t.Errorf("unexpected record %+v after a partial one (%v)", rec, err)
+	}
+	if records, err := log.Query(Filter{}); err != nil || len(records) != 2 || records[1].User != "bob" {
+		t.Errorf("unexpected records %v (%v)", records, err)
+	}
+}
+
+func TestQueryDuringAppends(t *testing.T) {
+	log, err := Open(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			log.Append(Record{Time: time.Now(), User: "alice", Operation: "POST /api/test"})
+		}
+	}()
+	last := 0
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		records, err := log.Query(Filter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) < last || (len(records) > 0 && records[len(records)-1].Seq != int64(len(records))) {
+			t.Fatalf("inconsistent snapshot of %d records after %d", len(records), last)
+		}
+		last = len(records)
+	}
+	if records, _ := log.Query(Filter{}); len(records) != 100 {
+		t.Errorf("expected every record, got %d", len(records))
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	log, err := Open(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	Default = log
+	defer func() { Default = nil }()
+
+	handler := Middleware(func(c echo.Context) error {
+		body, _ := io.ReadAll(c.Request().Body)
+		if string(body) != "new value" {
+			t.Errorf("handler got body %q", body)
+		}
+		SetPrevious(c, func() ([]byte, error) { return []byte("old value"), nil })
+		return c.String(http.StatusOK, "")
+	})
+	denied := Middleware(func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusForbidden, "no access")
+	})
+
+	e := echo.New()
+	for _, h := range []echo.HandlerFunc{handler, denied} {
+		req := httptest.NewRequest(http.MethodPost, "/api/skeletons/skeleton/hemibrain/1", strings.NewReader("new value"))
+		c := e.NewContext(req, httptest.NewRecorder())
+		c.SetPath("/api/skeletons/skeleton/:dataset/:id")
+		c.SetParamNames("dataset", "id")
+		c.SetParamValues("hemibrain", "1")
+		c.Set("dsg_identity", &secure.DSGIdentity{Email: "alice@example.org"})
+		h(c)
+	}
+
+	records, err := log.Query(Filter{})
+	if err != nil || len(records) != 2 {
+		t.Fatalf("unexpected records %v (%v)", records, err)
+	}
+	rec := records[0]
+	if rec.User != "alice@example.org" || rec.Dataset != "hemibrain" || rec.Target != "dataset=hemibrain&id=1" ||
+		rec.Operation != "POST /api/skeletons/skeleton/:dataset/:id" || rec.PayloadHash != Hash([]byte("new value")) ||
+		rec.PreviousValue != Hash([]byte("old value")) || rec.Status != http.StatusOK || rec.Outcome != "success" {
+		t.Errorf("unexpected record %+v", rec)
+	}
+	if rec := records[1]; rec.Status != http.StatusForbidden || rec.Outcome != "failure" || rec.Error != "no access" {
+		t.Errorf("unexpected denied record %+v", rec)
+	}
+}
+
+func TestMiddlewareStreamsBody(t *testing.T) {
+	log, err := Open(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	Default = log
+	defer func() { Default = nil }()
+
+	// the handler's own limit applies to the body
+	limited := Middleware(func(c echo.Context) error {
+		if _, err := io.ReadAll(http.MaxBytesReader(c.Response(), c.Request().Body, 1024)); err == nil {
+			t.Error("expected the handler's limit to be reached")
+		}
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "too large")
+	})
+	handler := Middleware(func(c echo.Context) error {
+		io.Copy(io.Discard, c.Request().Body)
+		return c.NoContent(http.StatusOK)
+	})
+
+	body := strings.Repeat(`{"dataset": "hemibrain"}`, 1000)
+	e := echo.New()
+	for _, h := range []echo.HandlerFunc{limited, handler} {
+		req := httptest.NewRequest(http.MethodPost, "/api/custom/custom", strings.NewReader(body))
+		c := e.NewContext(req, httptest.NewRecorder())
+		c.SetPath("/api/custom/custom")
+		h(c)
+	}
+
+	records, err := log.Query(Filter{})
+	if err != nil || len(records) != 2 {
+		t.Fatalf("unexpected records %v (%v)", records, err)
+	}
+	if rec := records[0]; rec.Status != http.StatusRequestEntityTooLarge || rec.PayloadHash == Hash([]byte(body)) {
+		t.Errorf("unexpected limited record %+v", rec)
+	}
+	if rec := records[1]; rec.PayloadHash != Hash([]byte(body)) || rec.Target != body[:MaxTargetBytes] {
+		t.Errorf("expected the streamed body to be hashed and its start kept, got %+v", rec)
+	}
+}
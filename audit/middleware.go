@@ -0,0 +1,146 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/secure"
+	"github.com/labstack/echo/v4"
+)
+
+// MaxTargetBytes bounds the request body recorded as the target of a
+// mutation without path parameters
+const MaxTargetBytes = 4096
+
+// previousKey holds the hash of the value a handler replaces
+const previousKey = "audit_previous"
+
+// SetPrevious records the value a mutation replaces, so its hash is
+// logged.  The value is only fetched when mutations are being logged.
+func SetPrevious(c echo.Context, fetch func() ([]byte, error)) {
+	if Default == nil {
+		return
+	}
+	if value, err := fetch(); err == nil && len(value) > 0 {
+		c.Set(previousKey, Hash(value))
+	}
+}
+
+// Middleware records the requests to a mutating route in the Default log,
+// including requests that are denied or fail
+func Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		log := Default
+		if log == nil || c.Request().Method == http.MethodGet {
+			return next(c)
+		}
+
+		// the body is hashed as the handler streams it, so the handler's
+		// own size limits apply
+		hasher := sha256.New()
+		head := &prefix{}
+		if req := c.Request(); req.Body != nil {
+			req.Body = readCloser{io.TeeReader(req.Body, io.MultiWriter(hasher, head)), req.Body}
+		}
+
+		handlerErr := next(c)
+		body := head.data
+
+		previous, _ := c.Get(previousKey).(string)
+		rec := Record{
+			Time:          time.Now().UTC(),
+			User:          user(c),
+			Dataset:       dataset(c, body),
+			Operation:     c.Request().Method + " " + c.Path(),
+			Target:        target(c, body),
+			PayloadHash:   hex.EncodeToString(hasher.Sum(nil)),
+			PreviousValue: previous,
+			Status:        c.Response().Status,
+			Outcome:       "success",
+		}
+		if handlerErr != nil {
+			rec.Status = http.StatusInternalServerError
+			rec.Error = handlerErr.Error()
+			if httpErr, ok := handlerErr.(*echo.HTTPError); ok {
+				rec.Status = httpErr.Code
+				rec.Error = fmt.Sprint(httpErr.Message)
+			}
+		}
+		if rec.Status >= http.StatusBadRequest {
+			rec.Outcome = "failure"
+		}
+		if _, err := log.Append(rec); err != nil {
+			fmt.Printf("Error writing audit record for %s: %v\n", rec.Operation, err)
+		}
+		return handlerErr
+	}
+}
+
+// prefix keeps the first MaxTargetBytes written to it
+type prefix struct {
+	data []byte
+}
+
+func (p *prefix) Write(data []byte) (int, error) {
+	if keep := MaxTargetBytes - len(p.data); keep > 0 {
+		if keep > len(data) {
+			keep = len(data)
+		}
+		p.data = append(p.data, data[:keep]...)
+	}
+	return len(data), nil
+}
+
+// readCloser reads through a reader and closes the original body
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// user returns the email of the authenticated identity
+func user(c echo.Context) string {
+	if identity, ok := c.Get("dsg_identity").(*secure.DSGIdentity); ok && identity != nil {
+		return identity.Email
+	}
+	email, _ := c.Get("email").(string)
+	return email
+}
+
+// dataset returns the dataset set by the handler, in the path or in a JSON
+// request body of at most MaxTargetBytes
+func dataset(c echo.Context, body []byte) string {
+	if name, ok := c.Get("dataset").(string); ok && name != "" {
+		return name
+	}
+	if name := c.Param("dataset"); name != "" {
+		return name
+	}
+	var req struct {
+		Dataset string `json:"dataset"`
+	}
+	if json.Unmarshal(body, &req) == nil {
+		return req.Dataset
+	}
+	return ""
+}
+
+// target returns the path parameters of the request, or the start of its
+// body if there are none
+func target(c echo.Context, body []byte) string {
+	var params []string
+	for i, name := range c.ParamNames() {
+		if name != "ver" && i < len(c.ParamValues()) {
+			params = append(params, name+"="+c.ParamValues()[i])
+		}
+	}
+	if len(params) > 0 {
+		return strings.Join(params, "&")
+	}
+	return string(body)
+}
@@ -0,0 +1,564 @@
+/*
+   Caches named computations over datasets.  Results are kept until the
+   dataset's :Meta.lastDatabaseEdit changes, optionally persisted across
+   restarts, and computed by a bounded pool of workers.
+*/
+
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+)
+
+// Func computes a result for a dataset.  The key distinguishes results
+// computed with different parameters and is empty for the default result.
+type Func func(dataset, key string) ([]byte, error)
+
+// Computation is a cached computation.  Depends names the computations
+// whose results Compute reads; they are computed first when warming the
+// cache and a new result for one of them drops the results of this one.
+// DefaultKey, if set, gives the key of the result to warm, e.g., one that
+// changes each day.
+type Computation struct {
+	Name       string
+	Depends    []string
+	Compute    Func
+	DefaultKey func() string
+}
+
+// registry holds the computations added with Register
+var registry = make(map[string]*Computation)
+var registryMux sync.Mutex
+
+// Register adds a computation, replacing any computation with the same
+// name
+func Register(comp Computation) {
+	registryMux.Lock()
+	defer registryMux.Unlock()
+	registry[comp.Name] = &comp
+}
+
+// Entry is a computed result
+type Entry struct {
+	LastEdit string        `json:"lastEdit"`
+	Computed time.Time     `json:"computed"`
+	Duration time.Duration `json:"duration"`
+	Value    []byte        `json:"value"`
+}
+
+// call is a computation in progress.  Evicted is set when the results it
+// would replace are evicted while it runs, so its result is not saved.
+type call struct {
+	done    chan struct{}
+	entry   *Entry
+	err     error
+	evicted bool
+}
+
+// Manager computes and caches the registered computations
+type Manager struct {
+	store   storage.Store
+	persist Persister
+	order   []string
+	slots   chan struct{}
+
+	mux      sync.Mutex
+	entries  map[string]*Entry
+	inflight map[string]*call
+	errors   map[string]*ComputeError
+	edits    map[string]string // the last edit seen of each dataset
+
+	// saveMux orders saving results against evicting them, so a result is
+	// never persisted after it was evicted
+	saveMux sync.Mutex
+
+	recordMux sync.Mutex
+	records   map[string][]byte // when not persisted
+}
+
+// EntryInfo describes a stored result
+type EntryInfo struct {
+	Name     string    `json:"name"`
+	Dataset  string    `json:"dataset"`
+	Key      string    `json:"key,omitempty"`
+	LastEdit string    `json:"lastEdit"`
+	Computed time.Time `json:"computed"`
+	Age      float64   `json:"ageSeconds"`
+	Size     int       `json:"size"`
+	Duration float64   `json:"durationSeconds"`
+}
+
+// ComputeError is the last failure of a computation
+type ComputeError struct {
+	Name    string    `json:"name"`
+	Dataset string    `json:"dataset"`
+	Key     string    `json:"key,omitempty"`
+	Error   string    `json:"error"`
+	Time    time.Time `json:"time"`
+}
+
+// NewManager returns a manager for the registered computations.  Results
+// are saved to persist, which may be nil to keep them in memory only, and
+// at most workers computations run at once.
+func NewManager(store storage.Store, persist Persister, workers int) (*Manager, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	order, err := sortComputations()
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{
+		store:    store,
+		persist:  persist,
+		order:    order,
+		slots:    make(chan struct{}, workers),
+		entries:  make(map[string]*Entry),
+		inflight: make(map[string]*call),
+		errors:   make(map[string]*ComputeError),
+		edits:    make(map[string]string),
+		records:  make(map[string][]byte),
+	}, nil
+}
+
+// sortComputations orders the computations so dependencies come first
+func sortComputations() ([]string, error) {
+	registryMux.Lock()
+	defer registryMux.Unlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var order []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		comp, ok := registry[name]
+		if !ok {
+			return fmt.Errorf("unknown cached computation %s", name)
+		}
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("cached computation %s depends on itself", name)
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, dep := range comp.Depends {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		order = append(order, name)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// Computations returns the names of the computations, dependencies first
+func (m *Manager) Computations() []string {
+	return append([]string(nil), m.order...)
+}
+
+// DefaultKey returns the key of the default result of a computation
+func (m *Manager) DefaultKey(name string) string {
+	registryMux.Lock()
+	comp, ok := registry[name]
+	registryMux.Unlock()
+	if !ok || comp.DefaultKey == nil {
+		return ""
+	}
+	return comp.DefaultKey()
+}
+
+// entryKey identifies a result
+func entryKey(name, dataset, key string) string {
+	return name + "\x00" + dataset + "\x00" + key
+}
+
+// LastEdit returns the lastDatabaseEdit stamp for a dataset
+func (m *Manager) LastEdit(dataset string) (string, error) {
+	return storage.LastEdit(m.store.GetMain(dataset))
+}
+
+// dropStale removes the results of a dataset kept in memory for earlier
+// edits, the first time a new edit of the dataset is seen
+func (m *Manager) dropStale(dataset, edit string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.edits[dataset] == edit {
+		return
+	}
+	m.edits[dataset] = edit
+	for id, entry := range m.entries {
+		if _, entryDataset, _, ok := splitKey(id); ok && entryDataset == dataset && entry.LastEdit != edit {
+			delete(m.entries, id)
+		}
+	}
+}
+
+// lookup returns the stored result for the edit from memory or the
+// persisted store
+func (m *Manager) lookup(id, edit string) *Entry {
+	m.mux.Lock()
+	entry, ok := m.entries[id]
+	m.mux.Unlock()
+	if ok && entry.LastEdit != edit {
+		return nil
+	}
+	if ok || m.persist == nil {
+		return entry
+	}
+	data, err := m.persist.Load(id)
+	if err != nil || data == nil {
+		return nil
+	}
+	entry = &Entry{}
+	if err := json.Unmarshal(data, entry); err != nil || entry.LastEdit != edit {
+		return nil
+	}
+	m.mux.Lock()
+	m.entries[id] = entry
+	m.mux.Unlock()
+	return entry
+}
+
+// Get returns the result of a computation for a dataset, computing it if
+// there is no result for the current lastDatabaseEdit
+func (m *Manager) Get(name, dataset, key string) ([]byte, error) {
+	edit, err := m.LastEdit(dataset)
+	if err != nil {
+		return nil, err
+	}
+	m.dropStale(dataset, edit)
+	if entry := m.lookup(entryKey(name, dataset, key), edit); entry != nil {
+		return entry.Value, nil
+	}
+	entry, err := m.compute(name, dataset, key, edit)
+	if err != nil {
+		return nil, err
+	}
+	return entry.Value, nil
+}
+
+// Recompute computes a result even if it is current
+func (m *Manager) Recompute(name, dataset, key string) (*Entry, error) {
+	edit, err := m.LastEdit(dataset)
+	if err != nil {
+		return nil, err
+	}
+	return m.compute(name, dataset, key, edit)
+}
+
+// compute runs a computation in a worker slot.  Concurrent requests for
+// the same result share one computation.
+func (m *Manager) compute(name, dataset, key, edit string) (*Entry, error) {
+	registryMux.Lock()
+	comp, ok := registry[name]
+	registryMux.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown cached computation %s", name)
+	}
+
+	id := entryKey(name, dataset, key)
+	m.mux.Lock()
+	if pending, ok := m.inflight[id]; ok {
+		m.mux.Unlock()
+		<-pending.done
+		return pending.entry, pending.err
+	}
+	pending := &call{done: make(chan struct{})}
+	m.inflight[id] = pending
+	m.mux.Unlock()
+	defer func() {
+		m.mux.Lock()
+		delete(m.inflight, id)
+		if pending.err != nil {
+			m.errors[name+"\x00"+dataset] = &ComputeError{name, dataset, key, pending.err.Error(), time.Now()}
+		} else {
+			delete(m.errors, name+"\x00"+dataset)
+		}
+		m.mux.Unlock()
+		close(pending.done)
+	}()
+
+	value, start, err := m.run(comp, dataset, key)
+	if err == nil {
+		pending.entry = &Entry{LastEdit: edit, Computed: start, Duration: time.Since(start), Value: value}
+		if m.save(id, pending) {
+			m.dropDependents(name, dataset)
+		}
+	}
+	pending.err = err
+	return pending.entry, pending.err
+}
+
+// run computes a result in a worker slot, returning a panic as an error
+func (m *Manager) run(comp *Computation, dataset, key string) (value []byte, start time.Time, err error) {
+	m.slots <- struct{}{}
+	defer func() {
+		<-m.slots
+		if r := recover(); r != nil {
+			err = fmt.Errorf("cached %s panicked: %v", comp.Name, r)
+		}
+	}()
+	start = time.Now()
+	value, err = comp.Compute(dataset, key)
+	return
+}
+
+// save stores the result of a call in memory and the persisted store,
+// unless it was evicted while computing, and reports whether it was saved
+func (m *Manager) save(id string, pending *call) bool {
+	m.saveMux.Lock()
+	defer m.saveMux.Unlock()
+	m.mux.Lock()
+	if pending.evicted {
+		m.mux.Unlock()
+		return false
+	}
+	m.entries[id] = pending.entry
+	m.mux.Unlock()
+	if m.persist == nil {
+		return true
+	}
+	data, err := json.Marshal(pending.entry)
+	if err == nil {
+		err = m.persist.Save(id, data)
+	}
+	if err != nil {
+		fmt.Printf("Error persisting cached %s: %v\n", strings.SplitN(id, "\x00", 2)[0], err)
+	}
+	return true
+}
+
+// Evict drops the results of a computation for a dataset, and of the
+// computations depending on it.  Results being computed are not saved.  An
+// empty dataset evicts every dataset.
+func (m *Manager) Evict(name, dataset string) error {
+	prefix := name + "\x00"
+	if dataset != "" {
+		prefix += dataset + "\x00"
+	}
+	m.saveMux.Lock()
+	m.mux.Lock()
+	for id := range m.entries {
+		if strings.HasPrefix(id, prefix) {
+			delete(m.entries, id)
+		}
+	}
+	for id, pending := range m.inflight {
+		if strings.HasPrefix(id, prefix) {
+			pending.evicted = true
+		}
+	}
+	m.mux.Unlock()
+	var err error
+	if m.persist != nil {
+		err = m.persist.DeletePrefix(prefix)
+	}
+	m.saveMux.Unlock()
+	if err != nil {
+		return err
+	}
+	return m.dropDependents(name, dataset)
+}
+
+// dropDependents evicts the computations that depend on name
+func (m *Manager) dropDependents(name, dataset string) error {
+	registryMux.Lock()
+	var dependents []string
+	for other, comp := range registry {
+		for _, dep := range comp.Depends {
+			if dep == name {
+				dependents = append(dependents, other)
+			}
+		}
+	}
+	registryMux.Unlock()
+	for _, dependent := range dependents {
+		if err := m.Evict(dependent, dataset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Warm computes the default result of each computation for each dataset
+// unless a result for the current lastDatabaseEdit is already stored.
+// Computations run in dependency order.
+func (m *Manager) Warm(datasets []string) {
+	for _, dataset := range datasets {
+		edit, err := m.LastEdit(dataset)
+		if err != nil {
+			fmt.Printf("Error checking dataset %s for cached results: %v\n", dataset, err)
+			continue
+		}
+		m.dropStale(dataset, edit)
+		for _, name := range m.order {
+			key := m.DefaultKey(name)
+			if entry := m.lookup(entryKey(name, dataset, key), edit); entry != nil {
+				continue
+			}
+			if _, err := m.compute(name, dataset, key, edit); err != nil {
+				fmt.Printf("Error caching %s for dataset %s: %v\n", name, dataset, err)
+			} else {
+				fmt.Printf("Cached %s for dataset %s\n", name, dataset)
+			}
+		}
+	}
+}
+
+// recordPrefix starts the keys of records, which unlike results are kept
+// across edits
+const recordPrefix = "\x01record\x00"
+
+// Record keeps the first value recorded for a key of a computation and
+// dataset and returns the value kept, so concurrent or later callers agree
+// on it.  Records survive edits and, when results are persisted, restarts.
+func (m *Manager) Record(name, dataset, key string, value []byte) ([]byte, error) {
+	id := recordPrefix + entryKey(name, dataset, key)
+	m.recordMux.Lock()
+	defer m.recordMux.Unlock()
+	if m.persist == nil {
+		if kept, ok := m.records[id]; ok {
+			return kept, nil
+		}
+		m.records[id] = value
+		return value, nil
+	}
+	kept, err := m.persist.Load(id)
+	if err != nil || kept != nil {
+		return kept, err
+	}
+	return value, m.persist.Save(id, value)
+}
+
+// Recorded returns the value recorded for a key, or nil if there is none
+func (m *Manager) Recorded(name, dataset, key string) ([]byte, error) {
+	id := recordPrefix + entryKey(name, dataset, key)
+	m.recordMux.Lock()
+	defer m.recordMux.Unlock()
+	if m.persist == nil {
+		return m.records[id], nil
+	}
+	return m.persist.Load(id)
+}
+
+// splitKey returns the computation, dataset and key of a result id
+func splitKey(id string) (string, string, string, bool) {
+	parts := strings.SplitN(id, "\x00", 3)
+	if len(parts) != 3 {
+		return "", "", "", false
+	}
+	return parts[0], parts[1], parts[2], true
+}
+
+// Entries describes the stored results, including persisted results not
+// yet loaded, sorted by computation, dataset and key.  An empty name or
+// dataset matches all.
+func (m *Manager) Entries(name, dataset string) ([]EntryInfo, error) {
+	now := time.Now()
+	found := make(map[string]*Entry)
+	if m.persist != nil {
+		err := m.persist.Each(func(id string, data []byte) error {
+			if strings.HasPrefix(id, recordPrefix) {
+				return nil
+			}
+			entry := &Entry{}
+			if json.Unmarshal(data, entry) == nil {
+				found[id] = entry
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	m.mux.Lock()
+	for id, entry := range m.entries {
+		found[id] = entry
+	}
+	m.mux.Unlock()
+
+	infos := []EntryInfo{}
+	for id, entry := range found {
+		entryName, entryDataset, key, ok := splitKey(id)
+		if !ok || (name != "" && name != entryName) || (dataset != "" && dataset != entryDataset) {
+			continue
+		}
+		infos = append(infos, EntryInfo{
+			Name:     entryName,
+			Dataset:  entryDataset,
+			Key:      key,
+			LastEdit: entry.LastEdit,
+			Computed: entry.Computed,
+			Age:      now.Sub(entry.Computed).Seconds(),
+			Size:     len(entry.Value),
+			Duration: entry.Duration.Seconds(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		a, b := infos[i], infos[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Dataset != b.Dataset {
+			return a.Dataset < b.Dataset
+		}
+		return a.Key < b.Key
+	})
+	return infos, nil
+}
+
+// Errors returns the last error of each computation and dataset whose
+// latest attempt failed
+func (m *Manager) Errors() []ComputeError {
+	m.mux.Lock()
+	errs := make([]ComputeError, 0, len(m.errors))
+	for _, computeErr := range m.errors {
+		errs = append(errs, *computeErr)
+	}
+	m.mux.Unlock()
+	sort.Slice(errs, func(i, j int) bool {
+		if errs[i].Name != errs[j].Name {
+			return errs[i].Name < errs[j].Name
+		}
+		return errs[i].Dataset < errs[j].Dataset
+	})
+	return errs
+}
+
+// RecomputeAll recomputes the default result of each computation for a
+// dataset in dependency order, returning the first error
+func (m *Manager) RecomputeAll(dataset string) error {
+	edit, err := m.LastEdit(dataset)
+	if err != nil {
+		return err
+	}
+	for _, name := range m.order {
+		if _, err := m.compute(name, dataset, m.DefaultKey(name), edit); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,359 @@
+package cache
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/connectome-neuprint/neuPrintHTTP/storage"
+)
+
+// editCypher answers the lastDatabaseEdit query
+type editCypher struct {
+	mux  sync.Mutex
+	edit string
+}
+
+func (m *editCypher) setEdit(edit string) {
+	m.mux.Lock()
+	m.edit = edit
+	m.mux.Unlock()
+}
+
+func (m *editCypher) CypherRequest(query string, readonly bool) (storage.CypherResult, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return storage.CypherResult{Columns: []string{"edit"}, Data: [][]interface{}{{m.edit}}}, nil
+}
+func (m *editCypher) CypherRequestWithParams(query string, params map[string]interface{}, readonly bool) (storage.CypherResult, error) {
+	return m.CypherRequest(query, readonly)
+}
+func (m *editCypher) StartTrans() (storage.CypherTransaction, error) { return nil, nil }
+
+// editStore serves the edit stamp for every dataset
+type editStore struct {
+	*storage.NoStore
+	cypher *editCypher
+}
+
+func (s *editStore) GetMain(datasets ...string) storage.Cypher { return s.cypher }
+
+func newEditStore(edit string) *editStore {
+	return &editStore{&storage.NoStore{}, &editCypher{edit: edit}}
+}
+
+// counter is a computation counting its calls
+type counter struct {
+	calls int32
+}
+
+func (c *counter) compute(dataset, key string) ([]byte, error) {
+	n := atomic.AddInt32(&c.calls, 1)
+	return []byte(fmt.Sprintf("%s/%s/%d", dataset, key, n)), nil
+}
+
+func resetRegistry() {
+	registryMux.Lock()
+	registry = make(map[string]*Computation)
+	registryMux.Unlock()
+}
+
+func TestGetInvalidatesOnEdit(t *testing.T) {
+	resetRegistry()
+	count := &counter{}
+	Register(Computation{Name: "count", Compute: count.compute})
+	store := newEditStore("v1")
+	m, err := NewManager(store, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if res, err := m.Get("count", "ds", ""); err != nil || string(res) != "ds//1" {
+			t.Errorf("unexpected result %q (%v)", res, err)
+		}
+	}
+	if res, _ := m.Get("count", "ds", "a"); string(res) != "ds/a/2" {
+		t.Errorf("unexpected keyed result %q", res)
+	}
+	store.cypher.setEdit("v2")
+	if res, _ := m.Get("count", "ds", ""); string(res) != "ds//3" {
+		t.Errorf("expected recompute after edit, got %q", res)
+	}
+	if _, ok := m.entries[entryKey("count", "ds", "a")]; ok || len(m.entries) != 1 {
+		t.Errorf("expected results of the earlier edit to be dropped, have %d", len(m.entries))
+	}
+	if _, err := m.Get("missing", "ds", ""); err == nil {
+		t.Error("expected error for unknown computation")
+	}
+}
+
+func TestConcurrentGetsShareComputation(t *testing.T) {
+	resetRegistry()
+	var calls int32
+	release := make(chan struct{})
+	Register(Computation{Name: "slow", Compute: func(dataset, key string) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []byte("done"), nil
+	}})
+	m, _ := NewManager(newEditStore("v1"), nil, 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if res, err := m.Get("slow", "ds", ""); err != nil || string(res) != "done" {
+				t.Errorf("unexpected result %q (%v)", res, err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("expected one computation, got %d", calls)
+	}
+}
+
+func TestEvictDuringCompute(t *testing.T) {
+	resetRegistry()
+	started, release := make(chan struct{}), make(chan struct{})
+	Register(Computation{Name: "slow", Compute: func(dataset, key string) ([]byte, error) {
+		close(started)
+		<-release
+		return []byte("stale"), nil
+	}})
+	m, _ := NewManager(newEditStore("v1"), nil, 1)
+
+	done := make(chan []byte)
+	go func() {
+		res, _ := m.Get("slow", "ds", "")
+		done <- res
+	}()
+	<-started
+	if err := m.Evict("slow", "ds"); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	if res := <-done; string(res) != "stale" {
+		t.Errorf("expected the caller to get the computed result, got %q", res)
+	}
+	if entry := m.lookup(entryKey("slow", "ds", ""), "v1"); entry != nil {
+		t.Errorf("expected a result evicted while computing not to be saved, got %q", entry.Value)
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	resetRegistry()
+	var order []string
+	var mux sync.Mutex
+	record := func(name string) Func {
+		return func(dataset, key string) ([]byte, error) {
+			mux.Lock()
+			order = append(order, name)
+			mux.Unlock()
+			return []byte(name), nil
+		}
+	}
+	Register(Computation{Name: "a", Depends: []string{"c"}, Compute: record("a")})
+	Register(Computation{Name: "b", Compute: record("b")})
+	Register(Computation{Name: "c", Depends: []string{"b"}, Compute: record("c")})
+	m, err := NewManager(newEditStore("v1"), nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m.Computations(), []string{"b", "c", "a"}) {
+		t.Errorf("unexpected order: %v", m.Computations())
+	}
+
+	m.Warm([]string{"ds"})
+	m.Warm([]string{"ds"})
+	if !reflect.DeepEqual(order, []string{"b", "c", "a"}) {
+		t.Errorf("unexpected computations: %v", order)
+	}
+
+	// a new result for b drops c and a
+	if _, err := m.Recompute("b", "ds", ""); err != nil {
+		t.Fatal(err)
+	}
+	m.Warm([]string{"ds"})
+	if !reflect.DeepEqual(order, []string{"b", "c", "a", "b", "c", "a"}) {
+		t.Errorf("unexpected computations after recompute: %v", order)
+	}
+
+	Register(Computation{Name: "b", Depends: []string{"a"}, Compute: record("b")})
+	if _, err := NewManager(newEditStore("v1"), nil, 1); err == nil {
+		t.Error("expected error for a dependency cycle")
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	resetRegistry()
+	count := &counter{}
+	Register(Computation{Name: "count", Compute: count.compute})
+	dir := t.TempDir()
+
+	persist, err := OpenBadger(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ := NewManager(newEditStore("v1"), persist, 1)
+	m.Get("count", "ds", "")
+	m.Get("count", "other", "")
+	persist.Close()
+
+	// a restarted manager reads the stored result
+	persist, err = OpenBadger(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer persist.Close()
+	m, _ = NewManager(newEditStore("v1"), persist, 1)
+	if res, _ := m.Get("count", "ds", ""); string(res) != "ds//1" || count.calls != 2 {
+		t.Errorf("expected persisted result, got %q after %d calls", res, count.calls)
+	}
+
+	if err := m.Evict("count", "ds"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := persist.Load(entryKey("count", "ds", "")); data != nil {
+		t.Error("evicted result still persisted")
+	}
+	if data, _ := persist.Load(entryKey("count", "other", "")); data == nil {
+		t.Error("other dataset evicted")
+	}
+}
+
+func TestEntriesAndErrors(t *testing.T) {
+	resetRegistry()
+	count := &counter{}
+	fail := true
+	Register(Computation{Name: "count", Compute: count.compute})
+	Register(Computation{Name: "flaky", Depends: []string{"count"}, Compute: func(dataset, key string) ([]byte, error) {
+		if fail {
+			return nil, fmt.Errorf("no data")
+		}
+		return []byte("ok"), nil
+	}})
+	dir := t.TempDir()
+	persist, err := OpenBadger(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer persist.Close()
+	m, _ := NewManager(newEditStore("v1"), persist, 1)
+
+	if err := m.RecomputeAll("ds"); err == nil {
+		t.Error("expected error from failing computation")
+	}
+	errs := m.Errors()
+	if len(errs) != 1 || errs[0].Name != "flaky" || errs[0].Dataset != "ds" || errs[0].Error != "no data" {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	fail = false
+	if err := m.RecomputeAll("ds"); err != nil {
+		t.Fatal(err)
+	}
+	if errs := m.Errors(); len(errs) != 0 {
+		t.Errorf("expected errors to clear, got %v", errs)
+	}
+	m.Get("count", "other", "a")
+
+	// a restarted manager lists the persisted results
+	m, _ = NewManager(newEditStore("v1"), persist, 1)
+	entries, err := m.Entries("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.Name+"/"+entry.Dataset+"/"+entry.Key)
+		if entry.LastEdit != "v1" || entry.Size == 0 || entry.Age < 0 {
+			t.Errorf("unexpected entry %+v", entry)
+		}
+	}
+	if !reflect.DeepEqual(got, []string{"count/ds/", "count/other/a", "flaky/ds/"}) {
+		t.Errorf("unexpected entries: %v", got)
+	}
+	if entries, _ := m.Entries("count", "ds"); len(entries) != 1 {
+		t.Errorf("expected one filtered entry, got %v", entries)
+	}
+}
+
+func TestComputePanic(t *testing.T) {
+	resetRegistry()
+	var calls int32
+	Register(Computation{Name: "panics", Compute: func(dataset, key string) ([]byte, error) {
+		if atomic.AddInt32(&calls, 1) <= 3 {
+			panic("bad data")
+		}
+		return []byte("ok"), nil
+	}})
+	m, err := NewManager(newEditStore("v1"), nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// more panics than worker slots must not leak the slots
+	for i := 0; i < 3; i++ {
+		if _, err := m.Get("panics", "ds", ""); err == nil {
+			t.Fatal("expected a panicking computation to return an error")
+		}
+	}
+	if errs := m.Errors(); len(errs) != 1 {
+		t.Errorf("expected the panic to be recorded, got %+v", errs)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		if res, err := m.Get("panics", "ds", ""); err != nil || string(res) != "ok" {
+			t.Errorf("unexpected result %q (%v)", res, err)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("computation blocked after earlier panics")
+	}
+}
+
+func TestRecords(t *testing.T) {
+	resetRegistry()
+	count := &counter{}
+	Register(Computation{Name: "count", Compute: count.compute})
+
+	persist, err := OpenBadger(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer persist.Close()
+	for _, p := range []Persister{nil, persist} {
+		m, _ := NewManager(newEditStore("v1"), p, 1)
+		if kept, _ := m.Record("count", "ds", "2024-05-01", []byte("a")); string(kept) != "a" {
+			t.Errorf("unexpected first record %q", kept)
+		}
+		if kept, _ := m.Record("count", "ds", "2024-05-01", []byte("b")); string(kept) != "a" {
+			t.Errorf("expected the first record to be kept, got %q", kept)
+		}
+		if value, _ := m.Recorded("count", "ds", "2024-05-02"); value != nil {
+			t.Errorf("unexpected record %q", value)
+		}
+
+		// records are neither results nor dropped with them
+		m.Get("count", "ds", "")
+		if infos, _ := m.Entries("", ""); len(infos) != 1 {
+			t.Errorf("expected records not to be listed as results, got %+v", infos)
+		}
+		m.Evict("count", "ds")
+		if value, _ := m.Recorded("count", "ds", "2024-05-01"); string(value) != "a" {
+			t.Errorf("expected the record to survive eviction, got %q", value)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package cache
+
+import (
+	badgerdb "github.com/dgraph-io/badger/v3"
+)
+
+// Persister saves cached results across restarts
+type Persister interface {
+	// Load returns nil if there is no value for the key
+	Load(key string) ([]byte, error)
+	Save(key string, value []byte) error
+	DeletePrefix(prefix string) error
+	// Each calls fn for each key and value, stopping at the first error
+	Each(fn func(key string, value []byte) error) error
+	Close() error
+}
+
+// badgerPersister keeps cached results in a local badger database
+type badgerPersister struct {
+	db *badgerdb.DB
+}
+
+// OpenBadger opens (or creates) a badger database in dir for cached
+// results
+func OpenBadger(dir string) (Persister, error) {
+	opts := badgerdb.DefaultOptions(dir)
+	opts.Logger = nil
+	db, err := badgerdb.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &badgerPersister{db}, nil
+}
+
+func (p *badgerPersister) Load(key string) ([]byte, error) {
+	var value []byte
+	err := p.db.View(func(txn *badgerdb.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		value, err = item.ValueCopy(nil)
+		return err
+	})
+	if err == badgerdb.ErrKeyNotFound {
+		return nil, nil
+	}
+	return value, err
+}
+
+func (p *badgerPersister) Save(key string, value []byte) error {
+	return p.db.Update(func(txn *badgerdb.Txn) error {
+		return txn.Set([]byte(key), value)
+	})
+}
+
+func (p *badgerPersister) DeletePrefix(prefix string) error {
+	return p.db.DropPrefix([]byte(prefix))
+}
+
+func (p *badgerPersister) Each(fn func(key string, value []byte) error) error {
+	return p.db.View(func(txn *badgerdb.Txn) error {
+		it := txn.NewIterator(badgerdb.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if err := fn(string(item.Key()), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *badgerPersister) Close() error {
+	return p.db.Close()
+}
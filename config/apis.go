@@ -8,5 +8,8 @@ import _ "github.com/connectome-neuprint/neuPrintHTTP/api/roimeshes"
 import _ "github.com/connectome-neuprint/neuPrintHTTP/api/raw/cypher"
 import _ "github.com/connectome-neuprint/neuPrintHTTP/api/raw/keyvalue"
 import _ "github.com/connectome-neuprint/neuPrintHTTP/api/cached"
+import _ "github.com/connectome-neuprint/neuPrintHTTP/api/neurons"
+import _ "github.com/connectome-neuprint/neuPrintHTTP/api/rois"
+import _ "github.com/connectome-neuprint/neuPrintHTTP/api/auditlog"
 
 //import _ "github.com/connectome-neuprint/neuPrintHTTP/api/raw/spatial"
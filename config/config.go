@@ -28,6 +28,52 @@ type Config struct {
 	DSGUrl          string        `json:"dsg-url,omitempty"`                // DatasetGateway base URL
 	DSGCacheTTL     int           `json:"dsg-cache-ttl,omitempty"`          // seconds to cache DSG identity and decisions (default 300)
 	DSGServiceName  string        `json:"dsg-service-name,omitempty"`       // service name for DSG TOS checks (default "neuprint")
+	AuthFile        string        `json:"auth-file,omitempty"`              // JSON or YAML file of users, hashed API tokens and dataset roles, used instead of DSG
+	JWT             *JWTOptions   `json:"jwt,omitempty"`                    // validate OIDC bearer JWTs locally against the issuer's keys, used instead of DSG
+	SkeletonBulkMax int64         `json:"bulk-skeleton-bytes,omitempty"`    // total SWC bytes allowed in a bulk skeleton download (default 512 MiB)
+	SkeletonRoots   int           `json:"skeleton-max-roots,omitempty"`     // roots allowed in an uploaded skeleton (default 1)
+	CacheDir        string        `json:"cache-dir,omitempty"`              // directory persisting cached results across restarts
+	CacheWorkers    int           `json:"cache-workers,omitempty"`          // cached computations run at once (default 2)
+	CacheWarmup     WarmupModes   `json:"cache-warmup,omitempty"`           // cache warm-up mode of each dataset: eager (default), lazy or disabled
+	DailyType       *TypeRules    `json:"dailytype,omitempty"`              // rules for picking the cell type of the day
+	CypherTxTTL     int           `json:"cypher-transaction-ttl,omitempty"` // seconds before an unused raw cypher transaction is rolled back (default 300)
+	CypherTxMax     int           `json:"cypher-transaction-max,omitempty"` // open raw cypher transactions per user (default 5)
+	AuditLog        string        `json:"audit-log,omitempty"`              // hash-chained log file recording admin mutations
+	NeuronEdit      string        `json:"neuron-edit-level,omitempty"`      // access needed to update neuron annotations: readwrite (default) or admin
+	NeuronStatuses  []string      `json:"neuron-statuses,omitempty"`        // statuses neurons can be given (default the standard tracing statuses)
+	DefaultTags     DatasetTags   `json:"default-tags,omitempty"`           // version used for each dataset named without a tag (default from :Meta, else the latest release)
+}
+
+// WarmupModes maps dataset names to cache warm-up modes
+type WarmupModes map[string]string
+
+// DatasetTags maps dataset names to tags
+type DatasetTags map[string]string
+
+// JWTOptions configure the validation of OIDC bearer JWTs.  Claim names may
+// be dotted paths into nested claims.
+type JWTOptions struct {
+	JWKSURL       string            `json:"jwks-url"`       // URL of the issuer's signing keys
+	JWKSFile      string            `json:"jwks-file"`      // or a file holding them
+	Issuer        string            `json:"issuer"`         // required iss claim
+	Audience      string            `json:"audience"`       // required aud claim
+	ClockSkew     int               `json:"clock-skew"`     // seconds allowed between clocks (default 60)
+	Refresh       int               `json:"jwks-refresh"`   // seconds between reads of the keys (default 3600)
+	EmailClaim    string            `json:"email-claim"`    // identity (default email, falling back to sub)
+	NameClaim     string            `json:"name-claim"`     // display name (default name)
+	AdminClaim    string            `json:"admin-claim"`    // true, or a list or string holding admin-value, for global admins
+	AdminValue    string            `json:"admin-value"`    // default admin
+	DatasetsClaim string            `json:"datasets-claim"` // object of roles by dataset, or list of "dataset:role"
+	Public        map[string]string `json:"public"`         // role of anonymous requests by dataset
+}
+
+// TypeRules restrict the neurons whose types can be the cell type of the
+// day.  The defaults are traced or anchor neurons, not cropped, above the
+// 20th percentile of pre or post synapses.
+type TypeRules struct {
+	Statuses      []string `json:"statuses"`       // allowed statuses; any status if empty
+	AllowCropped  bool     `json:"allow-cropped"`  // admit cropped neurons
+	MinPercentile float64  `json:"min-percentile"` // synapse count percentile to exceed; 0 admits all
 }
 
 // LoadConfig parses json configuration and loads options
@@ -19,6 +19,7 @@ require (
 	golang.org/x/crypto v0.45.0
 	google.golang.org/grpc v1.69.2
 	gopkg.in/confluentinc/confluent-kafka-go.v1 v1.8.2
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -34,9 +34,16 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/connectome-neuprint/neuPrintHTTP/api"
+	"github.com/connectome-neuprint/neuPrintHTTP/api/cached"
 	"github.com/connectome-neuprint/neuPrintHTTP/api/custom"
+	"github.com/connectome-neuprint/neuPrintHTTP/api/neurons"
+	"github.com/connectome-neuprint/neuPrintHTTP/api/npexplorer"
+	rawcypher "github.com/connectome-neuprint/neuPrintHTTP/api/raw/cypher"
+	"github.com/connectome-neuprint/neuPrintHTTP/api/skeletons"
+	"github.com/connectome-neuprint/neuPrintHTTP/audit"
 	"github.com/connectome-neuprint/neuPrintHTTP/config"
 	"github.com/connectome-neuprint/neuPrintHTTP/internal/version"
 	"github.com/connectome-neuprint/neuPrintHTTP/logging"
@@ -272,17 +279,51 @@ func main() {
 	e.Pre(middleware.NonWWWRedirect())
 
 	// --- Auth setup ---
-	var dsgClient *secure.DSGClient
+	var authProvider secure.AuthProvider
 	var secureAPI *secure.EchoSecure
 
-	if !options.DisableAuth && options.DSGUrl == "" {
-		fmt.Println("ERROR: dsg-url is required when auth is enabled")
-		return
+	if options.JWT != nil {
+		// bearer JWTs checked against the identity provider's keys
+		jwt := options.JWT
+		clockSkew := 60
+		if jwt.ClockSkew > 0 {
+			clockSkew = jwt.ClockSkew
+		}
+		if authProvider, err = secure.NewJWTProvider(secure.JWTConfig{
+			JWKSURL:       jwt.JWKSURL,
+			JWKSFile:      jwt.JWKSFile,
+			Issuer:        jwt.Issuer,
+			Audience:      jwt.Audience,
+			ClockSkew:     time.Duration(clockSkew) * time.Second,
+			Refresh:       time.Duration(jwt.Refresh) * time.Second,
+			EmailClaim:    jwt.EmailClaim,
+			NameClaim:     jwt.NameClaim,
+			AdminClaim:    jwt.AdminClaim,
+			AdminValue:    jwt.AdminValue,
+			DatasetsClaim: jwt.DatasetsClaim,
+			Public:        jwt.Public,
+		}); err != nil {
+			fmt.Println(err)
+			return
+		}
+		options.DSGUrl = ""
+	} else if options.AuthFile != "" {
+		// local users and roles instead of a DatasetGateway
+		if authProvider, err = secure.NewFileProvider(options.AuthFile); err != nil {
+			fmt.Println(err)
+			return
+		}
+		options.DSGUrl = ""
+	} else {
+		if !options.DisableAuth && options.DSGUrl == "" {
+			fmt.Println("ERROR: dsg-url, auth-file or jwt is required when auth is enabled")
+			return
+		}
+		authProvider = secure.NewDSGClient(options.DSGUrl, options.DSGCacheTTL, options.DSGServiceName)
 	}
-	dsgClient = secure.NewDSGClient(options.DSGUrl, options.DSGCacheTTL, options.DSGServiceName)
 
 	if !options.DisableAuth {
-		secureAPI, err = secure.InitializeEchoSecure(e, options.CertPEM, options.KeyPEM, options.Hostname, options.DSGUrl, dsgClient)
+		secureAPI, err = secure.InitializeEchoSecure(e, options.CertPEM, options.KeyPEM, options.Hostname, options.DSGUrl, authProvider)
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -291,7 +332,8 @@ func main() {
 
 	// create read only group
 	readGrp := e.Group("/api")
-	readGrp.Use(secure.DSGOptionalAuthMiddleware(dsgClient, options.DisableAuth))
+	readGrp.Use(secure.DSGOptionalAuthMiddleware(authProvider, options.DisableAuth))
+	readGrp.Use(api.ResolveDatasets(store))
 
 	adminMiddleware := secure.DSGAdminMiddleware()
 	registerBaseAPIRoutes(readGrp, options)
@@ -338,6 +380,59 @@ func main() {
 		return adminMiddleware(next)
 	}
 
+	// layer templates used to build neuroglancer states
+	npexplorer.LayerDir = options.NgDir
+	if options.SkeletonBulkMax > 0 {
+		skeletons.MaxBulkBytes = options.SkeletonBulkMax
+	}
+	if options.SkeletonRoots > 0 {
+		skeletons.MaxRoots = options.SkeletonRoots
+	}
+	cached.CacheDir = options.CacheDir
+	if options.CacheWorkers > 0 {
+		cached.CacheWorkers = options.CacheWorkers
+	}
+	if options.CacheWarmup != nil {
+		cached.WarmupModes = options.CacheWarmup
+	}
+	if options.CypherTxTTL > 0 {
+		rawcypher.IdleTimeout = time.Duration(options.CypherTxTTL) * time.Second
+	}
+	if options.CypherTxMax > 0 {
+		rawcypher.MaxPerUser = options.CypherTxMax
+	}
+	if rules := options.DailyType; rules != nil {
+		cached.DailyTypeRules = cached.TypeRules{
+			Statuses:      rules.Statuses,
+			AllowCropped:  rules.AllowCropped,
+			MinPercentile: rules.MinPercentile,
+		}
+	}
+
+	switch options.NeuronEdit {
+	case "", "readwrite":
+		neurons.EditLevel = secure.READWRITE
+	case "admin":
+		neurons.EditLevel = secure.ADMIN
+	default:
+		fmt.Printf("neuron-edit-level must be readwrite or admin, not %q\n", options.NeuronEdit)
+		return
+	}
+	if options.DefaultTags != nil {
+		storage.DefaultTags = options.DefaultTags
+	}
+	if len(options.NeuronStatuses) > 0 {
+		neurons.AllowedStatuses = options.NeuronStatuses
+	}
+
+	// record data mutations
+	if options.AuditLog != "" {
+		if audit.Default, err = audit.Open(options.AuditLog); err != nil {
+			fmt.Printf("cannot open audit log: %v\n", err)
+			return
+		}
+	}
+
 	// load connectomic default READ-ONLY API
 	if err = api.SetupRoutes(e, readGrp, store, combinedAdmin); err != nil {
 		fmt.Print(err)
@@ -356,7 +451,7 @@ func main() {
 	if options.DisableAuth {
 		if options.CertPEM != "" && options.KeyPEM != "" {
 			// Create a minimal secure config just for SSL
-			secureAPI, err = secure.InitializeEchoSecure(e, options.CertPEM, options.KeyPEM, options.Hostname, "", dsgClient)
+			secureAPI, err = secure.InitializeEchoSecure(e, options.CertPEM, options.KeyPEM, options.Hostname, "", authProvider)
 			if err != nil {
 				fmt.Println(err)
 				return
@@ -194,12 +194,31 @@ func TestEveryAPIRouteHasDeclaredPolicy(t *testing.T) {
 		"/raw/cypher/transaction/:id/commit",
 		"/raw/cypher/transaction/:id/cypher",
 		"/raw/cypher/transaction/:id/kill",
+		"/raw/cypher/transactions/:id/kill",
+		"/cached/admin/recompute",
+		"/cached/admin/evict",
+		"/neurons/:dataset/import",
 	}
-	wantAdmin := make(map[api.RoutePolicyKey]bool, len(wantAdminPaths)*2)
+	// admin routes with other methods than POST
+	wantAdminOther := []api.RoutePolicyKey{
+		{Method: http.MethodGet, Path: "/cached/admin/entries"},
+		{Method: http.MethodGet, Path: "/cached/admin/errors"},
+		{Method: http.MethodPatch, Path: "/neurons/:dataset/:bodyId"},
+		{Method: http.MethodPatch, Path: "/neurons/:dataset"},
+		{Method: http.MethodPut, Path: "/raw/cypher/transaction/:id"},
+		{Method: http.MethodDelete, Path: "/raw/cypher/transaction/:id"},
+		{Method: http.MethodGet, Path: "/raw/cypher/transactions"},
+		{Method: http.MethodGet, Path: "/audit/records"},
+	}
+	wantAdmin := make(map[api.RoutePolicyKey]bool, (len(wantAdminPaths)+len(wantAdminOther))*2)
 	for _, path := range wantAdminPaths {
 		wantAdmin[api.RoutePolicyKey{Method: http.MethodPost, Path: "/api" + path}] = true
 		wantAdmin[api.RoutePolicyKey{Method: http.MethodPost, Path: "/api/v:ver" + path}] = true
 	}
+	for _, key := range wantAdminOther {
+		wantAdmin[api.RoutePolicyKey{Method: key.Method, Path: "/api" + key.Path}] = true
+		wantAdmin[api.RoutePolicyKey{Method: key.Method, Path: "/api/v:ver" + key.Path}] = true
+	}
 	for key, policy := range policies {
 		if policy == api.AdminRoute && !wantAdmin[key] {
 			t.Errorf("unexpected admin route: %s %s", key.Method, key.Path)
@@ -0,0 +1,86 @@
+package mesh
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// JSONMesh is the JSON form of a mesh
+type JSONMesh struct {
+	Vertices [][3]float64 `json:"vertices"`
+	Faces    [][3]int     `json:"faces"`
+}
+
+// JSON returns the vertices and (zero-based) faces
+func (m *Mesh) JSON() *JSONMesh {
+	resp := &JSONMesh{Vertices: m.Vertices, Faces: m.Faces}
+	if resp.Vertices == nil {
+		resp.Vertices = [][3]float64{}
+	}
+	if resp.Faces == nil {
+		resp.Faces = [][3]int{}
+	}
+	return resp
+}
+
+// WriteOBJ writes the mesh as OBJ vertices and triangles
+func (m *Mesh) WriteOBJ(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	for _, vertex := range m.Vertices {
+		fmt.Fprintf(buf, "v %s %s %s\n",
+			strconv.FormatFloat(vertex[0], 'f', -1, 64),
+			strconv.FormatFloat(vertex[1], 'f', -1, 64),
+			strconv.FormatFloat(vertex[2], 'f', -1, 64))
+	}
+	for _, face := range m.Faces {
+		fmt.Fprintf(buf, "f %d %d %d\n", face[0]+1, face[1]+1, face[2]+1)
+	}
+	return buf.Flush()
+}
+
+// WritePLY writes the mesh as binary little endian PLY with float32
+// vertices and uint32 face indices
+func (m *Mesh) WritePLY(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	fmt.Fprintf(buf, "ply\nformat binary_little_endian 1.0\n")
+	fmt.Fprintf(buf, "element vertex %d\nproperty float x\nproperty float y\nproperty float z\n", len(m.Vertices))
+	fmt.Fprintf(buf, "element face %d\nproperty list uchar uint vertex_indices\nend_header\n", len(m.Faces))
+	for _, vertex := range m.Vertices {
+		position := [3]float32{float32(vertex[0]), float32(vertex[1]), float32(vertex[2])}
+		if err := binary.Write(buf, binary.LittleEndian, position); err != nil {
+			return err
+		}
+	}
+	for _, face := range m.Faces {
+		buf.WriteByte(3)
+		indices := [3]uint32{uint32(face[0]), uint32(face[1]), uint32(face[2])}
+		if err := binary.Write(buf, binary.LittleEndian, indices); err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
+
+// WriteNgMesh writes the mesh in the neuroglancer legacy single resolution
+// format: the vertex count, float32 vertex positions and uint32 triangle
+// indices, all little endian
+func (m *Mesh) WriteNgMesh(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	positions := make([]float32, 0, 3*len(m.Vertices))
+	for _, vertex := range m.Vertices {
+		positions = append(positions, float32(vertex[0]), float32(vertex[1]), float32(vertex[2]))
+	}
+	indices := make([]uint32, 0, 3*len(m.Faces))
+	for _, face := range m.Faces {
+		indices = append(indices, uint32(face[0]), uint32(face[1]), uint32(face[2]))
+	}
+	for _, data := range []interface{}{uint32(len(m.Vertices)), positions, indices} {
+		if err := binary.Write(buf, binary.LittleEndian, data); err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
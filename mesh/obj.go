@@ -0,0 +1,189 @@
+/*
+   Parses triangle meshes stored as OBJ and tests whether points lie inside
+   them.
+*/
+
+package mesh
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Mesh is a triangle mesh.  Faces index into Vertices.
+type Mesh struct {
+	Vertices [][3]float64
+	Faces    [][3]int
+
+	// index bins the faces by their y/z extent for Contains
+	index *faceGrid
+}
+
+// ParseOBJ reads the vertices and faces of an OBJ file.  Polygons are split
+// into triangle fans and other statements are ignored.
+func ParseOBJ(data []byte) (*Mesh, error) {
+	m := &Mesh{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "v":
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("line %d: vertex needs 3 coordinates", lineno)
+			}
+			var vertex [3]float64
+			for i := 0; i < 3; i++ {
+				val, err := strconv.ParseFloat(fields[i+1], 64)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid coordinate %q", lineno, fields[i+1])
+				}
+				vertex[i] = val
+			}
+			m.Vertices = append(m.Vertices, vertex)
+		case "f":
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("line %d: face needs at least 3 vertices", lineno)
+			}
+			corners := make([]int, 0, len(fields)-1)
+			for _, field := range fields[1:] {
+				// vertex/texture/normal references only need the vertex
+				idx, err := strconv.Atoi(strings.SplitN(field, "/", 2)[0])
+				if err != nil || idx == 0 {
+					return nil, fmt.Errorf("line %d: invalid face vertex %q", lineno, field)
+				}
+				if idx < 0 {
+					idx += len(m.Vertices)
+				} else {
+					idx--
+				}
+				if idx < 0 || idx >= len(m.Vertices) {
+					return nil, fmt.Errorf("line %d: face vertex %q out of range", lineno, field)
+				}
+				corners = append(corners, idx)
+			}
+			for i := 1; i+1 < len(corners); i++ {
+				m.Faces = append(m.Faces, [3]int{corners[0], corners[i], corners[i+1]})
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Bounds returns the minimum and maximum vertex coordinates
+func (m *Mesh) Bounds() ([3]float64, [3]float64) {
+	var lo, hi [3]float64
+	for i, vertex := range m.Vertices {
+		for d := 0; d < 3; d++ {
+			if i == 0 || vertex[d] < lo[d] {
+				lo[d] = vertex[d]
+			}
+			if i == 0 || vertex[d] > hi[d] {
+				hi[d] = vertex[d]
+			}
+		}
+	}
+	return lo, hi
+}
+
+// faceGrid bins faces by the y/z cells they overlap so a ray along x only
+// tests the faces in one cell
+type faceGrid struct {
+	lo, hi [3]float64
+	size   int
+	cells  [][]int32
+	nudge  [2]float64
+}
+
+// maxGridSize bounds the number of cells along each axis
+const maxGridSize = 256
+
+func (g *faceGrid) cell(y, z float64) int {
+	cy := int(float64(g.size) * (y - g.lo[1]) / (g.hi[1] - g.lo[1]))
+	cz := int(float64(g.size) * (z - g.lo[2]) / (g.hi[2] - g.lo[2]))
+	cy = min(max(cy, 0), g.size-1)
+	cz = min(max(cz, 0), g.size-1)
+	return cy*g.size + cz
+}
+
+// buildIndex computes the face grid
+func (m *Mesh) buildIndex() *faceGrid {
+	g := &faceGrid{}
+	g.lo, g.hi = m.Bounds()
+	for d := 1; d < 3; d++ {
+		if g.hi[d] <= g.lo[d] {
+			g.hi[d] = g.lo[d] + 1
+		}
+	}
+	g.size = min(max(int(math.Sqrt(float64(len(m.Faces))/4)), 1), maxGridSize)
+	g.cells = make([][]int32, g.size*g.size)
+
+	// query points are moved by a tiny irrational fraction of the extent so
+	// rays do not pass exactly through shared edges and vertices
+	g.nudge = [2]float64{(g.hi[1] - g.lo[1]) * 1e-9 * math.Sqrt2, (g.hi[2] - g.lo[2]) * 1e-9 * math.Pi}
+
+	for f, face := range m.Faces {
+		a, b, c := m.Vertices[face[0]], m.Vertices[face[1]], m.Vertices[face[2]]
+		first := g.cell(min(a[1], b[1], c[1]), min(a[2], b[2], c[2]))
+		last := g.cell(max(a[1], b[1], c[1]), max(a[2], b[2], c[2]))
+		for cy := first / g.size; cy <= last/g.size; cy++ {
+			for cz := first % g.size; cz <= last%g.size; cz++ {
+				g.cells[cy*g.size+cz] = append(g.cells[cy*g.size+cz], int32(f))
+			}
+		}
+	}
+	return g
+}
+
+// Index builds the spatial index used by Contains.  It is built on first
+// use otherwise; call it before sharing the mesh between goroutines.
+func (m *Mesh) Index() {
+	if m.index == nil {
+		m.index = m.buildIndex()
+	}
+}
+
+// Contains reports whether a point is inside the closed mesh by counting
+// the faces crossed by a ray from the point along +x
+func (m *Mesh) Contains(x, y, z float64) bool {
+	m.Index()
+	g := m.index
+	if len(m.Faces) == 0 || x < g.lo[0] || x > g.hi[0] || y < g.lo[1] || y > g.hi[1] || z < g.lo[2] || z > g.hi[2] {
+		return false
+	}
+	y += g.nudge[0]
+	z += g.nudge[1]
+
+	crossings := 0
+	for _, f := range g.cells[g.cell(y, z)] {
+		face := m.Faces[f]
+		a, b, c := m.Vertices[face[0]], m.Vertices[face[1]], m.Vertices[face[2]]
+
+		// barycentric coordinates of (y, z) in the projected triangle
+		det := (b[1]-a[1])*(c[2]-a[2]) - (c[1]-a[1])*(b[2]-a[2])
+		if det == 0 {
+			continue
+		}
+		u := ((y-a[1])*(c[2]-a[2]) - (c[1]-a[1])*(z-a[2])) / det
+		v := ((b[1]-a[1])*(z-a[2]) - (y-a[1])*(b[2]-a[2])) / det
+		if u < 0 || v < 0 || u+v > 1 {
+			continue
+		}
+		if a[0]+u*(b[0]-a[0])+v*(c[0]-a[0]) > x {
+			crossings++
+		}
+	}
+	return crossings%2 == 1
+}
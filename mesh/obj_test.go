@@ -0,0 +1,105 @@
+package mesh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// cubeOBJ is the unit cube from 0 to 10 with quad faces
+const cubeOBJ = `# cube
+v 0 0 0
+v 10 0 0
+v 10 10 0
+v 0 10 0
+v 0 0 10
+v 10 0 10
+v 10 10 10
+v 0 10 10
+f 1 4 3 2
+f 5 6 7 8
+f 1 2 6 5
+f 2 3 7 6
+f 3 4 8 7
+f 4/1 1/2 5/3 8/4
+`
+
+func TestParseOBJ(t *testing.T) {
+	m, err := ParseOBJ([]byte(cubeOBJ))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Vertices) != 8 || len(m.Faces) != 12 {
+		t.Fatalf("expected 8 vertices and 12 triangles, got %d and %d", len(m.Vertices), len(m.Faces))
+	}
+	if m.Faces[11] != [3]int{3, 4, 7} {
+		t.Errorf("unexpected face: %v", m.Faces[11])
+	}
+	lo, hi := m.Bounds()
+	if lo != [3]float64{0, 0, 0} || hi != [3]float64{10, 10, 10} {
+		t.Errorf("unexpected bounds: %v %v", lo, hi)
+	}
+
+	for _, obj := range []string{"v 1 2\n", "v 0 0 0\nf 1 2 3\n", "v 0 0 x\n"} {
+		if _, err := ParseOBJ([]byte(obj)); err == nil {
+			t.Errorf("expected error for %q", obj)
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	m, _ := ParseOBJ([]byte(cubeOBJ))
+	for _, point := range [][3]float64{{5, 5, 5}, {0.1, 9.9, 0.1}, {5, 0, 5}, {9, 5, 5}, {1, 5, 5}} {
+		if !m.Contains(point[0], point[1], point[2]) {
+			t.Errorf("expected %v inside", point)
+		}
+	}
+	for _, point := range [][3]float64{{-1, 5, 5}, {11, 5, 5}, {5, 5, 10.5}, {5, -0.1, 5}} {
+		if m.Contains(point[0], point[1], point[2]) {
+			t.Errorf("expected %v outside", point)
+		}
+	}
+}
+
+func TestWriteFormats(t *testing.T) {
+	m, _ := ParseOBJ([]byte(cubeOBJ))
+
+	var obj bytes.Buffer
+	if err := m.WriteOBJ(&obj); err != nil {
+		t.Fatal(err)
+	}
+	again, err := ParseOBJ(obj.Bytes())
+	if err != nil || !reflect.DeepEqual(again.Vertices, m.Vertices) || !reflect.DeepEqual(again.Faces, m.Faces) {
+		t.Errorf("OBJ round trip changed mesh (%v)", err)
+	}
+
+	var ng bytes.Buffer
+	if err := m.WriteNgMesh(&ng); err != nil {
+		t.Fatal(err)
+	}
+	var count uint32
+	binary.Read(&ng, binary.LittleEndian, &count)
+	positions := make([]float32, 24)
+	binary.Read(&ng, binary.LittleEndian, positions)
+	if count != 8 || positions[3] != 10 || ng.Len() != 12*3*4 {
+		t.Errorf("unexpected ngmesh: %d vertices, %v, %d index bytes", count, positions, ng.Len())
+	}
+
+	var ply bytes.Buffer
+	if err := m.WritePLY(&ply); err != nil {
+		t.Fatal(err)
+	}
+	header, body, ok := strings.Cut(ply.String(), "end_header\n")
+	if !ok || !strings.Contains(header, "element vertex 8\n") || !strings.Contains(header, "element face 12\n") {
+		t.Fatalf("unexpected PLY header: %q", header)
+	}
+	if len(body) != 8*3*4+12*(1+3*4) {
+		t.Errorf("unexpected PLY body size %d", len(body))
+	}
+
+	if resp := (&Mesh{}).JSON(); resp.Vertices == nil || resp.Faces == nil {
+		t.Error("empty mesh should have empty lists")
+	}
+}
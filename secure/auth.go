@@ -96,14 +96,15 @@ func dsgDatasetAccessHandler(c echo.Context) error {
 	}
 
 	identity := c.Get("dsg_identity").(*DSGIdentity)
-	client := c.Get("dsg_client").(*DSGClient)
+	client := c.Get("dsg_client").(Authorizer)
+	service := providerService(client)
 
 	if identity.Admin {
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"access":       true,
 			"tos_required": false,
 			"dataset":      dataset,
-			"service":      client.ServiceName,
+			"service":      service,
 			"level":        StringFromLevel(ADMIN),
 		})
 	}
@@ -126,7 +127,7 @@ func dsgDatasetAccessHandler(c echo.Context) error {
 			"access":       true,
 			"tos_required": false,
 			"dataset":      dataset,
-			"service":      client.ServiceName,
+			"service":      service,
 			"level":        StringFromLevel(level),
 		})
 	}
@@ -135,7 +136,7 @@ func dsgDatasetAccessHandler(c echo.Context) error {
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"access":       false,
 			"dataset":      dataset,
-			"service":      client.ServiceName,
+			"service":      service,
 			"level":        StringFromLevel(level),
 			"tos_required": true,
 			"tos_url":      decision.TOSURL,
@@ -146,7 +147,7 @@ func dsgDatasetAccessHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"access":       false,
 		"dataset":      dataset,
-		"service":      client.ServiceName,
+		"service":      service,
 		"level":        StringFromLevel(level),
 		"tos_required": false,
 		"message":      "You do not have access to " + dataset + " dataset",
@@ -464,11 +464,10 @@ func RequireDatasetAccess(c echo.Context, dataset string, level AuthorizationLev
 }
 
 func datasetDecisionForContext(c echo.Context, dataset string) (*DSGDecision, bool, error) {
-	clientVal := c.Get("dsg_client")
-	if clientVal == nil {
+	client, ok := c.Get("dsg_client").(Authorizer)
+	if !ok || client == nil {
 		return nil, c.Get("dsg_identity") == nil, echo.NewHTTPError(http.StatusBadGateway, "auth service unavailable")
 	}
-	client := clientVal.(*DSGClient)
 
 	var decision *DSGDecision
 	var err error
@@ -571,6 +570,25 @@ func RequireAnyDatasetAccess(c echo.Context, datasets []string, level Authorizat
 	return echo.NewHTTPError(http.StatusForbidden, "You do not have access to any dataset")
 }
 
+// AccessibleDatasets returns the listed datasets the request has at least
+// the given access to, in the order given
+func AccessibleDatasets(c echo.Context, datasets []string, level AuthorizationLevel) ([]string, error) {
+	if identity, ok := c.Get("dsg_identity").(*DSGIdentity); ok && identity.Admin {
+		return datasets, nil
+	}
+	accessible := make([]string, 0, len(datasets))
+	for _, dataset := range datasets {
+		decision, _, err := datasetDecisionForContext(c, dataset)
+		if err != nil {
+			return nil, err
+		}
+		if decision.Level() >= level {
+			accessible = append(accessible, dataset)
+		}
+	}
+	return accessible, nil
+}
+
 func currentRequestURL(c echo.Context) string {
 	req := c.Request()
 	if req == nil {
@@ -585,11 +603,12 @@ func currentRequestURL(c echo.Context) string {
 
 // DSGOptionalAuthMiddleware authenticates attempted credentials and otherwise
 // proceeds anonymously. Disable-auth mode installs a synthetic global admin so
-// every authorization guard takes the existing admin short-circuit.
-func DSGOptionalAuthMiddleware(client *DSGClient, disableAuth bool) echo.MiddlewareFunc {
+// every authorization guard takes the existing admin short-circuit. The
+// client is the DSGClient or any other AuthProvider.
+func DSGOptionalAuthMiddleware(client AuthProvider, disableAuth bool) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			if client == nil {
+			if missingProvider(client) {
 				return echo.NewHTTPError(http.StatusBadGateway, "auth service unavailable")
 			}
 			c.Set("dsg_client", client)
@@ -630,7 +649,7 @@ func DSGOptionalAuthMiddleware(client *DSGClient, disableAuth bool) echo.Middlew
 // DSGAuthMiddleware validates the dsg_token and populates the echo context
 // with the authenticated identity. It performs authentication only —
 // per-dataset authorization is done by handlers via RequireDatasetAccess.
-func DSGAuthMiddleware(client *DSGClient) echo.MiddlewareFunc {
+func DSGAuthMiddleware(client AuthProvider) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			token, attempted, valid := credentialFromRequest(c)
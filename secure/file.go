@@ -0,0 +1,250 @@
+package secure
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileUser is a user of the file provider
+type FileUser struct {
+	Name     string            `json:"name" yaml:"name"`
+	Admin    bool              `json:"admin" yaml:"admin"`
+	Tokens   []string          `json:"tokens" yaml:"tokens"`     // SHA-256 hashes of the user's API tokens (see HashToken)
+	Datasets map[string]string `json:"datasets" yaml:"datasets"` // role by dataset name, name:tag or "*"
+}
+
+// AuthFile is the content of a file provider's file (JSON, or YAML for
+// .yaml and .yml files).  Roles are view, edit, manage, admin or none;
+// read and readwrite are accepted for view and edit.
+type AuthFile struct {
+	Users  map[string]FileUser `json:"users" yaml:"users"`   // by email
+	Public map[string]string   `json:"public" yaml:"public"` // role of anonymous requests by dataset
+}
+
+// HashToken returns the hash an API token is stored as
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// fileRoles maps the roles of the file to DSG roles
+var fileRoles = map[string]string{
+	"view": "view", "read": "view",
+	"edit": "edit", "readwrite": "edit",
+	"manage": "manage",
+	"admin":  "admin",
+	"none":   "",
+}
+
+// mapRoles converts roles by dataset to DSG roles by lower case dataset
+func mapRoles(roles map[string]string) (map[string]string, error) {
+	mapped := make(map[string]string, len(roles))
+	for dataset, role := range roles {
+		dsgRole, ok := fileRoles[strings.ToLower(role)]
+		if !ok {
+			return nil, fmt.Errorf("unknown role %q for dataset %s", role, dataset)
+		}
+		mapped[strings.ToLower(dataset)] = dsgRole
+	}
+	return mapped, nil
+}
+
+// fileState is a loaded auth file
+type fileState struct {
+	identities map[string]*DSGIdentity      // by token hash
+	roles      map[string]map[string]string // DSG role by lower case email and dataset
+	public     map[string]string
+}
+
+// parseAuthFile checks an auth file and indexes its tokens
+func parseAuthFile(auth AuthFile) (*fileState, error) {
+	state := &fileState{
+		identities: make(map[string]*DSGIdentity),
+		roles:      make(map[string]map[string]string),
+		public:     make(map[string]string),
+	}
+	for email, user := range auth.Users {
+		roles, err := mapRoles(user.Datasets)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %v", email, err)
+		}
+		state.roles[strings.ToLower(email)] = roles
+		identity := &DSGIdentity{Email: email, Name: user.Name, Admin: user.Admin}
+		for _, hash := range user.Tokens {
+			hash = strings.ToLower(strings.TrimPrefix(hash, "sha256:"))
+			if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+				return nil, fmt.Errorf("user %s: tokens must be SHA-256 hashes", email)
+			}
+			hash = "sha256:" + hash
+			if other, ok := state.identities[hash]; ok {
+				return nil, fmt.Errorf("users %s and %s share a token", other.Email, email)
+			}
+			state.identities[hash] = identity
+		}
+	}
+	var err error
+	if state.public, err = mapRoles(auth.Public); err != nil {
+		return nil, fmt.Errorf("public: %v", err)
+	}
+	return state, nil
+}
+
+// fileRole returns the DSG role for a dataset: the role of its full name, of
+// its name without the tag, or of "*"
+func fileRole(roles map[string]string, dataset string) string {
+	dataset = strings.ToLower(dataset)
+	name, _ := splitNeuPrintDataset(dataset)
+	for _, key := range []string{dataset, name, "*"} {
+		if role, ok := roles[key]; ok {
+			return role
+		}
+	}
+	return ""
+}
+
+// FileProvider authenticates API tokens and authorizes datasets from a
+// local file, for deployments without a DatasetGateway.  The file is read
+// again when it changes.
+type FileProvider struct {
+	Path string
+
+	// ReloadCheck is how often the file is checked for changes
+	ReloadCheck time.Duration
+
+	mux     sync.Mutex
+	state   *fileState
+	checked time.Time
+	modTime time.Time
+	size    int64
+}
+
+// NewFileProvider loads the users and roles of an auth file
+func NewFileProvider(path string) (*FileProvider, error) {
+	provider := &FileProvider{Path: path, ReloadCheck: 2 * time.Second}
+	if err := provider.load(); err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// load reads the file
+func (p *FileProvider) load() error {
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		return err
+	}
+	var auth AuthFile
+	switch strings.ToLower(filepath.Ext(p.Path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &auth)
+	default:
+		err = json.Unmarshal(data, &auth)
+	}
+	if err != nil {
+		return fmt.Errorf("auth file %s cannot be read: %v", p.Path, err)
+	}
+	state, err := parseAuthFile(auth)
+	if err != nil {
+		return fmt.Errorf("auth file %s: %v", p.Path, err)
+	}
+	p.state, p.modTime, p.size = state, info.ModTime(), info.Size()
+	return nil
+}
+
+// current returns the loaded file, reloading it if it changed.  A file that
+// cannot be loaded leaves the previous users in place.
+func (p *FileProvider) current(force bool) *fileState {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if force || time.Since(p.checked) >= p.ReloadCheck {
+		p.checked = time.Now()
+		if info, err := os.Stat(p.Path); err == nil && (!info.ModTime().Equal(p.modTime) || info.Size() != p.size) {
+			if err := p.load(); err != nil {
+				log.Printf("auth: keeping previous users: %v", err)
+			}
+		}
+	}
+	return p.state
+}
+
+// identity returns a copy of the identity of a token
+func (p *FileProvider) identity(token string, force bool) *DSGIdentity {
+	if token == "" {
+		return nil
+	}
+	identity, ok := p.current(force).identities[HashToken(token)]
+	if !ok {
+		return nil
+	}
+	copied := *identity
+	return &copied
+}
+
+// Identity returns the user of an API token, or nil if it is unknown
+func (p *FileProvider) Identity(token string) (*DSGIdentity, error) {
+	return p.identity(token, false), nil
+}
+
+// IdentityFresh returns the user of an API token after checking the file
+// for changes
+func (p *FileProvider) IdentityFresh(token string) (*DSGIdentity, error) {
+	return p.identity(token, true), nil
+}
+
+// roleDecision returns the DSG decision for a role
+func roleDecision(dataset, role string) *DSGDecision {
+	name, version := splitNeuPrintDataset(dataset)
+	if role == "" {
+		return &DSGDecision{Name: name, Version: version, Decision: "deny", Roles: []string{}}
+	}
+	return &DSGDecision{Name: name, Version: version, Decision: "allow", Roles: []string{role}}
+}
+
+// DatasetDecision returns the access of a token to a dataset
+func (p *FileProvider) DatasetDecision(token, dataset, returnURL string, forceRefresh bool) (*DSGDecision, error) {
+	decisions, err := p.AuthorizeDatasets(token, []string{dataset}, returnURL, forceRefresh)
+	if err != nil {
+		return nil, err
+	}
+	return decisions[dataset], nil
+}
+
+// AuthorizeDatasets returns the access of a token to each dataset
+func (p *FileProvider) AuthorizeDatasets(token string, datasets []string, returnURL string, forceRefresh bool) (map[string]*DSGDecision, error) {
+	state := p.current(forceRefresh)
+	var identity *DSGIdentity
+	if token != "" {
+		identity = state.identities[HashToken(token)]
+	}
+	results := make(map[string]*DSGDecision, len(datasets))
+	for _, dataset := range datasets {
+		switch {
+		case identity == nil:
+			results[dataset] = roleDecision(dataset, "")
+		case identity.Admin:
+			results[dataset] = roleDecision(dataset, "admin")
+		default:
+			results[dataset] = roleDecision(dataset, fileRole(state.roles[strings.ToLower(identity.Email)], dataset))
+		}
+	}
+	return results, nil
+}
+
+// AnonymousDatasetDecision returns the public access to a dataset
+func (p *FileProvider) AnonymousDatasetDecision(dataset, returnURL string) (*DSGDecision, error) {
+	return roleDecision(dataset, fileRole(p.current(false).public, dataset)), nil
+}
@@ -0,0 +1,128 @@
+package secure
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const authYAML = `
+users:
+  alice@example.org:
+    name: Alice
+    tokens: ["%ALICE%"]
+    datasets:
+      hemibrain: edit
+      manc:v1.0: view
+      "*": view
+      secret: none
+  root@example.org:
+    admin: true
+    tokens: ["%ROOT%"]
+public:
+  hemibrain: view
+`
+
+func writeAuthFile(t *testing.T, path, content string) {
+	content = strings.ReplaceAll(content, "%ALICE%", HashToken("alice-token"))
+	content = strings.ReplaceAll(content, "%ROOT%", HashToken("root-token"))
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.yaml")
+	writeAuthFile(t, path, authYAML)
+	provider, err := NewFileProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, _ := provider.Identity("alice-token")
+	if identity == nil || identity.Email != "alice@example.org" || identity.Admin {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+	if identity, _ := provider.Identity("wrong"); identity != nil {
+		t.Error("expected an unknown token to be rejected")
+	}
+
+	decisions, _ := provider.AuthorizeDatasets("alice-token", []string{"hemibrain:v1.2.1", "manc:v1.0", "fib19", "secret"}, "", false)
+	for dataset, want := range map[string]AuthorizationLevel{"hemibrain:v1.2.1": READWRITE, "manc:v1.0": READ, "fib19": READ, "secret": NOAUTH} {
+		if level := decisions[dataset].Level(); level != want {
+			t.Errorf("alice has level %d on %s, want %d", level, dataset, want)
+		}
+	}
+	if decision, _ := provider.DatasetDecision("root-token", "secret", "", false); decision.Level() != ADMIN {
+		t.Error("expected admins to be allowed everything")
+	}
+	if decision, _ := provider.AnonymousDatasetDecision("hemibrain:v1.2.1", ""); decision.Level() != READ {
+		t.Error("expected public read access to hemibrain")
+	}
+	if decision, _ := provider.AnonymousDatasetDecision("manc", ""); decision.Level() != NOAUTH {
+		t.Error("expected no public access to manc")
+	}
+
+	// edits are picked up without a restart; a broken file is ignored
+	provider.ReloadCheck = 0
+	writeAuthFile(t, path, `{"users": {"bob@example.org": {"tokens": ["%ALICE%"]}}}`)
+	os.Chtimes(path, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	if identity, _ := provider.Identity("alice-token"); identity == nil || identity.Email != "bob@example.org" {
+		t.Errorf("expected the reloaded file to be used, got %+v", identity)
+	}
+	writeAuthFile(t, path, "users: [")
+	os.Chtimes(path, time.Now().Add(2*time.Minute), time.Now().Add(2*time.Minute))
+	if identity, _ := provider.Identity("alice-token"); identity == nil || identity.Email != "bob@example.org" {
+		t.Errorf("expected a broken file to keep the previous users, got %+v", identity)
+	}
+}
+
+func TestFileProviderRejectsPlainTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	writeAuthFile(t, path, `{"users": {"alice@example.org": {"tokens": ["alice-token"]}}}`)
+	if _, err := NewFileProvider(path); err == nil {
+		t.Error("expected unhashed tokens to be rejected")
+	}
+	writeAuthFile(t, path, `{"public": {"hemibrain": "owner"}}`)
+	if _, err := NewFileProvider(path); err == nil {
+		t.Error("expected an unknown role to be rejected")
+	}
+}
+
+func TestFileProviderMiddleware(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.yaml")
+	writeAuthFile(t, path, authYAML)
+	provider, err := NewFileProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	handler := DSGOptionalAuthMiddleware(provider, false)(func(c echo.Context) error {
+		if err := RequireDatasetAccess(c, "hemibrain", READWRITE); err != nil {
+			return err
+		}
+		return c.String(http.StatusOK, c.Get("email").(string))
+	})
+	for token, want := range map[string]int{"alice-token": http.StatusOK, "": http.StatusUnauthorized, "wrong": http.StatusUnauthorized} {
+		req := httptest.NewRequest(http.MethodGet, "/api/test", nil)
+		if token != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		err := handler(e.NewContext(req, rec))
+		status := rec.Code
+		if httpErr, ok := err.(*echo.HTTPError); ok {
+			status = httpErr.Code
+		}
+		if status != want {
+			t.Errorf("token %q: status %d, want %d", token, status, want)
+		}
+	}
+}
@@ -0,0 +1,512 @@
+package secure
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWTConfig configures the validation of bearer JWTs and the mapping of
+// their claims.  Claim names may be dotted paths into nested claims, such
+// as realm_access.roles.
+type JWTConfig struct {
+	JWKSURL  string // URL of the signing keys
+	JWKSFile string // or a file holding them
+	Issuer   string // required iss, if set
+	Audience string // required in aud, if set
+
+	ClockSkew  time.Duration // allowed difference from the issuer's clock
+	Refresh    time.Duration // how often the keys are read again (default 1 hour)
+	MinRefresh time.Duration // least time between reads for unknown key ids (default 1 minute)
+
+	EmailClaim    string            // identity (default email, falling back to sub)
+	NameClaim     string            // display name (default name)
+	AdminClaim    string            // true, or a list or string holding AdminValue, for global admins
+	AdminValue    string            // default admin
+	DatasetsClaim string            // object of roles by dataset, or list of "dataset:role"
+	Public        map[string]string // role of anonymous requests by dataset
+}
+
+// jwtKey is a JSON web key
+type jwtKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey decodes an RSA or P-256 key
+func (key jwtKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(value string) (*big.Int, error) {
+		data, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil || len(data) == 0 {
+			return nil, fmt.Errorf("key %s is not encoded correctly", key.Kid)
+		}
+		return new(big.Int).SetBytes(data), nil
+	}
+	switch key.Kty {
+	case "RSA":
+		n, err := decode(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(key.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("key %s has an invalid exponent", key.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if key.Crv != "P-256" {
+			return nil, fmt.Errorf("key %s uses unsupported curve %s", key.Kid, key.Crv)
+		}
+		x, err := decode(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(key.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, fmt.Errorf("key %s is not on its curve", key.Kid)
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("key %s has unsupported type %s", key.Kid, key.Kty)
+}
+
+// parseJWKS decodes a key set, skipping keys that are not for signatures
+// or cannot be used
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwtKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("key set is not formatted correctly: %v", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		public, err := key.publicKey()
+		if err != nil {
+			log.Printf("jwt: skipping key: %v", err)
+			continue
+		}
+		keys[key.Kid] = public
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("key set holds no usable signing keys")
+	}
+	return keys, nil
+}
+
+// jwtSession is a validated token
+type jwtSession struct {
+	identity *DSGIdentity
+	roles    map[string]string // DSG role by lower case dataset
+	expires  time.Time
+}
+
+// JWTProvider authenticates bearer JWTs signed by an identity provider,
+// checking them locally against its published keys, and authorizes
+// datasets from their claims.  Keys are read again periodically and when a
+// token names an unknown key, so keys can be rotated without a restart.
+type JWTProvider struct {
+	config JWTConfig
+	client *http.Client
+	public map[string]string
+
+	mux        sync.Mutex
+	keys       map[string]crypto.PublicKey
+	fetched    time.Time
+	refreshing chan struct{} // closed when the keys being read are in
+	sessions   map[string]*jwtSession
+
+	// now is the clock tokens are checked against
+	now func() time.Time
+}
+
+// NewJWTProvider creates a provider and reads its keys
+func NewJWTProvider(config JWTConfig) (*JWTProvider, error) {
+	if (config.JWKSURL == "") == (config.JWKSFile == "") {
+		return nil, fmt.Errorf("jwt: exactly one of a JWKS URL and a JWKS file must be given")
+	}
+	if config.Refresh <= 0 {
+		config.Refresh = time.Hour
+	}
+	if config.MinRefresh <= 0 {
+		config.MinRefresh = time.Minute
+	}
+	if config.EmailClaim == "" {
+		config.EmailClaim = "email"
+	}
+	if config.NameClaim == "" {
+		config.NameClaim = "name"
+	}
+	if config.AdminValue == "" {
+		config.AdminValue = "admin"
+	}
+	public, err := mapRoles(config.Public)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: public: %v", err)
+	}
+	provider := &JWTProvider{
+		config:   config,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		public:   public,
+		sessions: make(map[string]*jwtSession),
+		now:      time.Now,
+	}
+	if provider.keys, err = provider.fetchKeys(); err != nil {
+		return nil, err
+	}
+	provider.fetched = provider.now()
+	return provider, nil
+}
+
+// readJWKS reads the key set from the URL or file
+func (p *JWTProvider) readJWKS() ([]byte, error) {
+	if p.config.JWKSFile != "" {
+		return os.ReadFile(p.config.JWKSFile)
+	}
+	resp, err := p.client.Get(p.config.JWKSURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// fetchKeys reads and decodes the keys
+func (p *JWTProvider) fetchKeys() (map[string]crypto.PublicKey, error) {
+	data, err := p.readJWKS()
+	if err == nil {
+		var keys map[string]crypto.PublicKey
+		if keys, err = parseJWKS(data); err == nil {
+			return keys, nil
+		}
+	}
+	return nil, fmt.Errorf("jwt: cannot read signing keys: %v", err)
+}
+
+// key returns the key a token was signed with, reading the keys again if
+// they are stale or the key is unknown.  One request reads the keys without
+// holding the lock; meanwhile known keys are served as they are and
+// requests for unknown keys wait for the read.  The previous keys are kept
+// if reading fails.
+func (p *JWTProvider) key(kid string) (crypto.PublicKey, error) {
+	p.mux.Lock()
+	since := p.now().Sub(p.fetched)
+	key, ok := p.keys[kid]
+	stale := since >= p.config.Refresh || (!ok && since >= p.config.MinRefresh)
+	switch {
+	case ok && (!stale || p.refreshing != nil):
+		// known keys are served while the keys are read
+	case p.refreshing != nil:
+		wait := p.refreshing
+		p.mux.Unlock()
+		<-wait
+		p.mux.Lock()
+		key, ok = p.keys[kid]
+	case stale:
+		done := make(chan struct{})
+		p.refreshing = done
+		p.fetched = p.now()
+		p.mux.Unlock()
+		keys, err := p.fetchKeys()
+		p.mux.Lock()
+		if err != nil {
+			log.Print(err)
+		} else {
+			p.keys = keys
+		}
+		p.refreshing = nil
+		close(done)
+		key, ok = p.keys[kid]
+	}
+	p.mux.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	return key, nil
+}
+
+// verify checks the signature of a token and returns its claims, or nil if
+// the token is not valid
+func (p *JWTProvider) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(data, &header) != nil {
+		return nil, nil
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil
+	}
+
+	key, err := p.key(header.Kid)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return nil, nil
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(signature) != 64 {
+			return nil, nil
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(key, digest[:], r, s) {
+			return nil, nil
+		}
+	default:
+		return nil, nil
+	}
+
+	var claims map[string]interface{}
+	if data, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+		return nil, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if decoder.Decode(&claims) != nil {
+		return nil, nil
+	}
+	return claims, nil
+}
+
+// claim returns a claim by its dotted path
+func claim(claims map[string]interface{}, path string) interface{} {
+	var value interface{} = claims
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	return value
+}
+
+// claimTime returns a numeric date claim
+func claimTime(claims map[string]interface{}, name string) (time.Time, bool) {
+	number, ok := claims[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+// checkClaims checks the issuer, audience and validity period
+func (p *JWTProvider) checkClaims(claims map[string]interface{}) (time.Time, bool) {
+	now := p.now()
+	expires, ok := claimTime(claims, "exp")
+	if !ok || !now.Before(expires.Add(p.config.ClockSkew)) {
+		return expires, false
+	}
+	if notBefore, ok := claimTime(claims, "nbf"); ok && now.Add(p.config.ClockSkew).Before(notBefore) {
+		return expires, false
+	}
+	if p.config.Issuer != "" && claims["iss"] != p.config.Issuer {
+		return expires, false
+	}
+	if p.config.Audience != "" {
+		found := false
+		switch aud := claims["aud"].(type) {
+		case string:
+			found = aud == p.config.Audience
+		case []interface{}:
+			for _, value := range aud {
+				found = found || value == p.config.Audience
+			}
+		}
+		if !found {
+			return expires, false
+		}
+	}
+	return expires.Add(p.config.ClockSkew), true
+}
+
+// session maps the claims of a valid token
+func (p *JWTProvider) session(claims map[string]interface{}, expires time.Time) *jwtSession {
+	email, _ := claim(claims, p.config.EmailClaim).(string)
+	if email == "" {
+		email, _ = claims["sub"].(string)
+	}
+	if email == "" {
+		return nil
+	}
+	name, _ := claim(claims, p.config.NameClaim).(string)
+	identity := &DSGIdentity{Email: email, Name: name}
+
+	if p.config.AdminClaim != "" {
+		switch value := claim(claims, p.config.AdminClaim).(type) {
+		case bool:
+			identity.Admin = value
+		case string:
+			identity.Admin = value == p.config.AdminValue
+		case []interface{}:
+			for _, item := range value {
+				identity.Admin = identity.Admin || item == p.config.AdminValue
+			}
+		}
+	}
+
+	roles := make(map[string]string)
+	if p.config.DatasetsClaim != "" {
+		switch value := claim(claims, p.config.DatasetsClaim).(type) {
+		case map[string]interface{}:
+			for dataset, role := range value {
+				if role, ok := role.(string); ok {
+					roles[dataset] = role
+				}
+			}
+		case []interface{}:
+			for _, item := range value {
+				if item, ok := item.(string); ok {
+					if cut := strings.LastIndex(item, ":"); cut > 0 {
+						roles[item[:cut]] = item[cut+1:]
+					}
+				}
+			}
+		}
+	}
+	mapped := make(map[string]string, len(roles))
+	for dataset, role := range roles {
+		if dsgRole, ok := fileRoles[strings.ToLower(role)]; ok {
+			mapped[strings.ToLower(dataset)] = dsgRole
+		}
+	}
+	return &jwtSession{identity: identity, roles: mapped, expires: expires}
+}
+
+// validate returns the session of a token, or nil if it is not valid
+func (p *JWTProvider) validate(token string) (*jwtSession, error) {
+	if token == "" {
+		return nil, nil
+	}
+	now := p.now()
+	p.mux.Lock()
+	session, ok := p.sessions[token]
+	if ok && !now.Before(session.expires) {
+		delete(p.sessions, token)
+		ok = false
+	}
+	p.mux.Unlock()
+	if ok {
+		return session, nil
+	}
+
+	claims, err := p.verify(token)
+	if err != nil || claims == nil {
+		return nil, err
+	}
+	expires, valid := p.checkClaims(claims)
+	if !valid {
+		return nil, nil
+	}
+	if session = p.session(claims, expires); session == nil {
+		return nil, nil
+	}
+
+	p.mux.Lock()
+	for cached, old := range p.sessions {
+		if !now.Before(old.expires) {
+			delete(p.sessions, cached)
+		}
+	}
+	p.sessions[token] = session
+	p.mux.Unlock()
+	return session, nil
+}
+
+// Identity returns the identity of a valid token
+func (p *JWTProvider) Identity(token string) (*DSGIdentity, error) {
+	session, err := p.validate(token)
+	if session == nil {
+		return nil, err
+	}
+	identity := *session.identity
+	return &identity, nil
+}
+
+// IdentityFresh is Identity; tokens are checked locally
+func (p *JWTProvider) IdentityFresh(token string) (*DSGIdentity, error) {
+	return p.Identity(token)
+}
+
+// DatasetDecision returns the access a token's claims give to a dataset
+func (p *JWTProvider) DatasetDecision(token, dataset, returnURL string, forceRefresh bool) (*DSGDecision, error) {
+	decisions, err := p.AuthorizeDatasets(token, []string{dataset}, returnURL, forceRefresh)
+	if err != nil {
+		return nil, err
+	}
+	return decisions[dataset], nil
+}
+
+// AuthorizeDatasets returns the access a token's claims give to each
+// dataset
+func (p *JWTProvider) AuthorizeDatasets(token string, datasets []string, returnURL string, forceRefresh bool) (map[string]*DSGDecision, error) {
+	session, err := p.validate(token)
+	if err != nil {
+		return nil, err
+	}
+	results := make(map[string]*DSGDecision, len(datasets))
+	for _, dataset := range datasets {
+		switch {
+		case session == nil:
+			results[dataset] = roleDecision(dataset, "")
+		case session.identity.Admin:
+			results[dataset] = roleDecision(dataset, "admin")
+		default:
+			results[dataset] = roleDecision(dataset, fileRole(session.roles, dataset))
+		}
+	}
+	return results, nil
+}
+
+// AnonymousDatasetDecision returns the public access to a dataset
+func (p *JWTProvider) AnonymousDatasetDecision(dataset, returnURL string) (*DSGDecision, error) {
+	return roleDecision(dataset, fileRole(p.public, dataset)), nil
+}
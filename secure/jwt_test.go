@@ -0,0 +1,355 @@
+package secure
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var b64 = base64.RawURLEncoding
+
+// testSigner is a locally generated signing key
+type testSigner struct {
+	kid string
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+func newRSASigner(t *testing.T, kid string) *testSigner {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testSigner{kid: kid, rsa: key}
+}
+
+func newECSigner(t *testing.T, kid string) *testSigner {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testSigner{kid: kid, ec: key}
+}
+
+func (s *testSigner) jwk() map[string]string {
+	if s.rsa != nil {
+		return map[string]string{
+			"kty": "RSA", "kid": s.kid, "use": "sig", "alg": "RS256",
+			"n": b64.EncodeToString(s.rsa.N.Bytes()),
+			"e": b64.EncodeToString(big.NewInt(int64(s.rsa.E)).Bytes()),
+		}
+	}
+	return map[string]string{
+		"kty": "EC", "kid": s.kid, "use": "sig", "alg": "ES256", "crv": "P-256",
+		"x": b64.EncodeToString(s.ec.X.FillBytes(make([]byte, 32))),
+		"y": b64.EncodeToString(s.ec.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func (s *testSigner) sign(t *testing.T, claims map[string]interface{}) string {
+	alg := "ES256"
+	if s.rsa != nil {
+		alg = "RS256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": s.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	var signature []byte
+	if s.rsa != nil {
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, s.rsa, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		r, sv, err := ecdsa.Sign(rand.Reader, s.ec, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), sv.FillBytes(make([]byte, 32))...)
+	}
+	return input + "." + b64.EncodeToString(signature)
+}
+
+func jwksJSON(signers ...*testSigner) []byte {
+	keys := make([]map[string]string, len(signers))
+	for i, signer := range signers {
+		keys[i] = signer.jwk()
+	}
+	data, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	return data
+}
+
+// jwksServer serves a key set that can be replaced.  Reads wait while
+// hold is open.
+type jwksServer struct {
+	mux   sync.Mutex
+	data  []byte
+	reads int
+	hold  chan struct{}
+}
+
+func (s *jwksServer) set(data []byte) {
+	s.mux.Lock()
+	s.data = data
+	s.mux.Unlock()
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.Lock()
+	hold := s.hold
+	s.mux.Unlock()
+	if hold != nil {
+		<-hold
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.reads++
+	w.Write(s.data)
+}
+
+func testClaims(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":   "https://idp.example.org",
+		"aud":   []string{"account", "neuprint"},
+		"sub":   "1234",
+		"email": "alice@example.org",
+		"name":  "Alice",
+		"exp":   now.Add(time.Hour).Unix(),
+		"iat":   now.Unix(),
+		"neuprint": map[string]interface{}{
+			"datasets": map[string]string{"hemibrain": "edit", "manc:v1.0": "view"},
+		},
+	}
+}
+
+func newTestJWTProvider(t *testing.T, url string) *JWTProvider {
+	provider, err := NewJWTProvider(JWTConfig{
+		JWKSURL:       url,
+		Issuer:        "https://idp.example.org",
+		Audience:      "neuprint",
+		ClockSkew:     time.Minute,
+		AdminClaim:    "realm_access.roles",
+		AdminValue:    "neuprint-admin",
+		DatasetsClaim: "neuprint.datasets",
+		Public:        map[string]string{"hemibrain": "view"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func TestJWTProvider(t *testing.T) {
+	rsaSigner, ecSigner := newRSASigner(t, "rsa-1"), newECSigner(t, "ec-1")
+	keys := &jwksServer{data: jwksJSON(rsaSigner, ecSigner)}
+	server := httptest.NewServer(keys)
+	defer server.Close()
+	provider := newTestJWTProvider(t, server.URL)
+	now := time.Now()
+
+	for _, signer := range []*testSigner{rsaSigner, ecSigner} {
+		identity, err := provider.Identity(signer.sign(t, testClaims(now)))
+		if err != nil || identity == nil || identity.Email != "alice@example.org" || identity.Name != "Alice" || identity.Admin {
+			t.Errorf("%s: unexpected identity %+v (%v)", signer.kid, identity, err)
+		}
+	}
+
+	token := rsaSigner.sign(t, testClaims(now))
+	decisions, _ := provider.AuthorizeDatasets(token, []string{"hemibrain:v1.2.1", "manc:v1.0", "manc:v1.2"}, "", false)
+	for dataset, want := range map[string]AuthorizationLevel{"hemibrain:v1.2.1": READWRITE, "manc:v1.0": READ, "manc:v1.2": NOAUTH} {
+		if level := decisions[dataset].Level(); level != want {
+			t.Errorf("alice has level %d on %s, want %d", level, dataset, want)
+		}
+	}
+	if decision, _ := provider.AnonymousDatasetDecision("hemibrain", ""); decision.Level() != READ {
+		t.Error("expected public read access to hemibrain")
+	}
+
+	admin := testClaims(now)
+	admin["realm_access"] = map[string]interface{}{"roles": []string{"offline_access", "neuprint-admin"}}
+	if decision, _ := provider.DatasetDecision(ecSigner.sign(t, admin), "secret", "", false); decision.Level() != ADMIN {
+		t.Error("expected the admin role claim to give admin access")
+	}
+
+	listed := testClaims(now)
+	listed["neuprint"] = map[string]interface{}{"datasets": []string{"manc:v1.2:manage"}}
+	if decision, _ := provider.DatasetDecision(ecSigner.sign(t, listed), "manc:v1.2", "", false); decision.Level() != READWRITE {
+		t.Error("expected a dataset:role list to be mapped")
+	}
+}
+
+func TestJWTProviderRejects(t *testing.T) {
+	signer := newRSASigner(t, "rsa-1")
+	server := httptest.NewServer(&jwksServer{data: jwksJSON(signer)})
+	defer server.Close()
+	provider := newTestJWTProvider(t, server.URL)
+	now := time.Now()
+
+	modify := func(change func(map[string]interface{})) string {
+		claims := testClaims(now)
+		change(claims)
+		return signer.sign(t, claims)
+	}
+	valid := signer.sign(t, testClaims(now))
+	parts := strings.Split(valid, ".")
+	unsigned := b64.EncodeToString([]byte(`{"alg":"none","kid":"rsa-1"}`)) + "." + parts[1] + "."
+	other := newRSASigner(t, "rsa-1").sign(t, testClaims(now))
+
+	for name, token := range map[string]string{
+		"issuer":         modify(func(c map[string]interface{}) { c["iss"] = "https://other.example.org" }),
+		"audience":       modify(func(c map[string]interface{}) { c["aud"] = "account" }),
+		"expired":        modify(func(c map[string]interface{}) { c["exp"] = now.Add(-2 * time.Minute).Unix() }),
+		"no expiry":      modify(func(c map[string]interface{}) { delete(c, "exp") }),
+		"not yet valid":  modify(func(c map[string]interface{}) { c["nbf"] = now.Add(5 * time.Minute).Unix() }),
+		"unsigned":       unsigned,
+		"wrong key":      other,
+		"tampered":       parts[0] + "." + b64.EncodeToString([]byte(`{"email":"root@example.org"}`)) + "." + parts[2],
+		"not a jwt":      "alice-token",
+		"unknown key id": newECSigner(t, "ec-9").sign(t, testClaims(now)),
+	} {
+		if identity, err := provider.Identity(token); identity != nil || err != nil {
+			t.Errorf("%s: expected the token to be rejected, got %+v (%v)", name, identity, err)
+		}
+	}
+
+	// within the allowed clock skew
+	skewed := modify(func(c map[string]interface{}) {
+		c["exp"] = now.Add(-30 * time.Second).Unix()
+		c["nbf"] = now.Add(30 * time.Second).Unix()
+	})
+	if identity, _ := provider.Identity(skewed); identity == nil {
+		t.Error("expected a token within the clock skew to be accepted")
+	}
+}
+
+func TestJWTProviderRotation(t *testing.T) {
+	old, next := newRSASigner(t, "2026-01"), newECSigner(t, "2026-02")
+	keys := &jwksServer{data: jwksJSON(old)}
+	server := httptest.NewServer(keys)
+	defer server.Close()
+	provider := newTestJWTProvider(t, server.URL)
+	clock := time.Now()
+	provider.now = func() time.Time { return clock }
+
+	if identity, _ := provider.Identity(old.sign(t, testClaims(clock))); identity == nil {
+		t.Fatal("expected the current key to be accepted")
+	}
+
+	// a token signed by a new key is accepted once the keys may be read again
+	keys.set(jwksJSON(old, next))
+	token := next.sign(t, testClaims(clock))
+	if identity, _ := provider.Identity(token); identity != nil {
+		t.Error("expected unknown keys not to be read again before the minimum refresh")
+	}
+	clock = clock.Add(2 * time.Minute)
+	if identity, _ := provider.Identity(token); identity == nil {
+		t.Error("expected the rotated key to be read without a restart")
+	}
+
+	// retired keys are dropped at the periodic refresh, and a failing key
+	// server leaves the current keys in place
+	keys.set(jwksJSON(next))
+	clock = clock.Add(2 * time.Hour)
+	if identity, _ := provider.Identity(old.sign(t, testClaims(clock))); identity != nil {
+		t.Error("expected the retired key to be rejected")
+	}
+	keys.set([]byte("not json"))
+	clock = clock.Add(2 * time.Hour)
+	if identity, _ := provider.Identity(next.sign(t, testClaims(clock))); identity == nil {
+		t.Error("expected the current keys to be kept when the key server fails")
+	}
+}
+
+func TestJWTProviderConcurrentRefresh(t *testing.T) {
+	old, next := newRSASigner(t, "2026-01"), newECSigner(t, "2026-02")
+	keys := &jwksServer{data: jwksJSON(old)}
+	server := httptest.NewServer(keys)
+	defer server.Close()
+	provider := newTestJWTProvider(t, server.URL)
+	clock := time.Now()
+	provider.now = func() time.Time { return clock }
+	known, rotated := old.sign(t, testClaims(clock)), next.sign(t, testClaims(clock))
+
+	// tokens of a new key wait for a single read of the keys
+	hold := make(chan struct{})
+	keys.mux.Lock()
+	keys.data, keys.hold, keys.reads = jwksJSON(old, next), hold, 0
+	keys.mux.Unlock()
+	clock = clock.Add(2 * time.Minute)
+	var wg sync.WaitGroup
+	accepted := make(chan bool, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			identity, _ := provider.Identity(rotated)
+			accepted <- identity != nil
+		}()
+	}
+
+	// known keys are served while the keys are read
+	for provider.refreshingKeys() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	if identity, _ := provider.Identity(known); identity == nil {
+		t.Error("expected the current key to be accepted during the read")
+	}
+	close(hold)
+	wg.Wait()
+	close(accepted)
+	for ok := range accepted {
+		if !ok {
+			t.Error("expected the rotated key to be accepted after the read")
+		}
+	}
+	if keys.reads != 1 {
+		t.Errorf("expected one read of the keys, got %d", keys.reads)
+	}
+}
+
+// refreshingKeys returns the channel of a read of the keys in progress
+func (p *JWTProvider) refreshingKeys() chan struct{} {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.refreshing
+}
+
+func TestJWTProviderFile(t *testing.T) {
+	signer := newECSigner(t, "")
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwksJSON(signer), 0600); err != nil {
+		t.Fatal(err)
+	}
+	provider, err := NewJWTProvider(JWTConfig{JWKSFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := testClaims(time.Now())
+	delete(claims, "email")
+	identity, _ := provider.Identity(signer.sign(t, claims))
+	if identity == nil || identity.Email != "1234" {
+		t.Errorf("expected the subject to identify a token without an email, got %+v", identity)
+	}
+
+	if _, err := NewJWTProvider(JWTConfig{JWKSFile: filepath.Join(t.TempDir(), "missing.json")}); err == nil {
+		t.Error("expected a missing key file to be an error")
+	}
+	if _, err := NewJWTProvider(JWTConfig{}); err == nil {
+		t.Error("expected a key source to be required")
+	}
+}
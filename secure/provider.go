@@ -0,0 +1,45 @@
+package secure
+
+import "reflect"
+
+// Authenticator validates bearer tokens.  A nil identity with a nil error
+// means the token is not valid.
+type Authenticator interface {
+	Identity(token string) (*DSGIdentity, error)
+	IdentityFresh(token string) (*DSGIdentity, error)
+}
+
+// Authorizer decides the access of a token, or of anonymous requests, to
+// neuPrint datasets.  Decisions use the DSG shape: "allow" with roles
+// (view, edit, manage, admin), "deny" or "tos_required".
+type Authorizer interface {
+	DatasetDecision(token, dataset, returnURL string, forceRefresh bool) (*DSGDecision, error)
+	AuthorizeDatasets(token string, datasets []string, returnURL string, forceRefresh bool) (map[string]*DSGDecision, error)
+	AnonymousDatasetDecision(dataset, returnURL string) (*DSGDecision, error)
+}
+
+// AuthProvider authenticates and authorizes requests.  The DSGClient is the
+// DatasetGateway provider; FileProvider and JWTProvider serve deployments
+// without one.
+type AuthProvider interface {
+	Authenticator
+	Authorizer
+}
+
+// missingProvider reports whether no provider was given, including a typed
+// nil such as a nil *DSGClient
+func missingProvider(provider AuthProvider) bool {
+	if provider == nil {
+		return true
+	}
+	value := reflect.ValueOf(provider)
+	return value.Kind() == reflect.Ptr && value.IsNil()
+}
+
+// providerService returns the service name access is checked for
+func providerService(provider interface{}) string {
+	if client, ok := provider.(*DSGClient); ok && client != nil {
+		return client.ServiceName
+	}
+	return "neuprint"
+}
@@ -17,9 +17,10 @@ type EchoSecure struct {
 }
 
 // InitializeEchoSecure configures HTTPS (manual cert or autocert) and
-// registers the DSG auth routes (login, logout, profile, token).
-// It no longer sets up sessions, OAuth, or JWT — DSG handles all of that.
-func InitializeEchoSecure(e *echo.Echo, sslCert, sslKey, hostname, dsgURL string, dsgClient *DSGClient) (*EchoSecure, error) {
+// registers the auth routes (profile, dataset-access and, when a DSG URL is
+// given, login, logout and token).
+// It no longer sets up sessions, OAuth, or JWT — the provider handles all of that.
+func InitializeEchoSecure(e *echo.Echo, sslCert, sslKey, hostname, dsgURL string, provider AuthProvider) (*EchoSecure, error) {
 	manCert := sslCert != "" && sslKey != ""
 
 	if !manCert {
@@ -31,11 +32,13 @@ func InitializeEchoSecure(e *echo.Echo, sslCert, sslKey, hostname, dsgURL string
 
 	// Register auth-related routes (no middleware — these must be accessible
 	// before authentication).
-	e.GET("/login", dsgLoginHandler(dsgURL))
-	e.POST("/logout", DSGAuthMiddleware(dsgClient)(dsgLogoutHandler(dsgURL)))
-	e.GET("/profile", DSGAuthMiddleware(dsgClient)(dsgProfileHandler))
-	e.GET("/dataset-access", DSGAuthMiddleware(dsgClient)(dsgDatasetAccessHandler))
-	e.GET("/token", DSGAuthMiddleware(dsgClient)(dsgTokenHandler(dsgURL)))
+	e.GET("/profile", DSGAuthMiddleware(provider)(dsgProfileHandler))
+	e.GET("/dataset-access", DSGAuthMiddleware(provider)(dsgDatasetAccessHandler))
+	if dsgURL != "" {
+		e.GET("/login", dsgLoginHandler(dsgURL))
+		e.POST("/logout", DSGAuthMiddleware(provider)(dsgLogoutHandler(dsgURL)))
+		e.GET("/token", DSGAuthMiddleware(provider)(dsgTokenHandler(dsgURL)))
+	}
 
 	return &EchoSecure{
 		e:       e,
@@ -0,0 +1,116 @@
+package skeleton
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// PrecomputedAttributes are the vertex attributes written after the edges
+// of a precomputed skeleton, as listed in the neuroglancer info file
+var PrecomputedAttributes = []map[string]interface{}{
+	{"id": "radius", "data_type": "float32", "num_components": 1},
+	{"id": "type", "data_type": "float32", "num_components": 1},
+}
+
+// nodeFields are the navis column names for the skeleton nodes
+var nodeFields = []arrow.Field{
+	{Name: "node_id", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "label", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "x", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "y", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "z", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "radius", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "parent_id", Type: arrow.PrimitiveTypes.Int64},
+}
+
+// appendNodes adds the nodes to the node columns starting at field first
+func (skel *Skeleton) appendNodes(builder *array.RecordBuilder, first int) {
+	for _, node := range skel.Nodes {
+		builder.Field(first).(*array.Int64Builder).Append(int64(node.ID))
+		builder.Field(first + 1).(*array.Int64Builder).Append(int64(node.Type))
+		builder.Field(first + 2).(*array.Float64Builder).Append(node.X)
+		builder.Field(first + 3).(*array.Float64Builder).Append(node.Y)
+		builder.Field(first + 4).(*array.Float64Builder).Append(node.Z)
+		builder.Field(first + 5).(*array.Float64Builder).Append(node.Radius)
+		builder.Field(first + 6).(*array.Int64Builder).Append(int64(node.Parent))
+	}
+}
+
+// ArrowRecord returns the nodes as a table with the column names used by
+// navis (node_id, label, x, y, z, radius, parent_id).  Header metadata is
+// kept in the schema metadata.
+func (skel *Skeleton) ArrowRecord(allocator memory.Allocator) arrow.Record {
+	keys := make([]string, 0, len(skel.Metadata))
+	for key := range skel.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = skel.Metadata[key]
+	}
+	metadata := arrow.NewMetadata(keys, values)
+
+	builder := array.NewRecordBuilder(allocator, arrow.NewSchema(nodeFields, &metadata))
+	defer builder.Release()
+	skel.appendNodes(builder, 0)
+	return builder.NewRecord()
+}
+
+// ArrowTable returns the nodes of several skeletons in one table, with a
+// bodyId column before the ArrowRecord columns
+func ArrowTable(allocator memory.Allocator, bodyIds []int64, skels []*Skeleton, metadata arrow.Metadata) arrow.Record {
+	fields := append([]arrow.Field{{Name: "bodyId", Type: arrow.PrimitiveTypes.Int64}}, nodeFields...)
+	builder := array.NewRecordBuilder(allocator, arrow.NewSchema(fields, &metadata))
+	defer builder.Release()
+	for i, skel := range skels {
+		for range skel.Nodes {
+			builder.Field(0).(*array.Int64Builder).Append(bodyIds[i])
+		}
+		skel.appendNodes(builder, 1)
+	}
+	return builder.NewRecord()
+}
+
+// WritePrecomputed writes the skeleton in the neuroglancer precomputed
+// skeleton format: vertex and edge counts, vertex positions, edges as
+// vertex index pairs (child, parent) and then the PrecomputedAttributes.
+func (skel *Skeleton) WritePrecomputed(w io.Writer) error {
+	index := make(map[int]uint32, len(skel.Nodes))
+	for i, node := range skel.Nodes {
+		if _, ok := index[node.ID]; ok {
+			return fmt.Errorf("duplicate sample id %d", node.ID)
+		}
+		index[node.ID] = uint32(i)
+	}
+	var edges []uint32
+	for i, node := range skel.Nodes {
+		if parent, ok := index[node.Parent]; ok && node.Parent != node.ID {
+			edges = append(edges, uint32(i), parent)
+		}
+	}
+
+	positions := make([]float32, 0, 3*len(skel.Nodes))
+	radii := make([]float32, 0, len(skel.Nodes))
+	types := make([]float32, 0, len(skel.Nodes))
+	for _, node := range skel.Nodes {
+		positions = append(positions, float32(node.X), float32(node.Y), float32(node.Z))
+		radii = append(radii, float32(node.Radius))
+		types = append(types, float32(node.Type))
+	}
+
+	for _, data := range []interface{}{
+		uint32(len(skel.Nodes)), uint32(len(edges) / 2), positions, edges, radii, types,
+	} {
+		if err := binary.Write(w, binary.LittleEndian, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
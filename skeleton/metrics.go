@@ -0,0 +1,153 @@
+package skeleton
+
+import (
+	"math"
+)
+
+// Metrics are morphology measures of a skeleton in the units of its
+// coordinates
+type Metrics struct {
+	Nodes         int                `json:"nodes"`
+	Roots         int                `json:"roots"`
+	CableLength   float64            `json:"cableLength"`
+	Branches      int                `json:"branches"`
+	Tips          int                `json:"tips"`
+	MaxPathLength float64            `json:"maxPathLength"`
+	StrahlerOrder int                `json:"strahlerOrder"`
+	Strahler      map[int]int        `json:"strahlerSegments"`
+	BoundingBox   [2][3]float64      `json:"boundingBox"`
+	Units         string             `json:"units,omitempty"`
+	ROICable      map[string]float64 `json:"roiCableLength,omitempty"`
+}
+
+// Region is a volume such as an ROI mesh
+type Region interface {
+	Contains(x, y, z float64) bool
+}
+
+func distance(a, b *Node) float64 {
+	dx, dy, dz := a.X-b.X, a.Y-b.Y, a.Z-b.Z
+	return math.Sqrt(dx*dx + dy*dy + dz*dz)
+}
+
+// Metrics computes the cable length, branch points (nodes with several
+// children), tips (nodes other than roots without children), the longest
+// path from a root, the bounding box and the number of segments between
+// branch points of each Strahler order.  Nodes not connected to a root are
+// ignored apart from the bounding box.
+func (skel *Skeleton) Metrics() *Metrics {
+	metrics := &Metrics{Nodes: len(skel.Nodes), Strahler: make(map[int]int), Units: skel.Metadata["units"]}
+	for i, node := range skel.Nodes {
+		for d, val := range []float64{node.X, node.Y, node.Z} {
+			if i == 0 || val < metrics.BoundingBox[0][d] {
+				metrics.BoundingBox[0][d] = val
+			}
+			if i == 0 || val > metrics.BoundingBox[1][d] {
+				metrics.BoundingBox[1][d] = val
+			}
+		}
+	}
+
+	order, parents, children := skel.tree()
+
+	// path lengths from the root, parents first
+	pathLength := make([]float64, len(skel.Nodes))
+	for _, i := range order {
+		if parents[i] < 0 {
+			metrics.Roots++
+			continue
+		}
+		length := skel.nodeDistance(i, parents[i])
+		metrics.CableLength += length
+		pathLength[i] = pathLength[parents[i]] + length
+		metrics.MaxPathLength = math.Max(metrics.MaxPathLength, pathLength[i])
+		if children[i] == 0 {
+			metrics.Tips++
+		}
+	}
+	for _, i := range order {
+		if children[i] > 1 {
+			metrics.Branches++
+		}
+	}
+
+	// Strahler orders, children first
+	strahler := make([]int, len(skel.Nodes))
+	highest := make([]int, len(skel.Nodes))
+	highestCount := make([]int, len(skel.Nodes))
+	for k := len(order) - 1; k >= 0; k-- {
+		i := order[k]
+		switch {
+		case children[i] == 0:
+			strahler[i] = 1
+		case highestCount[i] > 1:
+			strahler[i] = highest[i] + 1
+		default:
+			strahler[i] = highest[i]
+		}
+		if parent := parents[i]; parent >= 0 {
+			if strahler[i] > highest[parent] {
+				highest[parent], highestCount[parent] = strahler[i], 1
+			} else if strahler[i] == highest[parent] {
+				highestCount[parent]++
+			}
+		}
+	}
+	for _, i := range order {
+		parent := parents[i]
+		if parent < 0 {
+			metrics.StrahlerOrder = max(metrics.StrahlerOrder, strahler[i])
+			continue
+		}
+		// a segment starts below each root and branch point
+		if parents[parent] < 0 || children[parent] > 1 {
+			metrics.Strahler[strahler[i]]++
+		}
+	}
+	return metrics
+}
+
+// parents returns the index of the parent of each node reached in order,
+// or -1 for roots and unreached nodes
+func (skel *Skeleton) parents(order []int) []int {
+	index := make(map[int]int, len(skel.Nodes))
+	for _, i := range order {
+		index[skel.Nodes[i].ID] = i
+	}
+	parents := make([]int, len(skel.Nodes))
+	for i := range parents {
+		parents[i] = -1
+	}
+	for _, i := range order {
+		if parent, ok := index[skel.Nodes[i].Parent]; ok && skel.Nodes[i].Parent != skel.Nodes[i].ID {
+			parents[i] = parent
+		}
+	}
+	return parents
+}
+
+// RegionCable returns the cable length inside each region.  Each edge is
+// assigned to the first region (in the given order) containing its
+// midpoint.
+func (skel *Skeleton) RegionCable(names []string, regions []Region) map[string]float64 {
+	cable := make(map[string]float64, len(names))
+	for _, name := range names {
+		cable[name] = 0
+	}
+	order := skel.order()
+	parents := skel.parents(order)
+	for _, i := range order {
+		if parents[i] < 0 {
+			continue
+		}
+		child, parent := &skel.Nodes[i], &skel.Nodes[parents[i]]
+		x, y, z := (child.X+parent.X)/2, (child.Y+parent.Y)/2, (child.Z+parent.Z)/2
+		for r, region := range regions {
+			if region.Contains(x, y, z) {
+				cable[names[r]] += distance(child, parent)
+				break
+			}
+		}
+	}
+	return cable
+}
@@ -0,0 +1,117 @@
+package skeleton
+
+import (
+	"math"
+	"sort"
+)
+
+// nodeIndex is a k-d tree over skeleton nodes for nearest node queries.
+// Nodes can be removed, so queries only see the nodes left.  The tree is
+// stored implicitly: each range of order is split at its middle node.
+type nodeIndex struct {
+	skel  *Skeleton
+	order []int  // node indices in tree order
+	pos   []int  // position in order of each node
+	live  []int  // nodes left in the range split at each position
+	alive []bool // whether the node at each position is left
+}
+
+// newNodeIndex indexes the given nodes
+func newNodeIndex(skel *Skeleton, nodes []int) *nodeIndex {
+	index := &nodeIndex{
+		skel:  skel,
+		order: append([]int(nil), nodes...),
+		pos:   make([]int, len(skel.Nodes)),
+		live:  make([]int, len(nodes)),
+		alive: make([]bool, len(nodes)),
+	}
+	index.build(0, len(nodes), 0)
+	for i, node := range index.order {
+		index.pos[node] = i
+		index.alive[i] = true
+	}
+	return index
+}
+
+// coord returns a coordinate of a node by axis
+func (index *nodeIndex) coord(node, axis int) float64 {
+	n := &index.skel.Nodes[node]
+	switch axis {
+	case 0:
+		return n.X
+	case 1:
+		return n.Y
+	}
+	return n.Z
+}
+
+func (index *nodeIndex) build(lo, hi, depth int) {
+	if lo >= hi {
+		return
+	}
+	axis := depth % 3
+	nodes := index.order[lo:hi]
+	sort.Slice(nodes, func(i, j int) bool { return index.coord(nodes[i], axis) < index.coord(nodes[j], axis) })
+	mid := (lo + hi) / 2
+	index.live[mid] = hi - lo
+	index.build(lo, mid, depth+1)
+	index.build(mid+1, hi, depth+1)
+}
+
+// remove drops a node from the index
+func (index *nodeIndex) remove(node int) {
+	target := index.pos[node]
+	if !index.alive[target] {
+		return
+	}
+	index.alive[target] = false
+	lo, hi := 0, len(index.order)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		index.live[mid]--
+		if target == mid {
+			return
+		} else if target < mid {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+}
+
+// nearest returns the node left closest to a node and its distance, or -1
+// if no node is left
+func (index *nodeIndex) nearest(node int) (int, float64) {
+	best, bestDist := -1, math.Inf(1)
+	var search func(lo, hi, depth int)
+	search = func(lo, hi, depth int) {
+		if lo >= hi {
+			return
+		}
+		mid := (lo + hi) / 2
+		if index.live[mid] == 0 {
+			return
+		}
+		split := index.order[mid]
+		if index.alive[mid] {
+			if d := index.skel.nodeDistance(node, split); d < bestDist {
+				best, bestDist = split, d
+			}
+		}
+		axis := depth % 3
+		diff := index.coord(node, axis) - index.coord(split, axis)
+		if diff < 0 {
+			search(lo, mid, depth+1)
+			if -diff < bestDist {
+				search(mid+1, hi, depth+1)
+			}
+		} else {
+			search(mid+1, hi, depth+1)
+			if diff < bestDist {
+				search(lo, mid, depth+1)
+			}
+		}
+	}
+	search(0, len(index.order), 0)
+	return best, bestDist
+}
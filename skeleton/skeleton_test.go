@@ -0,0 +1,350 @@
+package skeleton
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+const testSWC = `# neuPrint skeleton
+# units: nm
+# coordinate_space = voxels
+
+1 1 10 20 30 5 -1
+2 3 11.5 21 31 2.5 1
+3 3 12 22 32 2 2
+4 2 13 19 29 1 1
+`
+
+func TestParseSWC(t *testing.T) {
+	skel, err := ParseSWC([]byte(testSWC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(skel.Header, []string{"neuPrint skeleton", "units: nm", "coordinate_space = voxels"}) {
+		t.Errorf("unexpected header: %v", skel.Header)
+	}
+	if !reflect.DeepEqual(skel.Metadata, map[string]string{"units": "nm", "coordinate_space": "voxels"}) {
+		t.Errorf("unexpected metadata: %v", skel.Metadata)
+	}
+	if len(skel.Nodes) != 4 {
+		t.Fatalf("expected 4 nodes, got %d", len(skel.Nodes))
+	}
+	if skel.Nodes[1] != (Node{ID: 2, Type: 3, X: 11.5, Y: 21, Z: 31, Radius: 2.5, Parent: 1}) {
+		t.Errorf("unexpected node: %+v", skel.Nodes[1])
+	}
+
+	table := skel.Table()
+	if !reflect.DeepEqual(table.Data[1], []interface{}{2, 11.5, 21.0, 31.0, 2.5, 1, 3}) {
+		t.Errorf("unexpected table row: %v", table.Data[1])
+	}
+	if table.Columns[6] != "type" || table.Metadata["units"] != "nm" {
+		t.Errorf("unexpected table: %+v", table)
+	}
+}
+
+func TestParseSWCErrors(t *testing.T) {
+	for swc, want := range map[string]string{
+		"1 1 0 0 0 1 -1\n2 1 0 0 1 -1\n": "line 2: expected 7 columns",
+		"# header\nx 1 0 0 0 1 -1\n":     "line 2: invalid sample id",
+		"1 1 0 0 zero 1 -1\n":            "line 1: invalid number",
+		"1 1 0 0 0 1 root\n":             "line 1: invalid parent id",
+	} {
+		_, err := ParseSWC([]byte(swc))
+		if err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("expected %q, got %v", want, err)
+		}
+	}
+}
+
+func TestParseStoredSWC(t *testing.T) {
+	// stored skeletons written before uploads were validated are still read
+	skel, err := ParseStoredSWC([]byte("1 1 0 0 nan? 1 -1.0\n2.0 x 1 0 0 1 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skel.Nodes) != 2 || skel.Nodes[0].Parent != -1 || skel.Nodes[0].Z != 0 ||
+		skel.Nodes[1].ID != 2 || skel.Nodes[1].Type != 0 || skel.Nodes[1].Parent != 1 {
+		t.Errorf("unexpected nodes %+v", skel.Nodes)
+	}
+	if _, err := ParseSWC([]byte("1 1 0 0 nan? 1 -1.0\n")); err == nil {
+		t.Error("expected uploads to be parsed strictly")
+	}
+	if _, err := ParseStoredSWC([]byte("1 1 0 0 0 1\n")); err == nil {
+		t.Error("expected a line without 7 columns to be an error")
+	}
+}
+
+func TestWriteSWCRoundTrip(t *testing.T) {
+	skel, err := ParseSWC([]byte(testSWC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := skel.WriteSWC(&buf); err != nil {
+		t.Fatal(err)
+	}
+	again, err := ParseSWC(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(skel.Header, again.Header) || !reflect.DeepEqual(skel.Metadata, again.Metadata) || !reflect.DeepEqual(skel.Nodes, again.Nodes) {
+		t.Errorf("round trip changed skeleton:\n%s", buf.String())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	skel, _ := ParseSWC([]byte(testSWC))
+	if problems := skel.Validate(1); len(problems) != 0 {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+
+	for swc, want := range map[string]string{
+		"1 1 0 0 0 1 -1\n1 1 0 0 1 1 -1\n":               "line 2: sample id 1 already used on line 1",
+		"1 1 0 0 0 1 -1\n2 1 0 0 1 1 5\n":                "line 2: parent 5 of node 2 does not exist",
+		"# two roots\n1 1 0 0 0 1 -1\n2 1 0 0 1 1 -1\n":  "line 3: found 2 roots, at most 1 allowed",
+		"1 1 0 0 0 1 -1\n2 1 0 0 1 1 3\n3 1 0 0 1 1 2\n": "line 2: node 2 is in or below a cycle",
+		"1 1 0 0 0 1 -1\n2 1 0 0 1 1 2\n":                "line 2: node 2 is its own parent",
+		"1 1 0 NaN 0 1 -1\n":                             "line 1: coordinates and radius must be finite",
+		"# empty\n":                                      "skeleton has no nodes",
+	} {
+		skel, err := ParseSWC([]byte(swc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		problems := skel.Validate(1)
+		if len(problems) == 0 || problems[0].Error() != want {
+			t.Errorf("expected %q, got %v", want, problems)
+		}
+	}
+
+	twoRoots, _ := ParseSWC([]byte("1 1 0 0 0 1 -1\n2 1 0 0 1 1 -1\n"))
+	if problems := twoRoots.Validate(2); len(problems) != 0 {
+		t.Errorf("unexpected problems with two roots allowed: %v", problems)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	skel, _ := ParseSWC([]byte("# uploaded_by: old@example.com\n# units: nm\n7 1 1 0 0 1 9\n9 1 0 0 0 1 -1\n8 1 2 0 0 1 7\n"))
+	skel.Renumber()
+	skel.Stamp("user@example.com", time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	want := []Node{
+		{ID: 1, Type: 1, X: 0, Radius: 1, Parent: -1},
+		{ID: 2, Type: 1, X: 1, Radius: 1, Parent: 1},
+		{ID: 3, Type: 1, X: 2, Radius: 1, Parent: 2},
+	}
+	if !reflect.DeepEqual(skel.Nodes, want) {
+		t.Errorf("unexpected nodes: %+v", skel.Nodes)
+	}
+	if !reflect.DeepEqual(skel.Header, []string{"uploaded_by: user@example.com", "units: nm", "uploaded_at: 2024-05-01T12:00:00Z"}) {
+		t.Errorf("unexpected header: %v", skel.Header)
+	}
+	if skel.Metadata["uploaded_by"] != "user@example.com" {
+		t.Errorf("unexpected metadata: %v", skel.Metadata)
+	}
+}
+
+// box is a Region bounded by two corners
+type box [2][3]float64
+
+func (b box) Contains(x, y, z float64) bool {
+	return x >= b[0][0] && x <= b[1][0] && y >= b[0][1] && y <= b[1][1] && z >= b[0][2] && z <= b[1][2]
+}
+
+func TestMetrics(t *testing.T) {
+	skel, _ := ParseSWC([]byte(testSWC))
+	metrics := skel.Metrics()
+
+	cable := math.Sqrt(4.25) + 1.5 + math.Sqrt(11)
+	if math.Abs(metrics.CableLength-cable) > 1e-9 || math.Abs(metrics.MaxPathLength-(math.Sqrt(4.25)+1.5)) > 1e-9 {
+		t.Errorf("unexpected lengths: %+v", metrics)
+	}
+	if metrics.Nodes != 4 || metrics.Roots != 1 || metrics.Branches != 1 || metrics.Tips != 2 {
+		t.Errorf("unexpected counts: %+v", metrics)
+	}
+	if metrics.StrahlerOrder != 2 || !reflect.DeepEqual(metrics.Strahler, map[int]int{1: 2}) {
+		t.Errorf("unexpected Strahler orders: %+v", metrics)
+	}
+	if metrics.BoundingBox != [2][3]float64{{10, 19, 29}, {13, 22, 32}} || metrics.Units != "nm" {
+		t.Errorf("unexpected bounding box: %+v", metrics)
+	}
+
+	// two branches of order 2 join at the root
+	forked, _ := ParseSWC([]byte("1 1 0 0 0 1 -1\n2 1 1 0 0 1 1\n3 1 2 0 0 1 2\n4 1 2 1 0 1 2\n5 1 -1 0 0 1 1\n6 1 -2 0 0 1 5\n7 1 -2 1 0 1 5\n"))
+	metrics = forked.Metrics()
+	if metrics.StrahlerOrder != 3 || !reflect.DeepEqual(metrics.Strahler, map[int]int{1: 4, 2: 2}) {
+		t.Errorf("unexpected Strahler orders: %+v", metrics)
+	}
+
+	regions := []Region{box{{9, 20, 30}, {12, 23, 33}}, box{{0, 0, 0}, {100, 100, 100}}}
+	roiCable := skel.RegionCable([]string{"a", "b"}, regions)
+	if math.Abs(roiCable["a"]-(math.Sqrt(4.25)+1.5)) > 1e-9 || math.Abs(roiCable["b"]-math.Sqrt(11)) > 1e-9 {
+		t.Errorf("unexpected ROI cable: %v", roiCable)
+	}
+}
+
+func nodeIds(skel *Skeleton) [][2]int {
+	var ids [][2]int
+	for _, node := range skel.Nodes {
+		ids = append(ids, [2]int{node.ID, node.Parent})
+	}
+	return ids
+}
+
+func TestDownsample(t *testing.T) {
+	// a straight line of 6 unit edges with a branch at node 3
+	skel, _ := ParseSWC([]byte("1 1 0 0 0 1 -1\n2 1 1 0 0 1 1\n3 1 2 0 0 1 2\n4 1 3 0 0 1 3\n5 1 4 0 0 1 4\n6 1 5 0 0 1 5\n7 1 6 0 0 1 6\n8 1 2 1 0 1 3\n"))
+	skel.Downsample(2)
+	want := [][2]int{{1, -1}, {3, 1}, {5, 3}, {7, 5}, {8, 3}}
+	if got := nodeIds(skel); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected downsampled nodes: %v", got)
+	}
+}
+
+func TestPruneTwigs(t *testing.T) {
+	// a long main branch with a twig of length 1 and a branch of length 3
+	skel, _ := ParseSWC([]byte("1 1 0 0 0 1 -1\n2 1 1 0 0 1 1\n3 1 2 0 0 1 2\n4 1 1 1 0 1 2\n5 1 1 0 1 1 2\n6 1 1 0 2 1 5\n7 1 1 0 4 1 6\n"))
+	skel.PruneTwigs(2)
+	want := [][2]int{{1, -1}, {2, 1}, {5, 2}, {6, 5}, {7, 6}}
+	if got := nodeIds(skel); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected pruned nodes: %v", got)
+	}
+
+	line, _ := ParseSWC([]byte("1 1 0 0 0 1 -1\n2 1 1 0 0 1 1\n"))
+	line.PruneTwigs(5)
+	if len(line.Nodes) != 2 {
+		t.Errorf("unbranched skeleton was pruned: %v", nodeIds(line))
+	}
+}
+
+func TestHeal(t *testing.T) {
+	// three fragments; the single node 6 is closest to node 5
+	skel, _ := ParseSWC([]byte("1 1 0 0 0 1 -1\n2 1 1 0 0 1 1\n3 1 2 0 0 1 2\n4 1 6 0 0 1 -1\n5 1 5 0 0 1 4\n6 1 5 1 0 1 -1\n"))
+	skel.Heal()
+	want := [][2]int{{1, -1}, {2, 1}, {3, 2}, {4, 5}, {5, 3}, {6, 5}}
+	if got := nodeIds(skel); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected healed nodes: %v", got)
+	}
+	if problems := skel.Validate(1); len(problems) != 0 {
+		t.Errorf("healed skeleton is invalid: %v", problems)
+	}
+}
+
+func TestHealSpanningTree(t *testing.T) {
+	// random fragments of short chains; the added cable is the weight of
+	// the minimum spanning tree over the fragments
+	random := rand.New(rand.NewSource(1))
+	var swc strings.Builder
+	var fragments [][]Node
+	id := 0
+	for f := 0; f < 200; f++ {
+		var nodes []Node
+		parent, size := -1, 1+random.Intn(4)
+		for i := 0; i < size; i++ {
+			id++
+			node := Node{X: random.Float64() * 1000, Y: random.Float64() * 1000, Z: random.Float64() * 100}
+			fmt.Fprintf(&swc, "%d 0 %v %v %v 1 %d\n", id, node.X, node.Y, node.Z, parent)
+			nodes = append(nodes, node)
+			parent = id
+		}
+		fragments = append(fragments, nodes)
+	}
+	skel, err := ParseSWC([]byte(swc.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := skel.Metrics().CableLength
+	skel.Heal()
+	if problems := skel.Validate(1); len(problems) != 0 {
+		t.Fatalf("healed skeleton is invalid: %v", problems)
+	}
+
+	gap := func(a, b []Node) float64 {
+		best := math.Inf(1)
+		for i := range a {
+			for j := range b {
+				best = math.Min(best, distance(&a[i], &b[j]))
+			}
+		}
+		return best
+	}
+	joined := map[int]bool{0: true}
+	weight := 0.0
+	for len(joined) < len(fragments) {
+		next, best := -1, math.Inf(1)
+		for f := range fragments {
+			for g := range joined {
+				if d := gap(fragments[f], fragments[g]); !joined[f] && d < best {
+					next, best = f, d
+				}
+			}
+		}
+		joined[next] = true
+		weight += best
+	}
+	if added := skel.Metrics().CableLength - before; math.Abs(added-weight) > 1e-6 {
+		t.Errorf("healing added %f of cable, want %f", added, weight)
+	}
+}
+
+func TestArrowRecord(t *testing.T) {
+	skel, _ := ParseSWC([]byte(testSWC))
+	record := skel.ArrowRecord(memory.DefaultAllocator)
+	defer record.Release()
+
+	if record.NumRows() != 4 || record.ColumnName(1) != "label" || record.ColumnName(6) != "parent_id" {
+		t.Fatalf("unexpected schema: %v", record.Schema())
+	}
+	if got := record.Column(6).(*array.Int64).Int64Values(); !reflect.DeepEqual(got, []int64{-1, 1, 2, 1}) {
+		t.Errorf("unexpected parents: %v", got)
+	}
+	if idx := record.Schema().Metadata().FindKey("units"); idx < 0 || record.Schema().Metadata().Values()[idx] != "nm" {
+		t.Errorf("units missing from schema metadata")
+	}
+}
+
+func TestWritePrecomputed(t *testing.T) {
+	skel, _ := ParseSWC([]byte(testSWC))
+	var buf bytes.Buffer
+	if err := skel.WritePrecomputed(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var counts [2]uint32
+	binary.Read(&buf, binary.LittleEndian, &counts)
+	if counts != [2]uint32{4, 3} {
+		t.Fatalf("unexpected counts: %v", counts)
+	}
+	positions := make([]float32, 12)
+	binary.Read(&buf, binary.LittleEndian, positions)
+	if positions[3] != 11.5 {
+		t.Errorf("unexpected positions: %v", positions)
+	}
+	edges := make([]uint32, 6)
+	binary.Read(&buf, binary.LittleEndian, edges)
+	if !reflect.DeepEqual(edges, []uint32{1, 0, 2, 1, 3, 0}) {
+		t.Errorf("unexpected edges: %v", edges)
+	}
+	radii := make([]float32, 4)
+	types := make([]float32, 4)
+	binary.Read(&buf, binary.LittleEndian, radii)
+	binary.Read(&buf, binary.LittleEndian, types)
+	if radii[1] != 2.5 || types[3] != 2 || buf.Len() != 0 {
+		t.Errorf("unexpected attributes: %v %v (%d bytes left)", radii, types, buf.Len())
+	}
+
+	dup := &Skeleton{Nodes: []Node{{ID: 1, Parent: -1}, {ID: 1, Parent: -1}}}
+	if err := dup.WritePrecomputed(&buf); err == nil {
+		t.Error("expected error for duplicate ids")
+	}
+}
@@ -0,0 +1,200 @@
+/*
+   Parses, writes and converts neuron skeletons stored as SWC.
+*/
+
+package skeleton
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Node is one sample point of an SWC skeleton.  Parent is -1 for roots.
+type Node struct {
+	ID     int
+	Type   int
+	X      float64
+	Y      float64
+	Z      float64
+	Radius float64
+	Parent int
+}
+
+// Skeleton is a parsed SWC file.  Header holds the comment lines without
+// the leading '#' and Metadata the header lines of the form "key: value" or
+// "key = value", such as units or the coordinate space.
+type Skeleton struct {
+	Header   []string
+	Metadata map[string]string
+	Nodes    []Node
+
+	// lines holds the line number of each node in the parsed file
+	lines []int
+}
+
+// SWCError is a problem found at a line of an SWC file.  Line is 0 for
+// problems with the file as a whole.
+type SWCError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+func (e *SWCError) Error() string {
+	if e.Line == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// lineError returns an SWCError for a line
+func lineError(line int, format string, args ...interface{}) *SWCError {
+	return &SWCError{line, fmt.Sprintf(format, args...)}
+}
+
+// Columns are the column names of the node table.  The type column follows
+// the original six so positional readers are unaffected.
+var Columns = []string{"rowId", "x", "y", "z", "radius", "link", "type"}
+
+// Table is the JSON node table for a skeleton
+type Table struct {
+	Columns  []string          `json:"columns"`
+	Data     [][]interface{}   `json:"data"`
+	Header   []string          `json:"header,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// parseMetadata splits a header line into a key and value
+func parseMetadata(line string) (string, string, bool) {
+	idx := strings.IndexAny(line, ":=")
+	if idx <= 0 {
+		return "", "", false
+	}
+	key := strings.TrimSpace(line[:idx])
+	value := strings.TrimSpace(line[idx+1:])
+	if key == "" || value == "" || strings.ContainsAny(key, " \t") {
+		return "", "", false
+	}
+	return strings.ToLower(key), value, true
+}
+
+// ParseSWC parses an SWC file.  Errors are SWCErrors giving the line of the
+// problem.
+func ParseSWC(data []byte) (*Skeleton, error) {
+	return parseSWC(data, true)
+}
+
+// ParseStoredSWC parses an SWC file read from storage, which may predate
+// upload validation.  Numbers that do not parse are read as 0, as stored
+// skeletons have always been served; a line without 7 columns is still an
+// error.
+func ParseStoredSWC(data []byte) (*Skeleton, error) {
+	return parseSWC(data, false)
+}
+
+// lenientInt reads an integer column, accepting a number written with a
+// fraction and reading anything else as 0
+func lenientInt(entry string) int {
+	if value, err := strconv.Atoi(entry); err == nil {
+		return value
+	}
+	value, _ := strconv.ParseFloat(entry, 64)
+	return int(value)
+}
+
+// parseSWC parses an SWC file, rejecting numbers that do not parse if
+// strict is set
+func parseSWC(data []byte, strict bool) (*Skeleton, error) {
+	skel := &Skeleton{Metadata: make(map[string]string)}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line[0] == '#' {
+			comment := strings.TrimSpace(line[1:])
+			skel.Header = append(skel.Header, comment)
+			if key, value, ok := parseMetadata(comment); ok {
+				skel.Metadata[key] = value
+			}
+			continue
+		}
+
+		entries := strings.Fields(line)
+		if len(entries) != 7 {
+			return nil, lineError(lineno, "expected 7 columns, found %d", len(entries))
+		}
+		var node Node
+		coords := []*float64{&node.X, &node.Y, &node.Z, &node.Radius}
+		if !strict {
+			node.ID = lenientInt(entries[0])
+			node.Type = lenientInt(entries[1])
+			for i, coord := range coords {
+				*coord, _ = strconv.ParseFloat(entries[2+i], 64)
+			}
+			node.Parent = lenientInt(entries[6])
+			skel.Nodes = append(skel.Nodes, node)
+			skel.lines = append(skel.lines, lineno)
+			continue
+		}
+
+		var err error
+		if node.ID, err = strconv.Atoi(entries[0]); err != nil {
+			return nil, lineError(lineno, "invalid sample id %q", entries[0])
+		}
+		if node.Type, err = strconv.Atoi(entries[1]); err != nil {
+			return nil, lineError(lineno, "invalid node type %q", entries[1])
+		}
+		for i, coord := range coords {
+			if *coord, err = strconv.ParseFloat(entries[2+i], 64); err != nil {
+				return nil, lineError(lineno, "invalid number %q", entries[2+i])
+			}
+		}
+		if node.Parent, err = strconv.Atoi(entries[6]); err != nil {
+			return nil, lineError(lineno, "invalid parent id %q", entries[6])
+		}
+		skel.Nodes = append(skel.Nodes, node)
+		skel.lines = append(skel.lines, lineno)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, lineError(lineno+1, "%v", err)
+	}
+	return skel, nil
+}
+
+// WriteSWC writes the skeleton, including its header, as SWC
+func (skel *Skeleton) WriteSWC(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	for _, line := range skel.Header {
+		fmt.Fprintf(buf, "# %s\n", line)
+	}
+	for _, node := range skel.Nodes {
+		fmt.Fprintf(buf, "%d %d %s %s %s %s %d\n", node.ID, node.Type,
+			strconv.FormatFloat(node.X, 'f', -1, 64),
+			strconv.FormatFloat(node.Y, 'f', -1, 64),
+			strconv.FormatFloat(node.Z, 'f', -1, 64),
+			strconv.FormatFloat(node.Radius, 'f', -1, 64),
+			node.Parent)
+	}
+	return buf.Flush()
+}
+
+// Table returns the skeleton as a JSON node table
+func (skel *Skeleton) Table() *Table {
+	data := make([][]interface{}, 0, len(skel.Nodes))
+	for _, node := range skel.Nodes {
+		data = append(data, []interface{}{node.ID, node.X, node.Y, node.Z, node.Radius, node.Parent, node.Type})
+	}
+	table := &Table{Columns: Columns, Data: data, Header: skel.Header}
+	if len(skel.Metadata) > 0 {
+		table.Metadata = skel.Metadata
+	}
+	return table
+}
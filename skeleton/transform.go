@@ -0,0 +1,223 @@
+package skeleton
+
+import (
+	"container/heap"
+)
+
+// tree returns the nodes reached from the roots (parents first), the parent
+// index of each node (-1 for roots) and the number of children of each node
+func (skel *Skeleton) tree() ([]int, []int, []int) {
+	order := skel.order()
+	parents := skel.parents(order)
+	children := make([]int, len(skel.Nodes))
+	for _, i := range order {
+		if parents[i] >= 0 {
+			children[parents[i]]++
+		}
+	}
+	return order, parents, children
+}
+
+// keep removes the nodes not kept, attaching each kept node to its nearest
+// kept ancestor.  Nodes not reached from a root are always kept.
+func (skel *Skeleton) keep(order, parents []int, keep []bool) {
+	reached := make([]bool, len(skel.Nodes))
+	ancestor := make([]int, len(skel.Nodes))
+	for _, i := range order {
+		reached[i] = true
+		parent := parents[i]
+		switch {
+		case parent < 0:
+			ancestor[i] = -1
+		case keep[parent]:
+			ancestor[i] = parent
+		default:
+			ancestor[i] = ancestor[parent]
+		}
+	}
+
+	nodes := make([]Node, 0, len(skel.Nodes))
+	lines := make([]int, 0, len(skel.Nodes))
+	for i, node := range skel.Nodes {
+		if reached[i] && !keep[i] {
+			continue
+		}
+		if reached[i] && ancestor[i] >= 0 {
+			node.Parent = skel.Nodes[ancestor[i]].ID
+		}
+		nodes = append(nodes, node)
+		lines = append(lines, skel.line(i))
+	}
+	skel.Nodes = nodes
+	skel.lines = lines
+}
+
+// Downsample removes nodes along unbranched paths, keeping roots, branch
+// points, tips and a node whenever the path length from the previous kept
+// node reaches distance
+func (skel *Skeleton) Downsample(distance float64) {
+	order, parents, children := skel.tree()
+	keep := make([]bool, len(skel.Nodes))
+	since := make([]float64, len(skel.Nodes))
+	for _, i := range order {
+		parent := parents[i]
+		if parent < 0 {
+			keep[i] = true
+			continue
+		}
+		since[i] = skel.nodeDistance(i, parent)
+		if !keep[parent] {
+			since[i] += since[parent]
+		}
+		keep[i] = children[i] != 1 || since[i] >= distance
+	}
+	skel.keep(order, parents, keep)
+}
+
+// nodeDistance is the distance between two nodes given by index
+func (skel *Skeleton) nodeDistance(a, b int) float64 {
+	return distance(&skel.Nodes[a], &skel.Nodes[b])
+}
+
+// PruneTwigs removes terminal branches shorter than length, measured from
+// the tip to the branch point they leave.  Unbranched skeletons are left
+// unchanged.
+func (skel *Skeleton) PruneTwigs(length float64) {
+	order, parents, children := skel.tree()
+	keep := make([]bool, len(skel.Nodes))
+	for i := range keep {
+		keep[i] = true
+	}
+	for _, tip := range order {
+		if children[tip] != 0 || parents[tip] < 0 {
+			continue
+		}
+		twig := []int{tip}
+		total := 0.0
+		node := tip
+		for {
+			parent := parents[node]
+			total += skel.nodeDistance(node, parent)
+			if children[parent] > 1 || total >= length {
+				break
+			}
+			if parents[parent] < 0 {
+				// reached the root without leaving a branch point
+				total = length
+				break
+			}
+			twig = append(twig, parent)
+			node = parent
+		}
+		if total < length {
+			for _, i := range twig {
+				keep[i] = false
+			}
+		}
+	}
+	skel.keep(order, parents, keep)
+}
+
+// Heal joins the fragments of a skeleton with several roots into one tree.
+// Starting from the largest fragment, the closest remaining fragment is
+// attached by an edge between its nearest nodes, which makes the joining
+// node the new root of that fragment (a minimum spanning tree over the
+// fragments).  Nearest nodes are found with a k-d tree over the nodes of
+// the fragments not yet joined.
+func (skel *Skeleton) Heal() {
+	order, parents, _ := skel.tree()
+	fragment := make([]int, len(skel.Nodes))
+	var members [][]int
+	for _, i := range order {
+		if parents[i] < 0 {
+			fragment[i] = len(members)
+			members = append(members, nil)
+		} else {
+			fragment[i] = fragment[parents[i]]
+		}
+		members[fragment[i]] = append(members[fragment[i]], i)
+	}
+	if len(members) < 2 {
+		return
+	}
+
+	main := 0
+	for f := range members {
+		if len(members[f]) > len(members[main]) {
+			main = f
+		}
+	}
+
+	// links holds, for each joined node, the closest node of a fragment not
+	// yet joined when it was last looked up, which can only get farther as
+	// fragments join
+	index := newNodeIndex(skel, order)
+	joined := make([]bool, len(members))
+	links := &linkHeap{}
+	update := func(from int) {
+		if to, dist := index.nearest(from); to >= 0 {
+			heap.Push(links, link{dist, from, to})
+		}
+	}
+	join := func(f int) {
+		joined[f] = true
+		for _, node := range members[f] {
+			index.remove(node)
+		}
+		for _, node := range members[f] {
+			update(node)
+		}
+	}
+	join(main)
+
+	for remaining := len(members) - 1; remaining > 0; {
+		next := heap.Pop(links).(link)
+		if !joined[fragment[next.to]] {
+			// reverse the parent links from the joining node to the old root
+			prev, node := next.from, next.to
+			for node >= 0 {
+				parent := parents[node]
+				skel.Nodes[node].Parent = skel.Nodes[prev].ID
+				prev, node = node, parent
+			}
+			join(fragment[next.to])
+			remaining--
+		}
+		update(next.from)
+	}
+}
+
+// link is a candidate edge joining a fragment while healing
+type link struct {
+	dist     float64
+	from, to int
+}
+
+// linkHeap orders links by distance
+type linkHeap []link
+
+func (h linkHeap) Len() int            { return len(h) }
+func (h linkHeap) Less(i, j int) bool  { return h[i].dist < h[j].dist }
+func (h linkHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *linkHeap) Push(x interface{}) { *h = append(*h, x.(link)) }
+func (h *linkHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// Scale multiplies the coordinates by factor and the radii by radius, and
+// records the new units in the header
+func (skel *Skeleton) Scale(factor [3]float64, radius float64, units string) {
+	for i := range skel.Nodes {
+		node := &skel.Nodes[i]
+		node.X *= factor[0]
+		node.Y *= factor[1]
+		node.Z *= factor[2]
+		node.Radius *= radius
+	}
+	if units != "" {
+		skel.SetHeader("units", units)
+	}
+}
@@ -0,0 +1,177 @@
+package skeleton
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// line returns the file line of node i, or 0 if the skeleton was not parsed
+func (skel *Skeleton) line(i int) int {
+	if i < len(skel.lines) {
+		return skel.lines[i]
+	}
+	return 0
+}
+
+// Roots returns the indices of the nodes without a parent
+func (skel *Skeleton) Roots() []int {
+	var roots []int
+	for i, node := range skel.Nodes {
+		if node.Parent == -1 {
+			roots = append(roots, i)
+		}
+	}
+	return roots
+}
+
+// Validate checks that the sample ids are unique, every parent exists, there
+// are at most maxRoots roots, the tree has no cycles and the coordinates and
+// radii are finite.  Every problem found is returned.
+func (skel *Skeleton) Validate(maxRoots int) []*SWCError {
+	var problems []*SWCError
+	if len(skel.Nodes) == 0 {
+		return []*SWCError{{0, "skeleton has no nodes"}}
+	}
+
+	index := make(map[int]int, len(skel.Nodes))
+	for i, node := range skel.Nodes {
+		if first, ok := index[node.ID]; ok {
+			problems = append(problems, lineError(skel.line(i), "sample id %d already used on line %d", node.ID, skel.line(first)))
+			continue
+		}
+		index[node.ID] = i
+	}
+
+	for i, node := range skel.Nodes {
+		for _, val := range []float64{node.X, node.Y, node.Z, node.Radius} {
+			if math.IsNaN(val) || math.IsInf(val, 0) {
+				problems = append(problems, lineError(skel.line(i), "coordinates and radius must be finite"))
+				break
+			}
+		}
+		if node.Parent == node.ID {
+			problems = append(problems, lineError(skel.line(i), "node %d is its own parent", node.ID))
+		} else if _, ok := index[node.Parent]; !ok && node.Parent != -1 {
+			problems = append(problems, lineError(skel.line(i), "parent %d of node %d does not exist", node.Parent, node.ID))
+		}
+	}
+
+	roots := skel.Roots()
+	if len(roots) == 0 {
+		problems = append(problems, &SWCError{0, "skeleton has no root (parent -1)"})
+	} else if len(roots) > maxRoots {
+		problems = append(problems, lineError(skel.line(roots[maxRoots]), "found %d roots, at most %d allowed", len(roots), maxRoots))
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+
+	// every node must be reached from a root, otherwise it hangs from a cycle
+	reached := make([]bool, len(skel.Nodes))
+	for _, i := range skel.order() {
+		reached[i] = true
+	}
+	for i, node := range skel.Nodes {
+		if !reached[i] {
+			problems = append(problems, lineError(skel.line(i), "node %d is in or below a cycle", node.ID))
+			break
+		}
+	}
+	return problems
+}
+
+// order returns the node indices reached from the roots in depth first
+// order, parents before children and siblings in file order
+func (skel *Skeleton) order() []int {
+	index := make(map[int]int, len(skel.Nodes))
+	for i, node := range skel.Nodes {
+		if _, ok := index[node.ID]; !ok {
+			index[node.ID] = i
+		}
+	}
+	children := make(map[int][]int)
+	for i, node := range skel.Nodes {
+		if parent, ok := index[node.Parent]; ok && node.Parent != node.ID {
+			children[parent] = append(children[parent], i)
+		}
+	}
+
+	visited := make([]bool, len(skel.Nodes))
+	order := make([]int, 0, len(skel.Nodes))
+	for _, root := range skel.Roots() {
+		stack := []int{root}
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if visited[i] {
+				continue
+			}
+			visited[i] = true
+			order = append(order, i)
+			kids := children[i]
+			for k := len(kids) - 1; k >= 0; k-- {
+				stack = append(stack, kids[k])
+			}
+		}
+	}
+	return order
+}
+
+// Renumber numbers the nodes 1..n in depth first order from the roots so
+// every parent precedes its children.  The skeleton must be valid.
+func (skel *Skeleton) Renumber() {
+	order := skel.order()
+	newID := make(map[int]int, len(order))
+	nodes := make([]Node, 0, len(order))
+	lines := make([]int, 0, len(order))
+	for _, i := range order {
+		node := skel.Nodes[i]
+		newID[node.ID] = len(nodes) + 1
+		node.ID = len(nodes) + 1
+		if node.Parent != -1 {
+			node.Parent = newID[node.Parent]
+		}
+		nodes = append(nodes, node)
+		lines = append(lines, skel.line(i))
+	}
+	skel.Nodes = nodes
+	skel.lines = lines
+}
+
+// SetHeader sets a "key: value" header line and the metadata entry,
+// replacing an existing line for the key
+func (skel *Skeleton) SetHeader(key, value string) {
+	line := fmt.Sprintf("%s: %s", key, value)
+	if skel.Metadata == nil {
+		skel.Metadata = make(map[string]string)
+	}
+	skel.Metadata[key] = value
+	for i, existing := range skel.Header {
+		if name, _, ok := parseMetadata(existing); ok && name == key {
+			skel.Header[i] = line
+			return
+		}
+	}
+	skel.Header = append(skel.Header, line)
+}
+
+// Stamp records who uploaded the skeleton and when in the header, replacing
+// any earlier provenance entries
+func (skel *Skeleton) Stamp(uploader string, when time.Time) {
+	if uploader == "" {
+		uploader = "unknown"
+	}
+	skel.SetHeader("uploaded_by", uploader)
+	skel.SetHeader("uploaded_at", when.UTC().Format(time.RFC3339))
+}
+
+// JoinErrors formats validation problems as a single message
+func JoinErrors(problems []*SWCError) string {
+	messages := make([]string, len(problems))
+	for i, problem := range problems {
+		messages[i] = problem.Error()
+	}
+	return strings.Join(messages, "; ")
+}
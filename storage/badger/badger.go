@@ -132,3 +132,19 @@ func (s *Store) Get(key []byte) ([]byte, error) {
 
 	return valCopy, err
 }
+
+// Keys lists the keys without reading their values
+func (s *Store) Keys() ([]string, error) {
+	var keys []string
+	err := s.db.View(func(txn *badgerdb.Txn) error {
+		opts := badgerdb.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, string(it.Item().Key()))
+		}
+		return nil
+	})
+	return keys, err
+}
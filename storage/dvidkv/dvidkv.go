@@ -2,6 +2,7 @@ package dvidkv
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/blang/semver"
 	"github.com/connectome-neuprint/neuPrintHTTP/storage"
@@ -156,6 +157,9 @@ func (s *Store) Get(key []byte) ([]byte, error) {
 		return nil, fmt.Errorf("request failed")
 	}
 	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, storage.ErrKeyNotFound
+	}
 	body, err := ioutil.ReadAll(res.Body)
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
 		if len(body) > 0 {
@@ -170,3 +174,33 @@ func (s *Store) Get(key []byte) ([]byte, error) {
 
 	return body, err
 }
+
+// Keys lists the keys of the DVID instance
+func (s *Store) Keys() ([]string, error) {
+	dvidClient := http.Client{
+		Timeout: time.Second * 60,
+	}
+
+	url := s.config.Server + "/api/node/" + s.config.Branch + "/" + s.config.Instance + "/keys"
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed")
+	}
+	if s.config.Token != "" {
+		req.Header.Add("Authorization", "Bearer "+s.config.Token)
+	}
+
+	res, err := dvidClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed")
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("request failed")
+	}
+	var keys []string
+	if err := json.NewDecoder(res.Body).Decode(&keys); err != nil {
+		return nil, fmt.Errorf("keys not formatted correctly: %v", err)
+	}
+	return keys, nil
+}
@@ -25,6 +25,15 @@ type CypherWrapper struct {
 }
 
 func (cw *CypherWrapper) CypherRequest(query string, readonly bool) (CypherResult, error) {
+	return cw.mainStore.CypherRequest(cw.datasetQuery(query), readonly)
+}
+
+func (cw *CypherWrapper) CypherRequestWithParams(query string, params map[string]interface{}, readonly bool) (CypherResult, error) {
+	return cw.mainStore.CypherRequestWithParams(cw.datasetQuery(query), params, readonly)
+}
+
+// datasetQuery rewrites generic labels to the dataset specific labels
+func (cw *CypherWrapper) datasetQuery(query string) string {
 	// if a dataset is provided, add dataset keyword in queries
 	if cw.dataset != "" {
 		// extract root dataset name
@@ -57,7 +66,7 @@ func (cw *CypherWrapper) CypherRequest(query string, readonly bool) (CypherResul
 		query = strings.Replace(query, ":XSynapsesTo", ":SynapsesTo", -1)
 	}
 
-	return cw.mainStore.CypherRequest(query, readonly)
+	return query
 }
 
 func (cw *CypherWrapper) StartTrans() (CypherTransaction, error) {
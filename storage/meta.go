@@ -0,0 +1,39 @@
+package storage
+
+import "encoding/json"
+
+// LastEditQuery fetches the dataset modification stamp
+const LastEditQuery = "MATCH (m :Meta) RETURN m.lastDatabaseEdit"
+
+// LastEdit returns the lastDatabaseEdit stamp of the dataset, which results
+// computed from it are cached against
+func LastEdit(cypher Cypher) (string, error) {
+	res, err := cypher.CypherRequest(LastEditQuery, true)
+	if err != nil {
+		return "", err
+	}
+	if len(res.Data) == 0 || len(res.Data[0]) == 0 {
+		return "", nil
+	}
+	edit, _ := res.Data[0][0].(string)
+	return edit, nil
+}
+
+// ToInt64 converts an integer returned by the database, reporting whether
+// the value is one
+func ToInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case int32:
+		return int64(v), true
+	case int:
+		return int64(v), true
+	case float64:
+		return int64(v), v == float64(int64(v))
+	case json.Number:
+		n, err := v.Int64()
+		return n, err == nil
+	}
+	return 0, false
+}
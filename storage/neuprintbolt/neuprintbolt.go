@@ -156,6 +156,8 @@ type databaseInfo struct {
 	Hidden         bool     `json:"hidden"`
 	Logo           string   `json:"logo"`
 	Description    string   `json:"description"`
+	Released       string   `json:"released,omitempty"`
+	Default        bool     `json:"default,omitempty"`
 }
 
 // GetDatasets returns information on the datasets supported
@@ -164,7 +166,7 @@ func (store *Store) GetDatasets() (map[string]interface{}, error) {
 		fmt.Printf("Trying to get datasets\n")
 	}
 	
-	cypher := "MATCH (m :Meta) RETURN m.dataset, m.uuid, m.lastDatabaseEdit, m.roiInfo, m.info, m.superLevelRois AS rois, m.tag AS tag, m.hideDataSet AS hidden, m.logo, m.description"
+	cypher := "MATCH (m :Meta) RETURN m.dataset, m.uuid, m.lastDatabaseEdit, m.roiInfo, m.info, m.superLevelRois AS rois, m.tag AS tag, m.hideDataSet AS hidden, m.logo, m.description, m.releaseDate AS released, m.defaultTag AS isDefault"
 	metadata, err := store.CypherRequest(cypher, true)
 	if err != nil {
 		return nil, err
@@ -223,6 +225,16 @@ func (store *Store) GetDatasets() (map[string]interface{}, error) {
 			description = row[9].(string)
 		}
 
+		// release date and default version for tagged datasets
+		released := ""
+		if len(row) > 10 && row[10] != nil {
+			released = fmt.Sprint(row[10])
+		}
+		isDefault := false
+		if len(row) > 11 && row[11] != nil {
+			isDefault, _ = row[11].(bool)
+		}
+
 		superROIs := row[5].([]interface{})
 		dbInfo := databaseInfo{
 			LastEdit:       edit,
@@ -233,6 +245,8 @@ func (store *Store) GetDatasets() (map[string]interface{}, error) {
 			Hidden:         hidden,
 			Logo:           logo,
 			Description:    description,
+			Released:       released,
+			Default:        isDefault,
 		}
 
 		for roi := range roidata {
@@ -260,12 +274,17 @@ func (store *Store) GetType() string {
 
 // CypherRequest makes a simple cypher request to neo4j
 func (store *Store) CypherRequest(cypher string, readonly bool) (storage.CypherResult, error) {
+	return store.CypherRequestWithParams(cypher, nil, readonly)
+}
+
+// CypherRequestWithParams makes a parameterized cypher request to neo4j
+func (store *Store) CypherRequestWithParams(cypher string, params map[string]interface{}, readonly bool) (storage.CypherResult, error) {
 	trans, err := store.StartTrans()
 	if err != nil {
 		return storage.CypherResult{}, err
 	}
 	
-	res, err := trans.CypherRequest(cypher, readonly)
+	res, err := trans.CypherRequestWithParams(cypher, params, readonly)
 	var cres storage.CypherResult
 	if err != nil {
 		if strings.Contains(err.Error(), "Timeout") {
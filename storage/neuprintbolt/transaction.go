@@ -22,6 +22,11 @@ type Transaction struct {
 
 // CypherRequest executes a Cypher query in the transaction
 func (t *Transaction) CypherRequest(cypher string, readonly bool) (storage.CypherResult, error) {
+	return t.CypherRequestWithParams(cypher, nil, readonly)
+}
+
+// CypherRequestWithParams executes a parameterized Cypher query in the transaction
+func (t *Transaction) CypherRequestWithParams(cypher string, params map[string]interface{}, readonly bool) (storage.CypherResult, error) {
 	var result storage.CypherResult
 	result.Debug = cypher
 
@@ -65,7 +70,7 @@ func (t *Transaction) CypherRequest(cypher string, readonly bool) (storage.Cyphe
 
 	if t.isExplicit && t.tx != nil {
 		// Run in explicit transaction
-		res, err := t.tx.Run(t.ctx, cypher, nil)
+		res, err := t.tx.Run(t.ctx, cypher, params)
 		if err != nil {
 			return result, fmt.Errorf("failed to execute query: %w", err)
 		}
@@ -89,7 +94,7 @@ func (t *Transaction) CypherRequest(cypher string, readonly bool) (storage.Cyphe
 				t.ctx,
 				t.driver,
 				cypher,
-				nil,
+				params,
 				neo4j.EagerResultTransformer,
 				neo4j.ExecuteQueryWithDatabase(t.database),
 			)
@@ -98,7 +103,7 @@ func (t *Transaction) CypherRequest(cypher string, readonly bool) (storage.Cyphe
 				t.ctx,
 				t.driver,
 				cypher,
-				nil,
+				params,
 				neo4j.EagerResultTransformer,
 			)
 		}
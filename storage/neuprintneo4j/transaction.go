@@ -20,10 +20,15 @@ type Transaction struct {
 }
 
 func (t *Transaction) CypherRequest(cypher string, readonly bool) (storage.CypherResult, error) {
+	return t.CypherRequestWithParams(cypher, nil, readonly)
+}
+
+// CypherRequestWithParams sends the query with its parameters in the statement body
+func (t *Transaction) CypherRequestWithParams(cypher string, params map[string]interface{}, readonly bool) (storage.CypherResult, error) {
 	// empty result
 	var cres storage.CypherResult
 
-	transaction := neoStatements{[]neoStatement{neoStatement{cypher, true}}}
+	transaction := neoStatements{[]neoStatement{neoStatement{cypher, params, true}}}
 
 	b := new(bytes.Buffer)
 	json.NewEncoder(b).Encode(transaction)
@@ -249,8 +254,9 @@ type neoResults struct {
 
 // neoStatement is a single query statement
 type neoStatement struct {
-	Statement    string `json:"statement"`
-	IncludeStats bool   `json:"includeStats"`
+	Statement    string                 `json:"statement"`
+	Parameters   map[string]interface{} `json:"parameters,omitempty"`
+	IncludeStats bool                   `json:"includeStats"`
 }
 
 // neoStatements is a set of query statements
@@ -75,6 +75,10 @@ func (c *noopCypher) CypherRequest(query string, readonly bool) (CypherResult, e
 	return CypherResult{Columns: []string{}, Data: [][]interface{}{}}, nil
 }
 
+func (c *noopCypher) CypherRequestWithParams(query string, params map[string]interface{}, readonly bool) (CypherResult, error) {
+	return c.CypherRequest(query, readonly)
+}
+
 func (c *noopCypher) StartTrans() (CypherTransaction, error) {
 	return &noopTransaction{}, nil
 }
@@ -85,5 +89,9 @@ func (t *noopTransaction) CypherRequest(query string, readonly bool) (CypherResu
 	return CypherResult{Columns: []string{}, Data: [][]interface{}{}}, nil
 }
 
+func (t *noopTransaction) CypherRequestWithParams(query string, params map[string]interface{}, readonly bool) (CypherResult, error) {
+	return t.CypherRequest(query, readonly)
+}
+
 func (t *noopTransaction) Kill() error  { return nil }
 func (t *noopTransaction) Commit() error { return nil }
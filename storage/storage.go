@@ -52,6 +52,7 @@ type CypherResult struct {
 // CypherTransaction provides transaction access to a graph database
 type CypherTransaction interface {
 	CypherRequest(string, bool) (CypherResult, error)
+	CypherRequestWithParams(string, map[string]interface{}, bool) (CypherResult, error)
 	Kill() error
 	Commit() error
 }
@@ -59,6 +60,9 @@ type CypherTransaction interface {
 // Cypher is the main interface for accessing graph databases
 type Cypher interface {
 	CypherRequest(string, bool) (CypherResult, error)
+	// CypherRequestWithParams runs a query whose literal values are passed
+	// separately as named parameters ($name) rather than spliced into the text
+	CypherRequestWithParams(string, map[string]interface{}, bool) (CypherResult, error)
 	StartTrans() (CypherTransaction, error)
 }
 
@@ -76,6 +80,12 @@ type KeyValue interface {
 	Set([]byte, []byte) error
 }
 
+// KeyLister is implemented by KeyValue stores that can list their keys
+// without fetching the values
+type KeyLister interface {
+	Keys() ([]string, error)
+}
+
 // ParseConfig finds the appropriate storage engine from the configuration and initializes it
 func ParseConfig(engineName string, data interface{}, mainstores []interface{}, datatypes_raw interface{}, timeout int) (Store, error) {
 	GlobalTimeout = timeout
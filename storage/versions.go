@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LatestTag names the most recently released version of a dataset
+const LatestTag = "latest"
+
+// DefaultTags maps dataset names to the tag used when a dataset is named
+// without one (set from the default-tags configuration).  It overrides the
+// default marked in :Meta; without either the latest release is used.
+var DefaultTags = map[string]string{}
+
+// VersionRefresh is how long the dataset versions are kept before they
+// are read again
+var VersionRefresh = time.Minute
+
+// DatasetVersion is one tagged version of a dataset
+type DatasetVersion struct {
+	Name     string `json:"name"`
+	Tag      string `json:"tag"`
+	Released string `json:"released,omitempty"`
+	Default  bool   `json:"default"`
+	Latest   bool   `json:"latest"`
+	Hidden   bool   `json:"hidden,omitempty"`
+}
+
+// versionInfo holds the version fields of the dataset metadata
+type versionInfo struct {
+	Released string `json:"released"`
+	Default  bool   `json:"default"`
+	Hidden   bool   `json:"hidden"`
+}
+
+// splitDataset splits a dataset name into its name and tag
+func splitDataset(name string) (string, string) {
+	root, tag, _ := strings.Cut(name, ":")
+	return root, tag
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// tagParts splits a tag into runs of digits and of other characters
+func tagParts(tag string) []string {
+	var parts []string
+	for tag != "" {
+		i := 1
+		for i < len(tag) && isDigit(tag[i]) == isDigit(tag[0]) {
+			i++
+		}
+		parts = append(parts, tag[:i])
+		tag = tag[i:]
+	}
+	return parts
+}
+
+// compareTags orders tags comparing their numbers by value, so v1.10
+// follows v1.9
+func compareTags(a, b string) int {
+	partsA, partsB := tagParts(a), tagParts(b)
+	for i := 0; i < len(partsA) && i < len(partsB); i++ {
+		partA, partB := partsA[i], partsB[i]
+		if isDigit(partA[0]) && isDigit(partB[0]) {
+			partA, partB = strings.TrimLeft(partA, "0"), strings.TrimLeft(partB, "0")
+			if len(partA) != len(partB) {
+				return len(partA) - len(partB)
+			}
+		}
+		if cmp := strings.Compare(partA, partB); cmp != 0 {
+			return cmp
+		}
+	}
+	return len(partsA) - len(partsB)
+}
+
+// DatasetVersions groups the datasets returned by GetDatasets by name,
+// keyed by the lower case name.  Versions are ordered from the oldest
+// release to the latest.
+func DatasetVersions(datasets map[string]interface{}) map[string][]DatasetVersion {
+	versions := make(map[string][]DatasetVersion)
+	marked := make(map[string]bool)
+	for name, meta := range datasets {
+		var info versionInfo
+		if data, err := json.Marshal(meta); err == nil {
+			json.Unmarshal(data, &info)
+		}
+		root, tag := splitDataset(name)
+		key := strings.ToLower(root)
+		versions[key] = append(versions[key], DatasetVersion{Name: name, Tag: tag, Released: info.Released, Default: info.Default, Hidden: info.Hidden})
+		marked[key] = marked[key] || info.Default
+	}
+
+	for key, list := range versions {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Released != list[j].Released {
+				return list[i].Released < list[j].Released
+			}
+			return compareTags(list[i].Tag, list[j].Tag) < 0
+		})
+		list[len(list)-1].Latest = true
+
+		// the configured default wins, then the last release marked in
+		// :Meta, then the latest release
+		defaultTag, configured := "", false
+		for root, tag := range DefaultTags {
+			if strings.ToLower(root) != key {
+				continue
+			}
+			for _, version := range list {
+				if strings.EqualFold(version.Tag, tag) {
+					defaultTag, configured = tag, true
+				}
+			}
+		}
+		found := false
+		for i := len(list) - 1; i >= 0; i-- {
+			switch {
+			case configured:
+				list[i].Default = strings.EqualFold(list[i].Tag, defaultTag)
+			case marked[key]:
+				list[i].Default = list[i].Default && !found
+			default:
+				list[i].Default = list[i].Latest
+			}
+			found = found || list[i].Default
+		}
+	}
+	return versions
+}
+
+// versionCache holds the versions of the datasets of a store
+type versionCache struct {
+	mux      sync.Mutex
+	store    SimpleStore
+	fetched  time.Time
+	versions map[string][]DatasetVersion
+}
+
+var versions versionCache
+
+// Versions returns the versions of every dataset of the store, reading them
+// again when they are older than VersionRefresh
+func Versions(store SimpleStore) (map[string][]DatasetVersion, error) {
+	versions.mux.Lock()
+	defer versions.mux.Unlock()
+	if versions.store == store && time.Since(versions.fetched) < VersionRefresh {
+		return versions.versions, nil
+	}
+	datasets, err := store.GetDatasets()
+	if err != nil {
+		return nil, err
+	}
+	versions.store, versions.fetched, versions.versions = store, time.Now(), DatasetVersions(datasets)
+	return versions.versions, nil
+}
+
+// ResolveDataset returns the full name of a dataset named without a tag
+// (its default version) or with the latest tag (its latest release).
+// Other names, including unknown datasets, are returned unchanged.
+func ResolveDataset(store SimpleStore, name string) (string, error) {
+	all, err := Versions(store)
+	if err != nil {
+		return name, err
+	}
+	return resolveVersion(all, name), nil
+}
+
+// resolveVersion picks the version a dataset name refers to
+func resolveVersion(all map[string][]DatasetVersion, name string) string {
+	root, tag := splitDataset(name)
+	list := all[strings.ToLower(root)]
+	for _, version := range list {
+		if strings.EqualFold(version.Name, name) {
+			return version.Name
+		}
+	}
+	for _, version := range list {
+		if (tag == "" && version.Default) || (strings.EqualFold(tag, LatestTag) && version.Latest) {
+			return version.Name
+		}
+	}
+	return name
+}
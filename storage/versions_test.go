@@ -0,0 +1,56 @@
+package storage
+
+import "testing"
+
+func TestCompareTags(t *testing.T) {
+	for _, pair := range [][2]string{{"v1.0", "v1.1"}, {"v1.9", "v1.10"}, {"v1.2", "v1.2.1"}, {"", "v1"}} {
+		if compareTags(pair[0], pair[1]) >= 0 || compareTags(pair[1], pair[0]) <= 0 {
+			t.Errorf("expected %q before %q", pair[0], pair[1])
+		}
+	}
+	if compareTags("v01", "v1") != 0 {
+		t.Error("expected leading zeros to be ignored")
+	}
+}
+
+func TestResolveVersion(t *testing.T) {
+	datasets := map[string]interface{}{
+		"hemibrain:v1.0":   map[string]interface{}{"released": "2020-01-21"},
+		"hemibrain:v1.2.1": map[string]interface{}{"released": "2021-05-10"},
+		"hemibrain:v1.1":   map[string]interface{}{"released": "2020-05-29", "default": true},
+		"fib19":            map[string]interface{}{},
+		"manc:v1.0":        map[string]interface{}{},
+		"manc:v1.2":        map[string]interface{}{},
+	}
+
+	all := DatasetVersions(datasets)
+	hemibrain := all["hemibrain"]
+	if len(hemibrain) != 3 || hemibrain[0].Tag != "v1.0" || !hemibrain[2].Latest || hemibrain[2].Name != "hemibrain:v1.2.1" {
+		t.Fatalf("unexpected versions %v", hemibrain)
+	}
+	for name, want := range map[string]string{
+		"hemibrain":        "hemibrain:v1.1",
+		"HemiBrain":        "hemibrain:v1.1",
+		"hemibrain:latest": "hemibrain:v1.2.1",
+		"hemibrain:v1.0":   "hemibrain:v1.0",
+		"hemibrain:v9":     "hemibrain:v9",
+		"manc":             "manc:v1.2",
+		"fib19":            "fib19",
+		"unknown":          "unknown",
+	} {
+		if got := resolveVersion(all, name); got != want {
+			t.Errorf("resolveVersion(%q) = %q, want %q", name, got, want)
+		}
+	}
+
+	// the configured default overrides :Meta
+	defer func() { DefaultTags = map[string]string{} }()
+	DefaultTags = map[string]string{"hemibrain": "v1.0", "manc": "v9"}
+	all = DatasetVersions(datasets)
+	if got := resolveVersion(all, "hemibrain"); got != "hemibrain:v1.0" {
+		t.Errorf("expected the configured default, got %q", got)
+	}
+	if got := resolveVersion(all, "manc"); got != "manc:v1.2" {
+		t.Errorf("expected an unknown configured tag to be ignored, got %q", got)
+	}
+}